@@ -0,0 +1,55 @@
+/**
+ * CROWN POINT CONSULTING
+ * Marketing attribution capture
+ */
+
+// ============================
+// ATTRIBUTION
+// ============================
+
+/**
+ * Remembers how the visitor arrived (UTM parameters, referrer and landing
+ * page) so the pre-audit form can send it with the submission. A visit that
+ * carries UTM parameters or an external referrer replaces the stored touch;
+ * plain internal navigation keeps it.
+ */
+(function () {
+  const STORAGE_KEY = "cpAttribution";
+  const UTM_KEYS = ["utm_source", "utm_medium", "utm_campaign", "utm_term", "utm_content"];
+
+  function read() {
+    try {
+      return JSON.parse(localStorage.getItem(STORAGE_KEY)) || null;
+    } catch (e) {
+      return null;
+    }
+  }
+
+  function capture() {
+    const params = new URLSearchParams(window.location.search);
+    const touch = {};
+    UTM_KEYS.forEach((key) => {
+      const value = params.get(key);
+      if (value) touch[key] = value;
+    });
+
+    let externalReferrer = "";
+    try {
+      if (document.referrer && new URL(document.referrer).host !== window.location.host) {
+        externalReferrer = document.referrer;
+      }
+    } catch (e) {}
+
+    if (Object.keys(touch).length === 0 && !externalReferrer && read()) {
+      return;
+    }
+    touch.referrer = externalReferrer;
+    touch.landing_page = window.location.origin + window.location.pathname;
+    try {
+      localStorage.setItem(STORAGE_KEY, JSON.stringify(touch));
+    } catch (e) {}
+  }
+
+  capture();
+  window.cpAttribution = { get: read };
+})();
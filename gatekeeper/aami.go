@@ -0,0 +1,660 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+//go:embed aami_catalog.json
+var defaultAAMICatalog []byte
+
+// AAMIClause is a clause of a standard. Open marks a clause whose
+// sub-clauses are not listed yet: references below it are accepted, with a
+// warning that they are not catalogued. Annexes are clauses with a letter,
+// such as "C" or "C.2".
+type AAMIClause struct {
+	ID    string `json:"id"`
+	Title string `json:"title,omitempty"`
+	Open  bool   `json:"open,omitempty"`
+}
+
+// AAMIStandard is one standard or technical report in the catalog. A
+// standard with no clauses listed yet accepts any clause, with the same
+// warning as an open clause.
+type AAMIStandard struct {
+	ID        string       `json:"id"`
+	Title     string       `json:"title"`
+	Edition   string       `json:"edition,omitempty"`
+	Clauses   []AAMIClause `json:"clauses"`
+	UpdatedAt int64        `json:"updatedAt"`
+}
+
+func (s *AAMIStandard) validate() error {
+	if !standardID.MatchString(s.ID) {
+		return fmt.Errorf("standard ID %q is not like ST79 or TIR30", s.ID)
+	}
+	if s.Clauses == nil {
+		s.Clauses = []AAMIClause{}
+	}
+	seen := map[string]bool{}
+	for i, c := range s.Clauses {
+		id := normalizeClause(c.ID)
+		if !clauseID.MatchString(id) {
+			return fmt.Errorf("clause ID %q is not like 8.4.2 or C.1", c.ID)
+		}
+		if seen[id] {
+			return fmt.Errorf("clause %s is listed twice", id)
+		}
+		seen[id] = true
+		s.Clauses[i].ID = id
+	}
+	sort.Slice(s.Clauses, func(i, j int) bool { return clauseLess(s.Clauses[i].ID, s.Clauses[j].ID) })
+	return nil
+}
+
+var (
+	standardID = regexp.MustCompile(`^(ST|TIR)\d+$`)
+	clauseID   = regexp.MustCompile(`^([A-Z]|\d+)(\.\d+)*$`)
+)
+
+func normalizeStandardID(s string) string {
+	return strings.ToUpper(strings.Join(strings.Fields(s), ""))
+}
+
+func normalizeClause(s string) string {
+	return strings.ToUpper(strings.Trim(strings.TrimSpace(s), "."))
+}
+
+// clauseLess orders clauses as the standard does: 2 before 10, numbered
+// clauses before annexes.
+func clauseLess(a, b string) bool {
+	pa, pb := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(pa) && i < len(pb); i++ {
+		if pa[i] == pb[i] {
+			continue
+		}
+		if len(pa[i]) != len(pb[i]) {
+			return len(pa[i]) < len(pb[i])
+		}
+		return pa[i] < pb[i]
+	}
+	return len(pa) < len(pb)
+}
+
+// clauseWithin reports whether clause is parent or one of its sub-clauses.
+func clauseWithin(clause, parent string) bool {
+	return clause == parent || strings.HasPrefix(clause, parent+".")
+}
+
+// AAMIRef is one standard clause cited by a finding. Clause is empty when
+// the finding cites the whole standard.
+type AAMIRef struct {
+	Standard string `json:"standard"`
+	Edition  string `json:"edition,omitempty"`
+	Clause   string `json:"clause,omitempty"`
+}
+
+func (r AAMIRef) String() string {
+	s := r.Standard
+	if r.Edition != "" {
+		s += ":" + r.Edition
+	}
+	if r.Clause != "" {
+		s += " " + clauseLabel(r.Clause)
+	}
+	return s
+}
+
+func clauseLabel(clause string) string {
+	if clause[0] >= 'A' && clause[0] <= 'Z' {
+		return "Annex " + clause
+	}
+	return clause
+}
+
+// refToken finds, in order: a standard with an optional edition, an annex,
+// a clause number and any other word.
+var refToken = regexp.MustCompile(`(?i)\b(?:(ST|TIR)\s*-?\s*(\d+)(?:\s*:\s*((?:19|20)\d\d))?|annex\s+([A-Z])((?:\.\d+)*)|(\d+(?:\.\d+)*)|([a-z]+))\b`)
+
+// refFiller are words that may surround references without changing them.
+var refFiller = map[string]bool{
+	"ansi": true, "aami": true, "section": true, "sec": true, "clause": true,
+	"cl": true, "para": true, "and": true, "see": true, "also": true,
+}
+
+var refSeparators = regexp.MustCompile(`^[\s,;&/().:\-–§]*$`)
+
+// ParseAAMIReferences reads the free-text references of a finding, such as
+// "ANSI/AAMI ST79:2017 §8.4 & 10.5; ST91 Annex C". Clauses belong to the
+// standard before them. leftover is true when the text holds anything that
+// is not a reference, in which case normalizing it would lose information.
+func ParseAAMIReferences(text string) (refs []AAMIRef, leftover bool) {
+	var current *AAMIRef // standard of the clauses being read
+	sawClause := false
+	last := 0
+	for _, m := range refToken.FindAllStringSubmatchIndex(text, -1) {
+		if !refSeparators.MatchString(text[last:m[0]]) {
+			leftover = true
+		}
+		last = m[1]
+		group := func(i int) string {
+			if m[2*i] < 0 {
+				return ""
+			}
+			return text[m[2*i]:m[2*i+1]]
+		}
+		switch {
+		case group(1) != "":
+			if current != nil && !sawClause {
+				refs = append(refs, *current)
+			}
+			current = &AAMIRef{Standard: strings.ToUpper(group(1)) + group(2), Edition: group(3)}
+			sawClause = false
+		case group(4) != "" || group(6) != "":
+			clause := strings.ToUpper(group(4)) + group(5) + group(6)
+			switch {
+			case current == nil:
+				leftover = true
+			case group(6) != "" && !sawClause && current.Edition == "" && len(clause) == 4 && (strings.HasPrefix(clause, "19") || strings.HasPrefix(clause, "20")):
+				current.Edition = clause // "ST79 2017"
+			default:
+				refs = append(refs, AAMIRef{Standard: current.Standard, Edition: current.Edition, Clause: clause})
+				sawClause = true
+			}
+		case !refFiller[strings.ToLower(group(7))]:
+			leftover = true
+		}
+	}
+	if !refSeparators.MatchString(text[last:]) {
+		leftover = true
+	}
+	if current != nil && !sawClause {
+		refs = append(refs, *current)
+	}
+	return refs, leftover
+}
+
+// formatAAMIReferences writes references in their normal form, grouping
+// consecutive clauses of one standard: "ST79 8.4, 10.5; ST91 Annex C".
+func formatAAMIReferences(refs []AAMIRef) string {
+	var b strings.Builder
+	for i, r := range refs {
+		switch {
+		case i > 0 && r.Clause != "" && refs[i-1].Clause != "" && r.Standard == refs[i-1].Standard && r.Edition == refs[i-1].Edition:
+			b.WriteString(", " + clauseLabel(r.Clause))
+			continue
+		case i > 0:
+			b.WriteString("; ")
+		}
+		b.WriteString(r.String())
+	}
+	return b.String()
+}
+
+// AAMICatalog is the list of standards and clauses findings may cite. It
+// starts from aami_catalog.json and is maintained through the API.
+type AAMICatalog struct {
+	file *fileStore[aamiCatalogData]
+}
+
+type aamiCatalogData struct {
+	Standards map[string]*AAMIStandard `json:"standards"`
+}
+
+func OpenAAMICatalog(path string) (*AAMICatalog, error) {
+	f, err := openFileStore[aamiCatalogData](path)
+	if err != nil {
+		return nil, err
+	}
+	c := &AAMICatalog{file: f}
+	err = f.Update(func(d *aamiCatalogData) error {
+		if d.Standards != nil {
+			return nil
+		}
+		var seed struct {
+			Standards []AAMIStandard `json:"standards"`
+		}
+		if err := json.Unmarshal(defaultAAMICatalog, &seed); err != nil {
+			return err
+		}
+		d.Standards = map[string]*AAMIStandard{}
+		now := nowMillis()
+		for _, s := range seed.Standards {
+			if err := s.validate(); err != nil {
+				return fmt.Errorf("aami_catalog.json: %w", err)
+			}
+			s.UpdatedAt = now
+			d.Standards[s.ID] = &s
+		}
+		return nil
+	})
+	return c, err
+}
+
+func cloneStandard(s *AAMIStandard) AAMIStandard {
+	out := *s
+	out.Clauses = append([]AAMIClause{}, s.Clauses...)
+	return out
+}
+
+func (c *AAMICatalog) Standards() []AAMIStandard {
+	out := []AAMIStandard{}
+	c.file.View(func(d *aamiCatalogData) {
+		for _, s := range d.Standards {
+			out = append(out, cloneStandard(s))
+		}
+	})
+	sort.Slice(out, func(i, j int) bool {
+		if len(out[i].ID) != len(out[j].ID) && out[i].ID[:2] == out[j].ID[:2] {
+			return len(out[i].ID) < len(out[j].ID)
+		}
+		return out[i].ID < out[j].ID
+	})
+	return out
+}
+
+func (c *AAMICatalog) Standard(id string) (AAMIStandard, error) {
+	var out AAMIStandard
+	err := errNotFound
+	c.file.View(func(d *aamiCatalogData) {
+		if s, ok := d.Standards[normalizeStandardID(id)]; ok {
+			out, err = cloneStandard(s), nil
+		}
+	})
+	return out, err
+}
+
+// PutStandard adds or replaces a standard. When replacing, s.UpdatedAt must
+// match the stored value.
+func (c *AAMICatalog) PutStandard(s AAMIStandard) (AAMIStandard, error) {
+	s.ID = normalizeStandardID(s.ID)
+	s.Clauses = append([]AAMIClause{}, s.Clauses...)
+	if err := s.validate(); err != nil {
+		return AAMIStandard{}, fmt.Errorf("%w: %v", errInvalid, err)
+	}
+	err := c.file.Update(func(d *aamiCatalogData) error {
+		if d.Standards == nil {
+			d.Standards = map[string]*AAMIStandard{}
+		}
+		prev, ok := d.Standards[s.ID]
+		if ok && prev.UpdatedAt != s.UpdatedAt {
+			return &conflictError{Current: cloneStandard(prev)}
+		}
+		if ok {
+			s.UpdatedAt = nextUpdatedAt(prev.UpdatedAt)
+		} else {
+			s.UpdatedAt = nowMillis()
+		}
+		stored := cloneStandard(&s)
+		d.Standards[s.ID] = &stored
+		return nil
+	})
+	return s, err
+}
+
+func (c *AAMICatalog) DeleteStandard(id string) error {
+	return c.file.Update(func(d *aamiCatalogData) error {
+		id = normalizeStandardID(id)
+		if _, ok := d.Standards[id]; !ok {
+			return errNotFound
+		}
+		delete(d.Standards, id)
+		return nil
+	})
+}
+
+// Check describes what is wrong with a reference, or returns "" when the
+// catalog knows its standard and clause. A clause is known when it is
+// listed or is the parent of a listed clause. One that falls under an open
+// clause, or cites a standard with no clauses listed, cannot be checked and
+// is reported as not catalogued.
+func (c *AAMICatalog) Check(ref AAMIRef) string {
+	problem := ""
+	c.file.View(func(d *aamiCatalogData) {
+		s, ok := d.Standards[ref.Standard]
+		switch {
+		case !ok:
+			problem = fmt.Sprintf("standard %s is not in the catalog", ref.Standard)
+		case ref.Edition != "" && s.Edition != "" && ref.Edition != s.Edition:
+			problem = fmt.Sprintf("cites the %s edition of %s; the catalog has %s", ref.Edition, s.ID, s.Edition)
+		case ref.Clause == "":
+		case len(s.Clauses) == 0:
+			problem = fmt.Sprintf("%s %s is not catalogued; %s has no clauses listed yet", s.ID, clauseLabel(ref.Clause), s.ID)
+		default:
+			open := ""
+			for _, cl := range s.Clauses {
+				if clauseWithin(cl.ID, ref.Clause) {
+					return
+				}
+				if cl.Open && clauseWithin(ref.Clause, cl.ID) {
+					open = cl.ID
+				}
+			}
+			if open != "" {
+				problem = fmt.Sprintf("%s %s is not catalogued; the sub-clauses of %s are not listed yet", s.ID, clauseLabel(ref.Clause), clauseLabel(open))
+				return
+			}
+			problem = fmt.Sprintf("%s has no clause %s in the catalog", s.ID, clauseLabel(ref.Clause))
+		}
+	})
+	return problem
+}
+
+// ClauseTitle is the catalog title of a clause, or of its standard when no
+// clause is given.
+func (c *AAMICatalog) ClauseTitle(ref AAMIRef) string {
+	title := ""
+	c.file.View(func(d *aamiCatalogData) {
+		s, ok := d.Standards[ref.Standard]
+		if !ok {
+			return
+		}
+		if ref.Clause == "" {
+			title = s.Title
+			return
+		}
+		for _, cl := range s.Clauses {
+			if cl.ID == ref.Clause {
+				title = cl.Title
+			}
+		}
+	})
+	return title
+}
+
+// Normalize rewrites a finding's reference text in normal form and lists
+// what the catalog does not recognise. Text that holds more than
+// references is left as written.
+func (c *AAMICatalog) Normalize(text string) (string, []string) {
+	if strings.TrimSpace(text) == "" {
+		return "", nil
+	}
+	refs, leftover := ParseAAMIReferences(text)
+	var problems []string
+	if len(refs) == 0 {
+		return text, []string{fmt.Sprintf("no AAMI standard found in reference %q", text)}
+	}
+	if leftover {
+		problems = append(problems, fmt.Sprintf("reference %q has text that is not a standard or clause; left as written", text))
+	} else {
+		text = formatAAMIReferences(refs)
+	}
+	for _, ref := range refs {
+		if p := c.Check(ref); p != "" {
+			problems = append(problems, p)
+		}
+	}
+	return text, problems
+}
+
+// CitingFinding is a master finding that cites a clause.
+type CitingFinding struct {
+	Category         AuditCategory `json:"category"`
+	Section          AuditSection  `json:"section"`
+	FindingID        string        `json:"findingId"`
+	EquipmentSubject string        `json:"equipmentSubject"`
+	Issue            string        `json:"issue"`
+	AAMIReference    string        `json:"aamiReference"`
+}
+
+func citingFinding(category AuditCategory, section AuditSection, f AuditFinding) CitingFinding {
+	return CitingFinding{category, section, f.ID, f.EquipmentSubject, f.Issue, f.AAMIReference}
+}
+
+// cites reports whether a finding cites the clause of ref or one of its
+// sub-clauses; a ref without a clause matches any citation of the standard.
+func cites(f AuditFinding, ref AAMIRef) bool {
+	refs, _ := ParseAAMIReferences(f.AAMIReference)
+	for _, r := range refs {
+		if r.Standard == ref.Standard && (ref.Clause == "" || clauseWithin(r.Clause, ref.Clause)) {
+			return true
+		}
+	}
+	return false
+}
+
+type ClauseCitations struct {
+	Reference string          `json:"reference"`
+	Title     string          `json:"title,omitempty"`
+	Problem   string          `json:"problem,omitempty"`
+	Findings  []CitingFinding `json:"findings"`
+}
+
+// Citations lists the findings of the current masters that cite ref.
+func (c *AAMICatalog) Citations(ws *WorkspaceStore, ref AAMIRef) ClauseCitations {
+	out := ClauseCitations{Reference: ref.String(), Title: c.ClauseTitle(ref), Problem: c.Check(ref), Findings: []CitingFinding{}}
+	masters := ws.Masters()
+	for _, cat := range auditCategories {
+		master := masters[cat]
+		if master == nil {
+			continue
+		}
+		for _, section := range auditSections {
+			for _, f := range master.Sections[section] {
+				if cites(f, ref) {
+					out.Findings = append(out.Findings, citingFinding(cat, section, f))
+				}
+			}
+		}
+	}
+	return out
+}
+
+// UnrecognisedReference is a master finding whose reference the catalog
+// does not recognise.
+type UnrecognisedReference struct {
+	CitingFinding
+	Problems []string `json:"problems"`
+}
+
+// Unrecognised checks every finding of the current masters against the
+// catalog, for references that predate it or were edited by hand.
+func (c *AAMICatalog) Unrecognised(ws *WorkspaceStore) []UnrecognisedReference {
+	out := []UnrecognisedReference{}
+	masters := ws.Masters()
+	for _, cat := range auditCategories {
+		master := masters[cat]
+		if master == nil {
+			continue
+		}
+		for _, section := range auditSections {
+			for _, f := range master.Sections[section] {
+				if _, problems := c.Normalize(f.AAMIReference); len(problems) > 0 {
+					out = append(out, UnrecognisedReference{citingFinding(cat, section, f), problems})
+				}
+			}
+		}
+	}
+	return out
+}
+
+type ClauseAudit struct {
+	InstanceID string         `json:"instanceId"`
+	Category   AuditCategory  `json:"category"`
+	Status     InstanceStatus `json:"status"`
+	UpdatedAt  int64          `json:"updatedAt"`
+}
+
+// ClauseFailure is a clause a facility was found non-compliant with, and
+// the audits and findings where that happened.
+type ClauseFailure struct {
+	Reference string          `json:"reference"`
+	Standard  string          `json:"standard"`
+	Clause    string          `json:"clause,omitempty"`
+	Title     string          `json:"title,omitempty"`
+	Known     bool            `json:"known"`
+	Failures  int             `json:"failures"`
+	Audits    []ClauseAudit   `json:"audits"`
+	Findings  []CitingFinding `json:"findings"`
+}
+
+// FacilityFailures lists the clauses cited by non-compliant responses in a
+// facility's audits, most often failed first. Drafts are left out since
+// their answers may still change.
+func (c *AAMICatalog) FacilityFailures(ws *WorkspaceStore, f Facility) []ClauseFailure {
+	byRef := map[string]*ClauseFailure{}
+	for _, inst := range ws.Instances() {
+		if inst.Status == InstanceDraft || inst.FacilityID != f.ID {
+			continue
+		}
+		master, err := ws.MasterFor(inst)
+		if err != nil {
+			continue
+		}
+		walkFindings(master, inst, func(section AuditSection, finding AuditFinding, resp AuditResponse) {
+			if resp.Status != StatusNonCompliant {
+				return
+			}
+			refs, _ := ParseAAMIReferences(finding.AAMIReference)
+			for _, ref := range refs {
+				ref.Edition = ""
+				key := ref.String()
+				cf, ok := byRef[key]
+				if !ok {
+					cf = &ClauseFailure{
+						Reference: key,
+						Standard:  ref.Standard,
+						Clause:    ref.Clause,
+						Title:     c.ClauseTitle(ref),
+						Known:     c.Check(ref) == "",
+					}
+					byRef[key] = cf
+				}
+				cf.Failures++
+				if n := len(cf.Audits); n == 0 || cf.Audits[n-1].InstanceID != inst.ID {
+					cf.Audits = append(cf.Audits, ClauseAudit{inst.ID, inst.Category, inst.Status, inst.UpdatedAt})
+				}
+				cf.Findings = appendCiting(cf.Findings, citingFinding(inst.Category, section, finding))
+			}
+		})
+	}
+	out := []ClauseFailure{}
+	for _, cf := range byRef {
+		sort.Slice(cf.Audits, func(i, j int) bool { return cf.Audits[i].UpdatedAt > cf.Audits[j].UpdatedAt })
+		out = append(out, *cf)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		a, b := out[i], out[j]
+		if len(a.Audits) != len(b.Audits) {
+			return len(a.Audits) > len(b.Audits)
+		}
+		if a.Failures != b.Failures {
+			return a.Failures > b.Failures
+		}
+		if a.Standard != b.Standard {
+			return a.Standard < b.Standard
+		}
+		return clauseLess(a.Clause, b.Clause)
+	})
+	return out
+}
+
+// appendCiting adds a finding once; the same finding fails in every audit
+// that repeats it.
+func appendCiting(list []CitingFinding, f CitingFinding) []CitingFinding {
+	for _, have := range list {
+		if have.Category == f.Category && have.FindingID == f.FindingID {
+			return list
+		}
+	}
+	return append(list, f)
+}
+
+// refFromQuery reads ?ref= ("ST79 8.4") or ?standard= and ?clause=.
+func refFromQuery(r *http.Request) (AAMIRef, error) {
+	q := r.URL.Query()
+	if text := q.Get("ref"); text != "" {
+		refs, leftover := ParseAAMIReferences(text)
+		if len(refs) != 1 || leftover {
+			return AAMIRef{}, errors.New(`ref must be one standard and at most one clause, like "ST79 8.4"`)
+		}
+		return refs[0], nil
+	}
+	ref := AAMIRef{Standard: normalizeStandardID(q.Get("standard")), Clause: normalizeClause(q.Get("clause"))}
+	if !standardID.MatchString(ref.Standard) {
+		return AAMIRef{}, errors.New("ref or standard is required")
+	}
+	if ref.Clause != "" && !clauseID.MatchString(ref.Clause) {
+		return AAMIRef{}, fmt.Errorf("clause %q is not like 8.4.2 or C.1", ref.Clause)
+	}
+	return ref, nil
+}
+
+func registerAAMIRoutes(mux *http.ServeMux, catalog *AAMICatalog, ws *WorkspaceStore, registry *FacilityRegistry) {
+	mux.Handle("GET /realm/aami/standards", realmRoute(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, catalog.Standards())
+	}))
+	mux.Handle("GET /realm/aami/standards/{id}", realmRoute(func(w http.ResponseWriter, r *http.Request) {
+		s, err := catalog.Standard(r.PathValue("id"))
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, s)
+	}))
+	mux.Handle("PUT /realm/aami/standards/{id}", realmRoute(func(w http.ResponseWriter, r *http.Request) {
+		var s AAMIStandard
+		if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if s.ID == "" {
+			s.ID = r.PathValue("id")
+		}
+		if normalizeStandardID(s.ID) != normalizeStandardID(r.PathValue("id")) {
+			http.Error(w, "standard ID does not match the URL", http.StatusBadRequest)
+			return
+		}
+		saved, err := catalog.PutStandard(s)
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, saved)
+	}, RoleAdmin, RolePartner))
+	mux.Handle("DELETE /realm/aami/standards/{id}", realmRoute(func(w http.ResponseWriter, r *http.Request) {
+		if err := catalog.DeleteStandard(r.PathValue("id")); err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}, RoleAdmin, RolePartner))
+	// Parses free text the way checklist import does, for editors to
+	// preview a reference before saving it.
+	mux.Handle("GET /realm/aami/normalize", realmRoute(func(w http.ResponseWriter, r *http.Request) {
+		text, problems := catalog.Normalize(r.URL.Query().Get("text"))
+		refs, _ := ParseAAMIReferences(text)
+		if refs == nil {
+			refs = []AAMIRef{}
+		}
+		if problems == nil {
+			problems = []string{}
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"text": text, "references": refs, "problems": problems})
+	}))
+	mux.Handle("GET /realm/aami/citations", realmRoute(func(w http.ResponseWriter, r *http.Request) {
+		ref, err := refFromQuery(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, http.StatusOK, catalog.Citations(ws, ref))
+	}))
+	mux.Handle("GET /realm/aami/unrecognised", realmRoute(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, catalog.Unrecognised(ws))
+	}))
+	mux.Handle("GET /realm/aami/facilities/{id}/failures", realmRoute(func(w http.ResponseWriter, r *http.Request) {
+		f, err := registry.Get(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, catalog.FacilityFailures(ws, f))
+	}))
+}
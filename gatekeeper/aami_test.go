@@ -0,0 +1,48 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAAMICheck(t *testing.T) {
+	c, err := OpenAAMICatalog(filepath.Join(t.TempDir(), "aami.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := c.Standard("ST108")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Clauses = []AAMIClause{{ID: "4"}, {ID: "4.2"}, {ID: "5", Open: true}, {ID: "C"}}
+	if _, err := c.PutStandard(s); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		ref  string
+		want string // substring of the problem; "" for none
+	}{
+		{"ST108 4.2", ""},
+		{"ST108 4", ""},
+		{"ST108 Annex C", ""},
+		{"ST108", ""},
+		{"ST108 4.3", "has no clause 4.3"},
+		{"ST108 5", ""},
+		{"ST108 5.1.2", "ST108 5.1.2 is not catalogued; the sub-clauses of 5"},
+		{"ST91 7.3", "ST91 7.3 is not catalogued; ST91 has no clauses"},
+		{"ST91", ""},
+		{"ST108:2014 4.2", "cites the 2014 edition"},
+		{"ST999 1", "not in the catalog"},
+	}
+	for _, tt := range tests {
+		refs, _ := ParseAAMIReferences(tt.ref)
+		if len(refs) != 1 {
+			t.Fatalf("%s parsed as %v", tt.ref, refs)
+		}
+		got := c.Check(refs[0])
+		if tt.want == "" && got != "" || tt.want != "" && !strings.Contains(got, tt.want) {
+			t.Errorf("Check(%s) = %q, want %q", tt.ref, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,455 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxAssetSize bounds a single evidence upload.
+const maxAssetSize = 25 << 20
+
+// uploadURLLifetime is how long a pre-signed upload URL stays valid.
+const uploadURLLifetime = 15 * time.Minute
+
+// downloadURLLifetime is how long a signed download URL stays valid.
+const downloadURLLifetime = 10 * time.Minute
+
+type AssetStatus string
+
+const (
+	AssetPending  AssetStatus = "pending"
+	AssetUploaded AssetStatus = "uploaded"
+	AssetRejected AssetStatus = "rejected"
+)
+
+// Asset is a piece of audit evidence, normally a photo, attached to one
+// finding's response within an audit instance.
+type Asset struct {
+	ID          string      `json:"id"`
+	InstanceID  string      `json:"instanceId"`
+	FindingID   string      `json:"findingId"`
+	FileName    string      `json:"fileName"`
+	ContentType string      `json:"contentType"`
+	Size        int64       `json:"size"`
+	Key         string      `json:"-"`
+	Status      AssetStatus `json:"status"`
+	// RejectReason says why a rejected upload was refused.
+	RejectReason string         `json:"rejectReason,omitempty"`
+	SHA256       string         `json:"sha256,omitempty"`
+	Width        int            `json:"width,omitempty"`
+	Height       int            `json:"height,omitempty"`
+	Variants     []ImageVariant `json:"variants,omitempty"`
+	CreatedAt    time.Time      `json:"createdAt"`
+	UploadedAt   *time.Time     `json:"uploadedAt,omitempty"`
+	// URL is the authenticated download path; it is what the app stores in
+	// AuditResponse.images.
+	URL string `json:"url"`
+	// DownloadURL is a short-lived signed link to the content, for image
+	// tags, which cannot send a bearer token. It is filled in per response.
+	DownloadURL string `json:"downloadUrl,omitempty"`
+}
+
+// AssetStore records asset metadata. The files themselves live in a BlobStore.
+type AssetStore struct {
+	file *fileStore[map[string]*Asset]
+}
+
+func OpenAssetStore(path string) (*AssetStore, error) {
+	f, err := openFileStore[map[string]*Asset](path)
+	if err != nil {
+		return nil, err
+	}
+	return &AssetStore{file: f}, nil
+}
+
+func (s *AssetStore) Add(a Asset) error {
+	return s.file.Update(func(m *map[string]*Asset) error {
+		if *m == nil {
+			*m = map[string]*Asset{}
+		}
+		stored := a
+		(*m)[a.ID] = &stored
+		return nil
+	})
+}
+
+func (s *AssetStore) Get(id string) (Asset, error) {
+	var (
+		a  Asset
+		ok bool
+	)
+	s.file.View(func(m *map[string]*Asset) {
+		var p *Asset
+		if p, ok = (*m)[id]; ok {
+			a = *p
+		}
+	})
+	if !ok {
+		return Asset{}, errNotFound
+	}
+	return a, nil
+}
+
+// Modify applies fn to the asset and returns the updated copy.
+func (s *AssetStore) Modify(id string, fn func(a *Asset) error) (Asset, error) {
+	var out Asset
+	err := s.file.Update(func(m *map[string]*Asset) error {
+		p, ok := (*m)[id]
+		if !ok {
+			return errNotFound
+		}
+		if err := fn(p); err != nil {
+			return err
+		}
+		out = *p
+		return nil
+	})
+	return out, err
+}
+
+// ForResponse lists the uploaded assets of one response, oldest first.
+func (s *AssetStore) ForResponse(instanceID, findingID string) []Asset {
+	out := []Asset{}
+	s.file.View(func(m *map[string]*Asset) {
+		for _, a := range *m {
+			if a.InstanceID == instanceID && a.FindingID == findingID && a.Status == AssetUploaded {
+				out = append(out, *a)
+			}
+		}
+	})
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.Before(out[j].CreatedAt) })
+	return out
+}
+
+// absoluteURL resolves a path against the URL the request was made to, for
+// upload URLs that point back at this server.
+func absoluteURL(r *http.Request, path string) string {
+	if strings.Contains(path, "://") {
+		return path
+	}
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s%s", scheme, r.Host, path)
+}
+
+// downloadSigner signs links to asset content. The signature is the only
+// credential such a link needs, as with upload URLs, so links are short-lived
+// and name one asset and variant.
+type downloadSigner struct {
+	key []byte
+}
+
+func newDownloadSigner(signingKey []byte) downloadSigner {
+	// A key of its own, so no download signature is also a valid upload one.
+	mac := hmac.New(sha256.New, signingKey)
+	mac.Write([]byte("asset downloads"))
+	return downloadSigner{key: mac.Sum(nil)}
+}
+
+func (s downloadSigner) sign(id, variant string, expires int64) string {
+	mac := hmac.New(sha256.New, s.key)
+	fmt.Fprintf(mac, "%s\n%s\n%d", id, variant, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// path returns the signed content path of an asset or one of its variants.
+func (s downloadSigner) path(id, variant string, now time.Time) string {
+	exp := now.Add(downloadURLLifetime).Unix()
+	q := url.Values{"expires": {strconv.FormatInt(exp, 10)}, "signature": {s.sign(id, variant, exp)}}
+	if variant != "" {
+		q.Set("variant", variant)
+	}
+	return "/realm/assets/" + id + "/content?" + q.Encode()
+}
+
+func (s downloadSigner) verify(id string, q url.Values, now time.Time) bool {
+	exp, err := strconv.ParseInt(q.Get("expires"), 10, 64)
+	if err != nil || now.Unix() > exp {
+		return false
+	}
+	return hmac.Equal([]byte(q.Get("signature")), []byte(s.sign(id, q.Get("variant"), exp)))
+}
+
+// withDownloadURLs returns a copy of asset with signed links to its content
+// and variants.
+func (s downloadSigner) withDownloadURLs(r *http.Request, asset Asset) Asset {
+	if asset.Status != AssetUploaded {
+		return asset
+	}
+	now := time.Now()
+	asset.DownloadURL = absoluteURL(r, s.path(asset.ID, "", now))
+	variants := make([]ImageVariant, len(asset.Variants))
+	for i, v := range asset.Variants {
+		v.DownloadURL = absoluteURL(r, s.path(asset.ID, v.Name, now))
+		variants[i] = v
+	}
+	asset.Variants = variants
+	return asset
+}
+
+type uploadRequest struct {
+	FileName    string `json:"fileName"`
+	ContentType string `json:"contentType"`
+	Size        int64  `json:"size"`
+}
+
+type uploadTicket struct {
+	Asset         Asset             `json:"asset"`
+	UploadURL     string            `json:"uploadUrl"`
+	UploadMethod  string            `json:"uploadMethod"`
+	UploadHeaders map[string]string `json:"uploadHeaders"`
+	ExpiresAt     time.Time         `json:"expiresAt"`
+}
+
+func registerAssetRoutes(mux *http.ServeMux, ws *WorkspaceStore, assets *AssetStore, blobs BlobStore, signingKey []byte) {
+	registerLocalUploadRoute(mux, blobs)
+	signer := newDownloadSigner(signingKey)
+
+	// Step one: reserve an asset and hand out an upload URL.
+	mux.Handle("POST /realm/instances/{id}/responses/{findingId}/assets", realmRoute(func(w http.ResponseWriter, r *http.Request) {
+		var req uploadRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !strings.HasPrefix(req.ContentType, "image/") {
+			http.Error(w, "only images can be uploaded", http.StatusBadRequest)
+			return
+		}
+		if req.Size <= 0 || req.Size > maxAssetSize {
+			http.Error(w, fmt.Sprintf("size must be between 1 and %d bytes", maxAssetSize), http.StatusBadRequest)
+			return
+		}
+		if _, err := ws.Instance(r.PathValue("id")); err != nil {
+			writeStoreError(w, err)
+			return
+		}
+
+		id := newID()
+		asset := Asset{
+			ID:          id,
+			InstanceID:  r.PathValue("id"),
+			FindingID:   r.PathValue("findingId"),
+			FileName:    req.FileName,
+			ContentType: req.ContentType,
+			Key:         id + "/original",
+			Status:      AssetPending,
+			CreatedAt:   time.Now().UTC(),
+			URL:         "/realm/assets/" + id + "/content",
+		}
+		uploadURL, err := blobs.PresignPut(r.Context(), asset.Key, uploadURLLifetime)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := assets.Add(asset); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusCreated, uploadTicket{
+			Asset:         asset,
+			UploadURL:     absoluteURL(r, uploadURL),
+			UploadMethod:  http.MethodPut,
+			UploadHeaders: map[string]string{"Content-Type": req.ContentType},
+			ExpiresAt:     time.Now().Add(uploadURLLifetime).UTC(),
+		})
+	}))
+
+	// Step two, after the client has PUT the file: check it and store the
+	// cleaned original and its variants.
+	mux.Handle("POST /realm/assets/{id}/complete", realmRoute(func(w http.ResponseWriter, r *http.Request) {
+		asset, err := assets.Get(r.PathValue("id"))
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		if asset.Status == AssetUploaded {
+			writeJSON(w, http.StatusOK, signer.withDownloadURLs(r, asset))
+			return
+		}
+		processed, err := processUpload(r.Context(), blobs, asset)
+		switch {
+		case errors.Is(err, errNotFound):
+			http.Error(w, "nothing has been uploaded for this asset", http.StatusConflict)
+			return
+		case errors.Is(err, errNotAnImage), errors.Is(err, errImageTooLarge):
+			_ = blobs.Delete(r.Context(), asset.Key)
+			asset, _ = assets.Modify(asset.ID, func(a *Asset) error {
+				a.Status = AssetRejected
+				a.RejectReason = err.Error()
+				return nil
+			})
+			writeJSON(w, http.StatusUnprocessableEntity, asset)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		asset, err = assets.Modify(asset.ID, func(a *Asset) error {
+			now := time.Now().UTC()
+			a.Status = AssetUploaded
+			a.UploadedAt = &now
+			a.ContentType = "image/jpeg"
+			a.Size = int64(len(processed.Original))
+			a.SHA256 = processed.SHA256
+			a.Width, a.Height = processed.Width, processed.Height
+			a.Variants = nil
+			for _, v := range processed.Variants {
+				a.Variants = append(a.Variants, v.ImageVariant)
+			}
+			return nil
+		})
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, signer.withDownloadURLs(r, asset))
+	}))
+
+	mux.Handle("GET /realm/instances/{id}/responses/{findingId}/assets", realmRoute(func(w http.ResponseWriter, r *http.Request) {
+		list := assets.ForResponse(r.PathValue("id"), r.PathValue("findingId"))
+		for i := range list {
+			list[i] = signer.withDownloadURLs(r, list[i])
+		}
+		writeJSON(w, http.StatusOK, list)
+	}))
+
+	mux.Handle("GET /realm/assets/{id}", realmRoute(func(w http.ResponseWriter, r *http.Request) {
+		asset, err := assets.Get(r.PathValue("id"))
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, signer.withDownloadURLs(r, asset))
+	}))
+
+	content := func(w http.ResponseWriter, r *http.Request) {
+		asset, err := assets.Get(r.PathValue("id"))
+		if err == nil && asset.Status != AssetUploaded {
+			err = errNotFound
+		}
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		key, contentType, size := asset.Key, asset.ContentType, asset.Size
+		if name := r.URL.Query().Get("variant"); name != "" {
+			v, ok := pickVariant(asset.Variants, name, r.Header.Get("Accept"))
+			if !ok {
+				http.Error(w, "unknown variant", http.StatusNotFound)
+				return
+			}
+			key, contentType, size = v.Key, v.ContentType, v.Size
+			w.Header().Set("Vary", "Accept")
+		}
+		body, err := blobs.Open(r.Context(), key)
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		defer body.Close()
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Length", fmt.Sprint(size))
+		w.Header().Set("Cache-Control", "private, max-age=86400")
+		if _, err := io.Copy(w, body); err != nil {
+			log.Printf("sending asset %s: %v", asset.ID, err)
+		}
+	}
+	// Staff fetch content with their token; image tags use a signed link
+	// from downloadUrl instead.
+	staffContent := realmRoute(content)
+	mux.HandleFunc("GET /realm/assets/{id}/content", func(w http.ResponseWriter, r *http.Request) {
+		if !r.URL.Query().Has("signature") {
+			staffContent.ServeHTTP(w, r)
+			return
+		}
+		enableCors(w, r)
+		if !signer.verify(r.PathValue("id"), r.URL.Query(), time.Now()) {
+			http.Error(w, "download URL is invalid or expired", http.StatusForbidden)
+			return
+		}
+		content(w, r)
+	})
+}
+
+// processUpload reads an uploaded original, runs it through ProcessImage and
+// writes the results back: the cleaned JPEG over the original key and each
+// variant next to it.
+func processUpload(ctx context.Context, blobs BlobStore, asset Asset) (ProcessedImage, error) {
+	size, err := blobs.Stat(ctx, asset.Key)
+	if err != nil {
+		return ProcessedImage{}, err
+	}
+	if size > maxAssetSize {
+		return ProcessedImage{}, errImageTooLarge
+	}
+	body, err := blobs.Open(ctx, asset.Key)
+	if err != nil {
+		return ProcessedImage{}, err
+	}
+	raw, err := io.ReadAll(io.LimitReader(body, maxAssetSize+1))
+	body.Close()
+	if err != nil {
+		return ProcessedImage{}, err
+	}
+	processed, err := ProcessImage(raw)
+	if err != nil {
+		return ProcessedImage{}, err
+	}
+
+	base := strings.TrimSuffix(asset.Key, "/original")
+	for i := range processed.Variants {
+		v := &processed.Variants[i]
+		ext := ".jpg"
+		if v.ContentType == "image/webp" {
+			ext = ".webp"
+		}
+		v.Key = base + "/" + v.Name + ext
+		v.Size = int64(len(v.Data))
+		v.URL = fmt.Sprintf("/realm/assets/%s/content?variant=%s", asset.ID, v.Name)
+		if err := blobs.Put(ctx, v.Key, v.ContentType, bytes.NewReader(v.Data), v.Size); err != nil {
+			return ProcessedImage{}, err
+		}
+	}
+	if err := blobs.Put(ctx, asset.Key, "image/jpeg", bytes.NewReader(processed.Original), int64(len(processed.Original))); err != nil {
+		return ProcessedImage{}, err
+	}
+	return processed, nil
+}
+
+// pickVariant finds the named variant, preferring WebP when the client
+// accepts it.
+func pickVariant(variants []ImageVariant, name, accept string) (ImageVariant, bool) {
+	var (
+		found ImageVariant
+		ok    bool
+	)
+	for _, v := range variants {
+		if v.Name != name {
+			continue
+		}
+		if v.ContentType == "image/webp" && !strings.Contains(accept, "image/webp") {
+			continue
+		}
+		if !ok || v.ContentType == "image/webp" {
+			found, ok = v, true
+		}
+	}
+	return found, ok
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newAssetTest serves the asset routes over a local blob store. Requests
+// carrying "Bearer staff" are made as an auditor; others are anonymous.
+func newAssetTest(t *testing.T) (srv *httptest.Server, inst AuditInstance) {
+	t.Helper()
+	dir := t.TempDir()
+	ws := openTestWorkspace(t)
+	master, _ := testMasters()
+	if _, err := ws.PublishMaster(master); err != nil {
+		t.Fatal(err)
+	}
+	inst, err := ws.CreateInstance(AuditInstance{FacilityName: "Mercy Hospital", Category: CategoryCSSD})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assets, err := OpenAssetStore(filepath.Join(dir, "assets.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := []byte("test signing key")
+	mux := http.NewServeMux()
+	registerAssetRoutes(mux, ws, assets, &LocalBlobStore{Dir: filepath.Join(dir, "blobs"), SigningKey: key}, key)
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "Bearer staff" {
+			r = asUser(r, RoleAuditor)
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, inst
+}
+
+func testPNG(t *testing.T) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 40, 30))
+	for x := range 40 {
+		img.Set(x, x%30, color.RGBA{200, 40, 40, 255})
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func call(t *testing.T, method, url, token string, body []byte, out any) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if out != nil && res.StatusCode < 300 {
+		if err := json.NewDecoder(res.Body).Decode(out); err != nil {
+			t.Fatal(err)
+		}
+	} else {
+		io.Copy(io.Discard, res.Body)
+	}
+	return res
+}
+
+// reserve asks for an upload URL for a file of the given size.
+func reserve(t *testing.T, srv *httptest.Server, inst AuditInstance, size int) uploadTicket {
+	t.Helper()
+	req, _ := json.Marshal(uploadRequest{FileName: "sink.png", ContentType: "image/png", Size: int64(size)})
+	var ticket uploadTicket
+	if res := call(t, http.MethodPost, srv.URL+"/realm/instances/"+inst.ID+"/responses/d-0/assets", "staff", req, &ticket); res.StatusCode != http.StatusCreated {
+		t.Fatalf("reserve: %s", res.Status)
+	}
+	return ticket
+}
+
+func TestAssetUploadFlow(t *testing.T) {
+	srv, inst := newAssetTest(t)
+	photo := testPNG(t)
+
+	req, _ := json.Marshal(uploadRequest{FileName: "sink.png", ContentType: "image/png", Size: int64(len(photo))})
+	if res := call(t, http.MethodPost, srv.URL+"/realm/instances/"+inst.ID+"/responses/d-0/assets", "", req, nil); res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("reserving without a token: %s", res.Status)
+	}
+	ticket := reserve(t, srv, inst, len(photo))
+	if ticket.Asset.Status != AssetPending || ticket.UploadMethod != http.MethodPut || !strings.HasPrefix(ticket.UploadURL, srv.URL+"/assets/upload/") {
+		t.Fatalf("ticket = %+v", ticket)
+	}
+	complete := srv.URL + "/realm/assets/" + ticket.Asset.ID + "/complete"
+	if res := call(t, http.MethodPost, complete, "staff", nil, nil); res.StatusCode != http.StatusConflict {
+		t.Errorf("completing before the upload: %s", res.Status)
+	}
+
+	// The signature covers the key and expiry; changing either voids it.
+	forged, _ := url.Parse(ticket.UploadURL)
+	q := forged.Query()
+	q.Set("expires", strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10))
+	forged.RawQuery = q.Encode()
+	if res := call(t, http.MethodPut, forged.String(), "", photo, nil); res.StatusCode != http.StatusForbidden {
+		t.Errorf("upload with a changed expiry: %s", res.Status)
+	}
+	other := strings.Replace(ticket.UploadURL, ticket.Asset.ID, strings.Repeat("0", len(ticket.Asset.ID)), 1)
+	if res := call(t, http.MethodPut, other, "", photo, nil); res.StatusCode != http.StatusForbidden {
+		t.Errorf("upload to another key: %s", res.Status)
+	}
+	if res := call(t, http.MethodPut, ticket.UploadURL, "", photo, nil); res.StatusCode != http.StatusOK {
+		t.Fatalf("upload: %s", res.Status)
+	}
+
+	var asset Asset
+	if res := call(t, http.MethodPost, complete, "staff", nil, &asset); res.StatusCode != http.StatusOK {
+		t.Fatalf("complete: %s", res.Status)
+	}
+	if asset.Status != AssetUploaded || asset.ContentType != "image/jpeg" || asset.Width != 40 || len(asset.Variants) == 0 {
+		t.Errorf("completed asset = %+v", asset)
+	}
+	if asset.URL != "/realm/assets/"+asset.ID+"/content" || asset.DownloadURL == "" {
+		t.Errorf("url %q, download url %q", asset.URL, asset.DownloadURL)
+	}
+	var again Asset
+	if res := call(t, http.MethodPost, complete, "staff", nil, &again); res.StatusCode != http.StatusOK || again.SHA256 != asset.SHA256 {
+		t.Errorf("completing twice: %s, %+v", res.Status, again)
+	}
+}
+
+func TestAssetUploadRejectsNonImages(t *testing.T) {
+	srv, inst := newAssetTest(t)
+	junk := []byte("%PDF-1.4 not a photo")
+	ticket := reserve(t, srv, inst, len(junk))
+	if res := call(t, http.MethodPut, ticket.UploadURL, "", junk, nil); res.StatusCode != http.StatusOK {
+		t.Fatalf("upload: %s", res.Status)
+	}
+	res := call(t, http.MethodPost, srv.URL+"/realm/assets/"+ticket.Asset.ID+"/complete", "staff", nil, nil)
+	if res.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("completing a non-image: %s", res.Status)
+	}
+	if res := call(t, http.MethodGet, srv.URL+ticket.Asset.URL, "staff", nil, nil); res.StatusCode != http.StatusNotFound {
+		t.Errorf("content of a rejected upload: %s", res.Status)
+	}
+}
+
+func TestAssetDownloadAuth(t *testing.T) {
+	srv, inst := newAssetTest(t)
+	photo := testPNG(t)
+	ticket := reserve(t, srv, inst, len(photo))
+	call(t, http.MethodPut, ticket.UploadURL, "", photo, nil)
+	var asset Asset
+	if res := call(t, http.MethodPost, srv.URL+"/realm/assets/"+ticket.Asset.ID+"/complete", "staff", nil, &asset); res.StatusCode != http.StatusOK {
+		t.Fatalf("complete: %s", res.Status)
+	}
+	var thumb string
+	for _, v := range asset.Variants {
+		if v.Name == "thumb" {
+			thumb = v.DownloadURL
+		}
+	}
+
+	tamper := func(u, key, value string) string {
+		parsed, _ := url.Parse(u)
+		q := parsed.Query()
+		q.Set(key, value)
+		parsed.RawQuery = q.Encode()
+		return parsed.String()
+	}
+	otherAsset := reserve(t, srv, inst, len(photo)).Asset.ID
+	tests := []struct {
+		name, url, token string
+		want             int
+	}{
+		{"staff token", srv.URL + asset.URL, "staff", http.StatusOK},
+		{"no credentials", srv.URL + asset.URL, "", http.StatusUnauthorized},
+		{"signed link", asset.DownloadURL, "", http.StatusOK},
+		{"signed variant link", thumb, "", http.StatusOK},
+		{"variant swapped", tamper(thumb, "variant", "web"), "", http.StatusForbidden},
+		{"expiry extended", tamper(asset.DownloadURL, "expires", strconv.FormatInt(time.Now().Add(24*time.Hour).Unix(), 10)), "", http.StatusForbidden},
+		{"another asset", strings.Replace(asset.DownloadURL, asset.ID, otherAsset, 1), "", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		if res := call(t, http.MethodGet, tt.url, tt.token, nil, nil); res.StatusCode != tt.want {
+			t.Errorf("%s: %s, want %d", tt.name, res.Status, tt.want)
+		}
+	}
+
+	// An expired link: signed in the past by the same signer.
+	signer := newDownloadSigner([]byte("test signing key"))
+	old := signer.path(asset.ID, "", time.Now().Add(-time.Hour))
+	if res := call(t, http.MethodGet, srv.URL+old, "", nil, nil); res.StatusCode != http.StatusForbidden {
+		t.Errorf("link signed an hour ago: %s", res.Status)
+	}
+	// Download and upload signatures are not interchangeable.
+	upload, _ := url.Parse(ticket.UploadURL)
+	if res := call(t, http.MethodGet, srv.URL+asset.URL+"?"+upload.RawQuery, "", nil, nil); res.StatusCode != http.StatusForbidden {
+		t.Errorf("upload signature used to download: %s", res.Status)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Attribution is how a visitor reached the pre-audit form. The website sends
+// it next to the form fields in the /gatekeeper body.
+type Attribution struct {
+	Source      string `json:"utm_source,omitempty"`
+	Medium      string `json:"utm_medium,omitempty"`
+	Campaign    string `json:"utm_campaign,omitempty"`
+	Term        string `json:"utm_term,omitempty"`
+	Content     string `json:"utm_content,omitempty"`
+	Referrer    string `json:"referrer,omitempty"`
+	LandingPage string `json:"landing_page,omitempty"`
+}
+
+// gatekeeperRequest is the body accepted by /gatekeeper.
+type gatekeeperRequest struct {
+	AuditData
+	Attribution *Attribution `json:"attribution,omitempty"`
+}
+
+const maxAttributionField = 500
+
+// clean trims every field and caps its length; the values come straight from
+// the browser's URL and referrer.
+func (a *Attribution) clean() *Attribution {
+	if a == nil {
+		return nil
+	}
+	out := *a
+	for _, f := range []*string{&out.Source, &out.Medium, &out.Campaign, &out.Term, &out.Content, &out.Referrer, &out.LandingPage} {
+		*f = strings.TrimSpace(*f)
+		if len(*f) > maxAttributionField {
+			// Cut on a rune boundary so the stored text stays valid UTF-8.
+			n := maxAttributionField
+			for n > 0 && !utf8.RuneStart((*f)[n]) {
+				n--
+			}
+			*f = (*f)[:n]
+		}
+	}
+	if out == (Attribution{}) {
+		return nil
+	}
+	return &out
+}
+
+// channel returns the source and medium a submission is credited to: the
+// UTM source if present, otherwise the referring host, otherwise direct.
+func (a *Attribution) channel() (source, medium string) {
+	if a == nil {
+		return "(direct)", "(none)"
+	}
+	if a.Source != "" {
+		medium = a.Medium
+		if medium == "" {
+			medium = "(not set)"
+		}
+		return strings.ToLower(a.Source), strings.ToLower(medium)
+	}
+	if u, err := url.Parse(a.Referrer); err == nil && u.Host != "" {
+		return strings.TrimPrefix(strings.ToLower(u.Host), "www."), "referral"
+	}
+	return "(direct)", "(none)"
+}
+
+func (a *Attribution) campaign() string {
+	if a == nil || a.Campaign == "" {
+		return "(none)"
+	}
+	return a.Campaign
+}
+
+type AttributionRow struct {
+	Source         string  `json:"source"`
+	Medium         string  `json:"medium"`
+	Campaign       string  `json:"campaign"`
+	Submissions    int     `json:"submissions"`
+	Conversions    int     `json:"conversions"`
+	ConversionRate float64 `json:"conversion_rate"`
+}
+
+// AttributionReport groups submissions received in [from, to) by source,
+// medium and campaign. A submission counts as converted once it is booked.
+func AttributionReport(subs []Submission, from, to time.Time) []AttributionRow {
+	type key struct{ source, medium, campaign string }
+	rows := map[key]*AttributionRow{}
+	for _, sub := range subs {
+		if (!from.IsZero() && sub.ReceivedAt.Before(from)) || (!to.IsZero() && !sub.ReceivedAt.Before(to)) {
+			continue
+		}
+		source, medium := sub.Attribution.channel()
+		k := key{source, medium, sub.Attribution.campaign()}
+		row, ok := rows[k]
+		if !ok {
+			row = &AttributionRow{Source: k.source, Medium: k.medium, Campaign: k.campaign}
+			rows[k] = row
+		}
+		row.Submissions++
+		if sub.Booking != nil {
+			row.Conversions++
+		}
+	}
+
+	out := []AttributionRow{}
+	for _, row := range rows {
+		row.ConversionRate = float64(row.Conversions) / float64(row.Submissions)
+		out = append(out, *row)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Submissions != out[j].Submissions {
+			return out[i].Submissions > out[j].Submissions
+		}
+		if out[i].Source != out[j].Source {
+			return out[i].Source < out[j].Source
+		}
+		return out[i].Campaign < out[j].Campaign
+	})
+	return out
+}
+
+func registerAttributionRoutes(mux *http.ServeMux, subs *SubmissionStore) {
+	mux.Handle("GET /reports/attribution", requireOffice(func(w http.ResponseWriter, r *http.Request) {
+		var from, to time.Time
+		for _, p := range []struct {
+			name string
+			dst  *time.Time
+		}{{"from", &from}, {"to", &to}} {
+			v := r.URL.Query().Get(p.name)
+			if v == "" {
+				continue
+			}
+			t, err := time.Parse(time.DateOnly, v)
+			if err != nil {
+				http.Error(w, p.name+" must be a YYYY-MM-DD date", http.StatusBadRequest)
+				return
+			}
+			*p.dst = t
+		}
+		// Both bounds are inclusive dates.
+		if !to.IsZero() {
+			to = to.AddDate(0, 0, 1)
+		}
+		writeJSON(w, http.StatusOK, AttributionReport(subs.List(), from, to))
+	}))
+}
@@ -0,0 +1,16 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestAttributionCleanKeepsRunesWhole(t *testing.T) {
+	// "é" is two bytes, so the limit falls inside the last one.
+	long := strings.Repeat("a", maxAttributionField-1) + "éé"
+	got := (&Attribution{Campaign: long}).clean()
+	if !utf8.ValidString(got.Campaign) || got.Campaign != strings.Repeat("a", maxAttributionField-1) {
+		t.Errorf("campaign cut to %d bytes, valid %v", len(got.Campaign), utf8.ValidString(got.Campaign))
+	}
+}
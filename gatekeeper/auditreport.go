@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// sectionTitles are the display names of sections in client documents.
+var sectionTitles = map[AuditSection]string{
+	SectionGeneral:       "General",
+	SectionPointOfUse:    "Point of Use",
+	SectionDecon:         "Decontamination",
+	SectionPrepandPack:   "Prep and Pack",
+	SectionSterilization: "Sterilization",
+	SectionStorage:       "Sterile Storage",
+}
+
+// ComplianceCounts tallies responses by status. Findings without a response
+// count as unanswered.
+type ComplianceCounts struct {
+	Compliant    int `json:"compliant"`
+	NonCompliant int `json:"nonCompliant"`
+	NA           int `json:"na"`
+	Unanswered   int `json:"unanswered"`
+}
+
+func (c *ComplianceCounts) add(status ResponseStatus) {
+	switch status {
+	case StatusCompliant:
+		c.Compliant++
+	case StatusNonCompliant:
+		c.NonCompliant++
+	case StatusNA:
+		c.NA++
+	default:
+		c.Unanswered++
+	}
+}
+
+// Rate is the share of assessed items that were compliant, as a percentage.
+// N/A and unanswered items are not assessed.
+func (c ComplianceCounts) Rate() float64 {
+	assessed := c.Compliant + c.NonCompliant
+	if assessed == 0 {
+		return 0
+	}
+	return float64(c.Compliant) * 100 / float64(assessed)
+}
+
+// RateText formats Rate for documents, with "n/a" when nothing was assessed.
+func (c ComplianceCounts) RateText() string {
+	if c.Compliant+c.NonCompliant == 0 {
+		return "n/a"
+	}
+	return fmt.Sprintf("%.0f%%", c.Rate())
+}
+
+func (c ComplianceCounts) Total() int {
+	return c.Compliant + c.NonCompliant + c.NA + c.Unanswered
+}
+
+type ReportPhoto struct {
+	Ref     string
+	Caption string
+}
+
+// ReportItem is a non-compliant finding as it appears in the final report.
+type ReportItem struct {
+	Number         string
+	Subject        string
+	Issue          string
+	Rationale      string
+	Recommendation string
+	Reference      string
+	Risk           string
+	Notes          string
+	Photos         []ReportPhoto
+}
+
+// RiskCounts tallies non-compliant items by risk level.
+type RiskCounts struct {
+	Critical, Major, Minor, Unrated int
+}
+
+func (c *RiskCounts) add(r RiskLevel) {
+	switch r {
+	case RiskCritical:
+		c.Critical++
+	case RiskMajor:
+		c.Major++
+	case RiskMinor:
+		c.Minor++
+	default:
+		c.Unrated++
+	}
+}
+
+type ReportSection struct {
+	Title  string
+	Counts ComplianceCounts
+	Items  []ReportItem
+}
+
+// AuditReportData is what final report templates are executed against.
+type AuditReportData struct {
+	Instance       AuditInstance
+	Date           string
+	Draft          bool
+	CompletedBy    string
+	Overall        ComplianceCounts
+	Risks          RiskCounts
+	Sections       []ReportSection
+	CriticalIssues []string
+	Approval       *ReportApproval
+}
+
+// ReportApproval is the sign-off printed on an approved report, with the
+// content hash a reader can check against the approval trail.
+type ReportApproval struct {
+	By   string
+	On   string
+	Hash string
+}
+
+// responseRisk is the response's own risk level, falling back to the
+// finding's default.
+func responseRisk(f AuditFinding, resp AuditResponse) RiskLevel {
+	if resp.RiskLevel != "" {
+		return resp.RiskLevel
+	}
+	return f.RiskLevel
+}
+
+// walkFindings calls fn for every finding of master in report order, with the
+// instance's response or an unanswered placeholder.
+func walkFindings(master AuditReport, inst AuditInstance, fn func(section AuditSection, f AuditFinding, resp AuditResponse)) {
+	for _, section := range auditSections {
+		for _, f := range master.Sections[section] {
+			resp, ok := inst.Responses[f.ID]
+			if !ok {
+				resp = AuditResponse{FindingID: f.ID, Status: StatusUnanswered}
+			}
+			fn(section, f, resp)
+		}
+	}
+}
+
+// buildAuditReportData lays out the report. Until it is approved the
+// report is marked as a draft; reports released before the review
+// workflow have no approval to show.
+func buildAuditReportData(inst AuditInstance, master AuditReport, approval *ReviewStep, now time.Time) AuditReportData {
+	// Names are typed by auditors and go into headings and table rows.
+	inst.FacilityName = oneLine(inst.FacilityName)
+	inst.EngagementPartner = oneLine(inst.EngagementPartner)
+	completedBy := make([]string, len(inst.CompletedBy))
+	for i, name := range inst.CompletedBy {
+		completedBy[i] = oneLine(name)
+	}
+	d := AuditReportData{
+		Instance:    inst,
+		Date:        now.Format("January 2, 2006"),
+		Draft:       inst.Status != InstanceApproved,
+		CompletedBy: strings.Join(completedBy, ", "),
+	}
+	if approval != nil {
+		d.Approval = &ReportApproval{
+			By:   oneLine(approval.UserName),
+			On:   time.UnixMilli(approval.CreatedAt).Format("January 2, 2006"),
+			Hash: approval.ContentHash,
+		}
+	}
+	bySection := map[AuditSection]*ReportSection{}
+	walkFindings(master, inst, func(section AuditSection, f AuditFinding, resp AuditResponse) {
+		s, ok := bySection[section]
+		if !ok {
+			s = &ReportSection{Title: sectionTitles[section]}
+			bySection[section] = s
+		}
+		s.Counts.add(resp.Status)
+		d.Overall.add(resp.Status)
+		if resp.Status != StatusNonCompliant {
+			return
+		}
+
+		risk := responseRisk(f, resp)
+		d.Risks.add(risk)
+		item := ReportItem{
+			Number:         fmt.Sprintf("%d.%d", len(bySection), len(s.Items)+1),
+			Subject:        oneLine(f.EquipmentSubject),
+			Issue:          oneLine(f.Issue),
+			Rationale:      oneLine(f.Rationale),
+			Recommendation: oneLine(f.Recommendation),
+			Reference:      oneLine(f.AAMIReference),
+			Risk:           string(risk),
+			Notes:          oneLine(resp.Notes),
+		}
+		if item.Risk == "" {
+			item.Risk = "Not rated"
+		}
+		for i, ref := range resp.Images {
+			item.Photos = append(item.Photos, ReportPhoto{Ref: ref, Caption: fmt.Sprintf("Photo %s.%d", item.Number, i+1)})
+		}
+		if risk == RiskCritical {
+			d.CriticalIssues = append(d.CriticalIssues, fmt.Sprintf("%s: %s", s.Title, item.Issue))
+		}
+		s.Items = append(s.Items, item)
+	})
+	for _, section := range auditSections {
+		if s, ok := bySection[section]; ok {
+			d.Sections = append(d.Sections, *s)
+		}
+	}
+	return d
+}
+
+var assetURLPattern = regexp.MustCompile(`/realm/assets/([0-9a-f]+)/content`)
+
+// loadReportPhoto fetches the bytes behind an AuditResponse image: an
+// uploaded asset (preferring its web-sized JPEG) or an inline data URL from
+// before uploads went to the server. It returns nil for anything else.
+func loadReportPhoto(ctx context.Context, assets *AssetStore, blobs BlobStore, ref string) []byte {
+	if rest, ok := strings.CutPrefix(ref, "data:"); ok {
+		meta, payload, ok := strings.Cut(rest, ",")
+		if !ok || !strings.HasSuffix(meta, ";base64") {
+			return nil
+		}
+		data, err := base64.StdEncoding.DecodeString(payload)
+		if err != nil {
+			return nil
+		}
+		return data
+	}
+	m := assetURLPattern.FindStringSubmatch(ref)
+	if m == nil {
+		return nil
+	}
+	asset, err := assets.Get(m[1])
+	if err != nil || asset.Status != AssetUploaded {
+		return nil
+	}
+	key := asset.Key
+	for _, v := range asset.Variants {
+		if v.Name == "web" && v.ContentType == "image/jpeg" {
+			key = v.Key
+		}
+	}
+	body, err := blobs.Open(ctx, key)
+	if err != nil {
+		return nil
+	}
+	defer body.Close()
+	data, err := io.ReadAll(io.LimitReader(body, maxAssetSize))
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+var errNoMaster = errors.New("no master checklist for this category")
+
+// GenerateAuditReport renders the final client report for an instance as PDF.
+// approval is the step that approved its current content, if any.
+func GenerateAuditReport(inst AuditInstance, master AuditReport, approval *ReviewStep, loadPhoto func(ref string) []byte) ([]byte, error) {
+	tmpl, err := loadDocTemplate("AUDIT_REPORT_TEMPLATE", "audit_report.tmpl")
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, buildAuditReportData(inst, master, approval, time.Now())); err != nil {
+		return nil, err
+	}
+	blocks := parseDocMarkup(buf.String())
+	resolveDocImages(blocks, loadPhoto)
+	return renderPDF(fmt.Sprintf("%s Audit – %s", inst.Category, oneLine(inst.FacilityName)), blocks)
+}
+
+func registerAuditReportRoutes(mux *http.ServeMux, ws *WorkspaceStore, assets *AssetStore, blobs BlobStore) {
+	mux.Handle("GET /realm/instances/{id}/report", realmRoute(func(w http.ResponseWriter, r *http.Request) {
+		inst, err := ws.Instance(r.PathValue("id"))
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		// Drafts can be previewed; the report marks them as such.
+		if inst.Status == InstanceDraft && r.URL.Query().Get("preview") == "" {
+			http.Error(w, "the audit has not been submitted; add ?preview=1 for a draft report", http.StatusConflict)
+			return
+		}
+		master, err := ws.MasterFor(inst)
+		if errors.Is(err, errNotFound) {
+			http.Error(w, errNoMaster.Error(), http.StatusConflict)
+			return
+		}
+		body, err := GenerateAuditReport(inst, master, ws.Approval(inst.ID), func(ref string) []byte {
+			return loadReportPhoto(r.Context(), assets, blobs, ref)
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeDocument(w, slugify(inst.FacilityName+" "+string(inst.Category)+" audit report"), "pdf", body)
+	}))
+}
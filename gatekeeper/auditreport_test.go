@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuditReportKeepsNamesOnOneLine(t *testing.T) {
+	master, _ := testMasters()
+	inst := AuditInstance{
+		FacilityName:      "Mercy | North\n# Injected",
+		Category:          CategoryCSSD,
+		Status:            InstanceDraft,
+		EngagementPartner: "Lee Chen |\n| Approved By | Someone",
+		CompletedBy:       []string{"Ana\nRuiz", "Sam | Lee"},
+		Responses:         map[string]AuditResponse{},
+	}
+	tmpl, err := loadDocTemplate("AUDIT_REPORT_TEMPLATE", "audit_report.tmpl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, buildAuditReportData(inst, master, nil, time.Now())); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, line := range strings.Split(out, "\n") {
+		if strings.HasPrefix(line, "# Injected") || strings.HasPrefix(line, "| Approved By") {
+			t.Errorf("a name added a line: %q", line)
+		}
+	}
+	for _, row := range []string{
+		"| Facility | Mercy / North # Injected |",
+		"| Engagement Partner | Lee Chen / / Approved By / Someone |",
+		"| Completed By | Ana Ruiz, Sam / Lee |",
+	} {
+		if !strings.Contains(out, row) {
+			t.Errorf("missing row %q in:\n%s", row, out)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+)
+
+type Role string
+
+const (
+	RoleAdmin   Role = "admin"
+	RolePartner Role = "engagement_partner"
+	RoleAuditor Role = "auditor"
+	// RoleClient users are facility staff. They see only the client portal
+	// of the facility on their account.
+	RoleClient Role = "client"
+)
+
+func (r Role) valid() bool {
+	switch r {
+	case RoleAdmin, RolePartner, RoleAuditor, RoleClient:
+		return true
+	}
+	return false
+}
+
+// Principal is who a request is authenticated as. Via is "session",
+// "token" or "admin_key".
+type Principal struct {
+	User User
+	Via  string
+}
+
+type principalKey struct{}
+
+func principalFrom(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(Principal)
+	return p, ok
+}
+
+const sessionCookie = "gk_session"
+
+// requestToken finds the credential on a request: a bearer token, the
+// session cookie or, for WebSocket upgrades only since browsers cannot set
+// headers on those, ?access_token=.
+func requestToken(r *http.Request) string {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return token
+	}
+	if c, err := r.Cookie(sessionCookie); err == nil {
+		return c.Value
+	}
+	if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		return r.URL.Query().Get("access_token")
+	}
+	return ""
+}
+
+// authenticate resolves the caller of every request and stores it in the
+// request context. It never rejects a request itself; routes state who may
+// call them with requireRole.
+func authenticate(users *UserStore, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token := requestToken(r); token != "" {
+			if p, ok := users.Principal(token); ok {
+				r = r.WithContext(context.WithValue(r.Context(), principalKey{}, p))
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireRole lets through callers holding one of roles: 401 without
+// credentials, 403 with the wrong role.
+func requireRole(next http.HandlerFunc, roles ...Role) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, ok := principalFrom(r.Context())
+		if !ok {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if !slices.Contains(roles, p.User.Role) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	})
+}
+
+// requireAdmin guards user management.
+func requireAdmin(next http.HandlerFunc) http.Handler {
+	return requireRole(next, RoleAdmin)
+}
+
+// requireOffice guards the business side: submissions, bookings, estimates,
+// proposals and the facility registry.
+func requireOffice(next http.HandlerFunc) http.Handler {
+	return requireRole(next, RoleAdmin, RolePartner)
+}
+
+// requireStaff guards the audit workspace.
+func requireStaff(next http.HandlerFunc) http.Handler {
+	return requireRole(next, RoleAdmin, RolePartner, RoleAuditor)
+}
+
+// validAdminToken checks the shared ADMIN_API_KEY, which acts as an admin
+// for bootstrapping and service-to-service calls. When the key is unset it
+// matches nothing.
+func validAdminToken(token string) bool {
+	key := os.Getenv("ADMIN_API_KEY")
+	return key != "" && subtle.ConstantTimeCompare([]byte(token), []byte(key)) == 1
+}
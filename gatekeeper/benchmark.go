@@ -0,0 +1,610 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+)
+
+//go:embed benchmark.json
+var defaultBenchmarkConfig []byte
+
+// ORBand groups facilities by main OR count. Max is inclusive; 0 means no
+// upper bound.
+type ORBand struct {
+	Label string `json:"label"`
+	Max   int    `json:"max"`
+}
+
+// BenchmarkConfig is loaded from BENCHMARK_CONFIG, or from the
+// benchmark.json compiled into the binary when that variable is unset.
+// MinFacilities is the k of k-anonymity: no figure is published that draws
+// on fewer distinct facilities.
+type BenchmarkConfig struct {
+	MinFacilities int       `json:"min_facilities"`
+	Percentiles   []float64 `json:"percentiles"`
+	ORBands       []ORBand  `json:"or_bands"`
+	TopFindings   int       `json:"top_findings"`
+}
+
+func (c BenchmarkConfig) validate() error {
+	if c.MinFacilities < 2 {
+		return errors.New("min_facilities must be at least 2")
+	}
+	// The 0th and 100th percentiles are the lowest and highest scores,
+	// each of which is one facility's.
+	for _, p := range c.Percentiles {
+		if p <= 0 || p >= 100 {
+			return fmt.Errorf("percentile %v must be strictly between 0 and 100", p)
+		}
+	}
+	for i, b := range c.ORBands {
+		if b.Label == "" {
+			return errors.New("every OR band needs a label")
+		}
+		if i > 0 && b.Max != 0 && b.Max <= c.ORBands[i-1].Max {
+			return errors.New("OR bands must be in increasing order")
+		}
+		if b.Max == 0 && i != len(c.ORBands)-1 {
+			return errors.New("only the last OR band may be open-ended")
+		}
+	}
+	return nil
+}
+
+// band returns the label of the OR band for a count, or "" when the count is
+// unknown.
+func (c BenchmarkConfig) band(ors int) string {
+	if ors <= 0 {
+		return ""
+	}
+	for _, b := range c.ORBands {
+		if b.Max == 0 || ors <= b.Max {
+			return b.Label
+		}
+	}
+	return ""
+}
+
+// Distribution summarises scores as percentiles, keyed "p50" and so on.
+type Distribution struct {
+	Count       int                `json:"count"`
+	Percentiles map[string]float64 `json:"percentiles"`
+}
+
+type SectionDistribution struct {
+	Section AuditSection `json:"section"`
+	Title   string       `json:"title"`
+	Distribution
+}
+
+// FindingFrequency is how often a master finding was non-compliant among
+// the facilities where it was assessed. Findings are matched across master
+// versions by their text.
+type FindingFrequency struct {
+	Section          AuditSection `json:"section"`
+	EquipmentSubject string       `json:"equipmentSubject"`
+	Issue            string       `json:"issue"`
+	Assessed         int          `json:"assessed"`
+	NonCompliantRate float64      `json:"nonCompliantRate"`
+}
+
+// Benchmark is the anonymised picture of one peer group. An empty
+// TraumaLevel or ORBand means the group spans all of them; Widened names
+// what a lookup had to drop to reach MinFacilities.
+type Benchmark struct {
+	Category    AuditCategory         `json:"category"`
+	TraumaLevel string                `json:"traumaLevel,omitempty"`
+	ORBand      string                `json:"orBand,omitempty"`
+	Widened     []string              `json:"widened,omitempty"`
+	Facilities  int                   `json:"facilities"`
+	Overall     Distribution          `json:"overall"`
+	Sections    []SectionDistribution `json:"sections"`
+	Findings    []FindingFrequency    `json:"findings"`
+}
+
+type benchmarkKey struct {
+	category AuditCategory
+	trauma   string
+	band     string
+}
+
+type findingOutcome struct {
+	section      AuditSection
+	finding      AuditFinding
+	nonCompliant bool
+}
+
+// benchmarkSample is one facility's latest completed audit in a category.
+type benchmarkSample struct {
+	facility  string
+	trauma    string
+	band      string
+	updatedAt int64
+	scores    InstanceScores
+	outcomes  map[string]findingOutcome // by findingKey
+}
+
+// Benchmarker aggregates completed audits into peer-group benchmarks. It
+// reads the stores on every call; there are few enough audits that caching
+// is not worth the staleness.
+type Benchmarker struct {
+	cfg      BenchmarkConfig
+	ws       *WorkspaceStore
+	registry *FacilityRegistry
+	subs     *SubmissionStore
+	scorer   *ComplianceScorer
+}
+
+func LoadBenchmarker(path string, ws *WorkspaceStore, registry *FacilityRegistry, subs *SubmissionStore, scorer *ComplianceScorer) (*Benchmarker, error) {
+	raw := defaultBenchmarkConfig
+	if path != "" {
+		var err error
+		if raw, err = os.ReadFile(path); err != nil {
+			return nil, err
+		}
+	}
+	var cfg BenchmarkConfig
+	if err := json.Unmarshal(raw, &cfg); err != nil {
+		return nil, err
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("benchmark config: %w", err)
+	}
+	return &Benchmarker{cfg: cfg, ws: ws, registry: registry, subs: subs, scorer: scorer}, nil
+}
+
+// profile finds the facility behind an instance and the trauma level and
+// OR band from its most recent audit request. The identity is the linked
+// registry ID, otherwise the normalised name; an unlinked instance counts
+// towards the overall benchmark but no peer group.
+func (b *Benchmarker) profile(inst AuditInstance) (facility, trauma, band string) {
+	f, err := b.registry.Get(inst.FacilityID)
+	if inst.FacilityID == "" || err != nil {
+		return "name:" + normalizeFacilityName(inst.FacilityName), "", ""
+	}
+	var latest *Submission
+	for _, id := range f.SubmissionIDs {
+		if sub, err := b.subs.Get(id); err == nil && (latest == nil || sub.ReceivedAt.After(latest.ReceivedAt)) {
+			latest = &sub
+		}
+	}
+	if latest == nil {
+		return f.ID, "", ""
+	}
+	return f.ID, latest.Data.TraumaLevel, b.cfg.band(parseCount(latest.Data.OrCount))
+}
+
+func (b *Benchmarker) sample(inst AuditInstance) (benchmarkSample, bool) {
+	master, err := b.ws.MasterFor(inst)
+	if err != nil {
+		return benchmarkSample{}, false
+	}
+	s := benchmarkSample{
+		updatedAt: inst.UpdatedAt,
+		scores:    b.scorer.Score(inst, master),
+		outcomes:  map[string]findingOutcome{},
+	}
+	s.facility, s.trauma, s.band = b.profile(inst)
+	walkFindings(master, inst, func(section AuditSection, f AuditFinding, resp AuditResponse) {
+		if resp.Status == StatusCompliant || resp.Status == StatusNonCompliant {
+			s.outcomes[findingKey(f)] = findingOutcome{section, f, resp.Status == StatusNonCompliant}
+		}
+	})
+	return s, true
+}
+
+// samples collects each facility's latest completed audit per category, so
+// a facility audited every year still counts once.
+func (b *Benchmarker) samples() map[AuditCategory][]benchmarkSample {
+	latest := map[[2]string]benchmarkSample{}
+	for _, inst := range b.ws.Instances() {
+		if !clientVisible(inst) {
+			continue
+		}
+		s, ok := b.sample(inst)
+		if !ok {
+			continue
+		}
+		key := [2]string{string(inst.Category), s.facility}
+		if prev, ok := latest[key]; !ok || s.updatedAt > prev.updatedAt {
+			latest[key] = s
+		}
+	}
+	out := map[AuditCategory][]benchmarkSample{}
+	for key, s := range latest {
+		out[AuditCategory(key[0])] = append(out[AuditCategory(key[0])], s)
+	}
+	return out
+}
+
+// in reports whether a sample belongs to a peer group. Samples with an
+// unknown trauma level or OR count only join groups that span all of them.
+func (k benchmarkKey) in(s benchmarkSample) bool {
+	return (k.trauma == "" || k.trauma == s.trauma) && (k.band == "" || k.band == s.band)
+}
+
+// percentile interpolates linearly between closest ranks of sorted values.
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 1 {
+		return sorted[0]
+	}
+	pos := p / 100 * float64(len(sorted)-1)
+	lo := int(math.Floor(pos))
+	hi := min(lo+1, len(sorted)-1)
+	v := sorted[lo] + (sorted[hi]-sorted[lo])*(pos-float64(lo))
+	return math.Round(v*10) / 10
+}
+
+func (b *Benchmarker) distribution(values []float64) Distribution {
+	sort.Float64s(values)
+	d := Distribution{Count: len(values), Percentiles: map[string]float64{}}
+	for _, p := range b.cfg.Percentiles {
+		d.Percentiles["p"+strconv.FormatFloat(p, 'f', -1, 64)] = percentile(values, p)
+	}
+	return d
+}
+
+// build aggregates a peer group, or returns false when it has fewer than
+// MinFacilities facilities. Section distributions and finding frequencies
+// are held to the same threshold on their own counts.
+func (b *Benchmarker) build(key benchmarkKey, samples []benchmarkSample) (Benchmark, []benchmarkSample, bool) {
+	var members []benchmarkSample
+	for _, s := range samples {
+		if key.in(s) {
+			members = append(members, s)
+		}
+	}
+	k := b.cfg.MinFacilities
+	if len(members) < k {
+		return Benchmark{}, nil, false
+	}
+	out := Benchmark{
+		Category:    key.category,
+		TraumaLevel: key.trauma,
+		ORBand:      key.band,
+		Facilities:  len(members),
+		Sections:    []SectionDistribution{},
+		Findings:    []FindingFrequency{},
+	}
+
+	var overall []float64
+	bySection := map[AuditSection][]float64{}
+	type tally struct {
+		outcome                findingOutcome
+		assessed, nonCompliant int
+	}
+	findings := map[string]*tally{}
+	for _, s := range members {
+		if v := s.scores.Overall.Score; v != nil {
+			overall = append(overall, *v)
+		}
+		for _, sec := range s.scores.Sections {
+			if sec.Score.Score != nil {
+				bySection[sec.Section] = append(bySection[sec.Section], *sec.Score.Score)
+			}
+		}
+		for fk, o := range s.outcomes {
+			t, ok := findings[fk]
+			if !ok {
+				t = &tally{outcome: o}
+				findings[fk] = t
+			}
+			t.assessed++
+			if o.nonCompliant {
+				t.nonCompliant++
+			}
+		}
+	}
+	if len(overall) < k {
+		return Benchmark{}, nil, false
+	}
+	out.Overall = b.distribution(overall)
+	for _, section := range auditSections {
+		if values := bySection[section]; len(values) >= k {
+			out.Sections = append(out.Sections, SectionDistribution{Section: section, Title: sectionTitles[section], Distribution: b.distribution(values)})
+		}
+	}
+	for _, t := range findings {
+		if t.assessed < k || t.nonCompliant == 0 {
+			continue
+		}
+		out.Findings = append(out.Findings, FindingFrequency{
+			Section:          t.outcome.section,
+			EquipmentSubject: t.outcome.finding.EquipmentSubject,
+			Issue:            t.outcome.finding.Issue,
+			Assessed:         t.assessed,
+			NonCompliantRate: math.Round(float64(t.nonCompliant)/float64(t.assessed)*1000) / 10,
+		})
+	}
+	sort.Slice(out.Findings, func(i, j int) bool {
+		a, c := out.Findings[i], out.Findings[j]
+		if a.NonCompliantRate != c.NonCompliantRate {
+			return a.NonCompliantRate > c.NonCompliantRate
+		}
+		if a.Assessed != c.Assessed {
+			return a.Assessed > c.Assessed
+		}
+		return a.Issue < c.Issue
+	})
+	if b.cfg.TopFindings > 0 && len(out.Findings) > b.cfg.TopFindings {
+		out.Findings = out.Findings[:b.cfg.TopFindings]
+	}
+	return out, members, true
+}
+
+type peerGroup struct {
+	bm      Benchmark
+	members []benchmarkSample
+}
+
+// groups builds the peer groups of a category that may be published: those
+// that meet the threshold and survive differencing, see differencingSafe.
+func (b *Benchmarker) groups(category AuditCategory, samples []benchmarkSample) map[benchmarkKey]peerGroup {
+	keys := map[benchmarkKey]bool{{category: category}: true}
+	for _, s := range samples {
+		if s.trauma != "" {
+			keys[benchmarkKey{category, s.trauma, ""}] = true
+		}
+		if s.band != "" {
+			keys[benchmarkKey{category, "", s.band}] = true
+		}
+		if s.trauma != "" && s.band != "" {
+			keys[benchmarkKey{category, s.trauma, s.band}] = true
+		}
+	}
+	built := map[benchmarkKey]peerGroup{}
+	sizes := map[benchmarkKey]int{}
+	for key := range keys {
+		if bm, members, ok := b.build(key, samples); ok {
+			built[key] = peerGroup{bm, members}
+			sizes[key] = len(members)
+		}
+	}
+	safe := differencingSafe(sizes, b.cfg.MinFacilities)
+	for key := range built {
+		if !safe[key] {
+			delete(built, key)
+		}
+	}
+	return built
+}
+
+// differencingSafe picks which of the peer groups, given by size, can be
+// published together. Groups overlap, so subtracting the published
+// subgroups of a group from it describes the facilities left over; when
+// fewer than k are left, the smallest subgroup is withheld until there are
+// enough. Subgroups are taken along the trauma level, the OR band or both,
+// which split a group into disjoint parts.
+func differencingSafe(sizes map[benchmarkKey]int, k int) map[benchmarkKey]bool {
+	keys := make([]benchmarkKey, 0, len(sizes))
+	for key := range sizes {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, c := keys[i], keys[j]
+		if sizes[a] != sizes[c] {
+			return sizes[a] < sizes[c]
+		}
+		if a.trauma != c.trauma {
+			return a.trauma < c.trauma
+		}
+		return a.band < c.band
+	})
+	published := map[benchmarkKey]bool{}
+	for _, key := range keys {
+		published[key] = true
+	}
+	// splits reports whether child is one part of parent split along the
+	// given dimensions.
+	splits := func(parent, child benchmarkKey, trauma, band bool) bool {
+		if child.category != parent.category || (!trauma && !band) {
+			return false
+		}
+		if trauma && (parent.trauma != "" || child.trauma == "") || !trauma && child.trauma != parent.trauma {
+			return false
+		}
+		if band && (parent.band != "" || child.band == "") || !band && child.band != parent.band {
+			return false
+		}
+		return true
+	}
+	for changed := true; changed; {
+		changed = false
+		for _, parent := range keys {
+			if !published[parent] {
+				continue
+			}
+			for _, dims := range [][2]bool{{true, false}, {false, true}, {true, true}} {
+				left := sizes[parent]
+				var parts []benchmarkKey // smallest first
+				for _, child := range keys {
+					if published[child] && splits(parent, child, dims[0], dims[1]) {
+						left -= sizes[child]
+						parts = append(parts, child)
+					}
+				}
+				if len(parts) > 0 && left > 0 && left < k {
+					published[parts[0]] = false
+					changed = true
+				}
+			}
+		}
+	}
+	return published
+}
+
+// All returns every peer group that may be published, broadest first
+// within each category.
+func (b *Benchmarker) All() []Benchmark {
+	out := []Benchmark{}
+	all := b.samples()
+	for _, cat := range auditCategories {
+		var group []Benchmark
+		for _, g := range b.groups(cat, all[cat]) {
+			group = append(group, g.bm)
+		}
+		sort.Slice(group, func(i, j int) bool {
+			gi, gj := group[i], group[j]
+			si, sj := specificity(gi), specificity(gj)
+			if si != sj {
+				return si < sj
+			}
+			if gi.TraumaLevel != gj.TraumaLevel {
+				return gi.TraumaLevel < gj.TraumaLevel
+			}
+			return gi.ORBand < gj.ORBand
+		})
+		out = append(out, group...)
+	}
+	return out
+}
+
+func specificity(bm Benchmark) int {
+	n := 0
+	if bm.TraumaLevel != "" {
+		n++
+	}
+	if bm.ORBand != "" {
+		n++
+	}
+	return n
+}
+
+// Lookup returns the most specific publishable peer group for a facility
+// profile, widening first on OR band, then on trauma level.
+// Widened lists the dimensions that were given but had to be dropped.
+func (b *Benchmarker) Lookup(category AuditCategory, trauma string, ors int) (Benchmark, bool) {
+	bm, _, ok := b.lookup(b.samples()[category], category, trauma, b.cfg.band(ors))
+	return bm, ok
+}
+
+func (b *Benchmarker) lookup(samples []benchmarkSample, category AuditCategory, trauma, band string) (Benchmark, []benchmarkSample, bool) {
+	groups := b.groups(category, samples)
+	for _, key := range []benchmarkKey{
+		{category, trauma, band},
+		{category, trauma, ""},
+		{category, "", band},
+		{category, "", ""},
+	} {
+		g, ok := groups[key]
+		if !ok {
+			continue
+		}
+		bm, members := g.bm, g.members
+		if trauma != "" && key.trauma == "" {
+			bm.Widened = append(bm.Widened, "traumaLevel")
+		}
+		if band != "" && key.band == "" {
+			bm.Widened = append(bm.Widened, "orBand")
+		}
+		return bm, members, true
+	}
+	return Benchmark{}, nil, false
+}
+
+// InstanceBenchmark places one audit within its peer group. Ranks are the
+// percentage of peer scores at or below the audit's own; they are omitted
+// when no group meets the threshold.
+type InstanceBenchmark struct {
+	InstanceID   string                   `json:"instanceId"`
+	Score        *float64                 `json:"score"`
+	PercentRank  *float64                 `json:"percentRank"`
+	SectionRanks map[AuditSection]float64 `json:"sectionRanks"`
+	Benchmark    *Benchmark               `json:"benchmark"`
+}
+
+func percentRank(peers []float64, v float64) float64 {
+	at := 0
+	for _, p := range peers {
+		if p <= v {
+			at++
+		}
+	}
+	return math.Round(float64(at)/float64(len(peers))*1000) / 10
+}
+
+// ForInstance benchmarks an audit against the peer group for its
+// facility's profile. The audit need not be approved yet.
+func (b *Benchmarker) ForInstance(inst AuditInstance) (InstanceBenchmark, error) {
+	s, ok := b.sample(inst)
+	if !ok {
+		return InstanceBenchmark{}, errNoMaster
+	}
+	out := InstanceBenchmark{InstanceID: inst.ID, Score: s.scores.Overall.Score, SectionRanks: map[AuditSection]float64{}}
+	bm, members, ok := b.lookup(b.samples()[inst.Category], inst.Category, s.trauma, s.band)
+	if !ok {
+		return out, nil
+	}
+	out.Benchmark = &bm
+	if out.Score != nil {
+		var peers []float64
+		for _, m := range members {
+			if v := m.scores.Overall.Score; v != nil {
+				peers = append(peers, *v)
+			}
+		}
+		rank := percentRank(peers, *out.Score)
+		out.PercentRank = &rank
+	}
+	published := map[AuditSection]bool{}
+	for _, sec := range bm.Sections {
+		published[sec.Section] = true
+	}
+	for _, sec := range s.scores.Sections {
+		if sec.Score.Score == nil || !published[sec.Section] {
+			continue
+		}
+		var peers []float64
+		for _, m := range members {
+			for _, ms := range m.scores.Sections {
+				if ms.Section == sec.Section && ms.Score.Score != nil {
+					peers = append(peers, *ms.Score.Score)
+				}
+			}
+		}
+		out.SectionRanks[sec.Section] = percentRank(peers, *sec.Score.Score)
+	}
+	return out, nil
+}
+
+func registerBenchmarkRoutes(mux *http.ServeMux, ws *WorkspaceStore, benchmarks *Benchmarker) {
+	mux.Handle("GET /realm/benchmarks", realmRoute(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, benchmarks.All())
+	}))
+	// Reports call this with the facility's profile; orCount accepts the
+	// same free text as the intake form.
+	mux.Handle("GET /realm/benchmarks/lookup", realmRoute(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		category := AuditCategory(q.Get("category"))
+		if !category.valid() {
+			http.Error(w, "unknown category", http.StatusBadRequest)
+			return
+		}
+		bm, ok := benchmarks.Lookup(category, q.Get("traumaLevel"), parseCount(q.Get("orCount")))
+		if !ok {
+			http.Error(w, "not enough completed audits to benchmark this category", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, bm)
+	}))
+	mux.Handle("GET /realm/instances/{id}/benchmark", realmRoute(func(w http.ResponseWriter, r *http.Request) {
+		inst, err := ws.Instance(r.PathValue("id"))
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		out, err := benchmarks.ForInstance(inst)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		writeJSON(w, http.StatusOK, out)
+	}))
+}
@@ -0,0 +1,74 @@
+package main
+
+import "testing"
+
+func TestDifferencingSafe(t *testing.T) {
+	const cat = CategoryCSSD
+	all := benchmarkKey{category: cat}
+	t1, t2 := benchmarkKey{cat, "Level I", ""}, benchmarkKey{cat, "Level II", ""}
+	b1, b2 := benchmarkKey{cat, "", "1-5 ORs"}, benchmarkKey{cat, "", "6-15 ORs"}
+	t1b1, t1b2 := benchmarkKey{cat, "Level I", "1-5 ORs"}, benchmarkKey{cat, "Level I", "6-15 ORs"}
+
+	tests := []struct {
+		name       string
+		sizes      map[benchmarkKey]int
+		suppressed []benchmarkKey
+	}{
+		{
+			name:       "subgroup almost the whole group",
+			sizes:      map[benchmarkKey]int{all: 12, t1: 10},
+			suppressed: []benchmarkKey{t1},
+		},
+		{
+			name:  "subgroup identical to the group",
+			sizes: map[benchmarkKey]int{all: 10, t1: 10},
+		},
+		{
+			name:  "two halves",
+			sizes: map[benchmarkKey]int{all: 12, t1: 6, t2: 6},
+		},
+		{
+			name:       "two subgroups leave a few unclassified",
+			sizes:      map[benchmarkKey]int{all: 13, t1: 6, t2: 5},
+			suppressed: []benchmarkKey{t2},
+		},
+		{
+			name:       "cells leave a few in a band",
+			sizes:      map[benchmarkKey]int{all: 20, b1: 8, b2: 12, t1: 10, t1b1: 5, t1b2: 5},
+			suppressed: []benchmarkKey{t1b1},
+		},
+		{
+			name:  "cells that fill their bands",
+			sizes: map[benchmarkKey]int{all: 20, b1: 10, b2: 10, t1: 10, t1b1: 5, t1b2: 5},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := differencingSafe(tt.sizes, 5)
+			want := map[benchmarkKey]bool{}
+			for key := range tt.sizes {
+				want[key] = true
+			}
+			for _, key := range tt.suppressed {
+				want[key] = false
+			}
+			for key := range tt.sizes {
+				if got[key] != want[key] {
+					t.Errorf("%+v published = %v, want %v", key, got[key], want[key])
+				}
+			}
+		})
+	}
+}
+
+func TestBenchmarkConfigRejectsExtremePercentiles(t *testing.T) {
+	for _, p := range []float64{0, 100} {
+		cfg := BenchmarkConfig{MinFacilities: 5, Percentiles: []float64{p, 50}}
+		if err := cfg.validate(); err == nil {
+			t.Errorf("percentile %v accepted", p)
+		}
+	}
+	if err := (BenchmarkConfig{MinFacilities: 5, Percentiles: []float64{10, 90}}).validate(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// BlobStore holds audit evidence files. Clients upload straight to the store
+// through a pre-signed URL; the server only reads objects back and writes
+// derived files.
+type BlobStore interface {
+	// PresignPut returns a URL that accepts a single PUT of key until it
+	// expires. The URL may be relative to the gatekeeper's own base URL.
+	PresignPut(ctx context.Context, key string, expires time.Duration) (string, error)
+	Put(ctx context.Context, key, contentType string, r io.Reader, size int64) error
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	// Stat returns the object's size, or errNotFound if nothing was uploaded.
+	Stat(ctx context.Context, key string) (int64, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// assetSigningKey is ASSET_SIGNING_KEY, or a random key when it is unset.
+// It signs the local backend's upload URLs and all asset download URLs.
+func assetSigningKey() ([]byte, error) {
+	key := []byte(os.Getenv("ASSET_SIGNING_KEY"))
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+		log.Print("ASSET_SIGNING_KEY is not set; upload and download URLs will not survive a restart")
+	}
+	return key, nil
+}
+
+// OpenBlobStore configures the backend named by ASSET_BACKEND: "s3" for any
+// S3-compatible bucket (AWS, R2, MinIO), or "local" (the default) for files
+// under ASSET_DIR, with upload URLs signed by signingKey.
+func OpenBlobStore(signingKey []byte) (BlobStore, error) {
+	switch backend := os.Getenv("ASSET_BACKEND"); backend {
+	case "s3":
+		useSSL, _ := strconv.ParseBool(os.Getenv("S3_USE_SSL"))
+		return NewS3BlobStore(S3Config{
+			Endpoint:  os.Getenv("S3_ENDPOINT"),
+			Region:    os.Getenv("S3_REGION"),
+			Bucket:    os.Getenv("S3_BUCKET"),
+			AccessKey: os.Getenv("S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("S3_SECRET_KEY"),
+			UseSSL:    useSSL,
+		})
+	case "", "local":
+		dir := os.Getenv("ASSET_DIR")
+		if dir == "" {
+			dir = dataPath("assets")
+		}
+		return &LocalBlobStore{Dir: dir, SigningKey: signingKey}, nil
+	default:
+		return nil, fmt.Errorf("unknown ASSET_BACKEND %q", backend)
+	}
+}
+
+// LocalBlobStore keeps objects on disk. Its upload URLs point back at the
+// gatekeeper's /assets/upload route and are signed with SigningKey.
+type LocalBlobStore struct {
+	Dir        string
+	SigningKey []byte
+}
+
+func (s *LocalBlobStore) path(key string) (string, error) {
+	clean := filepath.Clean(filepath.FromSlash(key))
+	if clean == "." || filepath.IsAbs(clean) || strings.HasPrefix(clean, "..") {
+		return "", fmt.Errorf("invalid blob key %q", key)
+	}
+	return filepath.Join(s.Dir, clean), nil
+}
+
+func (s *LocalBlobStore) sign(key string, expires int64) string {
+	mac := hmac.New(sha256.New, s.SigningKey)
+	fmt.Fprintf(mac, "%s\n%d", key, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (s *LocalBlobStore) PresignPut(_ context.Context, key string, expires time.Duration) (string, error) {
+	if _, err := s.path(key); err != nil {
+		return "", err
+	}
+	exp := time.Now().Add(expires).Unix()
+	q := url.Values{"expires": {strconv.FormatInt(exp, 10)}, "signature": {s.sign(key, exp)}}
+	return "/assets/upload/" + key + "?" + q.Encode(), nil
+}
+
+// verify checks an upload URL produced by PresignPut.
+func (s *LocalBlobStore) verify(key string, q url.Values) bool {
+	exp, err := strconv.ParseInt(q.Get("expires"), 10, 64)
+	if err != nil || time.Now().Unix() > exp {
+		return false
+	}
+	return hmac.Equal([]byte(q.Get("signature")), []byte(s.sign(key, exp)))
+}
+
+func (s *LocalBlobStore) Put(_ context.Context, key, _ string, r io.Reader, _ int64) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(p), filepath.Base(p)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), p)
+}
+
+func (s *LocalBlobStore) Open(_ context.Context, key string) (io.ReadCloser, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, errNotFound
+	}
+	return f, err
+}
+
+func (s *LocalBlobStore) Stat(_ context.Context, key string) (int64, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return 0, err
+	}
+	fi, err := os.Stat(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, errNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+	return fi.Size(), nil
+}
+
+func (s *LocalBlobStore) Delete(_ context.Context, key string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// registerLocalUploadRoute serves the upload URLs handed out by a
+// LocalBlobStore. The signature is the only credential, as with S3.
+func registerLocalUploadRoute(mux *http.ServeMux, blobs BlobStore) {
+	local, ok := blobs.(*LocalBlobStore)
+	if !ok {
+		return
+	}
+	mux.HandleFunc("OPTIONS /assets/upload/", func(w http.ResponseWriter, r *http.Request) {
+		enableCors(w, r)
+	})
+	mux.HandleFunc("PUT /assets/upload/{key...}", func(w http.ResponseWriter, r *http.Request) {
+		enableCors(w, r)
+		key := r.PathValue("key")
+		if !local.verify(key, r.URL.Query()) {
+			http.Error(w, "upload URL is invalid or expired", http.StatusForbidden)
+			return
+		}
+		body := http.MaxBytesReader(w, r.Body, maxAssetSize)
+		if err := local.Put(r.Context(), key, r.Header.Get("Content-Type"), body, r.ContentLength); err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				http.Error(w, "file is too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+type S3Config struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	UseSSL    bool
+}
+
+// S3BlobStore stores objects in an S3-compatible bucket.
+type S3BlobStore struct {
+	client *minio.Client
+	bucket string
+}
+
+func NewS3BlobStore(cfg S3Config) (*S3BlobStore, error) {
+	if cfg.Endpoint == "" || cfg.Bucket == "" {
+		return nil, errors.New("S3_ENDPOINT and S3_BUCKET are required for the s3 asset backend")
+	}
+	client, err := minio.New(cfg.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(cfg.AccessKey, cfg.SecretKey, ""),
+		Secure: cfg.UseSSL,
+		Region: cfg.Region,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &S3BlobStore{client: client, bucket: cfg.Bucket}, nil
+}
+
+func (s *S3BlobStore) PresignPut(ctx context.Context, key string, expires time.Duration) (string, error) {
+	u, err := s.client.PresignedPutObject(ctx, s.bucket, key, expires)
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
+
+func (s *S3BlobStore) Put(ctx context.Context, key, contentType string, r io.Reader, size int64) error {
+	_, err := s.client.PutObject(ctx, s.bucket, key, r, size, minio.PutObjectOptions{ContentType: contentType})
+	return err
+}
+
+func (s *S3BlobStore) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	if _, err := s.Stat(ctx, key); err != nil {
+		return nil, err
+	}
+	return s.client.GetObject(ctx, s.bucket, key, minio.GetObjectOptions{})
+}
+
+func (s *S3BlobStore) Stat(ctx context.Context, key string) (int64, error) {
+	info, err := s.client.StatObject(ctx, s.bucket, key, minio.StatObjectOptions{})
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return 0, errNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+	return info.Size, nil
+}
+
+func (s *S3BlobStore) Delete(ctx context.Context, key string) error {
+	return s.client.RemoveObject(ctx, s.bucket, key, minio.RemoveObjectOptions{})
+}
@@ -0,0 +1,603 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/mail"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mailjet/mailjet-apiv3-go/v4"
+)
+
+type CAPAStatus string
+
+const (
+	CAPAOpen         CAPAStatus = "open"
+	CAPAInProgress   CAPAStatus = "in-progress"
+	CAPAVerification CAPAStatus = "pending-verification"
+	CAPAClosed       CAPAStatus = "closed"
+	CAPACancelled    CAPAStatus = "cancelled"
+)
+
+func (s CAPAStatus) valid() bool {
+	switch s {
+	case CAPAOpen, CAPAInProgress, CAPAVerification, CAPAClosed, CAPACancelled:
+		return true
+	}
+	return false
+}
+
+// active reports whether the CAPA still needs work.
+func (s CAPAStatus) active() bool {
+	return s != CAPAClosed && s != CAPACancelled
+}
+
+// CAPA is a corrective and preventive action raised against one response of
+// an audit instance. Timestamps are milliseconds like the workspace records.
+type CAPA struct {
+	ID           string `json:"id"`
+	InstanceID   string `json:"instanceId"`
+	FindingID    string `json:"findingId"`
+	FacilityName string `json:"facilityName"`
+	// FacilityID is the facility the instance is linked to; it stays empty
+	// until the instance is linked, and follows the instance after that.
+	FacilityID string        `json:"facilityId,omitempty"`
+	Category   AuditCategory `json:"category"`
+	Issue      string        `json:"issue"`
+	RiskLevel  RiskLevel     `json:"riskLevel,omitempty"`
+
+	Owner      string `json:"owner"`
+	OwnerEmail string `json:"ownerEmail"`
+	// DueDate is YYYY-MM-DD in the server's local time.
+	DueDate              string     `json:"dueDate"`
+	RootCause            string     `json:"rootCause"`
+	ActionPlan           string     `json:"actionPlan"`
+	VerificationEvidence string     `json:"verificationEvidence"`
+	EvidenceAssetIDs     []string   `json:"evidenceAssetIds"`
+	Status               CAPAStatus `json:"status"`
+
+	CreatedAt int64 `json:"createdAt"`
+	UpdatedAt int64 `json:"updatedAt"`
+	ClosedAt  int64 `json:"closedAt,omitempty"`
+	// Overdue is derived when the record is read, never stored.
+	Overdue bool `json:"overdue"`
+}
+
+func (c CAPA) due() (time.Time, error) {
+	return time.ParseInLocation(time.DateOnly, c.DueDate, time.Local)
+}
+
+// isOverdue is true once the due date has fully passed on an active CAPA.
+func (c CAPA) isOverdue(now time.Time) bool {
+	due, err := c.due()
+	return err == nil && c.Status.active() && !now.Before(due.AddDate(0, 0, 1))
+}
+
+func (c CAPA) validate() error {
+	if !c.Status.valid() {
+		return fmt.Errorf("unknown status %q", c.Status)
+	}
+	if strings.TrimSpace(c.Owner) == "" {
+		return errors.New("owner is required")
+	}
+	if _, err := mail.ParseAddress(c.OwnerEmail); err != nil {
+		return fmt.Errorf("ownerEmail: %v", err)
+	}
+	if _, err := c.due(); err != nil {
+		return errors.New("dueDate must be a YYYY-MM-DD date")
+	}
+	if c.Status == CAPAClosed && strings.TrimSpace(c.VerificationEvidence) == "" && len(c.EvidenceAssetIDs) == 0 {
+		return errors.New("a CAPA cannot be closed without verification evidence")
+	}
+	return nil
+}
+
+// CAPAStore persists CAPA records.
+type CAPAStore struct {
+	file *fileStore[map[string]*CAPA]
+}
+
+func OpenCAPAStore(path string) (*CAPAStore, error) {
+	f, err := openFileStore[map[string]*CAPA](path)
+	if err != nil {
+		return nil, err
+	}
+	return &CAPAStore{file: f}, nil
+}
+
+func cloneCAPA(c *CAPA, now time.Time) CAPA {
+	out := *c
+	out.EvidenceAssetIDs = append([]string{}, c.EvidenceAssetIDs...)
+	out.Overdue = out.isOverdue(now)
+	return out
+}
+
+// Create stores a new CAPA. A response can have only one active CAPA.
+func (s *CAPAStore) Create(c CAPA) (CAPA, error) {
+	c.ID = newID()
+	if c.Status == "" {
+		c.Status = CAPAOpen
+	}
+	if err := c.validate(); err != nil {
+		return CAPA{}, fmt.Errorf("%w: %v", errInvalid, err)
+	}
+	c.CreatedAt = nowMillis()
+	c.UpdatedAt = c.CreatedAt
+	err := s.file.Update(func(m *map[string]*CAPA) error {
+		if *m == nil {
+			*m = map[string]*CAPA{}
+		}
+		for _, other := range *m {
+			if other.InstanceID == c.InstanceID && other.FindingID == c.FindingID && other.Status.active() {
+				return &conflictError{Current: cloneCAPA(other, time.Now())}
+			}
+		}
+		stored := cloneCAPA(&c, time.Now())
+		(*m)[c.ID] = &stored
+		return nil
+	})
+	return cloneCAPA(&c, time.Now()), err
+}
+
+func (s *CAPAStore) Get(id string) (CAPA, error) {
+	var (
+		c  CAPA
+		ok bool
+	)
+	s.file.View(func(m *map[string]*CAPA) {
+		var p *CAPA
+		if p, ok = (*m)[id]; ok {
+			c = cloneCAPA(p, time.Now())
+		}
+	})
+	if !ok {
+		return CAPA{}, errNotFound
+	}
+	return c, nil
+}
+
+// FollowInstances keeps the facility of each instance's CAPAs in step with
+// the instance's own link, starting with the links made so far.
+func (s *CAPAStore) FollowInstances(ws *WorkspaceStore) error {
+	for _, inst := range ws.Instances() {
+		if err := s.linkInstance(inst.ID, inst.FacilityID); err != nil {
+			return err
+		}
+	}
+	ws.Subscribe(func(e InstanceEvent) {
+		if err := s.linkInstance(e.Instance.ID, e.Instance.FacilityID); err != nil {
+			log.Printf("linking CAPAs of instance %s: %v", e.Instance.ID, err)
+		}
+	})
+	return nil
+}
+
+// linkInstance sets facilityID on the instance's CAPAs. Most instance events
+// are response edits that leave the link alone, so it only writes when a
+// CAPA differs.
+func (s *CAPAStore) linkInstance(instanceID, facilityID string) error {
+	var stale bool
+	s.file.View(func(m *map[string]*CAPA) {
+		for _, c := range *m {
+			stale = stale || c.InstanceID == instanceID && c.FacilityID != facilityID
+		}
+	})
+	if !stale {
+		return nil
+	}
+	return s.file.Update(func(m *map[string]*CAPA) error {
+		for _, c := range *m {
+			if c.InstanceID == instanceID {
+				c.FacilityID = facilityID
+			}
+		}
+		return nil
+	})
+}
+
+// Update replaces the editable fields of a CAPA. c.UpdatedAt must match the
+// stored record, as with workspace instances.
+func (s *CAPAStore) Update(id string, c CAPA) (CAPA, error) {
+	var out CAPA
+	err := s.file.Update(func(m *map[string]*CAPA) error {
+		existing, ok := (*m)[id]
+		if !ok {
+			return errNotFound
+		}
+		if existing.UpdatedAt != c.UpdatedAt {
+			return &conflictError{Current: cloneCAPA(existing, time.Now())}
+		}
+		next := *existing
+		next.Owner = c.Owner
+		next.OwnerEmail = c.OwnerEmail
+		next.DueDate = c.DueDate
+		next.RootCause = c.RootCause
+		next.ActionPlan = c.ActionPlan
+		next.VerificationEvidence = c.VerificationEvidence
+		next.EvidenceAssetIDs = append([]string{}, c.EvidenceAssetIDs...)
+		next.Status = c.Status
+		if err := next.validate(); err != nil {
+			return fmt.Errorf("%w: %v", errInvalid, err)
+		}
+		next.UpdatedAt = nextUpdatedAt(existing.UpdatedAt)
+		switch {
+		case next.Status == CAPAClosed && existing.Status != CAPAClosed:
+			next.ClosedAt = next.UpdatedAt
+		case next.Status != CAPAClosed:
+			next.ClosedAt = 0
+		}
+		*existing = next
+		out = cloneCAPA(existing, time.Now())
+		return nil
+	})
+	return out, err
+}
+
+// CAPAFilter selects CAPAs for List. Zero fields match everything.
+type CAPAFilter struct {
+	InstanceID string
+	// Facility matches CAPAs linked to it.
+	Facility    *Facility
+	ActiveOnly  bool
+	OverdueOnly bool
+}
+
+// List returns matching CAPAs, soonest due first.
+func (s *CAPAStore) List(f CAPAFilter, resolve func(id string) string) []CAPA {
+	now := time.Now()
+	out := []CAPA{}
+	s.file.View(func(m *map[string]*CAPA) {
+		for _, c := range *m {
+			if f.InstanceID != "" && c.InstanceID != f.InstanceID {
+				continue
+			}
+			if f.ActiveOnly && !c.Status.active() {
+				continue
+			}
+			if f.OverdueOnly && !c.isOverdue(now) {
+				continue
+			}
+			if f.Facility != nil && !capaAtFacility(c, *f.Facility, resolve) {
+				continue
+			}
+			out = append(out, cloneCAPA(c, now))
+		}
+	})
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].DueDate != out[j].DueDate {
+			return out[i].DueDate < out[j].DueDate
+		}
+		return out[i].CreatedAt < out[j].CreatedAt
+	})
+	return out
+}
+
+// capaAtFacility matches a CAPA to a registry facility by its linked ID,
+// resolved through merges. Unlinked CAPAs match no facility: facilities
+// share names, and a client must never see another's CAPAs.
+func capaAtFacility(c *CAPA, f Facility, resolve func(id string) string) bool {
+	return c.FacilityID != "" && resolve(c.FacilityID) == f.ID
+}
+
+const capaReminderJobKind = "capa_reminder"
+
+type capaReminderPayload struct {
+	CAPAID     string `json:"capa_id"`
+	DueDate    string `json:"due_date"`
+	OffsetDays int    `json:"offset_days"`
+}
+
+// CAPAReminders emails CAPA owners ahead of and after their due dates.
+type CAPAReminders struct {
+	jobs    *Scheduler
+	capas   *CAPAStore
+	mailer  Mailer
+	offsets []int
+}
+
+func NewCAPAReminders(jobs *Scheduler, capas *CAPAStore, mailer Mailer, offsets []int) *CAPAReminders {
+	cr := &CAPAReminders{jobs: jobs, capas: capas, mailer: mailer, offsets: offsets}
+	jobs.Handle(capaReminderJobKind, cr.send)
+	return cr
+}
+
+// capaReminderOffsets reads CAPA_REMINDER_OFFSETS, days before the due date
+// at which to remind the owner. Negative offsets are overdue notices sent
+// after it. The default is 7 and 1 days before, then 1 and 7 days after.
+func capaReminderOffsets() ([]int, error) {
+	raw := os.Getenv("CAPA_REMINDER_OFFSETS")
+	if raw == "" {
+		return []int{7, 1, -1, -7}, nil
+	}
+	var offsets []int
+	for _, part := range strings.Split(raw, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			return nil, fmt.Errorf("CAPA_REMINDER_OFFSETS: invalid offset %q", part)
+		}
+		offsets = append(offsets, n)
+	}
+	return offsets, nil
+}
+
+func capaReminderPrefix(capaID string) string {
+	return capaReminderJobKind + ":" + capaID + ":"
+}
+
+// ScheduleFor queues reminders for the CAPA's current due date, dropping those
+// for an earlier one. Inactive CAPAs have all their reminders cancelled.
+func (cr *CAPAReminders) ScheduleFor(c CAPA) error {
+	if !c.Status.active() {
+		return cr.jobs.CancelPending(capaReminderPrefix(c.ID), nil)
+	}
+	due, err := c.due()
+	if err != nil {
+		return err
+	}
+	err = cr.jobs.CancelPending(capaReminderPrefix(c.ID), func(job Job) bool {
+		var p capaReminderPayload
+		return json.Unmarshal(job.Payload, &p) == nil && p.DueDate == c.DueDate
+	})
+	if err != nil {
+		return err
+	}
+	sendDay := time.Date(due.Year(), due.Month(), due.Day(), reminderSendHour, 0, 0, 0, due.Location())
+	now := time.Now()
+	for _, offset := range cr.offsets {
+		runAt := sendDay.AddDate(0, 0, -offset)
+		if runAt.Before(now) {
+			continue
+		}
+		payload, err := json.Marshal(capaReminderPayload{CAPAID: c.ID, DueDate: c.DueDate, OffsetDays: offset})
+		if err != nil {
+			return err
+		}
+		err = cr.jobs.Schedule(Job{
+			ID:      fmt.Sprintf("%s%s:%d", capaReminderPrefix(c.ID), c.DueDate, offset),
+			Kind:    capaReminderJobKind,
+			RunAt:   runAt.UTC(),
+			Payload: payload,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (cr *CAPAReminders) send(_ context.Context, job Job) error {
+	var p capaReminderPayload
+	if err := json.Unmarshal(job.Payload, &p); err != nil {
+		return fmt.Errorf("%w: bad payload: %v", errJobObsolete, err)
+	}
+	c, err := cr.capas.Get(p.CAPAID)
+	if err != nil {
+		return fmt.Errorf("%w: CAPA %s is gone", errJobObsolete, p.CAPAID)
+	}
+	if !c.Status.active() || c.DueDate != p.DueDate {
+		return fmt.Errorf("%w: CAPA closed or rescheduled", errJobObsolete)
+	}
+
+	html, err := GenerateCAPAReminderEmail(c)
+	if err != nil {
+		return err
+	}
+	subject := fmt.Sprintf("Corrective action due %s: %s", c.DueDate, c.FacilityName)
+	if c.Overdue {
+		subject = fmt.Sprintf("Overdue corrective action (due %s): %s", c.DueDate, c.FacilityName)
+	}
+	return cr.mailer.Send([]mailjet.RecipientV31{{Email: c.OwnerEmail, Name: c.Owner}}, subject, html)
+}
+
+var capaReminderTemplate = template.Must(template.New("capa").Parse(`<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="utf-8">
+    <style>
+        body { font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, Arial, sans-serif; background: #f1f5f9; margin: 0; padding: 40px 20px; color: #1a202c; line-height: 1.6; }
+        .container { max-width: 640px; margin: 0 auto; background: #ffffff; border-radius: 20px; overflow: hidden; }
+        .header { background: {{if .Overdue}}#b91c1c{{else}}linear-gradient(135deg, #667eea 0%, #764ba2 100%){{end}}; padding: 32px 40px; color: white; }
+        .header h1 { font-size: 22px; margin: 0; }
+        .section { padding: 24px 40px; border-bottom: 2px solid #f7fafc; }
+        .label { font-weight: 700; font-size: 12px; color: #64748b; text-transform: uppercase; letter-spacing: 0.5px; }
+        .value { font-size: 15px; color: #1e293b; margin-bottom: 12px; }
+        .footer { background: #1e293b; padding: 24px 40px; color: #cbd5e1; font-size: 13px; }
+    </style>
+</head>
+<body>
+<div class="container">
+    <div class="header">
+        <h1>{{if .Overdue}}This corrective action is overdue{{else}}A corrective action is due {{.DueDate}}{{end}}</h1>
+    </div>
+    <div class="section">
+        <div class="label">Facility</div>
+        <div class="value">{{.FacilityName}}</div>
+        <div class="label">Finding</div>
+        <div class="value">{{.Issue}}{{if .RiskLevel}} ({{.RiskLevel}} risk){{end}}</div>
+        <div class="label">Due date</div>
+        <div class="value">{{.DueDate}}</div>
+        <div class="label">Status</div>
+        <div class="value">{{.Status}}</div>
+    </div>
+    {{if .ActionPlan}}
+    <div class="section">
+        <div class="label">Action plan</div>
+        <div class="value">{{.ActionPlan}}</div>
+    </div>
+    {{end}}
+    <div class="footer">
+        Hello {{.Owner}}, you are listed as the owner of this corrective action from your {{.Category}} audit.
+        Please record the root cause, actions taken and verification evidence, or contact your Crown Point engagement partner if the due date needs to change.
+    </div>
+</div>
+</body>
+</html>`))
+
+func GenerateCAPAReminderEmail(c CAPA) (string, error) {
+	var buf bytes.Buffer
+	if err := capaReminderTemplate.Execute(&buf, c); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+type capaRequest struct {
+	Owner                string     `json:"owner"`
+	OwnerEmail           string     `json:"ownerEmail"`
+	DueDate              string     `json:"dueDate"`
+	RootCause            string     `json:"rootCause"`
+	ActionPlan           string     `json:"actionPlan"`
+	VerificationEvidence string     `json:"verificationEvidence"`
+	EvidenceAssetIDs     []string   `json:"evidenceAssetIds"`
+	Status               CAPAStatus `json:"status"`
+	UpdatedAt            int64      `json:"updatedAt"`
+}
+
+func (req capaRequest) apply(c *CAPA) {
+	c.Owner = strings.TrimSpace(req.Owner)
+	c.OwnerEmail = strings.TrimSpace(req.OwnerEmail)
+	c.DueDate = req.DueDate
+	c.RootCause = req.RootCause
+	c.ActionPlan = req.ActionPlan
+	c.VerificationEvidence = req.VerificationEvidence
+	c.EvidenceAssetIDs = req.EvidenceAssetIDs
+	c.Status = req.Status
+	c.UpdatedAt = req.UpdatedAt
+}
+
+func registerCAPARoutes(mux *http.ServeMux, ws *WorkspaceStore, capas *CAPAStore, registry *FacilityRegistry, reminders *CAPAReminders) {
+	resolve := func(id string) string {
+		f, err := registry.Get(id)
+		if err != nil {
+			return ""
+		}
+		return f.ID
+	}
+	schedule := func(c CAPA) {
+		if err := reminders.ScheduleFor(c); err != nil {
+			log.Printf("scheduling reminders for CAPA %s: %v", c.ID, err)
+		}
+	}
+
+	mux.Handle("POST /realm/instances/{id}/responses/{findingId}/capas", realmRoute(func(w http.ResponseWriter, r *http.Request) {
+		var req capaRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		inst, err := ws.Instance(r.PathValue("id"))
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		c := CAPA{
+			InstanceID:   inst.ID,
+			FindingID:    r.PathValue("findingId"),
+			FacilityName: inst.FacilityName,
+			Category:     inst.Category,
+		}
+		var (
+			found bool
+			resp  AuditResponse
+		)
+		if master, err := ws.MasterFor(inst); err == nil {
+			walkFindings(master, inst, func(_ AuditSection, f AuditFinding, r AuditResponse) {
+				if f.ID == c.FindingID {
+					c.Issue = f.Issue
+					c.RiskLevel = responseRisk(f, r)
+					found, resp = true, r
+				}
+			})
+		}
+		if !found {
+			http.Error(w, "finding is not in the category's master checklist", http.StatusBadRequest)
+			return
+		}
+		// A CAPA corrects a non-compliance, or something the auditor
+		// flagged as needing one.
+		if resp.Status != StatusNonCompliant && (resp.CapaRequired == nil || !*resp.CapaRequired) {
+			http.Error(w, "a CAPA can only be opened for a non-compliant response or one marked as requiring a CAPA", http.StatusBadRequest)
+			return
+		}
+		c.FacilityID = inst.FacilityID
+		req.apply(&c)
+		if c.Status == "" {
+			c.Status = CAPAOpen
+		}
+		created, err := capas.Create(c)
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		schedule(created)
+		writeJSON(w, http.StatusCreated, created)
+	}))
+
+	mux.Handle("GET /realm/instances/{id}/capas", realmRoute(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, capas.List(CAPAFilter{InstanceID: r.PathValue("id")}, resolve))
+	}))
+
+	// Active CAPAs unless ?status=all; ?overdue=1 narrows to overdue ones and
+	// ?facilityId= to one registry facility.
+	mux.Handle("GET /realm/capas", realmRoute(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		filter := CAPAFilter{ActiveOnly: q.Get("status") != "all", OverdueOnly: q.Get("overdue") != ""}
+		if id := q.Get("facilityId"); id != "" {
+			f, err := registry.Get(id)
+			if err != nil {
+				writeStoreError(w, err)
+				return
+			}
+			filter.Facility = &f
+		}
+		writeJSON(w, http.StatusOK, capas.List(filter, resolve))
+	}))
+
+	mux.Handle("GET /realm/capas/{id}", realmRoute(func(w http.ResponseWriter, r *http.Request) {
+		c, err := capas.Get(r.PathValue("id"))
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, c)
+	}))
+
+	mux.Handle("PUT /realm/capas/{id}", realmRoute(func(w http.ResponseWriter, r *http.Request) {
+		var req capaRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var c CAPA
+		req.apply(&c)
+		updated, err := capas.Update(r.PathValue("id"), c)
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		schedule(updated)
+		writeJSON(w, http.StatusOK, updated)
+	}))
+
+	// Open CAPAs for a registry facility; ?status=all includes closed ones.
+	mux.Handle("GET /facilities/{id}/capas", requireOffice(func(w http.ResponseWriter, r *http.Request) {
+		f, err := registry.Get(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		q := r.URL.Query()
+		filter := CAPAFilter{Facility: &f, ActiveOnly: q.Get("status") != "all", OverdueOnly: q.Get("overdue") != ""}
+		writeJSON(w, http.StatusOK, capas.List(filter, resolve))
+	}))
+}
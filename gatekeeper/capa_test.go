@@ -0,0 +1,95 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestCAPAFollowsInstanceFacility(t *testing.T) {
+	ws := openTestWorkspace(t)
+	master, _ := testMasters()
+	if _, err := ws.PublishMaster(master); err != nil {
+		t.Fatal(err)
+	}
+	capas, err := OpenCAPAStore(filepath.Join(t.TempDir(), "capas.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	open := func(inst AuditInstance) CAPA {
+		t.Helper()
+		c, err := capas.Create(CAPA{InstanceID: inst.ID, FindingID: "d-0", FacilityName: inst.FacilityName, FacilityID: inst.FacilityID,
+			Owner: "Ana Ruiz", OwnerEmail: "ana@mercy.example", DueDate: "2026-12-01"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	facilityOf := func(c CAPA) string {
+		t.Helper()
+		got, err := capas.Get(c.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return got.FacilityID
+	}
+
+	// Linked while nothing was following: picked up at start.
+	early, _ := ws.CreateInstance(AuditInstance{FacilityName: "Mercy Hospital", Category: CategoryCSSD})
+	earlyCAPA := open(early)
+	if _, err := ws.LinkFacility(early.ID, "fac-1", 0); err != nil {
+		t.Fatal(err)
+	}
+	late, _ := ws.CreateInstance(AuditInstance{FacilityName: "Mercy Hospital", Category: CategoryCSSD})
+	lateCAPA := open(late)
+
+	if err := capas.FollowInstances(ws); err != nil {
+		t.Fatal(err)
+	}
+	if got := facilityOf(earlyCAPA); got != "fac-1" {
+		t.Errorf("CAPA of an instance linked earlier: facility %q", got)
+	}
+	// The same name is no reason to link the other instance's CAPA.
+	if got := facilityOf(lateCAPA); got != "" {
+		t.Errorf("CAPA of an unlinked instance: facility %q", got)
+	}
+	if _, err := ws.LinkFacility(late.ID, "fac-2", 0); err != nil {
+		t.Fatal(err)
+	}
+	if got := facilityOf(lateCAPA); got != "fac-2" {
+		t.Errorf("CAPA after its instance was linked: facility %q", got)
+	}
+	if got := facilityOf(earlyCAPA); got != "fac-1" {
+		t.Errorf("another instance's link moved a CAPA to %q", got)
+	}
+}
+
+func TestCAPAFacilityFilterNeedsLink(t *testing.T) {
+	capas, err := OpenCAPAStore(filepath.Join(t.TempDir(), "capas.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	byFacility := map[string]string{}
+	for i, facilityID := range []string{"fac-1", "", "fac-merged", "fac-2"} {
+		c, err := capas.Create(CAPA{InstanceID: string(rune('a' + i)), FindingID: "d-0", FacilityName: "St. Mary's Hospital", FacilityID: facilityID,
+			Owner: "Ana Ruiz", OwnerEmail: "ana@stmarys.example", DueDate: "2026-12-01"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		byFacility[c.ID] = facilityID
+	}
+	// fac-merged was merged into fac-1.
+	resolve := func(id string) string {
+		if id == "fac-merged" {
+			return "fac-1"
+		}
+		return id
+	}
+	f := Facility{ID: "fac-1", Name: "St. Mary's Hospital"}
+	var got []string
+	for _, c := range capas.List(CAPAFilter{Facility: &f}, resolve) {
+		got = append(got, byFacility[c.ID])
+	}
+	if len(got) != 2 || got[0] == "" || got[1] == "" || got[0] == "fac-2" || got[1] == "fac-2" {
+		t.Errorf("CAPAs listed for fac-1 are linked to %q; want fac-1 and fac-merged only", got)
+	}
+}
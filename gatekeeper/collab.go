@@ -0,0 +1,379 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	collabSendBuffer  = 64
+	collabWriteWait   = 10 * time.Second
+	collabPongWait    = 60 * time.Second
+	collabPingPeriod  = collabPongWait * 9 / 10
+	collabMaxMessage  = 64 << 10
+	collabMaxNameSize = 60
+)
+
+// Presence is one auditor connected to an instance, and the finding they
+// are working on, if any.
+type Presence struct {
+	ClientID  string `json:"clientId"`
+	Name      string `json:"name"`
+	FindingID string `json:"findingId,omitempty"`
+	JoinedAt  int64  `json:"joinedAt"`
+}
+
+// collabMessage is the single frame type in both directions.
+//
+// Client to server:
+//
+//	patch  {ref, findingId, patch}  change some fields of one response
+//	focus  {findingId}               show others what you are editing ("" to clear)
+//
+// Server to client:
+//
+//	hello     {clientId, instance, presence}  sent once on connect
+//	ack       {ref, findingId, response, fields, updatedAt}
+//	response  {by, findingId, response, fields, updatedAt}  someone else's patch
+//	instance  {instance}  the whole instance was replaced through the REST API
+//	deleted   {}
+//	presence  {presence}
+//	error     {ref, error}
+type collabMessage struct {
+	Type      string         `json:"type"`
+	Ref       string         `json:"ref,omitempty"`
+	FindingID string         `json:"findingId,omitempty"`
+	Patch     *ResponsePatch `json:"patch,omitempty"`
+
+	ClientID  string         `json:"clientId,omitempty"`
+	By        string         `json:"by,omitempty"`
+	Response  *AuditResponse `json:"response,omitempty"`
+	Fields    []string       `json:"fields,omitempty"`
+	UpdatedAt int64          `json:"updatedAt,omitempty"`
+	Instance  *AuditInstance `json:"instance,omitempty"`
+	Presence  []Presence     `json:"presence,omitempty"`
+	Error     string         `json:"error,omitempty"`
+}
+
+type collabClient struct {
+	conn       *websocket.Conn
+	instanceID string
+	presence   Presence // guarded by CollabHub.mu
+	send       chan collabMessage
+}
+
+// CollabHub relays response changes between auditors editing the same
+// instance. Changes are stored through the WorkspaceStore as field-level
+// patches, and every stored change to an instance, whichever route it came
+// through, is broadcast to the instance's room.
+type CollabHub struct {
+	ws       *WorkspaceStore
+	upgrader websocket.Upgrader
+
+	mu    sync.Mutex
+	rooms map[string]map[*collabClient]bool
+}
+
+func NewCollabHub(ws *WorkspaceStore) *CollabHub {
+	h := &CollabHub{
+		ws: ws,
+		// The realm app is served from another origin and authenticates
+		// with the API token, so the origin is not checked.
+		upgrader: websocket.Upgrader{CheckOrigin: func(*http.Request) bool { return true }},
+		rooms:    map[string]map[*collabClient]bool{},
+	}
+	ws.Subscribe(h.onEvent)
+	return h
+}
+
+// deliver queues msg for c. A client too slow to keep up is disconnected
+// rather than allowed to stall the room; it reloads on reconnect. Callers
+// hold h.mu.
+func (h *CollabHub) deliver(c *collabClient, msg collabMessage) {
+	select {
+	case c.send <- msg:
+	default:
+		h.removeLocked(c)
+	}
+}
+
+func (h *CollabHub) removeLocked(c *collabClient) bool {
+	room := h.rooms[c.instanceID]
+	if !room[c] {
+		return false
+	}
+	delete(room, c)
+	if len(room) == 0 {
+		delete(h.rooms, c.instanceID)
+	}
+	close(c.send)
+	return true
+}
+
+func (h *CollabHub) presenceLocked(instanceID string) []Presence {
+	out := []Presence{}
+	for c := range h.rooms[instanceID] {
+		out = append(out, c.presence)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].JoinedAt < out[j].JoinedAt })
+	return out
+}
+
+func (h *CollabHub) broadcastPresenceLocked(instanceID string) {
+	msg := collabMessage{Type: "presence", Presence: h.presenceLocked(instanceID)}
+	for c := range h.rooms[instanceID] {
+		h.deliver(c, msg)
+	}
+}
+
+// Presence returns who is connected to an instance.
+func (h *CollabHub) Presence(instanceID string) []Presence {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.presenceLocked(instanceID)
+}
+
+func (h *CollabHub) onEvent(e InstanceEvent) {
+	var msg collabMessage
+	switch {
+	case e.Deleted:
+		msg = collabMessage{Type: "deleted"}
+	case e.FindingID != "":
+		resp := e.Instance.Responses[e.FindingID]
+		msg = collabMessage{
+			Type:      "response",
+			By:        e.Origin,
+			FindingID: e.FindingID,
+			Response:  &resp,
+			Fields:    e.Fields,
+			UpdatedAt: e.Instance.UpdatedAt,
+		}
+	default:
+		inst := e.Instance
+		msg = collabMessage{Type: "instance", Instance: &inst, UpdatedAt: inst.UpdatedAt}
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for c := range h.rooms[e.Instance.ID] {
+		// The author of a patch gets an ack instead.
+		if e.Origin != "" && c.presence.ClientID == e.Origin {
+			continue
+		}
+		h.deliver(c, msg)
+	}
+}
+
+// join registers c and queues its hello. The snapshot is taken under the
+// hub lock so no change can be broadcast between it and registration.
+func (h *CollabHub) join(c *collabClient) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	inst, err := h.ws.Instance(c.instanceID)
+	if err != nil {
+		return err
+	}
+	if h.rooms[c.instanceID] == nil {
+		h.rooms[c.instanceID] = map[*collabClient]bool{}
+	}
+	h.rooms[c.instanceID][c] = true
+	c.send <- collabMessage{Type: "hello", ClientID: c.presence.ClientID, Instance: &inst, Presence: h.presenceLocked(c.instanceID)}
+	h.broadcastPresenceLocked(c.instanceID)
+	return nil
+}
+
+func (h *CollabHub) leave(c *collabClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.removeLocked(c) {
+		h.broadcastPresenceLocked(c.instanceID)
+	}
+}
+
+func (h *CollabHub) focus(c *collabClient, findingID string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.rooms[c.instanceID][c] || c.presence.FindingID == findingID {
+		return
+	}
+	c.presence.FindingID = findingID
+	h.broadcastPresenceLocked(c.instanceID)
+}
+
+func (h *CollabHub) reply(c *collabClient, msg collabMessage) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.rooms[c.instanceID][c] {
+		h.deliver(c, msg)
+	}
+}
+
+func (h *CollabHub) handle(c *collabClient, msg collabMessage) {
+	switch msg.Type {
+	case "patch":
+		if msg.Patch == nil {
+			h.reply(c, collabMessage{Type: "error", Ref: msg.Ref, Error: "patch is required"})
+			return
+		}
+		inst, fields, err := h.ws.PatchResponse(c.instanceID, msg.FindingID, *msg.Patch, c.presence.ClientID)
+		if err != nil {
+			h.reply(c, collabMessage{Type: "error", Ref: msg.Ref, Error: err.Error()})
+			return
+		}
+		resp := inst.Responses[msg.FindingID]
+		h.reply(c, collabMessage{
+			Type:      "ack",
+			Ref:       msg.Ref,
+			FindingID: msg.FindingID,
+			Response:  &resp,
+			Fields:    fields,
+			UpdatedAt: inst.UpdatedAt,
+		})
+	case "focus":
+		h.focus(c, msg.FindingID)
+	default:
+		h.reply(c, collabMessage{Type: "error", Ref: msg.Ref, Error: "unknown message type " + msg.Type})
+	}
+}
+
+// ServeHTTP upgrades GET /realm/instances/{id}/live. Browsers cannot set
+// headers on a WebSocket, so the token may also be passed as
+// ?access_token=; ?name= is shown to the other auditors and defaults to the
+// signed-in user's name.
+func (h *CollabHub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p, ok := principalFrom(r.Context())
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if p.User.Role == RoleClient {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	id := r.PathValue("id")
+	if _, err := h.ws.Instance(id); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	name := []rune(strings.TrimSpace(r.URL.Query().Get("name")))
+	if len(name) == 0 && p.Via != "admin_key" {
+		name = []rune(p.User.Name)
+	}
+	if len(name) > collabMaxNameSize {
+		name = name[:collabMaxNameSize]
+	}
+	if len(name) == 0 {
+		name = []rune("Auditor")
+	}
+
+	conn, err := h.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return // the upgrader has already replied
+	}
+	c := &collabClient{
+		conn:       conn,
+		instanceID: id,
+		presence:   Presence{ClientID: newID(), Name: string(name), JoinedAt: nowMillis()},
+		send:       make(chan collabMessage, collabSendBuffer),
+	}
+	if err := h.join(c); err != nil {
+		conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, err.Error()), time.Now().Add(collabWriteWait))
+		conn.Close()
+		return
+	}
+	go c.writeLoop()
+	c.readLoop(h)
+	h.leave(c)
+}
+
+func (c *collabClient) readLoop(h *CollabHub) {
+	c.conn.SetReadLimit(collabMaxMessage)
+	c.conn.SetReadDeadline(time.Now().Add(collabPongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(collabPongWait))
+	})
+	for {
+		_, raw, err := c.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				log.Printf("collab %s: %v", c.instanceID, err)
+			}
+			return
+		}
+		var msg collabMessage
+		if err := json.Unmarshal(raw, &msg); err != nil {
+			h.reply(c, collabMessage{Type: "error", Error: "invalid message: " + err.Error()})
+			continue
+		}
+		h.handle(c, msg)
+	}
+}
+
+// writeLoop owns writes to the connection. It ends when the hub closes
+// c.send, which happens when the client leaves or falls behind.
+func (c *collabClient) writeLoop() {
+	ping := time.NewTicker(collabPingPeriod)
+	defer func() {
+		ping.Stop()
+		c.conn.Close()
+	}()
+	for {
+		select {
+		case msg, ok := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(collabWriteWait))
+			if !ok {
+				c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+				return
+			}
+			if err := c.conn.WriteJSON(msg); err != nil {
+				return
+			}
+			if msg.Type == "deleted" {
+				c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "instance deleted"))
+				return
+			}
+		case <-ping.C:
+			c.conn.SetWriteDeadline(time.Now().Add(collabWriteWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+type patchResponseResult struct {
+	Response  AuditResponse `json:"response"`
+	Fields    []string      `json:"fields"`
+	UpdatedAt int64         `json:"updatedAt"`
+}
+
+func registerCollabRoutes(mux *http.ServeMux, ws *WorkspaceStore, hub *CollabHub) {
+	mux.Handle("GET /realm/instances/{id}/live", hub)
+	mux.Handle("GET /realm/instances/{id}/presence", realmRoute(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, hub.Presence(r.PathValue("id")))
+	}))
+	// The REST twin of a "patch" message, for clients without a socket.
+	mux.Handle("PATCH /realm/instances/{id}/responses/{findingId}", realmRoute(func(w http.ResponseWriter, r *http.Request) {
+		var patch ResponsePatch
+		if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		findingID := r.PathValue("findingId")
+		inst, fields, err := ws.PatchResponse(r.PathValue("id"), findingID, patch, "")
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		if fields == nil {
+			fields = []string{}
+		}
+		writeJSON(w, http.StatusOK, patchResponseResult{Response: inst.Responses[findingID], Fields: fields, UpdatedAt: inst.UpdatedAt})
+	}))
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type CRMCompany struct {
+	// FacilityID is the facility's registry ID. Companies are matched on it,
+	// or on the address while it is not known, never on the name: unrelated
+	// facilities often share one.
+	FacilityID string
+	Name       string
+	Address    string
+	// ParentName is the health system the facility belongs to, if any.
+	ParentName string
+}
+
+type CRMContact struct {
+	FirstName string
+	LastName  string
+	Title     string
+	Phone     string
+	Email     string
+}
+
+type CRMDeal struct {
+	// ID is set when the deal already exists and should be updated.
+	ID string
+	// SubmissionID identifies the deal when ID is not known yet.
+	SubmissionID string
+	Name         string
+	Amount       float64
+	Description  string
+}
+
+// CRMConnector pushes leads into an external CRM. Company and contact calls
+// must be idempotent upserts keyed on the CRM's natural identifiers, and
+// SaveDeal must find a deal by its SubmissionID before creating one, because
+// the sync queue retries a submission from the start after any failure.
+type CRMConnector interface {
+	UpsertCompany(ctx context.Context, company CRMCompany) (string, error)
+	UpsertContact(ctx context.Context, contact CRMContact, companyID string) (string, error)
+	SaveDeal(ctx context.Context, deal CRMDeal, companyID, contactID string) (string, error)
+}
+
+// CRMSync records what a submission maps to in the CRM.
+type CRMSync struct {
+	CompanyID string    `json:"company_id,omitempty"`
+	ContactID string    `json:"contact_id,omitempty"`
+	DealID    string    `json:"deal_id,omitempty"`
+	SyncedAt  time.Time `json:"synced_at,omitempty"`
+}
+
+const crmJobKind = "crm_sync"
+
+// CRMSyncQueue sends submissions to the CRM through the job scheduler, so
+// failed pushes are retried with backoff and survive restarts.
+type CRMSyncQueue struct {
+	jobs      *Scheduler
+	subs      *SubmissionStore
+	crm       CRMConnector
+	estimator *Estimator
+}
+
+func NewCRMSyncQueue(jobs *Scheduler, subs *SubmissionStore, crm CRMConnector, estimator *Estimator) *CRMSyncQueue {
+	q := &CRMSyncQueue{jobs: jobs, subs: subs, crm: crm, estimator: estimator}
+	jobs.Handle(crmJobKind, q.sync)
+	return q
+}
+
+func (q *CRMSyncQueue) Enqueue(submissionID string) error {
+	payload, err := json.Marshal(map[string]string{"submission_id": submissionID})
+	if err != nil {
+		return err
+	}
+	return q.jobs.Schedule(Job{
+		ID:      fmt.Sprintf("%s:%s:%d", crmJobKind, submissionID, time.Now().UnixNano()),
+		Kind:    crmJobKind,
+		RunAt:   time.Now().UTC(),
+		Payload: payload,
+	})
+}
+
+func splitName(full string) (first, last string) {
+	full = strings.TrimSpace(full)
+	if i := strings.LastIndex(full, " "); i > 0 {
+		return strings.TrimSpace(full[:i]), full[i+1:]
+	}
+	return full, ""
+}
+
+func (q *CRMSyncQueue) sync(ctx context.Context, job Job) error {
+	var p struct {
+		SubmissionID string `json:"submission_id"`
+	}
+	if err := json.Unmarshal(job.Payload, &p); err != nil {
+		return fmt.Errorf("%w: bad payload: %v", errJobObsolete, err)
+	}
+	sub, err := q.subs.Get(p.SubmissionID)
+	if err != nil {
+		return fmt.Errorf("%w: submission %s is gone", errJobObsolete, p.SubmissionID)
+	}
+	data := sub.Data
+	state := CRMSync{}
+	if sub.CRM != nil {
+		state = *sub.CRM
+	}
+
+	company := CRMCompany{FacilityID: sub.FacilityID, Name: data.FacilityName, Address: data.FacilityAddress}
+	if data.IsAffiliated && data.SystemName != nil {
+		company.ParentName = *data.SystemName
+	}
+	if state.CompanyID, err = q.crm.UpsertCompany(ctx, company); err != nil {
+		return fmt.Errorf("company: %w", err)
+	}
+
+	first, last := splitName(data.ContactName)
+	contact := CRMContact{
+		FirstName: first,
+		LastName:  last,
+		Title:     data.ContactTitle,
+		Phone:     data.ContactPhone,
+		Email:     data.ContactEmail,
+	}
+	if state.ContactID, err = q.crm.UpsertContact(ctx, contact, state.CompanyID); err != nil {
+		return fmt.Errorf("contact: %w", err)
+	}
+
+	estimate := q.estimator.Estimate(data)
+	deal := CRMDeal{
+		ID:           state.DealID,
+		SubmissionID: sub.ID,
+		Name:         fmt.Sprintf("%s – %s assessment", data.FacilityName, formatAuditTypes(data.AuditType)),
+		Amount:       (estimate.PriceLow + estimate.PriceHigh) / 2,
+		Description:  oneLine(data.PainPoints),
+	}
+	dealID, err := q.crm.SaveDeal(ctx, deal, state.CompanyID, state.ContactID)
+	if err != nil {
+		return fmt.Errorf("deal: %w", err)
+	}
+	state.DealID = dealID
+	state.SyncedAt = time.Now().UTC()
+
+	_, err = q.subs.Modify(sub.ID, func(s *Submission) error {
+		s.CRM = &state
+		return nil
+	})
+	return err
+}
+
+func registerCRMRoutes(mux *http.ServeMux, subs *SubmissionStore, queue *CRMSyncQueue) {
+	mux.Handle("POST /submissions/{id}/crm-sync", requireOffice(func(w http.ResponseWriter, r *http.Request) {
+		if queue == nil {
+			http.Error(w, "CRM sync is not configured", http.StatusServiceUnavailable)
+			return
+		}
+		if _, err := subs.Get(r.PathValue("id")); errors.Is(err, errNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err := queue.Enqueue(r.PathValue("id")); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+}
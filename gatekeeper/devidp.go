@@ -0,0 +1,228 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"html/template"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// devIDP is a stand-in OpenID Connect provider for trying OIDC sign-in
+// locally. It signs in whoever types an email address and must never face
+// the internet.
+type devIDP struct {
+	issuer       string
+	clientID     string
+	clientSecret string
+	key          *rsa.PrivateKey
+	kid          string
+
+	mu    sync.Mutex
+	codes map[string]devIDPCode
+}
+
+type devIDPCode struct {
+	email, name, nonce, challenge, redirectURI string
+	expires                                    time.Time
+}
+
+func (idp *devIDP) sign(claims any) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": idp.kid})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, idp.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+var devIDPForm = template.Must(template.New("dev-idp").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Development sign-in</title></head>
+<body style="font-family: sans-serif; max-width: 420px; margin: 60px auto;">
+<h1>Development identity provider</h1>
+<p>Anyone can sign in here as anyone. Use it only for local testing.</p>
+<form method="post">
+    {{range $k, $v := .Params}}<input type="hidden" name="{{$k}}" value="{{$v}}">{{end}}
+    <p><label>Email<br><input type="email" name="email" value="{{.Email}}" required style="width: 100%"></label></p>
+    <p><label>Name<br><input name="name" style="width: 100%"></label></p>
+    <button type="submit">Sign in</button>
+</form>
+</body></html>`))
+
+func (idp *devIDP) routes() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]any{
+			"issuer":                                idp.issuer,
+			"authorization_endpoint":                idp.issuer + "/authorize",
+			"token_endpoint":                        idp.issuer + "/token",
+			"jwks_uri":                              idp.issuer + "/jwks",
+			"response_types_supported":              []string{"code"},
+			"subject_types_supported":               []string{"public"},
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+			"code_challenge_methods_supported":      []string{"S256"},
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		pub := idp.key.PublicKey
+		writeJSON(w, http.StatusOK, map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"use": "sig",
+			"alg": "RS256",
+			"kid": idp.kid,
+			"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f := r.Form
+		if f.Get("client_id") != idp.clientID || f.Get("response_type") != "code" || f.Get("redirect_uri") == "" {
+			http.Error(w, "unknown client, bad response_type or missing redirect_uri", http.StatusBadRequest)
+			return
+		}
+		if f.Get("code_challenge_method") != "S256" || f.Get("code_challenge") == "" {
+			http.Error(w, "PKCE with S256 is required", http.StatusBadRequest)
+			return
+		}
+		if r.Method != http.MethodPost {
+			params := map[string]string{}
+			for _, k := range []string{"client_id", "response_type", "redirect_uri", "scope", "state", "nonce", "code_challenge", "code_challenge_method"} {
+				params[k] = f.Get(k)
+			}
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			_ = devIDPForm.Execute(w, map[string]any{"Params": params, "Email": f.Get("login_hint")})
+			return
+		}
+		code := newID()
+		idp.mu.Lock()
+		idp.codes[code] = devIDPCode{
+			email:       normalizeEmail(f.Get("email")),
+			name:        strings.TrimSpace(f.Get("name")),
+			nonce:       f.Get("nonce"),
+			challenge:   f.Get("code_challenge"),
+			redirectURI: f.Get("redirect_uri"),
+			expires:     time.Now().Add(time.Minute),
+		}
+		idp.mu.Unlock()
+		back, err := url.Parse(f.Get("redirect_uri"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		q := back.Query()
+		q.Set("code", code)
+		q.Set("state", f.Get("state"))
+		back.RawQuery = q.Encode()
+		http.Redirect(w, r, back.String(), http.StatusSeeOther)
+	})
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		fail := func(e string) { writeJSON(w, http.StatusBadRequest, map[string]string{"error": e}) }
+		id, secret, ok := r.BasicAuth()
+		if ok {
+			id, _ = url.QueryUnescape(id)
+			secret, _ = url.QueryUnescape(secret)
+		} else {
+			id, secret = r.FormValue("client_id"), r.FormValue("client_secret")
+		}
+		if id != idp.clientID || subtle.ConstantTimeCompare([]byte(secret), []byte(idp.clientSecret)) != 1 {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+			return
+		}
+		if r.FormValue("grant_type") != "authorization_code" {
+			fail("unsupported_grant_type")
+			return
+		}
+		idp.mu.Lock()
+		c, found := idp.codes[r.FormValue("code")]
+		delete(idp.codes, r.FormValue("code"))
+		idp.mu.Unlock()
+		if !found || time.Now().After(c.expires) || c.redirectURI != r.FormValue("redirect_uri") ||
+			pkceChallenge(r.FormValue("code_verifier")) != c.challenge {
+			fail("invalid_grant")
+			return
+		}
+		now := time.Now()
+		idToken, err := idp.sign(map[string]any{
+			"iss":            idp.issuer,
+			"sub":            "dev|" + c.email,
+			"aud":            idp.clientID,
+			"iat":            now.Unix(),
+			"exp":            now.Add(5 * time.Minute).Unix(),
+			"nonce":          c.nonce,
+			"email":          c.email,
+			"email_verified": true,
+			"name":           c.name,
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{
+			"access_token": newID(),
+			"token_type":   "Bearer",
+			"expires_in":   300,
+			"id_token":     idToken,
+		})
+	})
+	return mux
+}
+
+// runDevIDPCommand implements "gatekeeper dev-idp", a local stand-in
+// identity provider for testing OIDC sign-in. Point OIDC_ISSUER at it and
+// use the same client ID and secret.
+func runDevIDPCommand(args []string) error {
+	fs := flag.NewFlagSet("dev-idp", flag.ContinueOnError)
+	addr := fs.String("addr", "localhost:9000", "listen address")
+	issuer := fs.String("issuer", "", "issuer URL (default http://<addr>)")
+	clientID := fs.String("client-id", "gatekeeper", "client ID to accept")
+	clientSecret := fs.String("client-secret", "dev-secret", "client secret to accept")
+	if err := fs.Parse(args); errors.Is(err, flag.ErrHelp) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if *issuer == "" {
+		*issuer = "http://" + *addr
+	}
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return err
+	}
+	idp := &devIDP{
+		issuer:       strings.TrimRight(*issuer, "/"),
+		clientID:     *clientID,
+		clientSecret: *clientSecret,
+		key:          key,
+		kid:          newID()[:8],
+		codes:        map[string]devIDPCode{},
+	}
+	fmt.Printf("development identity provider at %s (client %q)\n", idp.issuer, idp.clientID)
+	log.Printf("anyone can sign in as anyone here; do not expose this server")
+	return http.ListenAndServe(*addr, idp.routes())
+}
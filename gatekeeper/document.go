@@ -0,0 +1,410 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/go-pdf/fpdf"
+)
+
+type blockKind int
+
+const (
+	blockHeading1 blockKind = iota
+	blockHeading2
+	blockParagraph
+	blockBullet
+	blockTable
+	blockImages
+	// blockBreak only separates paragraphs while parsing.
+	blockBreak
+)
+
+// docBlock is one element of a generated document. Documents are written in
+// a small line-based markup (see parseDocMarkup) so the same template can be
+// rendered to both DOCX and PDF.
+type docBlock struct {
+	kind   blockKind
+	text   string
+	rows   [][]string
+	images []docImage
+}
+
+// docImage is a picture in an image block. Markup only names it by ref; the
+// caller fills in Data (JPEG or PNG) before rendering, see resolveDocImages.
+type docImage struct {
+	ref     string
+	caption string
+	data    []byte
+}
+
+// parseDocMarkup splits rendered template text into blocks:
+//
+//	# Heading        level one heading
+//	## Heading       level two heading
+//	- item           bullet
+//	| a | b |        table row; consecutive rows form one table
+//	![caption](ref)  image; consecutive images form one block
+//
+// Any other non-blank line is a paragraph. Consecutive paragraph lines are
+// joined with a space.
+func parseDocMarkup(text string) []docBlock {
+	var blocks []docBlock
+	last := func() *docBlock {
+		if len(blocks) == 0 {
+			return nil
+		}
+		return &blocks[len(blocks)-1]
+	}
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "":
+			blocks = append(blocks, docBlock{kind: blockBreak})
+		case strings.HasPrefix(line, "## "):
+			blocks = append(blocks, docBlock{kind: blockHeading2, text: line[3:]})
+		case strings.HasPrefix(line, "# "):
+			blocks = append(blocks, docBlock{kind: blockHeading1, text: line[2:]})
+		case strings.HasPrefix(line, "- "):
+			blocks = append(blocks, docBlock{kind: blockBullet, text: line[2:]})
+		case strings.HasPrefix(line, "|"):
+			var cells []string
+			for _, c := range strings.Split(strings.Trim(line, "|"), "|") {
+				cells = append(cells, strings.TrimSpace(c))
+			}
+			if b := last(); b != nil && b.kind == blockTable {
+				b.rows = append(b.rows, cells)
+			} else {
+				blocks = append(blocks, docBlock{kind: blockTable, rows: [][]string{cells}})
+			}
+		case strings.HasPrefix(line, "![") && strings.HasSuffix(line, ")") && strings.Contains(line, "]("):
+			caption, ref, _ := strings.Cut(strings.TrimSuffix(line[2:], ")"), "](")
+			img := docImage{ref: ref, caption: caption}
+			if b := last(); b != nil && b.kind == blockImages {
+				b.images = append(b.images, img)
+			} else {
+				blocks = append(blocks, docBlock{kind: blockImages, images: []docImage{img}})
+			}
+		default:
+			if b := last(); b != nil && b.kind == blockParagraph {
+				b.text += " " + line
+			} else {
+				blocks = append(blocks, docBlock{kind: blockParagraph, text: line})
+			}
+		}
+	}
+	out := blocks[:0]
+	for _, b := range blocks {
+		if b.kind != blockBreak {
+			out = append(out, b)
+		}
+	}
+	return out
+}
+
+// resolveDocImages loads the data for every image block. Images that load
+// returns no data for are dropped.
+func resolveDocImages(blocks []docBlock, load func(ref string) []byte) {
+	for i := range blocks {
+		if blocks[i].kind != blockImages {
+			continue
+		}
+		kept := blocks[i].images[:0]
+		for _, img := range blocks[i].images {
+			if img.data = load(img.ref); img.data != nil {
+				kept = append(kept, img)
+			}
+		}
+		blocks[i].images = kept
+	}
+}
+
+func xmlEscape(s string) string {
+	var buf bytes.Buffer
+	_ = xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
+
+func docxRun(text string, bold bool) string {
+	props := ""
+	if bold {
+		props = "<w:rPr><w:b/></w:rPr>"
+	}
+	return `<w:r>` + props + `<w:t xml:space="preserve">` + xmlEscape(text) + `</w:t></w:r>`
+}
+
+func docxParagraph(style, text string) string {
+	props := ""
+	if style != "" {
+		props = `<w:pPr><w:pStyle w:val="` + style + `"/></w:pPr>`
+	}
+	return `<w:p>` + props + docxRun(text, false) + `</w:p>`
+}
+
+// renderDOCX writes blocks as a minimal WordprocessingML package. Image
+// blocks are not supported and are left out.
+func renderDOCX(blocks []docBlock) ([]byte, error) {
+	var body strings.Builder
+	for _, b := range blocks {
+		switch b.kind {
+		case blockHeading1:
+			body.WriteString(docxParagraph("Heading1", b.text))
+		case blockHeading2:
+			body.WriteString(docxParagraph("Heading2", b.text))
+		case blockParagraph:
+			body.WriteString(docxParagraph("", b.text))
+		case blockBullet:
+			body.WriteString(docxParagraph("ListBullet", "• "+b.text))
+		case blockTable:
+			body.WriteString(`<w:tbl><w:tblPr><w:tblStyle w:val="TableGrid"/><w:tblW w:w="5000" w:type="pct"/></w:tblPr>`)
+			for i, row := range b.rows {
+				body.WriteString(`<w:tr>`)
+				for _, cell := range row {
+					body.WriteString(`<w:tc><w:p>` + docxRun(cell, i == 0) + `</w:p></w:tc>`)
+				}
+				body.WriteString(`</w:tr>`)
+			}
+			body.WriteString(`</w:tbl>`)
+			body.WriteString(`<w:p/>`)
+		}
+	}
+
+	files := []struct{ name, body string }{
+		{"[Content_Types].xml", docxContentTypes},
+		{"_rels/.rels", docxRels},
+		{"word/_rels/document.xml.rels", docxDocumentRels},
+		{"word/styles.xml", docxStyles},
+		{"word/document.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` +
+			`<w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"><w:body>` +
+			body.String() +
+			`<w:sectPr><w:pgSz w:w="12240" w:h="15840"/><w:pgMar w:top="1440" w:right="1440" w:bottom="1440" w:left="1440"/></w:sectPr>` +
+			`</w:body></w:document>`},
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, f := range files {
+		w, err := zw.Create(f.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write([]byte(f.body)); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+const docxContentTypes = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">
+<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>
+<Default Extension="xml" ContentType="application/xml"/>
+<Override PartName="/word/document.xml" ContentType="application/vnd.openxmlformats-officedocument.wordprocessingml.document.main+xml"/>
+<Override PartName="/word/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.wordprocessingml.styles+xml"/>
+</Types>`
+
+const docxRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="word/document.xml"/>
+</Relationships>`
+
+const docxDocumentRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>
+</Relationships>`
+
+const docxStyles = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<w:styles xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main">
+<w:docDefaults><w:rPrDefault><w:rPr><w:rFonts w:ascii="Calibri" w:hAnsi="Calibri"/><w:sz w:val="22"/></w:rPr></w:rPrDefault>
+<w:pPrDefault><w:pPr><w:spacing w:after="120"/></w:pPr></w:pPrDefault></w:docDefaults>
+<w:style w:type="paragraph" w:default="1" w:styleId="Normal"><w:name w:val="Normal"/></w:style>
+<w:style w:type="paragraph" w:styleId="Heading1"><w:name w:val="heading 1"/><w:basedOn w:val="Normal"/><w:pPr><w:spacing w:before="360" w:after="120"/></w:pPr><w:rPr><w:b/><w:color w:val="4F46E5"/><w:sz w:val="36"/></w:rPr></w:style>
+<w:style w:type="paragraph" w:styleId="Heading2"><w:name w:val="heading 2"/><w:basedOn w:val="Normal"/><w:pPr><w:spacing w:before="240" w:after="80"/></w:pPr><w:rPr><w:b/><w:color w:val="334155"/><w:sz w:val="26"/></w:rPr></w:style>
+<w:style w:type="paragraph" w:styleId="ListBullet"><w:name w:val="List Bullet"/><w:basedOn w:val="Normal"/><w:pPr><w:ind w:left="360"/></w:pPr></w:style>
+<w:style w:type="table" w:styleId="TableGrid"><w:name w:val="Table Grid"/><w:tblPr><w:tblBorders>
+<w:top w:val="single" w:sz="4" w:color="CBD5E1"/><w:left w:val="single" w:sz="4" w:color="CBD5E1"/><w:bottom w:val="single" w:sz="4" w:color="CBD5E1"/><w:right w:val="single" w:sz="4" w:color="CBD5E1"/>
+<w:insideH w:val="single" w:sz="4" w:color="CBD5E1"/><w:insideV w:val="single" w:sz="4" w:color="CBD5E1"/></w:tblBorders></w:tblPr></w:style>
+</w:styles>`
+
+// renderPDF lays blocks out on US Letter pages with the built-in Helvetica
+// font. Text is translated to cp1252, which covers the characters the form
+// and templates produce.
+func renderPDF(title string, blocks []docBlock) ([]byte, error) {
+	pdf := fpdf.New("P", "mm", "Letter", "")
+	pdf.SetTitle(title, true)
+	pdf.SetAuthor("Crown Point Consulting", true)
+	pdf.SetMargins(20, 20, 20)
+	pdf.SetAutoPageBreak(true, 20)
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+	pageWidth, _ := pdf.GetPageSize()
+	contentWidth := pageWidth - 40
+
+	pdf.SetFooterFunc(func() {
+		pdf.SetY(-15)
+		pdf.SetFont("Helvetica", "I", 8)
+		pdf.SetTextColor(100, 116, 139)
+		pdf.CellFormat(0, 10, tr("Crown Point Consulting — "+title), "", 0, "L", false, 0, "")
+		pdf.CellFormat(0, 10, fmt.Sprintf("Page %d", pdf.PageNo()), "", 0, "R", false, 0, "")
+	})
+	pdf.AddPage()
+
+	for i, b := range blocks {
+		switch b.kind {
+		case blockHeading1:
+			if i > 0 {
+				pdf.Ln(4)
+			}
+			pdf.SetFont("Helvetica", "B", 18)
+			pdf.SetTextColor(79, 70, 229)
+			pdf.MultiCell(0, 9, tr(b.text), "", "L", false)
+			pdf.Ln(2)
+		case blockHeading2:
+			pdf.Ln(2)
+			pdf.SetFont("Helvetica", "B", 13)
+			pdf.SetTextColor(51, 65, 85)
+			pdf.MultiCell(0, 7, tr(b.text), "", "L", false)
+			pdf.Ln(1)
+		case blockParagraph:
+			pdf.SetFont("Helvetica", "", 10.5)
+			pdf.SetTextColor(30, 41, 59)
+			pdf.MultiCell(0, 5.5, tr(b.text), "", "L", false)
+			pdf.Ln(2)
+		case blockBullet:
+			pdf.SetFont("Helvetica", "", 10.5)
+			pdf.SetTextColor(30, 41, 59)
+			pdf.SetX(pdf.GetX() + 4)
+			pdf.CellFormat(5, 5.5, tr("•"), "", 0, "L", false, 0, "")
+			pdf.MultiCell(contentWidth-9, 5.5, tr(b.text), "", "L", false)
+		case blockTable:
+			renderPDFTable(pdf, tr, contentWidth, b.rows)
+			pdf.Ln(3)
+		case blockImages:
+			renderPDFImages(pdf, tr, contentWidth, b.images)
+			pdf.Ln(2)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := pdf.Output(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func renderPDFTable(pdf *fpdf.Fpdf, tr func(string) string, width float64, rows [][]string) {
+	cols := 0
+	for _, r := range rows {
+		cols = max(cols, len(r))
+	}
+	if cols == 0 {
+		return
+	}
+	colWidth := width / float64(cols)
+	pdf.SetDrawColor(203, 213, 225)
+	for i, row := range rows {
+		style := "D"
+		if i == 0 {
+			style = "FD"
+			pdf.SetFont("Helvetica", "B", 9.5)
+			pdf.SetFillColor(238, 242, 255)
+		} else {
+			pdf.SetFont("Helvetica", "", 9.5)
+		}
+		// Size the row to its tallest cell so wrapped text stays inside.
+		lines := 1
+		for _, cell := range row {
+			lines = max(lines, len(pdf.SplitLines([]byte(tr(cell)), colWidth-2)))
+		}
+		height := float64(lines) * 5
+		_, pageHeight := pdf.GetPageSize()
+		if pdf.GetY()+height > pageHeight-20 {
+			pdf.AddPage()
+		}
+		x, y := pdf.GetXY()
+		for c := 0; c < cols; c++ {
+			cell := ""
+			if c < len(row) {
+				cell = row[c]
+			}
+			pdf.Rect(x+float64(c)*colWidth, y, colWidth, height, style)
+			pdf.SetXY(x+float64(c)*colWidth+1, y)
+			pdf.MultiCell(colWidth-2, 5, tr(cell), "", "L", false)
+		}
+		pdf.SetXY(x, y+height)
+	}
+}
+
+// pdfImagesPerRow is how many pictures an image block fits across the page.
+const pdfImagesPerRow = 3
+
+// renderPDFImages lays images out in a grid with their captions underneath.
+func renderPDFImages(pdf *fpdf.Fpdf, tr func(string) string, width float64, images []docImage) {
+	const gap, captionHeight = 4.0, 5.0
+	cellWidth := (width - gap*(pdfImagesPerRow-1)) / pdfImagesPerRow
+	_, pageHeight := pdf.GetPageSize()
+
+	type placed struct {
+		name    string
+		caption string
+		w, h    float64
+	}
+	var row []placed
+	flush := func() {
+		if len(row) == 0 {
+			return
+		}
+		rowHeight := 0.0
+		for _, p := range row {
+			rowHeight = max(rowHeight, p.h)
+		}
+		if pdf.GetY()+rowHeight+captionHeight > pageHeight-20 {
+			pdf.AddPage()
+		}
+		x, y := pdf.GetXY()
+		pdf.SetFont("Helvetica", "I", 8)
+		pdf.SetTextColor(100, 116, 139)
+		for i, p := range row {
+			left := x + float64(i)*(cellWidth+gap)
+			pdf.ImageOptions(p.name, left, y, p.w, p.h, false, fpdf.ImageOptions{}, 0, "")
+			pdf.SetXY(left, y+rowHeight+1)
+			pdf.CellFormat(cellWidth, captionHeight-1, tr(p.caption), "", 0, "L", false, 0, "")
+		}
+		pdf.SetXY(x, y+rowHeight+captionHeight+2)
+		row = row[:0]
+	}
+
+	for _, img := range images {
+		var imageType string
+		switch http.DetectContentType(img.data) {
+		case "image/jpeg":
+			imageType = "JPG"
+		case "image/png":
+			imageType = "PNG"
+		default:
+			continue
+		}
+		opts := fpdf.ImageOptions{ImageType: imageType}
+		info := pdf.RegisterImageOptionsReader(img.ref, opts, bytes.NewReader(img.data))
+		if info == nil || pdf.Err() {
+			pdf.ClearError()
+			continue
+		}
+		// Fit inside a cell no taller than it is wide, keeping proportions.
+		w, h := cellWidth, cellWidth*info.Height()/info.Width()
+		if h > cellWidth {
+			w, h = cellWidth*cellWidth/h, cellWidth
+		}
+		row = append(row, placed{img.ref, img.caption, w, h})
+		if len(row) == pdfImagesPerRow {
+			flush()
+		}
+	}
+	flush()
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// auditCategoryFor maps an intake form audit type to its workspace category.
+func auditCategoryFor(t AuditType) (AuditCategory, bool) {
+	switch t {
+	case CSSD:
+		return CategoryCSSD, true
+	case ENDOSCOPY:
+		return CategoryEndoscopy, true
+	case DENTAL:
+		return CategoryDental, true
+	}
+	return "", false
+}
+
+// draftInstanceID is deterministic so converting a submission twice finds
+// the instances made the first time instead of duplicating them.
+func draftInstanceID(subID string, category AuditCategory) string {
+	return subID + "-" + slugify(string(category))
+}
+
+var seedStopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true,
+	"be": true, "by": true, "for": true, "from": true, "in": true, "is": true,
+	"it": true, "not": true, "of": true, "on": true, "or": true, "the": true,
+	"to": true, "was": true, "were": true, "with": true, "all": true,
+}
+
+// seedTokens are the significant words of s, crudely singularised so
+// "washers" matches "washer".
+func seedTokens(s string) map[string]bool {
+	out := map[string]bool{}
+	for _, w := range normalizeWords(s, nil, seedStopWords) {
+		if len(w) > 3 {
+			w = strings.TrimSuffix(w, "s")
+		}
+		out[w] = true
+	}
+	return out
+}
+
+// maxFocusMatches keeps a broad focus keyword ("compliance") from being
+// noted on every finding; those stay in the summary note only.
+const maxFocusMatches = 5
+
+// seedResponses pre-fills response notes for a new instance from what the
+// client told us on the intake form. The first finding of the checklist gets
+// a summary of every area of focus and prior survey finding. A prior finding
+// is also noted on the master finding it most resembles, and an area of
+// focus on the few findings that mention all its words.
+func seedResponses(master AuditReport, data AuditData) map[string]AuditResponse {
+	var focus, prior []string
+	for _, f := range data.AreasOfFocus {
+		if f = strings.TrimSpace(f); f != "" {
+			focus = append(focus, f)
+		}
+	}
+	for _, f := range data.Findings {
+		if f = strings.TrimSpace(f); f != "" {
+			prior = append(prior, f)
+		}
+	}
+	notes := map[string][]string{}
+	var order []string
+	note := func(findingID, text string) {
+		if _, ok := notes[findingID]; !ok {
+			order = append(order, findingID)
+		}
+		notes[findingID] = append(notes[findingID], text)
+	}
+
+	type candidate struct {
+		id     string
+		tokens map[string]bool
+	}
+	var findings []candidate
+	walkFindings(master, AuditInstance{}, func(section AuditSection, f AuditFinding, _ AuditResponse) {
+		findings = append(findings, candidate{f.ID, seedTokens(sectionTitles[section] + " " + f.EquipmentSubject + " " + f.Issue)})
+	})
+	if len(findings) == 0 {
+		return map[string]AuditResponse{}
+	}
+
+	if len(focus) > 0 || len(prior) > 0 {
+		lines := []string{"Pre-audit request:"}
+		if len(focus) > 0 {
+			lines = append(lines, "Areas of focus: "+strings.Join(focus, ", "))
+		}
+		for _, f := range prior {
+			lines = append(lines, "Prior survey finding: "+f)
+		}
+		note(findings[0].id, strings.Join(lines, "\n"))
+	}
+
+	for _, f := range focus {
+		want := seedTokens(f)
+		if len(want) == 0 {
+			continue
+		}
+		var matched []string
+		for _, c := range findings {
+			all := true
+			for w := range want {
+				if !c.tokens[w] {
+					all = false
+					break
+				}
+			}
+			if all {
+				matched = append(matched, c.id)
+			}
+		}
+		if len(matched) > maxFocusMatches {
+			continue
+		}
+		for _, id := range matched {
+			note(id, "Client area of focus: "+f)
+		}
+	}
+
+	// A prior finding needs at least two words in common with a master
+	// finding, covering half of its own words, to be attached there.
+	for _, f := range prior {
+		want := seedTokens(f)
+		best, bestShared := "", 0
+		for _, c := range findings {
+			shared := 0
+			for w := range want {
+				if c.tokens[w] {
+					shared++
+				}
+			}
+			if shared > bestShared {
+				best, bestShared = c.id, shared
+			}
+		}
+		if bestShared >= 2 && bestShared*2 >= len(want) {
+			note(best, "Cited on prior survey: "+f)
+		}
+	}
+
+	out := map[string]AuditResponse{}
+	for _, id := range order {
+		out[id] = AuditResponse{
+			FindingID: id,
+			Status:    StatusUnanswered,
+			Notes:     strings.Join(notes[id], "\n"),
+			Images:    []string{},
+		}
+	}
+	return out
+}
+
+// DraftInstancesResult reports what converting a submission did. Instances
+// holds one entry per requested audit type, whether created now or before.
+type DraftInstancesResult struct {
+	Instances []AuditInstance `json:"instances"`
+	Created   []string        `json:"created"`
+	Existing  []string        `json:"existing"`
+	// Unseeded lists categories without a master checklist, whose
+	// instances start with no notes.
+	Unseeded []AuditCategory `json:"unseeded,omitempty"`
+}
+
+// CreateDraftInstances converts a won submission into one draft instance per
+// requested audit type. It is safe to call again: instances that already
+// exist are returned untouched.
+func CreateDraftInstances(ws *WorkspaceStore, sub Submission) (DraftInstancesResult, error) {
+	res := DraftInstancesResult{Instances: []AuditInstance{}, Created: []string{}, Existing: []string{}}
+	seen := map[AuditCategory]bool{}
+	for _, t := range sub.Data.AuditType {
+		category, ok := auditCategoryFor(t)
+		if !ok {
+			return res, fmt.Errorf("%w: unknown audit type %q", errInvalid, t)
+		}
+		if seen[category] {
+			continue
+		}
+		seen[category] = true
+
+		inst := AuditInstance{
+			ID:           draftInstanceID(sub.ID, category),
+			FacilityName: sub.Data.FacilityName,
+			FacilityID:   sub.FacilityID,
+			Category:     category,
+			Status:       InstanceDraft,
+		}
+		if master, err := ws.Master(category); err == nil {
+			inst.Responses = seedResponses(master, sub.Data)
+		} else {
+			res.Unseeded = append(res.Unseeded, category)
+		}
+		created, err := ws.CreateInstance(inst)
+		var conflict *conflictError
+		if errors.As(err, &conflict) {
+			res.Instances = append(res.Instances, conflict.Current.(AuditInstance))
+			res.Existing = append(res.Existing, inst.ID)
+			continue
+		}
+		if err != nil {
+			return res, err
+		}
+		res.Instances = append(res.Instances, created)
+		res.Created = append(res.Created, created.ID)
+	}
+	if len(seen) == 0 {
+		return res, fmt.Errorf("%w: the submission requests no audit types", errInvalid)
+	}
+	return res, nil
+}
+
+func registerDraftInstanceRoutes(mux *http.ServeMux, subs *SubmissionStore, ws *WorkspaceStore) {
+	mux.Handle("POST /submissions/{id}/instances", requireOffice(func(w http.ResponseWriter, r *http.Request) {
+		sub, err := subs.Get(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		res, err := CreateDraftInstances(ws, sub)
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		status := http.StatusOK
+		if len(res.Created) > 0 {
+			status = http.StatusCreated
+		}
+		writeJSON(w, status, res)
+	}))
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+)
+
+//go:embed estimator.json
+var defaultEstimatorConfig []byte
+
+// EstimatorConfig holds the rates and multipliers used to quote an
+// engagement. It is loaded from ESTIMATOR_CONFIG, or from the estimator.json
+// compiled into the binary when that variable is unset.
+type EstimatorConfig struct {
+	Currency          string             `json:"currency"`
+	DayRate           float64            `json:"day_rate"`
+	PriceSpread       float64            `json:"price_spread"`
+	PriceRounding     float64            `json:"price_rounding"`
+	DayIncrement      float64            `json:"day_increment"`
+	MinimumDays       float64            `json:"minimum_days"`
+	BaseDays          map[string]float64 `json:"base_days"`
+	DefaultBaseDays   float64            `json:"default_base_days"`
+	PerORDays         float64            `json:"per_or_days"`
+	PerClinicDays     float64            `json:"per_clinic_days"`
+	PerStaffDays      float64            `json:"per_staff_days"`
+	ProcedureDays     map[string]float64 `json:"procedure_days"`
+	TraumaMultipliers map[string]float64 `json:"trauma_multipliers"`
+}
+
+type EstimateLine struct {
+	Label string  `json:"label"`
+	Days  float64 `json:"days"`
+}
+
+// Estimate is a rough quote for an engagement. ConsultantDays is rounded up
+// to the configured increment before pricing.
+type Estimate struct {
+	ConsultantDays   float64        `json:"consultant_days"`
+	TraumaMultiplier float64        `json:"trauma_multiplier"`
+	PriceLow         float64        `json:"price_low"`
+	PriceHigh        float64        `json:"price_high"`
+	Currency         string         `json:"currency"`
+	Breakdown        []EstimateLine `json:"breakdown"`
+}
+
+type Estimator struct {
+	cfg EstimatorConfig
+}
+
+func LoadEstimator(path string) (*Estimator, error) {
+	raw := defaultEstimatorConfig
+	if path != "" {
+		var err error
+		if raw, err = os.ReadFile(path); err != nil {
+			return nil, err
+		}
+	}
+	var cfg EstimatorConfig
+	if err := json.Unmarshal(raw, &cfg); err != nil {
+		return nil, err
+	}
+	if cfg.DayRate <= 0 {
+		return nil, errors.New("estimator config: day_rate must be positive")
+	}
+	return &Estimator{cfg: cfg}, nil
+}
+
+var countPattern = regexp.MustCompile(`\d+`)
+
+// parseCount reads a free-text count from the intake form. Answers such as
+// "10-12" or "about 8" are common, so the largest number found wins.
+func parseCount(s string) int {
+	n := 0
+	for _, m := range countPattern.FindAllString(s, -1) {
+		if v, err := strconv.Atoi(m); err == nil && v > n {
+			n = v
+		}
+	}
+	return n
+}
+
+func staffTotal(data AuditData) int {
+	return parseCount(data.StaffFtWFmla) + parseCount(data.StaffPt) +
+		parseCount(data.StaffPd) + parseCount(data.StaffTravelers)
+}
+
+func (e *Estimator) Estimate(data AuditData) Estimate {
+	cfg := e.cfg
+	est := Estimate{Currency: cfg.Currency, TraumaMultiplier: 1}
+	add := func(label string, days float64) {
+		if days > 0 {
+			est.Breakdown = append(est.Breakdown, EstimateLine{Label: label, Days: days})
+		}
+	}
+
+	if len(data.AuditType) == 0 {
+		add("Operational Review", cfg.DefaultBaseDays)
+	}
+	for _, at := range data.AuditType {
+		days, ok := cfg.BaseDays[string(at)]
+		if !ok {
+			days = cfg.DefaultBaseDays
+		}
+		add(string(at)+" base scope", days)
+	}
+
+	ors, clinics, staff := parseCount(data.OrCount), parseCount(data.ClinicCount), staffTotal(data)
+	add(fmt.Sprintf("Operating rooms (%d)", ors), float64(ors)*cfg.PerORDays)
+	add(fmt.Sprintf("Clinics (%d)", clinics), float64(clinics)*cfg.PerClinicDays)
+	add(fmt.Sprintf("Staff interviews (%d)", staff), float64(staff)*cfg.PerStaffDays)
+
+	procedures := []struct {
+		key, label string
+		on         bool
+	}{
+		{"endoscopes", "Endoscopes", data.ProcEndoscopes},
+		{"vascular", "Vascular/Heart", data.ProcVascular},
+		{"arthroscopic", "Arthroscopic", data.ProcArthroscopic},
+		{"robotic", "Robotic", data.ProcRobotic},
+		{"tee", "TEE Probes", data.ProcTee},
+	}
+	for _, p := range procedures {
+		if p.on {
+			add(p.label, cfg.ProcedureDays[p.key])
+		}
+	}
+
+	var days float64
+	for _, line := range est.Breakdown {
+		days += line.Days
+	}
+	if m, ok := cfg.TraumaMultipliers[data.TraumaLevel]; ok && m > 0 {
+		est.TraumaMultiplier = m
+	}
+	days *= est.TraumaMultiplier
+	if days < cfg.MinimumDays {
+		days = cfg.MinimumDays
+	}
+	if cfg.DayIncrement > 0 {
+		// Trim float noise first so 3.0000001 days doesn't round up to 3.5.
+		days = math.Ceil(math.Round(days*100)/100/cfg.DayIncrement) * cfg.DayIncrement
+	}
+	est.ConsultantDays = days
+
+	price := days * cfg.DayRate
+	est.PriceLow = roundTo(price*(1-cfg.PriceSpread), cfg.PriceRounding)
+	est.PriceHigh = roundTo(price*(1+cfg.PriceSpread), cfg.PriceRounding)
+	return est
+}
+
+func roundTo(v, step float64) float64 {
+	if step <= 0 {
+		return math.Round(v)
+	}
+	return math.Round(v/step) * step
+}
+
+func registerEstimateRoutes(mux *http.ServeMux, subs *SubmissionStore, estimator *Estimator) {
+	mux.Handle("POST /estimate", requireOffice(func(w http.ResponseWriter, r *http.Request) {
+		var data AuditData
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, http.StatusOK, estimator.Estimate(data))
+	}))
+	mux.Handle("GET /submissions/{id}/estimate", requireOffice(func(w http.ResponseWriter, r *http.Request) {
+		sub, err := subs.Get(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, estimator.Estimate(sub.Data))
+	}))
+}
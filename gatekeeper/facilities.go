@@ -0,0 +1,466 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Facility is one physical site, however many spellings it has been
+// submitted under.
+type Facility struct {
+	ID            string    `json:"id"`
+	Name          string    `json:"name"`
+	Address       string    `json:"address"`
+	Key           string    `json:"key"`
+	SystemID      string    `json:"system_id,omitempty"`
+	Aliases       []string  `json:"aliases,omitempty"`
+	SubmissionIDs []string  `json:"submission_ids"`
+	MergedInto    string    `json:"merged_into,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// HealthSystem is the parent organisation named in SystemName.
+type HealthSystem struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Key       string    `json:"key"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type registryData struct {
+	Facilities map[string]*Facility     `json:"facilities"`
+	Systems    map[string]*HealthSystem `json:"systems"`
+}
+
+type FacilityRegistry struct {
+	file *fileStore[registryData]
+}
+
+func OpenFacilityRegistry(path string) (*FacilityRegistry, error) {
+	f, err := openFileStore[registryData](path)
+	if err != nil {
+		return nil, err
+	}
+	return &FacilityRegistry{file: f}, nil
+}
+
+var (
+	nonAlnum   = regexp.MustCompile(`[^a-z0-9 ]+`)
+	zipPattern = regexp.MustCompile(`\b\d{5}\b`)
+	unitSuffix = regexp.MustCompile(`\b(suite|ste|unit|apt|bldg|building|floor|fl|room|rm) [a-z0-9]+\b`)
+)
+
+var nameWords = map[string]string{
+	"st": "saint", "mt": "mount", "hosp": "hospital", "med": "medical",
+	"ctr": "center", "cntr": "center", "centre": "center", "reg": "regional",
+	"univ": "university",
+}
+
+var nameStopWords = map[string]bool{"the": true, "inc": true, "llc": true, "of": true, "and": true}
+
+var addressWords = map[string]string{
+	"street": "st", "avenue": "ave", "av": "ave", "road": "rd", "boulevard": "blvd",
+	"drive": "dr", "lane": "ln", "court": "ct", "place": "pl", "parkway": "pkwy",
+	"highway": "hwy", "circle": "cir", "terrace": "ter",
+	"north": "n", "south": "s", "east": "e", "west": "w",
+	"northeast": "ne", "northwest": "nw", "southeast": "se", "southwest": "sw",
+}
+
+func normalizeWords(s string, replace map[string]string, drop map[string]bool) []string {
+	s = strings.ToLower(strings.ReplaceAll(s, "&", " and "))
+	s = strings.NewReplacer("'", "", "’", "").Replace(s)
+	s = nonAlnum.ReplaceAllString(s, " ")
+	var out []string
+	for _, w := range strings.Fields(s) {
+		if r, ok := replace[w]; ok {
+			w = r
+		}
+		if !drop[w] {
+			out = append(out, w)
+		}
+	}
+	return out
+}
+
+func normalizeFacilityName(name string) string {
+	return strings.Join(normalizeWords(name, nameWords, nameStopWords), " ")
+}
+
+// normalizeAddress canonicalises street suffixes and directions and drops
+// suite or floor designators, which vary between submitters at one site.
+func normalizeAddress(addr string) string {
+	words := strings.Join(normalizeWords(addr, addressWords, nil), " ")
+	return strings.Join(strings.Fields(unitSuffix.ReplaceAllString(words, " ")), " ")
+}
+
+func facilityKey(name, address string) string {
+	return normalizeFacilityName(name) + "|" + normalizeAddress(address)
+}
+
+// nameSimilarity is the Jaccard overlap of the normalised name tokens.
+func nameSimilarity(a, b string) float64 {
+	ta, tb := map[string]bool{}, map[string]bool{}
+	for _, w := range strings.Fields(a) {
+		ta[w] = true
+	}
+	for _, w := range strings.Fields(b) {
+		tb[w] = true
+	}
+	inter := 0
+	for w := range ta {
+		if tb[w] {
+			inter++
+		}
+	}
+	union := len(ta) + len(tb) - inter
+	if union == 0 {
+		return 0
+	}
+	return float64(inter) / float64(union)
+}
+
+// live follows merges from id to the surviving facility.
+func (d *registryData) live(id string) *Facility {
+	for hops := 0; hops < 16; hops++ {
+		f, ok := d.Facilities[id]
+		if !ok {
+			return nil
+		}
+		if f.MergedInto == "" {
+			return f
+		}
+		id = f.MergedInto
+	}
+	return nil
+}
+
+// match finds the live facility a name and address most likely refer to:
+// an exact key match, then the same street address with a similar name,
+// then the same name in the same ZIP code. Merged records still match, and
+// resolve to the facility they were merged into, so a duplicate's spelling
+// keeps leading to the surviving record.
+func (d *registryData) match(name, address string) *Facility {
+	normName, normAddr := normalizeFacilityName(name), normalizeAddress(address)
+	zip := zipPattern.FindString(address)
+	var byAddr, byZip *Facility
+	for _, f := range d.Facilities {
+		if f.Key == normName+"|"+normAddr {
+			if live := d.live(f.ID); live != nil {
+				return live
+			}
+			continue
+		}
+		fName, fAddr, _ := strings.Cut(f.Key, "|")
+		if byAddr == nil && fAddr != "" && fAddr == normAddr && nameSimilarity(fName, normName) >= 0.5 {
+			byAddr = f
+		}
+		if byZip == nil && zip != "" && fName == normName && zipPattern.FindString(f.Address) == zip {
+			byZip = f
+		}
+	}
+	if byAddr != nil {
+		return d.live(byAddr.ID)
+	}
+	if byZip != nil {
+		return d.live(byZip.ID)
+	}
+	return nil
+}
+
+func (d *registryData) system(name string) *HealthSystem {
+	key := normalizeFacilityName(name)
+	if key == "" {
+		return nil
+	}
+	for _, s := range d.Systems {
+		if s.Key == key {
+			return s
+		}
+	}
+	s := &HealthSystem{ID: newID(), Name: strings.TrimSpace(name), Key: key, CreatedAt: time.Now().UTC()}
+	d.Systems[s.ID] = s
+	return s
+}
+
+// Link attaches a submission to its facility, creating the facility and its
+// health system as needed, and returns the facility ID.
+func (r *FacilityRegistry) Link(sub Submission) (string, error) {
+	var id string
+	err := r.file.Update(func(d *registryData) error {
+		if d.Facilities == nil {
+			d.Facilities = map[string]*Facility{}
+		}
+		if d.Systems == nil {
+			d.Systems = map[string]*HealthSystem{}
+		}
+		data := sub.Data
+		f := d.match(data.FacilityName, data.FacilityAddress)
+		if f == nil {
+			f = &Facility{
+				ID:        newID(),
+				Name:      strings.TrimSpace(data.FacilityName),
+				Address:   strings.TrimSpace(data.FacilityAddress),
+				Key:       facilityKey(data.FacilityName, data.FacilityAddress),
+				CreatedAt: time.Now().UTC(),
+			}
+			d.Facilities[f.ID] = f
+		} else if name := strings.TrimSpace(data.FacilityName); name != f.Name && !containsString(f.Aliases, name) {
+			f.Aliases = append(f.Aliases, name)
+		}
+		if !containsString(f.SubmissionIDs, sub.ID) {
+			f.SubmissionIDs = append(f.SubmissionIDs, sub.ID)
+		}
+		if data.IsAffiliated && data.SystemName != nil {
+			if s := d.system(*data.SystemName); s != nil {
+				f.SystemID = s.ID
+			}
+		}
+		id = f.ID
+		return nil
+	})
+	return id, err
+}
+
+// Merge folds the duplicate facility into target and returns the submission
+// IDs that moved.
+func (r *FacilityRegistry) Merge(duplicateID, targetID string) ([]string, error) {
+	var moved []string
+	err := r.file.Update(func(d *registryData) error {
+		dup, ok := d.Facilities[duplicateID]
+		if !ok || dup.MergedInto != "" {
+			return errNotFound
+		}
+		target, ok := d.Facilities[targetID]
+		if !ok || target.MergedInto != "" {
+			return errNotFound
+		}
+		if dup.ID == target.ID {
+			return errors.New("cannot merge a facility into itself")
+		}
+		for _, name := range append([]string{dup.Name}, dup.Aliases...) {
+			if name != target.Name && !containsString(target.Aliases, name) {
+				target.Aliases = append(target.Aliases, name)
+			}
+		}
+		for _, id := range dup.SubmissionIDs {
+			if !containsString(target.SubmissionIDs, id) {
+				target.SubmissionIDs = append(target.SubmissionIDs, id)
+			}
+		}
+		if target.SystemID == "" {
+			target.SystemID = dup.SystemID
+		}
+		moved = dup.SubmissionIDs
+		dup.MergedInto = target.ID
+		dup.SubmissionIDs = nil
+		return nil
+	})
+	return moved, err
+}
+
+// Get returns a facility, following merges to the surviving record.
+func (r *FacilityRegistry) Get(id string) (Facility, error) {
+	var (
+		f  Facility
+		ok bool
+	)
+	r.file.View(func(d *registryData) {
+		var p *Facility
+		if p = d.live(id); p != nil {
+			f, ok = *p, true
+		}
+	})
+	if !ok {
+		return Facility{}, errNotFound
+	}
+	return f, nil
+}
+
+// List returns live facilities, optionally limited to one health system.
+func (r *FacilityRegistry) List(systemID string) []Facility {
+	out := []Facility{}
+	r.file.View(func(d *registryData) {
+		for _, f := range d.Facilities {
+			if f.MergedInto == "" && (systemID == "" || f.SystemID == systemID) {
+				out = append(out, *f)
+			}
+		}
+	})
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+func (r *FacilityRegistry) Systems() []HealthSystem {
+	out := []HealthSystem{}
+	r.file.View(func(d *registryData) {
+		for _, s := range d.Systems {
+			out = append(out, *s)
+		}
+	})
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+func (r *FacilityRegistry) System(id string) (HealthSystem, error) {
+	var (
+		s  HealthSystem
+		ok bool
+	)
+	r.file.View(func(d *registryData) {
+		var p *HealthSystem
+		if p, ok = d.Systems[id]; ok {
+			s = *p
+		}
+	})
+	if !ok {
+		return HealthSystem{}, errNotFound
+	}
+	return s, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// linkSubmission links sub to its facility and records the facility on the
+// submission.
+func linkSubmission(registry *FacilityRegistry, subs *SubmissionStore, sub Submission) error {
+	facilityID, err := registry.Link(sub)
+	if err != nil {
+		return err
+	}
+	_, err = subs.Modify(sub.ID, func(s *Submission) error {
+		s.FacilityID = facilityID
+		return nil
+	})
+	return err
+}
+
+// backfillFacilities links submissions received before the registry existed.
+func backfillFacilities(registry *FacilityRegistry, subs *SubmissionStore) error {
+	for _, sub := range subs.List() {
+		if sub.FacilityID != "" {
+			continue
+		}
+		if err := linkSubmission(registry, subs, sub); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type facilityHistory struct {
+	Facility
+	System      *HealthSystem `json:"system,omitempty"`
+	Submissions []Submission  `json:"submissions"`
+}
+
+func registerFacilityRoutes(mux *http.ServeMux, registry *FacilityRegistry, subs *SubmissionStore, ws *WorkspaceStore) {
+	mux.Handle("GET /facilities", requireOffice(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, registry.List(r.URL.Query().Get("system_id")))
+	}))
+	mux.Handle("GET /facilities/{id}", requireOffice(func(w http.ResponseWriter, r *http.Request) {
+		f, err := registry.Get(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		out := facilityHistory{Facility: f, Submissions: []Submission{}}
+		if s, err := registry.System(f.SystemID); err == nil {
+			out.System = &s
+		}
+		for _, id := range f.SubmissionIDs {
+			if sub, err := subs.Get(id); err == nil {
+				out.Submissions = append(out.Submissions, sub)
+			}
+		}
+		sort.Slice(out.Submissions, func(i, j int) bool {
+			return out.Submissions[i].ReceivedAt.After(out.Submissions[j].ReceivedAt)
+		})
+		writeJSON(w, http.StatusOK, out)
+	}))
+	mux.Handle("POST /facilities/{id}/merge", requireOffice(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Into string `json:"into"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Into == "" {
+			http.Error(w, "body must be {\"into\": \"<facility id>\"}", http.StatusBadRequest)
+			return
+		}
+		moved, err := registry.Merge(r.PathValue("id"), req.Into)
+		if errors.Is(err, errNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for _, id := range moved {
+			_, err := subs.Modify(id, func(s *Submission) error {
+				s.FacilityID = req.Into
+				return nil
+			})
+			if err != nil && !errors.Is(err, errNotFound) {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		if err := ws.RelinkFacility(r.PathValue("id"), req.Into); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		f, _ := registry.Get(req.Into)
+		writeJSON(w, http.StatusOK, f)
+	}))
+	// Audits started in the field carry only the name the auditor typed;
+	// staff link them to the registry so the facility can see them.
+	mux.Handle("PUT /realm/instances/{id}/facility", realmRoute(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			FacilityID string `json:"facilityId"`
+			UpdatedAt  int64  `json:"updatedAt"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.FacilityID != "" {
+			f, err := registry.Get(req.FacilityID)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			req.FacilityID = f.ID
+		}
+		inst, err := ws.LinkFacility(r.PathValue("id"), req.FacilityID, req.UpdatedAt)
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, inst)
+	}))
+	mux.Handle("GET /systems", requireOffice(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, registry.Systems())
+	}))
+	mux.Handle("GET /systems/{id}", requireOffice(func(w http.ResponseWriter, r *http.Request) {
+		s, err := registry.System(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, struct {
+			HealthSystem
+			Facilities []Facility `json:"facilities"`
+		}{s, registry.List(s.ID)})
+	}))
+}
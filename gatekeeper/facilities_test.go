@@ -0,0 +1,66 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestLinkAfterMergeFindsSurvivor(t *testing.T) {
+	r, err := OpenFacilityRegistry(filepath.Join(t.TempDir(), "facilities.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	link := func(name, address string) string {
+		t.Helper()
+		id, err := r.Link(Submission{ID: newID(), Data: AuditData{FacilityName: name, FacilityAddress: address}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	target := link("Mercy Hospital", "1 Main Street, Springfield 12345")
+	dup := link("Our Lady of Mercy", "400 Hill Road, Springfield 12346")
+	if dup == target {
+		t.Fatal("want two facilities before the merge")
+	}
+	if _, err := r.Merge(dup, target); err != nil {
+		t.Fatal(err)
+	}
+	if got := link("Our Lady of Mercy", "400 Hill Rd, Springfield 12346"); got != target {
+		t.Errorf("submission under the duplicate's name and address linked to %s, want %s", got, target)
+	}
+	if n := len(r.List("")); n != 1 {
+		t.Errorf("live facilities = %d, want 1", n)
+	}
+	f, err := r.Get(dup)
+	if err != nil || f.ID != target || len(f.SubmissionIDs) != 3 {
+		t.Errorf("Get(duplicate) = %+v, %v", f, err)
+	}
+}
+
+func TestInstanceFacilityLink(t *testing.T) {
+	ws := openTestWorkspace(t)
+	res, err := CreateDraftInstances(ws, Submission{ID: "sub-1", FacilityID: "fac-dup", Data: AuditData{FacilityName: "Mercy", AuditType: []AuditType{CSSD}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	inst := res.Instances[0]
+	if inst.FacilityID != "fac-dup" {
+		t.Fatalf("draft facility = %q, want the submission's", inst.FacilityID)
+	}
+	// Clients that do not know the field cannot unlink the instance.
+	inst.FacilityID = ""
+	inst.EngagementPartner = "Lee"
+	if inst, err = ws.UpdateInstance(inst.ID, inst); err != nil || inst.FacilityID != "fac-dup" {
+		t.Fatalf("after update: %q, %v", inst.FacilityID, err)
+	}
+	if err := ws.RelinkFacility("fac-dup", "fac-1"); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := ws.Instance(inst.ID); got.FacilityID != "fac-1" || got.Version <= inst.Version {
+		t.Errorf("after the merge: facility %q, version %d (was %d)", got.FacilityID, got.Version, inst.Version)
+	}
+	if inst, err = ws.LinkFacility(inst.ID, "", 0); err != nil || inst.FacilityID != "" {
+		t.Errorf("unlink: %q, %v", inst.FacilityID, err)
+	}
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HubSpot association type IDs (HUBSPOT_DEFINED category).
+const (
+	hubspotContactToCompany = 279
+	hubspotDealToCompany    = 5
+	hubspotDealToContact    = 3
+	hubspotChildToParent    = 14
+)
+
+// HubSpotCRM implements CRMConnector against the HubSpot CRM v3 object API.
+// BaseURL is configurable so it can be pointed at a local fake server.
+// DealKeyProperty names a custom deal property, which must exist in the
+// portal, holding the submission ID; deals are found by it before one is
+// created so a retried sync never creates a second deal. CompanyKeyProperty
+// is the custom company property holding the registry facility ID.
+type HubSpotCRM struct {
+	BaseURL            string
+	Token              string
+	Pipeline           string
+	DealStage          string
+	DealKeyProperty    string
+	CompanyKeyProperty string
+	Client             *http.Client
+}
+
+func NewHubSpotCRM(baseURL, token string) *HubSpotCRM {
+	if baseURL == "" {
+		baseURL = "https://api.hubapi.com"
+	}
+	return &HubSpotCRM{
+		BaseURL:            strings.TrimSuffix(baseURL, "/"),
+		Token:              token,
+		Pipeline:           "default",
+		DealStage:          "appointmentscheduled",
+		DealKeyProperty:    "gatekeeper_submission_id",
+		CompanyKeyProperty: "gatekeeper_facility_id",
+		Client:             &http.Client{Timeout: 20 * time.Second},
+	}
+}
+
+type hubspotAssociation struct {
+	To struct {
+		ID string `json:"id"`
+	} `json:"to"`
+	Types []hubspotAssociationType `json:"types"`
+}
+
+type hubspotAssociationType struct {
+	Category string `json:"associationCategory"`
+	TypeID   int    `json:"associationTypeId"`
+}
+
+func hubspotAssoc(id string, typeID int) hubspotAssociation {
+	a := hubspotAssociation{Types: []hubspotAssociationType{{Category: "HUBSPOT_DEFINED", TypeID: typeID}}}
+	a.To.ID = id
+	return a
+}
+
+type hubspotObject struct {
+	ID         string            `json:"id"`
+	Properties map[string]string `json:"properties"`
+}
+
+// hubspotError is returned for non-2xx responses.
+type hubspotError struct {
+	Status int
+	Body   string
+}
+
+func (e *hubspotError) Error() string {
+	return fmt.Sprintf("hubspot: status %d: %s", e.Status, e.Body)
+}
+
+func (h *HubSpotCRM) do(ctx context.Context, method, path string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		raw, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(raw)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, h.BaseURL+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+h.Token)
+	req.Header.Set("Content-Type", "application/json")
+	res, err := h.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 4096))
+		return &hubspotError{Status: res.StatusCode, Body: string(msg)}
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(res.Body).Decode(out)
+}
+
+// find returns the ID of the first object whose property equals value.
+func (h *HubSpotCRM) find(ctx context.Context, objectType, property, value string) (string, error) {
+	search := map[string]any{
+		"filterGroups": []any{
+			map[string]any{"filters": []any{
+				map[string]string{"propertyName": property, "operator": "EQ", "value": value},
+			}},
+		},
+		"limit": 1,
+	}
+	var res struct {
+		Results []hubspotObject `json:"results"`
+	}
+	if err := h.do(ctx, http.MethodPost, "/crm/v3/objects/"+objectType+"/search", search, &res); err != nil {
+		return "", err
+	}
+	if len(res.Results) == 0 {
+		return "", nil
+	}
+	return res.Results[0].ID, nil
+}
+
+// upsert updates the object matched by property=value, or creates it with
+// the given associations. created reports which of the two happened.
+func (h *HubSpotCRM) upsert(ctx context.Context, objectType, property, value string, props map[string]string, assoc []hubspotAssociation) (id string, created bool, err error) {
+	id, err = h.find(ctx, objectType, property, value)
+	if err != nil {
+		return "", false, err
+	}
+	var obj hubspotObject
+	if id != "" {
+		err = h.do(ctx, http.MethodPatch, "/crm/v3/objects/"+objectType+"/"+id, map[string]any{"properties": props}, &obj)
+		return id, false, err
+	}
+	body := map[string]any{"properties": props}
+	if len(assoc) > 0 {
+		body["associations"] = assoc
+	}
+	if err := h.do(ctx, http.MethodPost, "/crm/v3/objects/"+objectType, body, &obj); err != nil {
+		return "", false, err
+	}
+	return obj.ID, true, nil
+}
+
+// associate links two existing objects. Repeating it for a linked pair is a
+// no-op on HubSpot's side.
+func (h *HubSpotCRM) associate(ctx context.Context, fromType, fromID, toType, toID string, typeID int) error {
+	return h.do(ctx, http.MethodPut, "/crm/v4/objects/"+fromType+"/"+fromID+"/associations/"+toType+"/"+toID,
+		[]hubspotAssociationType{{Category: "HUBSPOT_DEFINED", TypeID: typeID}}, nil)
+}
+
+// UpsertCompany matches the facility on its registry ID, or on its address
+// when it has none. Health systems have no registry entry, so the parent is
+// matched on its name.
+func (h *HubSpotCRM) UpsertCompany(ctx context.Context, company CRMCompany) (string, error) {
+	props := map[string]string{
+		"name":    company.Name,
+		"address": company.Address,
+	}
+	property, value := h.CompanyKeyProperty, company.FacilityID
+	switch {
+	case value != "":
+		props[property] = value
+	case company.Address != "":
+		property, value = "address", company.Address
+	default:
+		return "", fmt.Errorf("company %q has neither a facility ID nor an address to match on", company.Name)
+	}
+	id, _, err := h.upsert(ctx, "companies", property, value, props, nil)
+	if err != nil || company.ParentName == "" {
+		return id, err
+	}
+	parentID, _, err := h.upsert(ctx, "companies", "name", company.ParentName, map[string]string{
+		"name": company.ParentName,
+	}, nil)
+	if err != nil {
+		return id, err
+	}
+	return id, h.associate(ctx, "companies", id, "companies", parentID, hubspotChildToParent)
+}
+
+func (h *HubSpotCRM) UpsertContact(ctx context.Context, contact CRMContact, companyID string) (string, error) {
+	var assoc []hubspotAssociation
+	if companyID != "" {
+		assoc = append(assoc, hubspotAssoc(companyID, hubspotContactToCompany))
+	}
+	id, created, err := h.upsert(ctx, "contacts", "email", contact.Email, map[string]string{
+		"email":     contact.Email,
+		"firstname": contact.FirstName,
+		"lastname":  contact.LastName,
+		"jobtitle":  contact.Title,
+		"phone":     contact.Phone,
+	}, assoc)
+	if err != nil || created || companyID == "" {
+		return id, err
+	}
+	return id, h.associate(ctx, "contacts", id, "companies", companyID, hubspotContactToCompany)
+}
+
+func (h *HubSpotCRM) SaveDeal(ctx context.Context, deal CRMDeal, companyID, contactID string) (string, error) {
+	props := map[string]string{
+		"dealname":    deal.Name,
+		"pipeline":    h.Pipeline,
+		"dealstage":   h.DealStage,
+		"amount":      strconv.FormatFloat(deal.Amount, 'f', 2, 64),
+		"description": deal.Description,
+	}
+	if deal.ID == "" && deal.SubmissionID != "" {
+		// A previous attempt may have created the deal and then failed
+		// before its ID was saved.
+		id, err := h.find(ctx, "deals", h.DealKeyProperty, deal.SubmissionID)
+		if err != nil {
+			return "", err
+		}
+		deal.ID = id
+	}
+	var obj hubspotObject
+	if deal.ID != "" {
+		// Leave pipeline and stage alone on updates; sales owns them once
+		// the deal exists.
+		delete(props, "pipeline")
+		delete(props, "dealstage")
+		err := h.do(ctx, http.MethodPatch, "/crm/v3/objects/deals/"+deal.ID, map[string]any{"properties": props}, &obj)
+		return deal.ID, err
+	}
+	var assoc []hubspotAssociation
+	if companyID != "" {
+		assoc = append(assoc, hubspotAssoc(companyID, hubspotDealToCompany))
+	}
+	if contactID != "" {
+		assoc = append(assoc, hubspotAssoc(contactID, hubspotDealToContact))
+	}
+	if deal.SubmissionID != "" {
+		props[h.DealKeyProperty] = deal.SubmissionID
+	}
+	body := map[string]any{"properties": props, "associations": assoc}
+	if err := h.do(ctx, http.MethodPost, "/crm/v3/objects/deals", body, &obj); err != nil {
+		return "", err
+	}
+	return obj.ID, nil
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeHubSpot serves the subset of the CRM object API HubSpotCRM uses,
+// keeping objects in memory.
+type fakeHubSpot struct {
+	mu           sync.Mutex
+	objects      map[string]map[string]map[string]string // type → id → properties
+	associations []string
+	creates      map[string]int
+	// loseCreate makes the next create of that type succeed but answer 502,
+	// as if the response was lost on the way back.
+	loseCreate map[string]bool
+	next       int
+}
+
+func newFakeHubSpot(t *testing.T) (*fakeHubSpot, *HubSpotCRM) {
+	f := &fakeHubSpot{
+		objects:    map[string]map[string]map[string]string{},
+		creates:    map[string]int{},
+		loseCreate: map[string]bool{},
+	}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	crm := NewHubSpotCRM(srv.URL, "test-token")
+	crm.Client = srv.Client()
+	return f, crm
+}
+
+func (f *fakeHubSpot) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if r.Header.Get("Authorization") != "Bearer test-token" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case r.Method == http.MethodPut && len(parts) == 8 && parts[1] == "v4":
+		f.associations = append(f.associations, parts[4]+"/"+parts[5]+"→"+parts[6]+"/"+parts[7])
+	case r.Method == http.MethodPost && len(parts) == 5 && parts[4] == "search":
+		var body struct {
+			FilterGroups []struct {
+				Filters []struct {
+					PropertyName string `json:"propertyName"`
+					Value        string `json:"value"`
+				} `json:"filters"`
+			} `json:"filterGroups"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		filter := body.FilterGroups[0].Filters[0]
+		results := []hubspotObject{}
+		for id, props := range f.objects[parts[3]] {
+			if props[filter.PropertyName] == filter.Value {
+				results = append(results, hubspotObject{ID: id, Properties: props})
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]any{"results": results})
+	case r.Method == http.MethodPost && len(parts) == 4:
+		var body struct {
+			Properties map[string]string `json:"properties"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		if f.objects[parts[3]] == nil {
+			f.objects[parts[3]] = map[string]map[string]string{}
+		}
+		f.next++
+		id := strconv.Itoa(f.next)
+		f.objects[parts[3]][id] = body.Properties
+		f.creates[parts[3]]++
+		if f.loseCreate[parts[3]] {
+			f.loseCreate[parts[3]] = false
+			http.Error(w, "bad gateway", http.StatusBadGateway)
+			return
+		}
+		json.NewEncoder(w).Encode(hubspotObject{ID: id, Properties: body.Properties})
+	case r.Method == http.MethodPatch && len(parts) == 5:
+		props, ok := f.objects[parts[3]][parts[4]]
+		if !ok {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		var body struct {
+			Properties map[string]string `json:"properties"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		for k, v := range body.Properties {
+			props[k] = v
+		}
+		json.NewEncoder(w).Encode(hubspotObject{ID: parts[4], Properties: props})
+	default:
+		http.Error(w, "unexpected "+r.Method+" "+r.URL.Path, http.StatusBadRequest)
+	}
+}
+
+func TestHubSpotUpsertsAreIdempotent(t *testing.T) {
+	fake, crm := newFakeHubSpot(t)
+	ctx := context.Background()
+	company := CRMCompany{FacilityID: "fac-1", Name: "Mercy Hospital", Address: "1 Main St", ParentName: "Mercy Health"}
+	first, err := crm.UpsertCompany(ctx, company)
+	if err != nil {
+		t.Fatal(err)
+	}
+	company.Address = "2 Main St"
+	second, err := crm.UpsertCompany(ctx, company)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != second || fake.creates["companies"] != 2 {
+		t.Fatalf("got ids %s, %s and %d company creates; want one facility and one parent", first, second, fake.creates["companies"])
+	}
+	if got := fake.objects["companies"][first]["address"]; got != "2 Main St" {
+		t.Errorf("address = %q, want the update applied", got)
+	}
+
+	contact := CRMContact{FirstName: "Ana", LastName: "Ruiz", Email: "ana@mercy.example"}
+	c1, err := crm.UpsertContact(ctx, contact, first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2, err := crm.UpsertContact(ctx, contact, first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c1 != c2 || fake.creates["contacts"] != 1 {
+		t.Fatalf("got contacts %s, %s with %d creates; want one", c1, c2, fake.creates["contacts"])
+	}
+	// The parent link is made on each company upsert and the contact link
+	// on the update; creates carry their associations inline.
+	if len(fake.associations) != 3 {
+		t.Errorf("associations = %v", fake.associations)
+	}
+}
+
+func TestHubSpotCompaniesAreNotMatchedByName(t *testing.T) {
+	fake, crm := newFakeHubSpot(t)
+	ctx := context.Background()
+	ids := map[string]bool{}
+	for _, company := range []CRMCompany{
+		{FacilityID: "fac-1", Name: "St. Mary's Hospital", Address: "1 Main St, Springfield"},
+		{FacilityID: "fac-2", Name: "St. Mary's Hospital", Address: "9 Elm St, Shelbyville"},
+		{Name: "St. Mary's Hospital", Address: "4 Oak Ave, Ogdenville"},
+		{Name: "St. Mary's Hospital", Address: "4 Oak Ave, Ogdenville"},
+	} {
+		id, err := crm.UpsertCompany(ctx, company)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[id] = true
+	}
+	if len(ids) != 3 || fake.creates["companies"] != 3 {
+		t.Errorf("got companies %v after %d creates; want one per facility", ids, fake.creates["companies"])
+	}
+	if _, err := crm.UpsertCompany(ctx, CRMCompany{Name: "St. Mary's Hospital"}); err == nil {
+		t.Error("a company with only a name was upserted")
+	}
+}
+
+func TestHubSpotSaveDealFindsExistingDeal(t *testing.T) {
+	fake, crm := newFakeHubSpot(t)
+	ctx := context.Background()
+	deal := CRMDeal{SubmissionID: "sub-1", Name: "Mercy – CSSD assessment", Amount: 12000}
+	fake.loseCreate["deals"] = true
+	if _, err := crm.SaveDeal(ctx, deal, "", ""); err == nil {
+		t.Fatal("want the lost response to fail the call")
+	}
+	fake.objects["deals"]["1"]["dealstage"] = "qualifiedtobuy"
+	deal.Amount = 15000
+	id, err := crm.SaveDeal(ctx, deal, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "1" || fake.creates["deals"] != 1 {
+		t.Fatalf("got deal %s after %d creates; want the retry to update deal 1", id, fake.creates["deals"])
+	}
+	props := fake.objects["deals"]["1"]
+	if props["amount"] != "15000.00" || props["dealstage"] != "qualifiedtobuy" {
+		t.Errorf("deal properties = %v; want amount updated and stage left alone", props)
+	}
+}
+
+func TestCRMSyncRetryDoesNotDuplicateDeal(t *testing.T) {
+	fake, crm := newFakeHubSpot(t)
+	dir := t.TempDir()
+	jobs, err := OpenScheduler(filepath.Join(dir, "jobs.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	subs, err := OpenSubmissionStore(filepath.Join(dir, "submissions.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	estimator, err := LoadEstimator("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub, err := subs.Add(AuditData{
+		FacilityName:    "Mercy Hospital",
+		FacilityAddress: "1 Main St",
+		ContactName:     "Ana Ruiz",
+		ContactEmail:    "ana@mercy.example",
+		AuditType:       []AuditType{CSSD},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := NewCRMSyncQueue(jobs, subs, crm, estimator)
+	payload, _ := json.Marshal(map[string]string{"submission_id": sub.ID})
+	job := Job{Kind: crmJobKind, Payload: payload}
+
+	fake.loseCreate["deals"] = true
+	if err := q.sync(context.Background(), job); err == nil {
+		t.Fatal("want the first attempt to fail")
+	}
+	if err := q.sync(context.Background(), job); err != nil {
+		t.Fatal(err)
+	}
+	if fake.creates["deals"] != 1 || fake.creates["companies"] != 1 || fake.creates["contacts"] != 1 {
+		t.Fatalf("creates = %v; want one of each", fake.creates)
+	}
+	got, _ := subs.Get(sub.ID)
+	if got.CRM == nil || got.CRM.DealID == "" || fake.objects["deals"][got.CRM.DealID] == nil {
+		t.Fatalf("sync state = %+v", got.CRM)
+	}
+}
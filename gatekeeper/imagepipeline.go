@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+
+	"github.com/HugoSmits86/nativewebp"
+	"golang.org/x/image/draw"
+	"golang.org/x/image/webp"
+)
+
+var (
+	errNotAnImage    = errors.New("file is not a JPEG, PNG or WebP image")
+	errImageTooLarge = errors.New("image is too large")
+)
+
+// maxImagePixels guards against decompression bombs: a small file can still
+// declare enormous dimensions.
+const maxImagePixels = 60_000_000
+
+// imageSizes are the derived sizes, by longest edge in pixels. The original
+// is kept at full resolution but re-encoded, which drops its metadata.
+var imageSizes = []struct {
+	Name    string
+	MaxEdge int
+}{
+	{"web", 1600},
+	{"thumb", 320},
+}
+
+const jpegQuality = 82
+
+// ImageVariant is one stored rendition of an uploaded image.
+type ImageVariant struct {
+	Name        string `json:"name"`
+	ContentType string `json:"contentType"`
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
+	Size        int64  `json:"size"`
+	Key         string `json:"-"`
+	URL         string `json:"url"`
+	// DownloadURL is a signed link, as for Asset.DownloadURL.
+	DownloadURL string `json:"downloadUrl,omitempty"`
+}
+
+type encodedVariant struct {
+	ImageVariant
+	Data []byte
+}
+
+// ProcessedImage is the output of ProcessImage. Original is the full-size,
+// upright, metadata-free JPEG that replaces the upload.
+type ProcessedImage struct {
+	SHA256   string
+	Width    int
+	Height   int
+	Original []byte
+	Variants []encodedVariant
+}
+
+// ProcessImage validates an uploaded photo and prepares what gets stored:
+// the image is turned upright according to its EXIF orientation and every
+// rendition is re-encoded from pixels, so no EXIF (GPS position, device
+// serials) survives. The hash is of the bytes as uploaded.
+func ProcessImage(raw []byte) (ProcessedImage, error) {
+	if len(raw) > maxAssetSize {
+		return ProcessedImage{}, errImageTooLarge
+	}
+	var decode func([]byte) (image.Image, error)
+	switch http.DetectContentType(raw) {
+	case "image/jpeg":
+		decode = func(b []byte) (image.Image, error) { return jpeg.Decode(bytes.NewReader(b)) }
+	case "image/png":
+		decode = func(b []byte) (image.Image, error) { return png.Decode(bytes.NewReader(b)) }
+	case "image/webp":
+		decode = func(b []byte) (image.Image, error) { return webp.Decode(bytes.NewReader(b)) }
+	default:
+		return ProcessedImage{}, errNotAnImage
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(raw))
+	if err != nil {
+		return ProcessedImage{}, fmt.Errorf("%w: %v", errNotAnImage, err)
+	}
+	if cfg.Width*cfg.Height > maxImagePixels {
+		return ProcessedImage{}, errImageTooLarge
+	}
+	img, err := decode(raw)
+	if err != nil {
+		return ProcessedImage{}, fmt.Errorf("%w: %v", errNotAnImage, err)
+	}
+	img = orient(img, jpegOrientation(raw))
+
+	sum := sha256.Sum256(raw)
+	out := ProcessedImage{
+		SHA256: hex.EncodeToString(sum[:]),
+		Width:  img.Bounds().Dx(),
+		Height: img.Bounds().Dy(),
+	}
+	if out.Original, err = encodeJPEG(img); err != nil {
+		return ProcessedImage{}, err
+	}
+	for _, size := range imageSizes {
+		scaled := fitWithin(img, size.MaxEdge)
+		b := scaled.Bounds()
+		variant := ImageVariant{Name: size.Name, Width: b.Dx(), Height: b.Dy()}
+
+		jpg, err := encodeJPEG(scaled)
+		if err != nil {
+			return ProcessedImage{}, err
+		}
+		variant.ContentType = "image/jpeg"
+		out.Variants = append(out.Variants, encodedVariant{variant, jpg})
+
+		// The pure-Go encoder only writes lossless WebP, which beats JPEG
+		// on flat images and screenshots but rarely on photos. Keep it only
+		// when it is the smaller file.
+		var webpBuf bytes.Buffer
+		if err := nativewebp.Encode(&webpBuf, scaled, nil); err == nil && webpBuf.Len() < len(jpg) {
+			variant.ContentType = "image/webp"
+			out.Variants = append(out.Variants, encodedVariant{variant, webpBuf.Bytes()})
+		}
+	}
+	return out, nil
+}
+
+func encodeJPEG(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: jpegQuality}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// fitWithin scales img down so its longest edge is at most maxEdge.
+func fitWithin(img image.Image, maxEdge int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= maxEdge && h <= maxEdge {
+		return img
+	}
+	if w >= h {
+		h = max(1, h*maxEdge/w)
+		w = maxEdge
+	} else {
+		w = max(1, w*maxEdge/h)
+		h = maxEdge
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+	return dst
+}
+
+// jpegOrientation returns the EXIF orientation tag (1-8) of a JPEG, or 1 if
+// there is none or it cannot be read.
+func jpegOrientation(raw []byte) int {
+	if len(raw) < 4 || raw[0] != 0xFF || raw[1] != 0xD8 {
+		return 1
+	}
+	for i := 2; i+4 <= len(raw); {
+		if raw[i] != 0xFF {
+			return 1
+		}
+		marker := raw[i+1]
+		if marker == 0xDA || marker == 0xD9 { // start of scan, end of image
+			return 1
+		}
+		length := int(binary.BigEndian.Uint16(raw[i+2:]))
+		if length < 2 || i+2+length > len(raw) {
+			return 1
+		}
+		seg := raw[i+4 : i+2+length]
+		if marker == 0xE1 && len(seg) > 6 && string(seg[:6]) == "Exif\x00\x00" {
+			return tiffOrientation(seg[6:])
+		}
+		i += 2 + length
+	}
+	return 1
+}
+
+func tiffOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd+2 > len(tiff) {
+		return 1
+	}
+	entries := int(order.Uint16(tiff[ifd:]))
+	for e := 0; e < entries; e++ {
+		at := ifd + 2 + e*12
+		if at+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[at:]) == 0x0112 {
+			if v := int(order.Uint16(tiff[at+8:])); v >= 1 && v <= 8 {
+				return v
+			}
+			return 1
+		}
+	}
+	return 1
+}
+
+// orient applies an EXIF orientation so the image displays upright.
+func orient(img image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	// Orientations 5-8 swap width and height.
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // mirrored
+				dx, dy = w-1-x, y
+			case 3: // rotated 180
+				dx, dy = w-1-x, h-1-y
+			case 4: // mirrored vertically
+				dx, dy = x, h-1-y
+			case 5: // transposed
+				dx, dy = y, x
+			case 6: // rotated 90 clockwise
+				dx, dy = h-1-y, x
+			case 7: // transversed
+				dx, dy = h-1-y, w-1-x
+			case 8: // rotated 90 counter-clockwise
+				dx, dy = y, w-1-x
+			}
+			dst.Set(dx, dy, img.At(b.Min.X+x, b.Min.Y+y))
+		}
+	}
+	return dst
+}
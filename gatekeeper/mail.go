@@ -0,0 +1,43 @@
+package main
+
+import (
+	"log"
+
+	"github.com/mailjet/mailjet-apiv3-go/v4"
+)
+
+// Mailer delivers a single HTML email. It exists so background jobs can send
+// mail without knowing about Mailjet.
+type Mailer interface {
+	Send(to []mailjet.RecipientV31, subject, html string) error
+}
+
+type mailjetMailer struct {
+	client *mailjet.Client
+	from   mailjet.RecipientV31
+}
+
+func newMailjetMailer(client *mailjet.Client, fromEmail string) *mailjetMailer {
+	return &mailjetMailer{
+		client: client,
+		from:   mailjet.RecipientV31{Email: fromEmail, Name: "Crown Point Gatekeeper"},
+	}
+}
+
+func (m *mailjetMailer) Send(to []mailjet.RecipientV31, subject, html string) error {
+	recipients := mailjet.RecipientsV31(to)
+	msgs := []mailjet.InfoMessagesV31{
+		{
+			From:     &m.from,
+			To:       &recipients,
+			Subject:  subject,
+			HTMLPart: html,
+		},
+	}
+	res, err := m.client.SendMailV31(&mailjet.MessagesV31{Info: msgs})
+	if err != nil {
+		return err
+	}
+	log.Default().Printf("Mailjet response: %v", res)
+	return nil
+}
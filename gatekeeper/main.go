@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"html/template"
@@ -9,6 +10,7 @@ import (
 	"net/http"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 	"github.com/mailjet/mailjet-apiv3-go/v4"
@@ -65,7 +67,7 @@ type AuditData struct {
 
 func enableCors(w http.ResponseWriter, r *http.Request) bool {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
 	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
 
 	// Handle preflight request
@@ -75,7 +77,28 @@ func enableCors(w http.ResponseWriter, r *http.Request) bool {
 	}
 	return false
 }
-func GenerateHtmlEmail(data AuditData) (string, error) {
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("writing response: %v", err)
+	}
+}
+
+// formatAuditTypes joins the requested audit types for display.
+func formatAuditTypes(types []AuditType) string {
+	var auditTypeStrs []string
+	for _, at := range types {
+		auditTypeStrs = append(auditTypeStrs, string(at))
+	}
+	if len(auditTypeStrs) == 0 {
+		return "Operational Review"
+	}
+	return strings.Join(auditTypeStrs, ", ")
+}
+
+func GenerateHtmlEmail(data AuditData, estimate Estimate) (string, error) {
 	// Prepare scope items
 	var scopeItems []string
 	if data.ProcEndoscopes {
@@ -100,14 +123,7 @@ func GenerateHtmlEmail(data AuditData) (string, error) {
 	}
 
 	// Format audit types
-	var auditTypeStrs []string
-	for _, at := range data.AuditType {
-		auditTypeStrs = append(auditTypeStrs, string(at))
-	}
-	auditTypeDisplay := strings.Join(auditTypeStrs, ", ")
-	if auditTypeDisplay == "" {
-		auditTypeDisplay = "Operational Review"
-	}
+	auditTypeDisplay := formatAuditTypes(data.AuditType)
 
 	// Prepare template data
 	templateData := struct {
@@ -116,12 +132,14 @@ func GenerateHtmlEmail(data AuditData) (string, error) {
 		ScopeStr           string
 		AuditTypeDisplay   string
 		HasSpecializedProc bool
+		Estimate           Estimate
 	}{
 		Data:               data,
 		ScopeItems:         scopeItems,
 		ScopeStr:           scopeStr,
 		AuditTypeDisplay:   auditTypeDisplay,
 		HasSpecializedProc: len(scopeItems) > 0,
+		Estimate:           estimate,
 	}
 
 	tmplSource := `
@@ -990,6 +1008,40 @@ func GenerateHtmlEmail(data AuditData) (string, error) {
             </div>
         </div>
 
+        <!-- Engagement Estimate -->
+        <div class="section">
+            <div class="section-header">
+                <span class="section-icon">💰</span>
+                <h2 class="section-title">Engagement Estimate (Internal)</h2>
+            </div>
+
+            <div class="stats-grid">
+                <div class="stat-card">
+                    <span class="stat-value">{{.Estimate.ConsultantDays}}</span>
+                    <span class="stat-label">Consultant Days</span>
+                </div>
+                <div class="stat-card">
+                    <span class="stat-value">{{printf "%.0f" .Estimate.PriceLow}}–{{printf "%.0f" .Estimate.PriceHigh}}</span>
+                    <span class="stat-label">Price Range ({{.Estimate.Currency}})</span>
+                </div>
+            </div>
+
+            <div class="info-grid" style="margin-top: 20px;">
+                {{range .Estimate.Breakdown}}
+                <div class="info-row">
+                    <div class="label">{{.Label}}</div>
+                    <div class="value">{{.Days}} days</div>
+                </div>
+                {{end}}
+                {{if ne .Estimate.TraumaMultiplier 1.0}}
+                <div class="info-row">
+                    <div class="label">Trauma Multiplier</div>
+                    <div class="value">× {{.Estimate.TraumaMultiplier}}</div>
+                </div>
+                {{end}}
+            </div>
+        </div>
+
         <!-- Footer -->
         <div class="footer">
             <div class="footer-text">
@@ -1022,7 +1074,112 @@ func main() {
 		log.Fatal("Error loading .env file")
 	}
 
+	if len(os.Args) > 1 {
+		commands := map[string]func([]string) error{
+			"import-master": runImportMasterCommand,
+			"add-user":      runAddUserCommand,
+			"dev-idp":       runDevIDPCommand,
+		}
+		if run, ok := commands[os.Args[1]]; ok {
+			if err := run(os.Args[2:]); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+	}
+
 	mj := mailjet.NewMailjetClient(os.Getenv("MAILJET_API_KEY"), os.Getenv("MAILJET_SECRET_KEY"))
+	mailer := newMailjetMailer(mj, os.Getenv("SENDER_EMAIL"))
+
+	submissions, err := OpenSubmissionStore(dataPath("submissions.json"))
+	if err != nil {
+		log.Fatalf("Error opening submission store: %v", err)
+	}
+	users, err := OpenUserStore(dataPath("users.json"))
+	if err != nil {
+		log.Fatalf("Error opening user store: %v", err)
+	}
+	oidc, err := NewOIDCProvider()
+	if err != nil {
+		log.Fatal(err)
+	}
+	registry, err := OpenFacilityRegistry(dataPath("facilities.json"))
+	if err != nil {
+		log.Fatalf("Error opening facility registry: %v", err)
+	}
+	if err := backfillFacilities(registry, submissions); err != nil {
+		log.Fatalf("Error linking submissions to facilities: %v", err)
+	}
+	scheduler, err := OpenScheduler(dataPath("jobs.json"))
+	if err != nil {
+		log.Fatalf("Error opening job store: %v", err)
+	}
+	offsets, err := reminderOffsets()
+	if err != nil {
+		log.Fatal(err)
+	}
+	reminders := NewReminderScheduler(scheduler, submissions, mailer, offsets)
+	estimator, err := LoadEstimator(os.Getenv("ESTIMATOR_CONFIG"))
+	if err != nil {
+		log.Fatalf("Error loading estimator config: %v", err)
+	}
+	scorer, err := LoadPriorityScorer(os.Getenv("PRIORITY_CONFIG"))
+	if err != nil {
+		log.Fatalf("Error loading priority config: %v", err)
+	}
+	compliance, err := LoadComplianceScorer(os.Getenv("SCORING_CONFIG"))
+	if err != nil {
+		log.Fatalf("Error loading scoring config: %v", err)
+	}
+	workspace, err := OpenWorkspaceStore(dataPath("realm.json"))
+	if err != nil {
+		log.Fatalf("Error opening realm workspace: %v", err)
+	}
+	assets, err := OpenAssetStore(dataPath("assets.json"))
+	if err != nil {
+		log.Fatalf("Error opening asset store: %v", err)
+	}
+	assetKey, err := assetSigningKey()
+	if err != nil {
+		log.Fatalf("Error configuring asset storage: %v", err)
+	}
+	blobs, err := OpenBlobStore(assetKey)
+	if err != nil {
+		log.Fatalf("Error configuring asset storage: %v", err)
+	}
+	collab := NewCollabHub(workspace)
+	capas, err := OpenCAPAStore(dataPath("capas.json"))
+	if err != nil {
+		log.Fatalf("Error opening CAPA store: %v", err)
+	}
+	if err := capas.FollowInstances(workspace); err != nil {
+		log.Fatalf("Error linking CAPAs to facilities: %v", err)
+	}
+	capaOffsets, err := capaReminderOffsets()
+	if err != nil {
+		log.Fatal(err)
+	}
+	capaReminders := NewCAPAReminders(scheduler, capas, mailer, capaOffsets)
+	portalStore, err := OpenPortalStore(dataPath("portal.json"))
+	if err != nil {
+		log.Fatalf("Error opening portal store: %v", err)
+	}
+	benchmarks, err := LoadBenchmarker(os.Getenv("BENCHMARK_CONFIG"), workspace, registry, submissions, compliance)
+	if err != nil {
+		log.Fatalf("Error loading benchmark config: %v", err)
+	}
+	search := NewSearchIndex(workspace)
+	aami, err := OpenAAMICatalog(dataPath("aami.json"))
+	if err != nil {
+		log.Fatalf("Error opening AAMI catalog: %v", err)
+	}
+	portal := NewPortal(portalStore, users, registry, submissions, workspace, capas, assets, blobs, mailer)
+	var crmQueue *CRMSyncQueue
+	if token := os.Getenv("HUBSPOT_TOKEN"); token != "" {
+		crmQueue = NewCRMSyncQueue(scheduler, submissions, NewHubSpotCRM(os.Getenv("HUBSPOT_BASE_URL"), token), estimator)
+	}
+	go scheduler.Run(context.Background())
+
 	mux := http.NewServeMux()
 	mux.HandleFunc(
 		"/health",
@@ -1036,7 +1193,7 @@ func main() {
 		},
 	)
 	mux.HandleFunc("/gatekeeper", func(w http.ResponseWriter, r *http.Request) {
-		var auditRequest AuditData
+		var request gatekeeperRequest
 		if enableCors(w, r) {
 			return
 		}
@@ -1045,41 +1202,71 @@ func main() {
 			w.WriteHeader(http.StatusBadRequest)
 		}
 		decoder.DisallowUnknownFields()
-		err := decoder.Decode(&auditRequest)
+		err := decoder.Decode(&request)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
+		auditRequest := request.AuditData
 		prettyJSON, err := json.MarshalIndent(auditRequest, "", "    ")
 		if err == nil {
 			fmt.Println(string(prettyJSON))
 		}
 
-		temp, err := GenerateHtmlEmail(auditRequest)
-		fmt.Println(temp)
+		sub, err := submissions.Add(auditRequest, request.Attribution.clean())
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
-
-		msgs := []mailjet.InfoMessagesV31{
-			{
-				From: &mailjet.RecipientV31{Email: os.Getenv("SENDER_EMAIL"), Name: "Crown Point Gatekeeper"},
-				To: &mailjet.RecipientsV31{
-					{Email: os.Getenv("RECIPIENT_EMAIL"), Name: "Recipient"},
-				},
-				Subject:  fmt.Sprintf("%s has submitted an audit request!", auditRequest.FacilityName),
-				HTMLPart: temp,
-			},
+		if err := linkSubmission(registry, submissions, sub); err != nil {
+			log.Printf("Error linking %s to a facility: %v", sub.ID, err)
+		}
+		if crmQueue != nil {
+			if err := crmQueue.Enqueue(sub.ID); err != nil {
+				log.Printf("Error queueing CRM sync for %s: %v", sub.ID, err)
+			}
 		}
-		messages := mailjet.MessagesV31{Info: msgs}
-		res, err := mj.SendMailV31(&messages)
 
+		temp, err := GenerateHtmlEmail(auditRequest, estimator.Estimate(auditRequest))
+		fmt.Println(temp)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
 
-		log.Default().Printf("Mailjet response: %v", res)
-
+		priority := scorer.Score(auditRequest, time.Now())
+		err = mailer.Send(
+			[]mailjet.RecipientV31{{Email: os.Getenv("RECIPIENT_EMAIL"), Name: "Recipient"}},
+			fmt.Sprintf("[%s priority %d] %s has submitted an audit request!", priority.Label, priority.Score, auditRequest.FacilityName),
+			temp,
+		)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
 	})
-	log.Fatal(http.ListenAndServe(":8080", mux))
+	registerSubmissionRoutes(mux, submissions, reminders, scorer)
+	registerEstimateRoutes(mux, submissions, estimator)
+	registerProposalRoutes(mux, submissions, estimator)
+	registerCRMRoutes(mux, submissions, crmQueue)
+	registerFacilityRoutes(mux, registry, submissions, workspace)
+	registerAttributionRoutes(mux, submissions)
+	registerWorkspaceRoutes(mux, workspace)
+	registerMasterImportRoutes(mux, workspace, aami)
+	registerMasterVersionRoutes(mux, workspace)
+	registerAssetRoutes(mux, workspace, assets, blobs, assetKey)
+	registerAuditReportRoutes(mux, workspace, assets, blobs)
+	registerCAPARoutes(mux, workspace, capas, registry, capaReminders)
+	registerScoringRoutes(mux, workspace, compliance)
+	registerBenchmarkRoutes(mux, workspace, benchmarks)
+	registerSearchRoutes(mux, search)
+	registerAAMIRoutes(mux, aami, workspace, registry)
+	registerReviewRoutes(mux, workspace)
+	registerTrendRoutes(mux, workspace, registry, compliance)
+	registerDraftInstanceRoutes(mux, submissions, workspace)
+	registerCollabRoutes(mux, workspace, collab)
+	registerSyncRoutes(mux, workspace)
+	registerPortalRoutes(mux, portal)
+	registerUserRoutes(mux, users, registry)
+	registerOIDCRoutes(mux, oidc, users)
+	log.Fatal(http.ListenAndServe(":8080", authenticate(users, mux)))
 }
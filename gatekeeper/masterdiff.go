@@ -0,0 +1,485 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// FindingChange pairs a finding in two master versions. Fields lists what
+// differs; a finding that was only renumbered has just "id".
+type FindingChange struct {
+	Before AuditFinding `json:"before"`
+	After  AuditFinding `json:"after"`
+	Fields []string     `json:"fields"`
+}
+
+// FindingMove is a finding that changed section.
+type FindingMove struct {
+	From AuditSection `json:"from"`
+	To   AuditSection `json:"to"`
+	FindingChange
+}
+
+type SectionDiff struct {
+	Section    AuditSection    `json:"section"`
+	Added      []AuditFinding  `json:"added"`
+	Removed    []AuditFinding  `json:"removed"`
+	Changed    []FindingChange `json:"changed"`
+	Renumbered []FindingChange `json:"renumbered"`
+	Unchanged  int             `json:"unchanged"`
+}
+
+type MasterDiffSummary struct {
+	Added      int `json:"added"`
+	Removed    int `json:"removed"`
+	Changed    int `json:"changed"`
+	Moved      int `json:"moved"`
+	Renumbered int `json:"renumbered"`
+	Unchanged  int `json:"unchanged"`
+}
+
+// MasterDiff compares two versions of a category's master. IDMap takes every
+// finding ID of the old version that survived to its ID in the new one.
+type MasterDiff struct {
+	Category AuditCategory     `json:"category"`
+	From     string            `json:"from"`
+	To       string            `json:"to"`
+	Summary  MasterDiffSummary `json:"summary"`
+	Sections []SectionDiff     `json:"sections"`
+	Moved    []FindingMove     `json:"moved"`
+	IDMap    map[string]string `json:"idMap"`
+}
+
+// Findings whose text is at least this similar are taken to be the same
+// finding reworded. A finding that kept its ID needs less.
+const (
+	rewordSimilarity  = 0.6
+	sameIDSimilarity  = 0.5
+	findingKeyDivider = " | "
+)
+
+// findingKey identifies a finding by what it says. Import IDs are positional,
+// so inserting a row renumbers everything after it; the text does not move.
+func findingKey(f AuditFinding) string {
+	return strings.Join(normalizeWords(f.EquipmentSubject, nil, nil), " ") + findingKeyDivider +
+		strings.Join(normalizeWords(f.Issue, nil, nil), " ")
+}
+
+func findingSimilarity(a, b AuditFinding) float64 {
+	ta := seedTokens(a.EquipmentSubject + " " + a.Issue)
+	tb := seedTokens(b.EquipmentSubject + " " + b.Issue)
+	inter := 0
+	for w := range ta {
+		if tb[w] {
+			inter++
+		}
+	}
+	union := len(ta) + len(tb) - inter
+	if union == 0 {
+		return 0
+	}
+	return float64(inter) / float64(union)
+}
+
+func changedFields(a, b AuditFinding) []string {
+	var fields []string
+	add := func(name string, differ bool) {
+		if differ {
+			fields = append(fields, name)
+		}
+	}
+	add("equipmentSubject", a.EquipmentSubject != b.EquipmentSubject)
+	add("issue", a.Issue != b.Issue)
+	add("rationale", a.Rationale != b.Rationale)
+	add("recommendation", a.Recommendation != b.Recommendation)
+	add("aamiReference", a.AAMIReference != b.AAMIReference)
+	add("riskLevel", a.RiskLevel != b.RiskLevel)
+	if fields == nil && a.ID != b.ID {
+		fields = []string{"id"}
+	}
+	return fields
+}
+
+// pairFindings matches the findings of one section across versions: first
+// by identical text, then by ID where the text is still similar, then by
+// the most similar remaining text. It returns old index -> new index.
+func pairFindings(olds, news []AuditFinding) map[int]int {
+	pairs := map[int]int{}
+	usedNew := map[int]bool{}
+
+	for i, o := range olds {
+		for j, n := range news {
+			if !usedNew[j] && findingKey(o) == findingKey(n) {
+				pairs[i], usedNew[j] = j, true
+				break
+			}
+		}
+	}
+	for i, o := range olds {
+		if _, ok := pairs[i]; ok {
+			continue
+		}
+		for j, n := range news {
+			if !usedNew[j] && o.ID == n.ID && findingSimilarity(o, n) >= sameIDSimilarity {
+				pairs[i], usedNew[j] = j, true
+				break
+			}
+		}
+	}
+	type candidate struct {
+		i, j  int
+		score float64
+	}
+	var candidates []candidate
+	for i, o := range olds {
+		if _, ok := pairs[i]; ok {
+			continue
+		}
+		for j, n := range news {
+			if usedNew[j] {
+				continue
+			}
+			if score := findingSimilarity(o, n); score >= rewordSimilarity {
+				candidates = append(candidates, candidate{i, j, score})
+			}
+		}
+	}
+	sort.SliceStable(candidates, func(a, b int) bool { return candidates[a].score > candidates[b].score })
+	for _, c := range candidates {
+		if _, ok := pairs[c.i]; ok || usedNew[c.j] {
+			continue
+		}
+		pairs[c.i], usedNew[c.j] = c.j, true
+	}
+	return pairs
+}
+
+// DiffMasters compares two versions of a master checklist.
+func DiffMasters(from, to AuditReport) MasterDiff {
+	d := MasterDiff{
+		Category: to.Category,
+		From:     from.Version,
+		To:       to.Version,
+		Sections: []SectionDiff{},
+		Moved:    []FindingMove{},
+		IDMap:    map[string]string{},
+	}
+	type loose struct {
+		section AuditSection
+		finding AuditFinding
+		index   int // into Sections
+	}
+	var removed, added []loose
+
+	for _, section := range auditSections {
+		olds, news := from.Sections[section], to.Sections[section]
+		if len(olds) == 0 && len(news) == 0 {
+			continue
+		}
+		sd := SectionDiff{
+			Section:    section,
+			Added:      []AuditFinding{},
+			Removed:    []AuditFinding{},
+			Changed:    []FindingChange{},
+			Renumbered: []FindingChange{},
+		}
+		pairs := pairFindings(olds, news)
+		paired := map[int]bool{}
+		for i, o := range olds {
+			j, ok := pairs[i]
+			if !ok {
+				removed = append(removed, loose{section, o, len(d.Sections)})
+				continue
+			}
+			paired[j] = true
+			n := news[j]
+			d.IDMap[o.ID] = n.ID
+			switch fields := changedFields(o, n); {
+			case fields == nil:
+				sd.Unchanged++
+			case len(fields) == 1 && fields[0] == "id":
+				sd.Renumbered = append(sd.Renumbered, FindingChange{o, n, fields})
+			default:
+				sd.Changed = append(sd.Changed, FindingChange{o, n, fields})
+			}
+		}
+		for j, n := range news {
+			if !paired[j] {
+				added = append(added, loose{section, n, len(d.Sections)})
+			}
+		}
+		d.Sections = append(d.Sections, sd)
+	}
+
+	// A finding that left one section and appeared word for word in another
+	// was moved, not removed and re-added.
+	movedNew := map[int]bool{}
+	for _, r := range removed {
+		found := -1
+		for k, a := range added {
+			if !movedNew[k] && a.section != r.section && findingKey(a.finding) == findingKey(r.finding) {
+				found = k
+				break
+			}
+		}
+		if found < 0 {
+			d.Sections[r.index].Removed = append(d.Sections[r.index].Removed, r.finding)
+			continue
+		}
+		movedNew[found] = true
+		a := added[found]
+		d.IDMap[r.finding.ID] = a.finding.ID
+		d.Moved = append(d.Moved, FindingMove{r.section, a.section, FindingChange{r.finding, a.finding, changedFields(r.finding, a.finding)}})
+	}
+	for k, a := range added {
+		if !movedNew[k] {
+			d.Sections[a.index].Added = append(d.Sections[a.index].Added, a.finding)
+		}
+	}
+
+	for _, sd := range d.Sections {
+		d.Summary.Added += len(sd.Added)
+		d.Summary.Removed += len(sd.Removed)
+		d.Summary.Changed += len(sd.Changed)
+		d.Summary.Renumbered += len(sd.Renumbered)
+		d.Summary.Unchanged += sd.Unchanged
+	}
+	d.Summary.Moved = len(d.Moved)
+	return d
+}
+
+// IDRemap is one response carried to a new finding ID.
+type IDRemap struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// MigrationResult describes moving an instance to another master version.
+// NeedsReview lists carried responses whose finding was reworded, which an
+// auditor should re-read; Orphaned lists responses with nowhere to go, kept
+// on the instance as OrphanedResponses.
+type MigrationResult struct {
+	Instance    AuditInstance     `json:"instance"`
+	From        string            `json:"from"`
+	To          string            `json:"to"`
+	DryRun      bool              `json:"dryRun"`
+	Summary     MasterDiffSummary `json:"summary"`
+	Remapped    []IDRemap         `json:"remapped"`
+	NeedsReview []string          `json:"needsReview"`
+	Orphaned    []string          `json:"orphaned"`
+}
+
+// guessMasterVersion picks the version an instance from before versions
+// were recorded was most likely answered against: the newest one that
+// contains the most of its finding IDs.
+func (d *workspaceData) guessMasterVersion(inst *AuditInstance) (AuditReport, bool) {
+	var versions []AuditReport
+	versions = append(versions, d.History[inst.Category]...)
+	if cur, ok := d.Masters[inst.Category]; ok {
+		versions = append(versions, *cur)
+	}
+	best, bestHits := -1, -1
+	for i, r := range versions {
+		ids := map[string]bool{}
+		for _, findings := range r.Sections {
+			for _, f := range findings {
+				ids[f.ID] = true
+			}
+		}
+		hits := 0
+		for id := range inst.Responses {
+			if ids[id] {
+				hits++
+			}
+		}
+		if hits >= bestHits {
+			best, bestHits = i, hits
+		}
+	}
+	if best < 0 {
+		return AuditReport{}, false
+	}
+	return cloneReport(&versions[best]), true
+}
+
+// emptyResponse is a response that records nothing worth keeping.
+func emptyResponse(r AuditResponse) bool {
+	return (r.Status == StatusUnanswered || r.Status == "") && r.Notes == "" && len(r.Images) == 0 &&
+		r.RiskLevel == "" && r.CapaRequired == nil
+}
+
+// MigrateInstance moves an instance's responses to another version of its
+// category's master ("" for the current one), remapping finding IDs through
+// DiffMasters. A non-zero updatedAt is checked as for updates. With dryRun
+// nothing is stored.
+func (s *WorkspaceStore) MigrateInstance(id, to string, updatedAt int64, dryRun bool) (MigrationResult, error) {
+	var res MigrationResult
+	err := s.file.Update(func(d *workspaceData) error {
+		existing, ok := d.Instances[id]
+		if !ok {
+			return errNotFound
+		}
+		if updatedAt != 0 && existing.UpdatedAt != updatedAt {
+			return &conflictError{Current: cloneInstance(existing)}
+		}
+		if !dryRun && existing.Status != InstanceDraft {
+			return errLocked
+		}
+		target, ok := d.masterVersion(existing.Category, to)
+		if !ok {
+			return fmt.Errorf("%w: no master version %q for %s", errInvalid, to, existing.Category)
+		}
+		source, ok := d.masterVersion(existing.Category, existing.MasterVersion)
+		if existing.MasterVersion == "" || !ok {
+			if source, ok = d.guessMasterVersion(existing); !ok {
+				return errNoMaster
+			}
+		}
+
+		diff := DiffMasters(source, target)
+		inst := cloneInstance(existing)
+		res = MigrationResult{
+			From:        source.Version,
+			To:          target.Version,
+			DryRun:      dryRun,
+			Summary:     diff.Summary,
+			Remapped:    []IDRemap{},
+			NeedsReview: []string{},
+			Orphaned:    []string{},
+		}
+		reworded := map[string]bool{}
+		for _, sd := range diff.Sections {
+			for _, c := range sd.Changed {
+				reworded[c.After.ID] = true
+			}
+		}
+		for _, m := range diff.Moved {
+			if len(m.Fields) > 0 && m.Fields[0] != "id" {
+				reworded[m.After.ID] = true
+			}
+		}
+		oldFindings := map[string]AuditFinding{}
+		oldSections := map[string]AuditSection{}
+		for section, findings := range source.Sections {
+			for _, f := range findings {
+				oldFindings[f.ID], oldSections[f.ID] = f, section
+			}
+		}
+
+		ids := make([]string, 0, len(inst.Responses))
+		for fid := range inst.Responses {
+			ids = append(ids, fid)
+		}
+		sort.Strings(ids)
+		responses := map[string]AuditResponse{}
+		for _, fid := range ids {
+			resp := inst.Responses[fid]
+			newID, ok := diff.IDMap[fid]
+			if !ok {
+				if !emptyResponse(resp) {
+					res.Orphaned = append(res.Orphaned, fid)
+					finding, known := oldFindings[fid]
+					if !known {
+						finding = AuditFinding{ID: fid}
+					}
+					inst.Orphans = append(inst.Orphans, OrphanedResponse{
+						Response:      resp,
+						Finding:       finding,
+						Section:       oldSections[fid],
+						MasterVersion: source.Version,
+					})
+				}
+				continue
+			}
+			if newID != fid {
+				res.Remapped = append(res.Remapped, IDRemap{fid, newID})
+			}
+			if reworded[newID] {
+				res.NeedsReview = append(res.NeedsReview, newID)
+			}
+			resp.FindingID = newID
+			responses[newID] = resp
+		}
+		inst.Responses = responses
+		inst.MasterVersion = target.Version
+
+		if !dryRun {
+			inst.UpdatedAt = nextUpdatedAt(existing.UpdatedAt)
+			d.stamp(&inst, existing, nowMillis())
+			stored := cloneInstance(&inst)
+			d.Instances[id] = &stored
+		}
+		res.Instance = inst
+		return nil
+	})
+	if err == nil && !dryRun {
+		s.publish(InstanceEvent{Instance: cloneInstance(&res.Instance)})
+	}
+	return res, err
+}
+
+type migrateRequest struct {
+	To        string `json:"to"`
+	UpdatedAt int64  `json:"updatedAt"`
+	DryRun    bool   `json:"dryRun"`
+}
+
+func registerMasterVersionRoutes(mux *http.ServeMux, ws *WorkspaceStore) {
+	mux.Handle("GET /realm/master/{category}/versions", realmRoute(func(w http.ResponseWriter, r *http.Request) {
+		category := AuditCategory(r.PathValue("category"))
+		if !category.valid() {
+			http.Error(w, "unknown category", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, ws.MasterVersions(category))
+	}))
+	mux.Handle("GET /realm/master/{category}/versions/{version}", realmRoute(func(w http.ResponseWriter, r *http.Request) {
+		report, err := ws.MasterVersion(AuditCategory(r.PathValue("category")), r.PathValue("version"))
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, report)
+	}))
+	// ?from= is required; ?to= defaults to the current version.
+	mux.Handle("GET /realm/master/{category}/diff", realmRoute(func(w http.ResponseWriter, r *http.Request) {
+		category := AuditCategory(r.PathValue("category"))
+		q := r.URL.Query()
+		if q.Get("from") == "" {
+			http.Error(w, "from is required", http.StatusBadRequest)
+			return
+		}
+		from, err := ws.MasterVersion(category, q.Get("from"))
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		to, err := ws.MasterVersion(category, q.Get("to"))
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, DiffMasters(from, to))
+	}))
+	mux.Handle("POST /realm/instances/{id}/migrate", realmRoute(func(w http.ResponseWriter, r *http.Request) {
+		var req migrateRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		res, err := ws.MigrateInstance(r.PathValue("id"), req.To, req.UpdatedAt, req.DryRun)
+		if errors.Is(err, errNoMaster) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, res)
+	}))
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func testMasters() (v1, v2 AuditReport) {
+	v1 = AuditReport{Category: CategoryCSSD, Sections: map[AuditSection][]AuditFinding{
+		SectionDecon: {
+			{ID: "d-0", EquipmentSubject: "Sink", Issue: "No brushes of the right size at the sink"},
+			{ID: "d-1", EquipmentSubject: "Floor", Issue: "Floor is visibly soiled"},
+			{ID: "d-2", EquipmentSubject: "Washer", Issue: "No daily cleaning verification test records kept"},
+			{ID: "d-3", EquipmentSubject: "Transport cart", Issue: "Cart is not covered during transport"},
+			{ID: "d-4", EquipmentSubject: "Ultrasonic", Issue: "Ultrasonic cleaner water changed once per day"},
+			{ID: "d-5", EquipmentSubject: "Sharps", Issue: "Sharps container is overfilled"},
+		},
+		SectionStorage: {
+			{ID: "s-0", EquipmentSubject: "Shelving", Issue: "Bottom shelf less than 8 inches from the floor"},
+		},
+	}}
+	v2 = AuditReport{Category: CategoryCSSD, Sections: map[AuditSection][]AuditFinding{
+		SectionDecon: {
+			// Added ahead of the sink, which is renumbered.
+			{ID: "d-0", EquipmentSubject: "Eyewash", Issue: "Eyewash station is not tested weekly"},
+			{ID: "d-1", EquipmentSubject: "Sink", Issue: "No brushes of the right size at the sink"},
+			// Reworded and renumbered.
+			{ID: "d-3", EquipmentSubject: "Washer", Issue: "No daily cleaning verification test records kept on file"},
+			// Reworded more heavily, but kept its ID.
+			{ID: "d-4", EquipmentSubject: "Ultrasonic", Issue: "Ultrasonic cleaner water changed only twice per shift"},
+		},
+		SectionStorage: {
+			{ID: "s-0", EquipmentSubject: "Shelving", Issue: "Bottom shelf less than 8 inches from the floor"},
+			{ID: "s-1", EquipmentSubject: "Transport cart", Issue: "Cart is not covered during transport"},
+		},
+	}}
+	return v1, v2
+}
+
+func sectionDiff(t *testing.T, d MasterDiff, section AuditSection) SectionDiff {
+	t.Helper()
+	for _, sd := range d.Sections {
+		if sd.Section == section {
+			return sd
+		}
+	}
+	t.Fatalf("no diff for section %s", section)
+	return SectionDiff{}
+}
+
+func TestDiffMasters(t *testing.T) {
+	v1, v2 := testMasters()
+	v1.Version, v2.Version = "1", "2"
+	d := DiffMasters(v1, v2)
+
+	want := MasterDiffSummary{Added: 1, Removed: 2, Changed: 2, Moved: 1, Renumbered: 1, Unchanged: 1}
+	if d.Summary != want {
+		t.Errorf("summary = %+v, want %+v", d.Summary, want)
+	}
+	wantMap := map[string]string{"d-0": "d-1", "d-2": "d-3", "d-3": "s-1", "d-4": "d-4", "s-0": "s-0"}
+	if !reflect.DeepEqual(d.IDMap, wantMap) {
+		t.Errorf("idMap = %v, want %v", d.IDMap, wantMap)
+	}
+
+	decon := sectionDiff(t, d, SectionDecon)
+	if len(decon.Added) != 1 || decon.Added[0].ID != "d-0" || decon.Added[0].EquipmentSubject != "Eyewash" {
+		t.Errorf("added = %+v", decon.Added)
+	}
+	var removed []string
+	for _, f := range decon.Removed {
+		removed = append(removed, f.ID)
+	}
+	if !reflect.DeepEqual(removed, []string{"d-1", "d-5"}) {
+		t.Errorf("removed = %v", removed)
+	}
+	if len(decon.Renumbered) != 1 || decon.Renumbered[0].Before.ID != "d-0" || decon.Renumbered[0].After.ID != "d-1" ||
+		!reflect.DeepEqual(decon.Renumbered[0].Fields, []string{"id"}) {
+		t.Errorf("renumbered = %+v", decon.Renumbered)
+	}
+	changed := map[string]FindingChange{}
+	for _, c := range decon.Changed {
+		changed[c.Before.ID] = c
+	}
+	if c := changed["d-2"]; c.After.ID != "d-3" || !reflect.DeepEqual(c.Fields, []string{"issue"}) {
+		t.Errorf("reworded washer = %+v", c)
+	}
+	if c := changed["d-4"]; c.After.ID != "d-4" || !reflect.DeepEqual(c.Fields, []string{"issue"}) {
+		t.Errorf("reworded ultrasonic = %+v", c)
+	}
+
+	if len(d.Moved) != 1 {
+		t.Fatalf("moved = %+v", d.Moved)
+	}
+	if m := d.Moved[0]; m.From != SectionDecon || m.To != SectionStorage || m.Before.ID != "d-3" || m.After.ID != "s-1" {
+		t.Errorf("moved = %+v", m)
+	}
+	if storage := sectionDiff(t, d, SectionStorage); len(storage.Added) != 0 || storage.Unchanged != 1 {
+		t.Errorf("storage = %+v; want the moved cart reported only as a move", storage)
+	}
+}
+
+func TestMigrateInstanceRoundTrip(t *testing.T) {
+	ws := openTestWorkspace(t)
+	v1, v2 := testMasters()
+	if _, err := ws.PublishMaster(v1); err != nil {
+		t.Fatal(err)
+	}
+	answer := func(id string, status ResponseStatus, notes string) AuditResponse {
+		return AuditResponse{FindingID: id, Status: status, Notes: notes}
+	}
+	original := map[string]AuditResponse{
+		"d-0": answer("d-0", StatusNonCompliant, "brushes missing"),
+		"d-1": answer("d-1", StatusNonCompliant, "dried soil under the sinks"),
+		"d-2": answer("d-2", StatusCompliant, ""),
+		"d-3": answer("d-3", StatusNonCompliant, "open cart in corridor"),
+		"d-4": answer("d-4", StatusCompliant, "log checked"),
+		"d-5": answer("d-5", StatusUnanswered, ""),
+		"s-0": answer("s-0", StatusNA, ""),
+	}
+	inst, err := ws.CreateInstance(AuditInstance{FacilityName: "Mercy Hospital", Category: CategoryCSSD, Responses: original})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inst.MasterVersion != "1" {
+		t.Fatalf("master version = %q, want 1", inst.MasterVersion)
+	}
+	if _, err := ws.PublishMaster(v2); err != nil {
+		t.Fatal(err)
+	}
+
+	dry, err := ws.MigrateInstance(inst.ID, "", 0, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored, _ := ws.Instance(inst.ID); stored.MasterVersion != "1" || stored.UpdatedAt != inst.UpdatedAt {
+		t.Errorf("dry run stored the migration: %+v", stored)
+	}
+
+	res, err := ws.MigrateInstance(inst.ID, "", inst.UpdatedAt, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(dry.Remapped, res.Remapped) || !reflect.DeepEqual(dry.Orphaned, res.Orphaned) {
+		t.Errorf("dry run %+v differs from migration %+v", dry, res)
+	}
+	wantRemap := []IDRemap{{"d-0", "d-1"}, {"d-2", "d-3"}, {"d-3", "s-1"}}
+	if !reflect.DeepEqual(res.Remapped, wantRemap) {
+		t.Errorf("remapped = %v, want %v", res.Remapped, wantRemap)
+	}
+	if !reflect.DeepEqual(res.NeedsReview, []string{"d-3", "d-4"}) {
+		t.Errorf("needs review = %v", res.NeedsReview)
+	}
+	// The floor response is kept as an orphan; the unanswered sharps one is dropped.
+	if !reflect.DeepEqual(res.Orphaned, []string{"d-1"}) {
+		t.Errorf("orphaned = %v", res.Orphaned)
+	}
+	got := res.Instance
+	if got.MasterVersion != "2" || res.From != "1" || res.To != "2" {
+		t.Errorf("migrated %s → %s, instance on %q", res.From, res.To, got.MasterVersion)
+	}
+	if r := got.Responses["d-1"]; r.FindingID != "d-1" || r.Notes != "brushes missing" {
+		t.Errorf("sink response = %+v", r)
+	}
+	if r := got.Responses["s-1"]; r.Notes != "open cart in corridor" {
+		t.Errorf("moved cart response = %+v", r)
+	}
+	if _, ok := got.Responses["d-0"]; ok {
+		t.Error("the new eyewash finding picked up a response")
+	}
+	if len(got.Orphans) != 1 || got.Orphans[0].Finding.EquipmentSubject != "Floor" ||
+		got.Orphans[0].Section != SectionDecon || got.Orphans[0].MasterVersion != "1" {
+		t.Errorf("orphans = %+v", got.Orphans)
+	}
+
+	back, err := ws.MigrateInstance(inst.ID, "1", 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id, want := range original {
+		r, ok := back.Instance.Responses[id]
+		switch id {
+		case "d-1", "d-5":
+			if ok {
+				t.Errorf("removed finding %s came back with %+v", id, r)
+			}
+		default:
+			if !ok || r.FindingID != id || r.Status != want.Status || r.Notes != want.Notes {
+				t.Errorf("response %s after the round trip = %+v, want %+v", id, r, want)
+			}
+		}
+	}
+	if len(back.Instance.Responses) != len(original)-2 || len(back.Instance.Orphans) != 1 {
+		t.Errorf("after the round trip: %d responses, %d orphans", len(back.Instance.Responses), len(back.Instance.Orphans))
+	}
+
+	if _, _, err := ws.Review(inst.ID, ReviewSubmit, User{ID: "u1", Name: "Ana Ruiz", Role: RoleAuditor}, "", 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ws.MigrateInstance(inst.ID, "2", 0, false); err != errLocked {
+		t.Errorf("migrating an instance under review: %v, want errLocked", err)
+	}
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"strings"
+	"unicode"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// ImportError is a problem with one sheet or row of a checklist workbook.
+// Row is the 1-based spreadsheet row, or 0 for sheet-level problems.
+type ImportError struct {
+	Sheet   string `json:"sheet"`
+	Row     int    `json:"row,omitempty"`
+	Message string `json:"message"`
+}
+
+func (e ImportError) String() string {
+	if e.Row == 0 {
+		return fmt.Sprintf("%s: %s", e.Sheet, e.Message)
+	}
+	return fmt.Sprintf("%s row %d: %s", e.Sheet, e.Row, e.Message)
+}
+
+// ImportResult is a parsed workbook. Errors stop the import; warnings,
+// such as references the AAMI catalog does not know, do not.
+type ImportResult struct {
+	Report   AuditReport   `json:"report"`
+	Errors   []ImportError `json:"errors"`
+	Warnings []ImportError `json:"warnings"`
+}
+
+// maxWorkbookSize bounds uploaded checklist workbooks.
+const maxWorkbookSize = 20 << 20
+
+// squash lowercases s and drops everything but letters and digits, so
+// "Equipment / Subject" and "equipmentSubject" compare equal.
+func squash(s string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(s) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// sheetSections maps squashed sheet names to sections. The canonical names
+// are what the browser importer accepted; the rest are spellings seen in the
+// checklist workbooks.
+var sheetSections = map[string]AuditSection{
+	"general":         SectionGeneral,
+	"prepandpack":     SectionPrepandPack,
+	"prepack":         SectionPrepandPack,
+	"assembly":        SectionPrepandPack,
+	"sterilization":   SectionSterilization,
+	"sterilisation":   SectionSterilization,
+	"storage":         SectionStorage,
+	"sterilestorage":  SectionStorage,
+	"pointofuse":      SectionPointOfUse,
+	"pou":             SectionPointOfUse,
+	"decon":           SectionDecon,
+	"decontamination": SectionDecon,
+}
+
+type findingColumn int
+
+const (
+	colSubject findingColumn = iota
+	colIssue
+	colRationale
+	colRecommendation
+	colReference
+	colRisk
+)
+
+var headerColumns = map[string]findingColumn{
+	"equipmentsubject": colSubject,
+	"equipment":        colSubject,
+	"subject":          colSubject,
+	"issue":            colIssue,
+	"finding":          colIssue,
+	"rationale":        colRationale,
+	"recommendation":   colRecommendation,
+	"aamireference":    colReference,
+	"aamiref":          colReference,
+	"aami":             colReference,
+	"reference":        colReference,
+	"risklevel":        colRisk,
+	"risk":             colRisk,
+}
+
+// ParseMasterWorkbook reads a checklist workbook for category. Each sheet is a
+// section and each row below the header row is a finding. Finding IDs are
+// "<category>-<section>-<index>", index counting the sheet's non-blank rows
+// below the header, as the browser importer numbered them. A workbook that
+// importer read, with sheets named exactly after their sections, gets the
+// same IDs; anything else gets new ones, and instances are carried over
+// with MigrateInstance, which matches findings by their text. AAMI
+// references are rewritten in normal form and checked against catalog.
+func ParseMasterWorkbook(r io.Reader, category AuditCategory, catalog *AAMICatalog) (ImportResult, error) {
+	if !category.valid() {
+		return ImportResult{}, fmt.Errorf("%w: unknown category %q", errInvalid, category)
+	}
+	book, err := excelize.OpenReader(r)
+	if err != nil {
+		return ImportResult{}, fmt.Errorf("%w: not an XLSX workbook: %v", errInvalid, err)
+	}
+	defer book.Close()
+
+	result := ImportResult{
+		Report:   AuditReport{Category: category, Sections: map[AuditSection][]AuditFinding{}},
+		Errors:   []ImportError{},
+		Warnings: []ImportError{},
+	}
+	seenSheet := map[AuditSection]string{}
+	for _, sheet := range book.GetSheetList() {
+		section, ok := sheetSections[squash(sheet)]
+		if !ok {
+			result.Errors = append(result.Errors, ImportError{Sheet: sheet, Message: "sheet name is not an audit section"})
+			continue
+		}
+		if prev, dup := seenSheet[section]; dup {
+			result.Errors = append(result.Errors, ImportError{Sheet: sheet, Message: fmt.Sprintf("section %s already imported from sheet %q", section, prev)})
+			continue
+		}
+		seenSheet[section] = sheet
+
+		rows, err := book.GetRows(sheet)
+		if err != nil {
+			result.Errors = append(result.Errors, ImportError{Sheet: sheet, Message: err.Error()})
+			continue
+		}
+		findings, errs, warnings := parseSectionRows(sheet, category, section, rows, catalog)
+		result.Errors = append(result.Errors, errs...)
+		result.Warnings = append(result.Warnings, warnings...)
+		result.Report.Sections[section] = findings
+	}
+	if len(result.Report.Sections) == 0 && len(result.Errors) == 0 {
+		result.Errors = append(result.Errors, ImportError{Message: "workbook has no section sheets"})
+	}
+	return result, nil
+}
+
+func parseSectionRows(sheet string, category AuditCategory, section AuditSection, rows [][]string, catalog *AAMICatalog) ([]AuditFinding, []ImportError, []ImportError) {
+	var errs, warnings []ImportError
+	headerAt := -1
+	for i, row := range rows {
+		if !blankRow(row) {
+			headerAt = i
+			break
+		}
+	}
+	if headerAt < 0 {
+		return []AuditFinding{}, []ImportError{{Sheet: sheet, Message: "sheet is empty"}}, nil
+	}
+
+	columns := map[findingColumn]int{}
+	for i, cell := range rows[headerAt] {
+		if col, ok := headerColumns[squash(cell)]; ok {
+			if _, dup := columns[col]; !dup {
+				columns[col] = i
+			}
+		}
+	}
+	if _, ok := columns[colIssue]; !ok {
+		return []AuditFinding{}, []ImportError{{Sheet: sheet, Row: headerAt + 1, Message: "header row has no issue column"}}, nil
+	}
+	cell := func(row []string, col findingColumn) string {
+		i, ok := columns[col]
+		if !ok || i >= len(row) {
+			return ""
+		}
+		return strings.TrimSpace(row[i])
+	}
+
+	findings := []AuditFinding{}
+	seen := map[string]int{}
+	index := -1
+	for i := headerAt + 1; i < len(rows); i++ {
+		row := rows[i]
+		if blankRow(row) {
+			continue
+		}
+		index++
+		line := i + 1
+		f := AuditFinding{
+			ID:               fmt.Sprintf("%s-%s-%d", category, section, index),
+			EquipmentSubject: cell(row, colSubject),
+			Issue:            cell(row, colIssue),
+			Rationale:        cell(row, colRationale),
+			Recommendation:   cell(row, colRecommendation),
+			AAMIReference:    cell(row, colReference),
+		}
+		if risk := cell(row, colRisk); risk != "" {
+			f.RiskLevel = parseRiskLevel(risk)
+			if f.RiskLevel == "" {
+				errs = append(errs, ImportError{Sheet: sheet, Row: line, Message: fmt.Sprintf("unknown risk level %q", risk)})
+				continue
+			}
+		}
+		if f.Issue == "" {
+			errs = append(errs, ImportError{Sheet: sheet, Row: line, Message: "issue is empty"})
+			continue
+		}
+		key := squash(f.EquipmentSubject + "|" + f.Issue)
+		if first, dup := seen[key]; dup {
+			errs = append(errs, ImportError{Sheet: sheet, Row: line, Message: fmt.Sprintf("duplicates row %d", first)})
+			continue
+		}
+		seen[key] = line
+		var problems []string
+		f.AAMIReference, problems = catalog.Normalize(f.AAMIReference)
+		for _, p := range problems {
+			warnings = append(warnings, ImportError{Sheet: sheet, Row: line, Message: p})
+		}
+		findings = append(findings, f)
+	}
+	return findings, errs, warnings
+}
+
+func blankRow(row []string) bool {
+	for _, c := range row {
+		if strings.TrimSpace(c) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+func parseRiskLevel(s string) RiskLevel {
+	switch squash(s) {
+	case "critical", "high":
+		return RiskCritical
+	case "major", "medium", "moderate":
+		return RiskMajor
+	case "minor", "low":
+		return RiskMinor
+	}
+	return ""
+}
+
+// importMaster parses a workbook and, when it has no errors and dryRun is
+// false, stores it as the category's next master version.
+func importMaster(ws *WorkspaceStore, catalog *AAMICatalog, r io.Reader, category AuditCategory, dryRun bool) (ImportResult, error) {
+	result, err := ParseMasterWorkbook(r, category, catalog)
+	if err != nil || len(result.Errors) > 0 || dryRun {
+		return result, err
+	}
+	result.Report, err = ws.PublishMaster(result.Report)
+	return result, err
+}
+
+// workbookFromRequest accepts either a multipart form with a "file" field or
+// the raw workbook as the request body.
+func workbookFromRequest(r *http.Request) (io.ReadCloser, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		return r.Body, nil
+	}
+	if err := r.ParseMultipartForm(maxWorkbookSize); err != nil {
+		return nil, err
+	}
+	file, _, err := r.FormFile("file")
+	return file, err
+}
+
+func registerMasterImportRoutes(mux *http.ServeMux, ws *WorkspaceStore, catalog *AAMICatalog) {
+	mux.Handle("POST /realm/master/{category}/import", realmRoute(func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, maxWorkbookSize)
+		body, err := workbookFromRequest(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer body.Close()
+
+		dryRun := r.URL.Query().Get("dry_run") != ""
+		result, err := importMaster(ws, catalog, body, AuditCategory(r.PathValue("category")), dryRun)
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		switch {
+		case len(result.Errors) > 0:
+			writeJSON(w, http.StatusUnprocessableEntity, result)
+		case dryRun:
+			writeJSON(w, http.StatusOK, result)
+		default:
+			writeJSON(w, http.StatusCreated, result)
+		}
+	}, RoleAdmin, RolePartner))
+}
+
+// runImportMasterCommand implements "gatekeeper import-master", which loads a
+// checklist workbook straight into the workspace store.
+func runImportMasterCommand(args []string) error {
+	fs := flag.NewFlagSet("import-master", flag.ContinueOnError)
+	category := fs.String("category", "", `audit category, e.g. "Sterile Processing (CSSD)"`)
+	dryRun := fs.Bool("dry-run", false, "validate the workbook without storing it")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: gatekeeper import-master -category <category> [-dry-run] <workbook.xlsx>")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); errors.Is(err, flag.ErrHelp) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if fs.NArg() != 1 || *category == "" {
+		fs.Usage()
+		return errors.New("a category and one workbook are required")
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	ws, err := OpenWorkspaceStore(dataPath("realm.json"))
+	if err != nil {
+		return err
+	}
+	catalog, err := OpenAAMICatalog(dataPath("aami.json"))
+	if err != nil {
+		return err
+	}
+	result, err := importMaster(ws, catalog, f, AuditCategory(*category), *dryRun)
+	if err != nil {
+		return err
+	}
+	for _, e := range result.Errors {
+		fmt.Fprintln(os.Stderr, e)
+	}
+	for _, e := range result.Warnings {
+		fmt.Fprintln(os.Stderr, "warning:", e)
+	}
+	if len(result.Errors) > 0 {
+		return fmt.Errorf("%d problems found; nothing was imported", len(result.Errors))
+	}
+	total := 0
+	for _, findings := range result.Report.Sections {
+		total += len(findings)
+	}
+	if *dryRun {
+		fmt.Printf("%s: %d findings in %d sections, no problems found\n", *category, total, len(result.Report.Sections))
+		return nil
+	}
+	fmt.Printf("%s: imported version %s with %d findings in %d sections\n", *category, result.Report.Version, total, len(result.Report.Sections))
+	return nil
+}
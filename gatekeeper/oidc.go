@@ -0,0 +1,399 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	oidcLoginTTL = 10 * time.Minute
+	// oidcStateCookie ties a callback to the browser that started the
+	// sign-in, so nobody can complete their own sign-in in someone else's
+	// browser.
+	oidcStateCookie = "gk_oidc_state"
+	// oidcClockSkew is how far the identity provider's clock may be off.
+	oidcClockSkew = 2 * time.Minute
+)
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// audience is the aud claim, which may be a string or a list.
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var one string
+	if err := json.Unmarshal(b, &one); err == nil {
+		*a = audience{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(b, &many); err != nil {
+		return err
+	}
+	*a = many
+	return nil
+}
+
+type oidcClaims struct {
+	Issuer        string   `json:"iss"`
+	Subject       string   `json:"sub"`
+	Audience      audience `json:"aud"`
+	Expiry        int64    `json:"exp"`
+	IssuedAt      int64    `json:"iat"`
+	Nonce         string   `json:"nonce"`
+	Email         string   `json:"email"`
+	EmailVerified bool     `json:"email_verified"`
+	Name          string   `json:"name"`
+}
+
+type oidcPending struct {
+	nonce    string
+	verifier string
+	expires  time.Time
+}
+
+// OIDCProvider signs users in with an OpenID Connect identity provider
+// using the authorization code flow with PKCE. Only RS256 ID tokens are
+// accepted.
+type OIDCProvider struct {
+	issuer       string
+	clientID     string
+	clientSecret string
+	redirectURL  string
+	afterLogin   string
+	client       *http.Client
+
+	mu      sync.Mutex
+	meta    *oidcDiscovery
+	keys    map[string]*rsa.PublicKey
+	pending map[string]oidcPending // by state
+}
+
+// NewOIDCProvider reads OIDC_ISSUER, OIDC_CLIENT_ID, OIDC_CLIENT_SECRET and
+// OIDC_REDIRECT_URL. It returns nil when OIDC_ISSUER is unset. Users land on
+// OIDC_POST_LOGIN_URL once signed in.
+func NewOIDCProvider() (*OIDCProvider, error) {
+	issuer := strings.TrimRight(os.Getenv("OIDC_ISSUER"), "/")
+	if issuer == "" {
+		return nil, nil
+	}
+	p := &OIDCProvider{
+		issuer:       issuer,
+		clientID:     os.Getenv("OIDC_CLIENT_ID"),
+		clientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+		redirectURL:  os.Getenv("OIDC_REDIRECT_URL"),
+		afterLogin:   os.Getenv("OIDC_POST_LOGIN_URL"),
+		client:       &http.Client{Timeout: 10 * time.Second},
+		pending:      map[string]oidcPending{},
+	}
+	if p.clientID == "" || p.redirectURL == "" {
+		return nil, errors.New("OIDC_CLIENT_ID and OIDC_REDIRECT_URL are required with OIDC_ISSUER")
+	}
+	if p.afterLogin == "" {
+		p.afterLogin = "/auth/me"
+	}
+	return p, nil
+}
+
+func (p *OIDCProvider) getJSON(ctx context.Context, u string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	res, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", u, res.Status)
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+func (p *OIDCProvider) discover(ctx context.Context) (*oidcDiscovery, error) {
+	p.mu.Lock()
+	meta := p.meta
+	p.mu.Unlock()
+	if meta != nil {
+		return meta, nil
+	}
+	meta = &oidcDiscovery{}
+	if err := p.getJSON(ctx, p.issuer+"/.well-known/openid-configuration", meta); err != nil {
+		return nil, err
+	}
+	if strings.TrimRight(meta.Issuer, "/") != p.issuer {
+		return nil, fmt.Errorf("discovery document is for issuer %q", meta.Issuer)
+	}
+	p.mu.Lock()
+	p.meta = meta
+	p.mu.Unlock()
+	return meta, nil
+}
+
+func base64URLBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// key returns the provider's signing key with the given ID, refetching the
+// key set once when the ID is unknown so rotated keys are picked up.
+func (p *OIDCProvider) key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	p.mu.Lock()
+	k := p.keys[kid]
+	p.mu.Unlock()
+	if k != nil {
+		return k, nil
+	}
+	meta, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := p.getJSON(ctx, meta.JWKSURI, &set); err != nil {
+		return nil, err
+	}
+	keys := map[string]*rsa.PublicKey{}
+	for _, jwk := range set.Keys {
+		if jwk.Kty != "RSA" {
+			continue
+		}
+		n, err := base64URLBigInt(jwk.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64URLBigInt(jwk.E)
+		if err != nil || !e.IsInt64() {
+			continue
+		}
+		keys[jwk.Kid] = &rsa.PublicKey{N: n, E: int(e.Int64())}
+	}
+	p.mu.Lock()
+	p.keys = keys
+	p.mu.Unlock()
+	if k = keys[kid]; k == nil {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return k, nil
+}
+
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// begin starts a sign-in and returns the provider URL to send the browser
+// to, and the state the callback must come back with.
+func (p *OIDCProvider) begin(ctx context.Context) (string, string, error) {
+	meta, err := p.discover(ctx)
+	if err != nil {
+		return "", "", err
+	}
+	state, nonce, verifier := newID(), newID(), newID()+newID()
+	now := time.Now()
+	p.mu.Lock()
+	for s, pend := range p.pending {
+		if now.After(pend.expires) {
+			delete(p.pending, s)
+		}
+	}
+	p.pending[state] = oidcPending{nonce: nonce, verifier: verifier, expires: now.Add(oidcLoginTTL)}
+	p.mu.Unlock()
+
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.clientID},
+		"redirect_uri":          {p.redirectURL},
+		"scope":                 {"openid email profile"},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {pkceChallenge(verifier)},
+		"code_challenge_method": {"S256"},
+	}
+	return meta.AuthorizationEndpoint + "?" + q.Encode(), state, nil
+}
+
+// finish redeems the code from the provider's redirect and returns the
+// verified ID token claims.
+func (p *OIDCProvider) finish(ctx context.Context, state, code string) (oidcClaims, error) {
+	p.mu.Lock()
+	pend, ok := p.pending[state]
+	delete(p.pending, state)
+	p.mu.Unlock()
+	if !ok || time.Now().After(pend.expires) {
+		return oidcClaims{}, errors.New("unknown or expired sign-in attempt")
+	}
+	meta, err := p.discover(ctx)
+	if err != nil {
+		return oidcClaims{}, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.redirectURL},
+		"code_verifier": {pend.verifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, meta.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return oidcClaims{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(p.clientID), url.QueryEscape(p.clientSecret))
+	res, err := p.client.Do(req)
+	if err != nil {
+		return oidcClaims{}, err
+	}
+	defer res.Body.Close()
+	var tok struct {
+		IDToken string `json:"id_token"`
+		Error   string `json:"error"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&tok); err != nil {
+		return oidcClaims{}, fmt.Errorf("token endpoint: %v", err)
+	}
+	if res.StatusCode != http.StatusOK || tok.IDToken == "" {
+		return oidcClaims{}, fmt.Errorf("token endpoint: %s %s", res.Status, tok.Error)
+	}
+	return p.verify(ctx, tok.IDToken, pend.nonce, time.Now())
+}
+
+// verify checks an ID token's signature and claims.
+func (p *OIDCProvider) verify(ctx context.Context, raw, nonce string, now time.Time) (oidcClaims, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return oidcClaims{}, errors.New("malformed ID token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if b, err := base64.RawURLEncoding.DecodeString(parts[0]); err != nil || json.Unmarshal(b, &header) != nil {
+		return oidcClaims{}, errors.New("malformed ID token header")
+	}
+	if header.Alg != "RS256" {
+		return oidcClaims{}, fmt.Errorf("unsupported ID token algorithm %q", header.Alg)
+	}
+	key, err := p.key(ctx, header.Kid)
+	if err != nil {
+		return oidcClaims{}, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return oidcClaims{}, errors.New("malformed ID token signature")
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig); err != nil {
+		return oidcClaims{}, errors.New("ID token signature does not verify")
+	}
+
+	var c oidcClaims
+	if b, err := base64.RawURLEncoding.DecodeString(parts[1]); err != nil || json.Unmarshal(b, &c) != nil {
+		return oidcClaims{}, errors.New("malformed ID token claims")
+	}
+	switch {
+	case strings.TrimRight(c.Issuer, "/") != p.issuer:
+		return oidcClaims{}, fmt.Errorf("ID token is from issuer %q", c.Issuer)
+	case !slices.Contains(c.Audience, p.clientID):
+		return oidcClaims{}, errors.New("ID token is not for this client")
+	case now.Add(-oidcClockSkew).Unix() >= c.Expiry:
+		return oidcClaims{}, errors.New("ID token has expired")
+	case c.IssuedAt > now.Add(oidcClockSkew).Unix():
+		return oidcClaims{}, errors.New("ID token is issued in the future")
+	case c.Nonce != nonce:
+		return oidcClaims{}, errors.New("ID token nonce does not match")
+	case c.Subject == "":
+		return oidcClaims{}, errors.New("ID token has no subject")
+	}
+	return c, nil
+}
+
+func registerOIDCRoutes(mux *http.ServeMux, provider *OIDCProvider, users *UserStore) {
+	if provider == nil {
+		return
+	}
+	stateCookie := func(w http.ResponseWriter, r *http.Request, state string, maxAge int) {
+		http.SetCookie(w, &http.Cookie{
+			Name:     oidcStateCookie,
+			Value:    state,
+			Path:     "/auth/oidc/",
+			MaxAge:   maxAge,
+			HttpOnly: true,
+			Secure:   r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https",
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+	mux.HandleFunc("GET /auth/oidc/login", func(w http.ResponseWriter, r *http.Request) {
+		u, state, err := provider.begin(r.Context())
+		if err != nil {
+			log.Printf("starting OIDC sign-in: %v", err)
+			http.Error(w, "the identity provider is unavailable", http.StatusBadGateway)
+			return
+		}
+		stateCookie(w, r, state, int(oidcLoginTTL/time.Second))
+		http.Redirect(w, r, u, http.StatusFound)
+	})
+	mux.HandleFunc("GET /auth/oidc/callback", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if e := q.Get("error"); e != "" {
+			http.Error(w, "sign-in was not completed: "+e, http.StatusUnauthorized)
+			return
+		}
+		state := q.Get("state")
+		c, err := r.Cookie(oidcStateCookie)
+		if err != nil || state == "" || subtle.ConstantTimeCompare([]byte(c.Value), []byte(state)) != 1 {
+			http.Error(w, "this sign-in was not started in this browser", http.StatusUnauthorized)
+			return
+		}
+		stateCookie(w, r, "", -1)
+		claims, err := provider.finish(r.Context(), state, q.Get("code"))
+		if err != nil {
+			log.Printf("OIDC sign-in: %v", err)
+			http.Error(w, "sign-in failed", http.StatusUnauthorized)
+			return
+		}
+		u, err := users.LinkOIDC(claims.Subject, claims.Email, claims.EmailVerified)
+		if err != nil {
+			http.Error(w, "there is no gatekeeper account for "+claims.Email, http.StatusForbidden)
+			return
+		}
+		token, expires, err := users.StartSession(u.ID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		setSessionCookie(w, r, token, expires)
+		http.Redirect(w, r, provider.afterLogin, http.StatusSeeOther)
+	})
+}
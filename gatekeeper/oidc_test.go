@@ -0,0 +1,130 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+)
+
+// newOIDCTest serves gatekeeper's OIDC routes against a development
+// identity provider and returns the gatekeeper server.
+func newOIDCTest(t *testing.T) *httptest.Server {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &devIDP{clientID: "gatekeeper", clientSecret: "dev-secret", key: key, kid: "k1", codes: map[string]devIDPCode{}}
+	idpSrv := httptest.NewServer(idp.routes())
+	t.Cleanup(idpSrv.Close)
+	idp.issuer = idpSrv.URL
+
+	users, err := OpenUserStore(filepath.Join(t.TempDir(), "users.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := users.Create(User{Email: "ana@mercy.example", Name: "Ana Ruiz", Role: RoleAuditor}, ""); err != nil {
+		t.Fatal(err)
+	}
+	provider := &OIDCProvider{
+		issuer:       idpSrv.URL,
+		clientID:     "gatekeeper",
+		clientSecret: "dev-secret",
+		afterLogin:   "/auth/me",
+		client:       idpSrv.Client(),
+		pending:      map[string]oidcPending{},
+	}
+	mux := http.NewServeMux()
+	registerOIDCRoutes(mux, provider, users)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	provider.redirectURL = srv.URL + "/auth/oidc/callback"
+	return srv
+}
+
+// browser is a client with its own cookies that does not follow redirects.
+func browser(t *testing.T) *http.Client {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &http.Client{Jar: jar, CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+}
+
+// startSignIn begins a sign-in in b and signs in at the provider as email,
+// returning the callback URL the provider sends the browser back to.
+func startSignIn(t *testing.T, srv *httptest.Server, b *http.Client, email string) string {
+	t.Helper()
+	res, err := b.Get(srv.URL + "/auth/oidc/login")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	authorize, err := url.Parse(res.Header.Get("Location"))
+	if res.StatusCode != http.StatusFound || err != nil {
+		t.Fatalf("login: %s, location %q", res.Status, res.Header.Get("Location"))
+	}
+	form := authorize.Query()
+	form.Set("email", email)
+	authorize.RawQuery = ""
+	res, err = b.PostForm(authorize.String(), form)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusSeeOther {
+		t.Fatalf("authorize: %s", res.Status)
+	}
+	return res.Header.Get("Location")
+}
+
+func signedIn(res *http.Response) bool {
+	for _, c := range res.Cookies() {
+		if c.Name == sessionCookie && c.Value != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func TestOIDCCallbackNeedsStateCookie(t *testing.T) {
+	srv := newOIDCTest(t)
+	starter, victim := browser(t), browser(t)
+	callback := startSignIn(t, srv, starter, "ana@mercy.example")
+
+	// Sent to a browser that never started a sign-in, or started its own,
+	// the callback must not sign that browser in.
+	for name, b := range map[string]*http.Client{"fresh browser": browser(t), "other sign-in": victim} {
+		if name == "other sign-in" {
+			startSignIn(t, srv, victim, "lee@mercy.example")
+		}
+		res, err := b.Get(callback)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusUnauthorized || signedIn(res) {
+			t.Errorf("%s: %s, signed in %v", name, res.Status, signedIn(res))
+		}
+	}
+
+	res, err := starter.Get(callback)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusSeeOther || res.Header.Get("Location") != "/auth/me" || !signedIn(res) {
+		t.Fatalf("the browser that started the sign-in: %s, signed in %v", res.Status, signedIn(res))
+	}
+	u, _ := url.Parse(srv.URL + "/auth/oidc/")
+	for _, c := range starter.Jar.Cookies(u) {
+		if c.Name == oidcStateCookie {
+			t.Error("the state cookie outlived the sign-in")
+		}
+	}
+}
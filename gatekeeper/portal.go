@@ -0,0 +1,677 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/mail"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mailjet/mailjet-apiv3-go/v4"
+)
+
+const (
+	magicLinkTTL     = 15 * time.Minute
+	portalSessionTTL = 12 * time.Hour
+	portalCookie     = "portal_session"
+	// maxPendingLinks bounds the unused links one address can hold for a
+	// facility, so the login form cannot be used to flood an inbox.
+	maxPendingLinks = 3
+)
+
+var errTooManyLinks = errors.New("too many sign-in links requested; use the latest email or try again later")
+
+// magicLink is a one-time sign-in link, stored by the hash of its token.
+type magicLink struct {
+	Email      string    `json:"email"`
+	FacilityID string    `json:"facility_id"`
+	CreatedAt  time.Time `json:"created_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// PortalSession is a facility contact signed in to the client portal. A
+// session only ever sees the one facility its link was issued for.
+type PortalSession struct {
+	Email      string    `json:"email"`
+	FacilityID string    `json:"facility_id"`
+	CreatedAt  time.Time `json:"created_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+type portalData struct {
+	Links    map[string]*magicLink     `json:"links"`
+	Sessions map[string]*PortalSession `json:"sessions"`
+	// Contacts are addresses granted access by the team, by facility ID, in
+	// addition to the contact on each of the facility's submissions.
+	Contacts map[string][]string `json:"contacts"`
+}
+
+// PortalStore keeps portal sign-in links, sessions and granted contacts.
+// Tokens are stored only as SHA-256 hashes.
+type PortalStore struct {
+	file *fileStore[portalData]
+}
+
+func OpenPortalStore(path string) (*PortalStore, error) {
+	f, err := openFileStore[portalData](path)
+	if err != nil {
+		return nil, err
+	}
+	return &PortalStore{file: f}, nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func normalizeEmail(s string) string {
+	return strings.ToLower(strings.TrimSpace(s))
+}
+
+// prune drops expired links and sessions.
+func (d *portalData) prune(now time.Time) {
+	for k, l := range d.Links {
+		if !now.Before(l.ExpiresAt) {
+			delete(d.Links, k)
+		}
+	}
+	for k, s := range d.Sessions {
+		if !now.Before(s.ExpiresAt) {
+			delete(d.Sessions, k)
+		}
+	}
+}
+
+// IssueLink creates a sign-in token for email at a facility.
+func (s *PortalStore) IssueLink(email, facilityID string, now time.Time) (string, error) {
+	token := newID()
+	err := s.file.Update(func(d *portalData) error {
+		d.prune(now)
+		pending := 0
+		for _, l := range d.Links {
+			if l.Email == email && l.FacilityID == facilityID {
+				pending++
+			}
+		}
+		if pending >= maxPendingLinks {
+			return errTooManyLinks
+		}
+		if d.Links == nil {
+			d.Links = map[string]*magicLink{}
+		}
+		d.Links[hashToken(token)] = &magicLink{Email: email, FacilityID: facilityID, CreatedAt: now, ExpiresAt: now.Add(magicLinkTTL)}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// Redeem uses up a sign-in token and starts a session, returning the
+// session's token. Unknown, used and expired tokens are all errNotFound.
+func (s *PortalStore) Redeem(token string, now time.Time) (PortalSession, string, error) {
+	var sess PortalSession
+	sessionToken := newID()
+	err := s.file.Update(func(d *portalData) error {
+		d.prune(now)
+		key := hashToken(token)
+		link, ok := d.Links[key]
+		if !ok {
+			return errNotFound
+		}
+		delete(d.Links, key)
+		sess = PortalSession{Email: link.Email, FacilityID: link.FacilityID, CreatedAt: now, ExpiresAt: now.Add(portalSessionTTL)}
+		if d.Sessions == nil {
+			d.Sessions = map[string]*PortalSession{}
+		}
+		stored := sess
+		d.Sessions[hashToken(sessionToken)] = &stored
+		return nil
+	})
+	if err != nil {
+		return PortalSession{}, "", err
+	}
+	return sess, sessionToken, nil
+}
+
+func (s *PortalStore) Session(token string, now time.Time) (PortalSession, bool) {
+	var (
+		sess PortalSession
+		ok   bool
+	)
+	s.file.View(func(d *portalData) {
+		var p *PortalSession
+		if p, ok = d.Sessions[hashToken(token)]; ok {
+			sess = *p
+		}
+	})
+	if !ok || !now.Before(sess.ExpiresAt) {
+		return PortalSession{}, false
+	}
+	return sess, true
+}
+
+func (s *PortalStore) EndSession(token string) error {
+	return s.file.Update(func(d *portalData) error {
+		delete(d.Sessions, hashToken(token))
+		return nil
+	})
+}
+
+func (s *PortalStore) Contacts(facilityID string) []string {
+	var out []string
+	s.file.View(func(d *portalData) {
+		out = append([]string{}, d.Contacts[facilityID]...)
+	})
+	return out
+}
+
+func (s *PortalStore) AddContact(facilityID, email string) error {
+	return s.file.Update(func(d *portalData) error {
+		if containsString(d.Contacts[facilityID], email) {
+			return nil
+		}
+		if d.Contacts == nil {
+			d.Contacts = map[string][]string{}
+		}
+		d.Contacts[facilityID] = append(d.Contacts[facilityID], email)
+		sort.Strings(d.Contacts[facilityID])
+		return nil
+	})
+}
+
+// RemoveContact revokes a granted address and ends its sessions for the
+// facility.
+func (s *PortalStore) RemoveContact(facilityID, email string) error {
+	return s.file.Update(func(d *portalData) error {
+		list := d.Contacts[facilityID]
+		i := -1
+		for j, e := range list {
+			if e == email {
+				i = j
+			}
+		}
+		if i < 0 {
+			return errNotFound
+		}
+		d.Contacts[facilityID] = append(list[:i], list[i+1:]...)
+		for k, sess := range d.Sessions {
+			if sess.Email == email && sess.FacilityID == facilityID {
+				delete(d.Sessions, k)
+			}
+		}
+		return nil
+	})
+}
+
+// PortalContact is an address that may sign in for a facility. Source is
+// "granted" for addresses added by the team and "submission" for contacts
+// named on the facility's booked audit requests.
+type PortalContact struct {
+	Email  string `json:"email"`
+	Source string `json:"source"`
+}
+
+// Portal serves the client-facing pages. Everything it shows is looked up
+// from the signed-in facility, never from request parameters.
+type Portal struct {
+	store    *PortalStore
+	users    *UserStore
+	registry *FacilityRegistry
+	subs     *SubmissionStore
+	ws       *WorkspaceStore
+	capas    *CAPAStore
+	assets   *AssetStore
+	blobs    BlobStore
+	mailer   Mailer
+	baseURL  string
+}
+
+// NewPortal builds the portal. Sign-in links point at PORTAL_BASE_URL, the
+// public address of this service.
+func NewPortal(store *PortalStore, users *UserStore, registry *FacilityRegistry, subs *SubmissionStore, ws *WorkspaceStore, capas *CAPAStore, assets *AssetStore, blobs BlobStore, mailer Mailer) *Portal {
+	base := strings.TrimRight(os.Getenv("PORTAL_BASE_URL"), "/")
+	if base == "" {
+		base = "http://localhost:8080"
+	}
+	return &Portal{store: store, users: users, registry: registry, subs: subs, ws: ws, capas: capas, assets: assets, blobs: blobs, mailer: mailer, baseURL: base}
+}
+
+// contacts lists who may sign in for a facility. Anyone can send an audit
+// request naming any facility, so a request's contact only counts once the
+// team has booked it.
+func (p *Portal) contacts(f Facility) []PortalContact {
+	out := []PortalContact{}
+	seen := map[string]bool{}
+	for _, e := range p.store.Contacts(f.ID) {
+		seen[e] = true
+		out = append(out, PortalContact{Email: e, Source: "granted"})
+	}
+	for _, id := range f.SubmissionIDs {
+		sub, err := p.subs.Get(id)
+		if err != nil || sub.Booking == nil {
+			continue
+		}
+		if e := normalizeEmail(sub.Data.ContactEmail); e != "" && !seen[e] {
+			seen[e] = true
+			out = append(out, PortalContact{Email: e, Source: "submission"})
+		}
+	}
+	return out
+}
+
+func (p *Portal) allowed(email string, f Facility) bool {
+	for _, c := range p.contacts(f) {
+		if c.Email == email {
+			return true
+		}
+	}
+	return false
+}
+
+// facilitiesFor lists the facilities an address may sign in for.
+func (p *Portal) facilitiesFor(email string) []Facility {
+	var out []Facility
+	for _, f := range p.registry.List("") {
+		if p.allowed(email, f) {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// clientVisible reports whether an instance's report has been released to
+// the facility.
+func clientVisible(inst AuditInstance) bool {
+	return inst.Status == InstanceApproved
+}
+
+type PortalBooking struct {
+	SubmissionID   string      `json:"submission_id"`
+	AuditType      []AuditType `json:"audit_type"`
+	Start          string      `json:"start"`
+	End            string      `json:"end"`
+	ConsultantName string      `json:"consultant_name"`
+	Upcoming       bool        `json:"upcoming"`
+}
+
+// PortalSubmission is a facility's view of its own audit request, without
+// the team's notes, scoring or CRM state.
+type PortalSubmission struct {
+	ID            string         `json:"id"`
+	ReceivedAt    time.Time      `json:"received_at"`
+	AuditType     []AuditType    `json:"audit_type"`
+	DateIntervals []DateInterval `json:"date_intervals"`
+	ContactName   string         `json:"contact_name"`
+	Booking       *PortalBooking `json:"booking,omitempty"`
+}
+
+type PortalReport struct {
+	InstanceID  string        `json:"instance_id"`
+	Category    AuditCategory `json:"category"`
+	CompletedBy []string      `json:"completed_by"`
+	UpdatedAt   time.Time     `json:"updated_at"`
+	URL         string        `json:"url"`
+}
+
+type PortalOverview struct {
+	Facility    PortalFacility     `json:"facility"`
+	Email       string             `json:"email"`
+	Submissions []PortalSubmission `json:"submissions"`
+	Bookings    []PortalBooking    `json:"bookings"`
+	Reports     []PortalReport     `json:"reports"`
+	CAPAs       []CAPA             `json:"capas"`
+}
+
+type PortalFacility struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Address string `json:"address"`
+}
+
+func (p *Portal) overview(sess PortalSession, f Facility, now time.Time) PortalOverview {
+	out := PortalOverview{
+		Facility:    PortalFacility{ID: f.ID, Name: f.Name, Address: f.Address},
+		Email:       sess.Email,
+		Submissions: []PortalSubmission{},
+		Bookings:    []PortalBooking{},
+		Reports:     []PortalReport{},
+	}
+	today := now.Format(time.DateOnly)
+	for _, id := range f.SubmissionIDs {
+		sub, err := p.subs.Get(id)
+		if err != nil {
+			continue
+		}
+		ps := PortalSubmission{
+			ID:            sub.ID,
+			ReceivedAt:    sub.ReceivedAt,
+			AuditType:     sub.Data.AuditType,
+			DateIntervals: sub.Data.DateIntervals,
+			ContactName:   sub.Data.ContactName,
+		}
+		if b := sub.Booking; b != nil {
+			ps.Booking = &PortalBooking{
+				SubmissionID:   sub.ID,
+				AuditType:      sub.Data.AuditType,
+				Start:          b.Start,
+				End:            b.End,
+				ConsultantName: b.ConsultantName,
+				Upcoming:       b.End >= today,
+			}
+			out.Bookings = append(out.Bookings, *ps.Booking)
+		}
+		out.Submissions = append(out.Submissions, ps)
+	}
+	sort.Slice(out.Submissions, func(i, j int) bool { return out.Submissions[i].ReceivedAt.After(out.Submissions[j].ReceivedAt) })
+	sort.Slice(out.Bookings, func(i, j int) bool { return out.Bookings[i].Start > out.Bookings[j].Start })
+
+	for _, inst := range p.ws.Instances() {
+		if !clientVisible(inst) || inst.FacilityID != f.ID {
+			continue
+		}
+		out.Reports = append(out.Reports, PortalReport{
+			InstanceID:  inst.ID,
+			Category:    inst.Category,
+			CompletedBy: inst.CompletedBy,
+			UpdatedAt:   time.UnixMilli(inst.UpdatedAt).UTC(),
+			URL:         "/portal/reports/" + inst.ID,
+		})
+	}
+	sort.Slice(out.Reports, func(i, j int) bool { return out.Reports[i].UpdatedAt.After(out.Reports[j].UpdatedAt) })
+
+	out.CAPAs = p.capas.List(CAPAFilter{Facility: &f, ActiveOnly: true}, func(id string) string {
+		if f, err := p.registry.Get(id); err == nil {
+			return f.ID
+		}
+		return ""
+	})
+	return out
+}
+
+// session resolves the request's portal session, or signed-in client user,
+// and its facility. The facility is re-read and access re-checked on every
+// request, so a revoked contact or merged facility takes effect immediately.
+func (p *Portal) session(r *http.Request) (PortalSession, Facility, string, bool) {
+	token := ""
+	if c, err := r.Cookie(portalCookie); err == nil {
+		token = c.Value
+	} else if t, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		token = t
+	}
+	sess, ok := p.store.Session(token, time.Now())
+	if !ok {
+		// Client users signed in to the gatekeeper see their own facility.
+		pr, ok := principalFrom(r.Context())
+		if !ok || pr.User.Role != RoleClient {
+			return PortalSession{}, Facility{}, "", false
+		}
+		f, err := p.registry.Get(pr.User.FacilityID)
+		if err != nil {
+			return PortalSession{}, Facility{}, "", false
+		}
+		return PortalSession{Email: pr.User.Email, FacilityID: f.ID}, f, "", true
+	}
+	f, err := p.registry.Get(sess.FacilityID)
+	if err != nil || !p.allowed(sess.Email, f) {
+		return PortalSession{}, Facility{}, "", false
+	}
+	return sess, f, token, true
+}
+
+// requirePortal guards portal routes. Pages redirect to the sign-in form;
+// API routes answer 401.
+func (p *Portal) requirePortal(page bool, next func(w http.ResponseWriter, r *http.Request, sess PortalSession, f Facility)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sess, f, _, ok := p.session(r)
+		if !ok {
+			if page {
+				http.Redirect(w, r, "/portal/login", http.StatusSeeOther)
+				return
+			}
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		next(w, r, sess, f)
+	})
+}
+
+// sendLinks emails one sign-in link per facility the address may access.
+// Unknown addresses get nothing, and the caller answers the same either way
+// so the form does not reveal who our clients are.
+func (p *Portal) sendLinks(email string) error {
+	type facilityLink struct {
+		Name, URL string
+	}
+	var links []facilityLink
+	for _, f := range p.facilitiesFor(email) {
+		token, err := p.store.IssueLink(email, f.ID, time.Now())
+		if errors.Is(err, errTooManyLinks) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		links = append(links, facilityLink{f.Name, p.baseURL + "/portal/verify?token=" + token})
+	}
+	if len(links) == 0 {
+		return nil
+	}
+	var buf bytes.Buffer
+	err := portalLinkEmailTemplate.Execute(&buf, struct {
+		Links   []facilityLink
+		Minutes int
+	}{links, int(magicLinkTTL.Minutes())})
+	if err != nil {
+		return err
+	}
+	return p.mailer.Send([]mailjet.RecipientV31{{Email: email}}, "Your Crown Point client portal sign-in link", buf.String())
+}
+
+var portalPages = template.Must(template.ParseFS(templateFS, "templates/portal.html"))
+
+func renderPortalPage(w http.ResponseWriter, name string, status int, data any) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := portalPages.ExecuteTemplate(w, name, data); err != nil {
+		log.Printf("rendering portal page %s: %v", name, err)
+	}
+}
+
+func (p *Portal) setSessionCookie(w http.ResponseWriter, token string, expires time.Time) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     portalCookie,
+		Value:    token,
+		Path:     "/portal",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(p.baseURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func registerPortalRoutes(mux *http.ServeMux, p *Portal) {
+	mux.HandleFunc("GET /portal/login", func(w http.ResponseWriter, r *http.Request) {
+		renderPortalPage(w, "login", http.StatusOK, nil)
+	})
+	// Accepts the sign-in form or {"email": ...}.
+	mux.HandleFunc("POST /portal/login", func(w http.ResponseWriter, r *http.Request) {
+		email := r.FormValue("email")
+		isJSON := strings.HasPrefix(r.Header.Get("Content-Type"), "application/json")
+		if isJSON {
+			var req struct {
+				Email string `json:"email"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			email = req.Email
+		}
+		addr, err := mail.ParseAddress(email)
+		if err != nil {
+			if isJSON {
+				http.Error(w, "email is not a valid address", http.StatusBadRequest)
+				return
+			}
+			renderPortalPage(w, "login", http.StatusBadRequest, map[string]string{"Error": "Please enter a valid email address."})
+			return
+		}
+		if err := p.sendLinks(normalizeEmail(addr.Address)); err != nil {
+			log.Printf("sending portal sign-in link: %v", err)
+		}
+		if isJSON {
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		renderPortalPage(w, "sent", http.StatusOK, map[string]any{"Minutes": int(magicLinkTTL.Minutes())})
+	})
+	// Mail scanners fetch links to check them, so opening the link only
+	// shows a button; the token is used up by the POST that follows.
+	mux.HandleFunc("GET /portal/verify", func(w http.ResponseWriter, r *http.Request) {
+		renderPortalPage(w, "verify", http.StatusOK, map[string]string{"Token": r.URL.Query().Get("token")})
+	})
+	mux.HandleFunc("POST /portal/verify", func(w http.ResponseWriter, r *http.Request) {
+		sess, token, err := p.store.Redeem(r.FormValue("token"), time.Now())
+		if err != nil {
+			renderPortalPage(w, "login", http.StatusUnauthorized, map[string]string{"Error": "That sign-in link has expired or was already used. Request a new one below."})
+			return
+		}
+		p.setSessionCookie(w, token, sess.ExpiresAt)
+		http.Redirect(w, r, "/portal", http.StatusSeeOther)
+	})
+	mux.HandleFunc("POST /portal/logout", func(w http.ResponseWriter, r *http.Request) {
+		if _, _, token, ok := p.session(r); ok && token != "" {
+			if err := p.store.EndSession(token); err != nil {
+				log.Printf("ending portal session: %v", err)
+			}
+		}
+		if pr, ok := principalFrom(r.Context()); ok && pr.Via == "session" {
+			if err := p.users.EndSession(requestToken(r)); err != nil {
+				log.Printf("ending session: %v", err)
+			}
+			setSessionCookie(w, r, "", time.Unix(0, 0))
+		}
+		p.setSessionCookie(w, "", time.Unix(0, 0))
+		http.Redirect(w, r, "/portal/login", http.StatusSeeOther)
+	})
+
+	mux.Handle("GET /portal", p.requirePortal(true, func(w http.ResponseWriter, r *http.Request, sess PortalSession, f Facility) {
+		renderPortalPage(w, "dashboard", http.StatusOK, p.overview(sess, f, time.Now()))
+	}))
+	mux.Handle("GET /portal/api/overview", p.requirePortal(false, func(w http.ResponseWriter, r *http.Request, sess PortalSession, f Facility) {
+		writeJSON(w, http.StatusOK, p.overview(sess, f, time.Now()))
+	}))
+	// Reports of other facilities and unreleased drafts are both 404, so
+	// instance IDs cannot be probed.
+	mux.Handle("GET /portal/reports/{id}", p.requirePortal(false, func(w http.ResponseWriter, r *http.Request, sess PortalSession, f Facility) {
+		inst, err := p.ws.Instance(r.PathValue("id"))
+		if err != nil || !clientVisible(inst) || inst.FacilityID != f.ID {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		master, err := p.ws.MasterFor(inst)
+		if err != nil {
+			http.Error(w, errNoMaster.Error(), http.StatusConflict)
+			return
+		}
+		body, err := GenerateAuditReport(inst, master, p.ws.Approval(inst.ID), func(ref string) []byte {
+			return loadReportPhoto(r.Context(), p.assets, p.blobs, ref)
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeDocument(w, slugify(inst.FacilityName+" "+string(inst.Category)+" audit report"), "pdf", body)
+	}))
+
+	// Who may sign in for a facility, and granting or revoking access for
+	// addresses that are not on any of its submissions.
+	mux.Handle("GET /facilities/{id}/portal-contacts", requireOffice(func(w http.ResponseWriter, r *http.Request) {
+		f, err := p.registry.Get(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, p.contacts(f))
+	}))
+	mux.Handle("POST /facilities/{id}/portal-contacts", requireOffice(func(w http.ResponseWriter, r *http.Request) {
+		f, err := p.registry.Get(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		var req struct {
+			Email string `json:"email"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		addr, err := mail.ParseAddress(req.Email)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("email: %v", err), http.StatusBadRequest)
+			return
+		}
+		if err := p.store.AddContact(f.ID, normalizeEmail(addr.Address)); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, p.contacts(f))
+	}))
+	mux.Handle("DELETE /facilities/{id}/portal-contacts/{email}", requireOffice(func(w http.ResponseWriter, r *http.Request) {
+		f, err := p.registry.Get(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err := p.store.RemoveContact(f.ID, normalizeEmail(r.PathValue("email"))); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+}
+
+var portalLinkEmailTemplate = template.Must(template.New("portal-link").Parse(`<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="utf-8">
+    <style>
+        body { font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, Arial, sans-serif; background: #f1f5f9; margin: 0; padding: 40px 20px; color: #1a202c; line-height: 1.6; }
+        .container { max-width: 640px; margin: 0 auto; background: #ffffff; border-radius: 20px; overflow: hidden; }
+        .header { background: linear-gradient(135deg, #667eea 0%, #764ba2 100%); padding: 32px 40px; color: white; }
+        .header h1 { font-size: 22px; margin: 0; }
+        .section { padding: 24px 40px; border-bottom: 2px solid #f7fafc; }
+        .button { display: inline-block; background: #4f46e5; color: #ffffff; padding: 12px 24px; border-radius: 10px; text-decoration: none; font-weight: 600; margin: 6px 0; }
+        .footer { background: #1e293b; padding: 24px 40px; color: #cbd5e1; font-size: 13px; }
+    </style>
+</head>
+<body>
+<div class="container">
+    <div class="header">
+        <h1>Sign in to your Crown Point client portal</h1>
+    </div>
+    <div class="section">
+        {{range .Links}}
+        <p><a class="button" href="{{.URL}}">Open {{.Name}}</a></p>
+        {{end}}
+    </div>
+    <div class="footer">
+        Each link works once and expires in {{.Minutes}} minutes. If you did not ask to sign in, you can ignore this email.
+    </div>
+</div>
+</body>
+</html>`))
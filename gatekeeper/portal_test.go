@@ -0,0 +1,47 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestPortalContactsNeedBookingOrGrant(t *testing.T) {
+	dir := t.TempDir()
+	store, err := OpenPortalStore(filepath.Join(dir, "portal.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	subs, err := OpenSubmissionStore(filepath.Join(dir, "submissions.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	add := func(email string, booked bool) string {
+		t.Helper()
+		sub, err := subs.Add(AuditData{FacilityName: "Mercy Hospital", ContactEmail: email}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if booked {
+			subs.Modify(sub.ID, func(sub *Submission) error {
+				sub.Booking = &Booking{Start: "2026-11-02", End: "2026-11-03"}
+				return nil
+			})
+		}
+		return sub.ID
+	}
+	f := Facility{ID: "fac-1", SubmissionIDs: []string{add("ana@mercy.example", true), add("intruder@example.com", false)}}
+	if err := store.AddContact(f.ID, "lee@mercy.example"); err != nil {
+		t.Fatal(err)
+	}
+	p := &Portal{store: store, subs: subs}
+
+	for email, want := range map[string]bool{
+		"lee@mercy.example":    true,
+		"ana@mercy.example":    true,
+		"intruder@example.com": false,
+	} {
+		if got := p.allowed(email, f); got != want {
+			t.Errorf("allowed(%s) = %v, want %v", email, got, want)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+//go:embed priority.json
+var defaultPriorityConfig []byte
+
+// PriorityConfig weights the signals used to rank incoming requests. It is
+// loaded from PRIORITY_CONFIG, or from the priority.json compiled into the
+// binary when that variable is unset.
+type PriorityConfig struct {
+	Weights struct {
+		HasFindings    int `json:"has_findings"`
+		PerFinding     int `json:"per_finding"`
+		NoTracking     int `json:"no_tracking"`
+		LevelITrauma   int `json:"level_i_trauma"`
+		TravelerHeavy  int `json:"traveler_heavy"`
+		ManyORs        int `json:"many_ors"`
+		NearTermWindow int `json:"near_term_window"`
+	} `json:"weights"`
+	MaxCountedFindings int     `json:"max_counted_findings"`
+	TravelerShare      float64 `json:"traveler_share"`
+	ManyORs            int     `json:"many_ors"`
+	NearTermDays       int     `json:"near_term_days"`
+	HighThreshold      int     `json:"high_threshold"`
+	MediumThreshold    int     `json:"medium_threshold"`
+}
+
+type PrioritySignal struct {
+	Signal string `json:"signal"`
+	Points int    `json:"points"`
+}
+
+// Priority ranks a request for follow-up. Score is capped at 100.
+type Priority struct {
+	Score   int              `json:"score"`
+	Label   string           `json:"label"`
+	Signals []PrioritySignal `json:"signals"`
+}
+
+type PriorityScorer struct {
+	cfg PriorityConfig
+}
+
+func LoadPriorityScorer(path string) (*PriorityScorer, error) {
+	raw := defaultPriorityConfig
+	if path != "" {
+		var err error
+		if raw, err = os.ReadFile(path); err != nil {
+			return nil, err
+		}
+	}
+	var cfg PriorityConfig
+	if err := json.Unmarshal(raw, &cfg); err != nil {
+		return nil, err
+	}
+	return &PriorityScorer{cfg: cfg}, nil
+}
+
+// Score ranks data as of now. The near-term signal depends on the current
+// date, so scores are recomputed rather than stored.
+func (p *PriorityScorer) Score(data AuditData, now time.Time) Priority {
+	cfg := p.cfg
+	var pr Priority
+	add := func(signal string, points int) {
+		if points > 0 {
+			pr.Signals = append(pr.Signals, PrioritySignal{Signal: signal, Points: points})
+			pr.Score += points
+		}
+	}
+
+	if data.HasFindings {
+		add("Findings on last survey", cfg.Weights.HasFindings)
+	}
+	findings := 0
+	for _, f := range data.Findings {
+		if strings.TrimSpace(f) != "" {
+			findings++
+		}
+	}
+	if cfg.MaxCountedFindings > 0 && findings > cfg.MaxCountedFindings {
+		findings = cfg.MaxCountedFindings
+	}
+	if findings > 0 {
+		add(fmt.Sprintf("%d prior finding(s)", findings), findings*cfg.Weights.PerFinding)
+	}
+	if !data.HasTracking {
+		add("No instrument tracking system", cfg.Weights.NoTracking)
+	}
+	if data.TraumaLevel == "Level I" {
+		add("Level I trauma center", cfg.Weights.LevelITrauma)
+	}
+	if total := staffTotal(data); total > 0 {
+		share := float64(parseCount(data.StaffTravelers)) / float64(total)
+		if share >= cfg.TravelerShare {
+			add(fmt.Sprintf("Traveler-heavy staffing (%.0f%%)", share*100), cfg.Weights.TravelerHeavy)
+		}
+	}
+	if ors := parseCount(data.OrCount); cfg.ManyORs > 0 && ors >= cfg.ManyORs {
+		add(fmt.Sprintf("%d operating rooms", ors), cfg.Weights.ManyORs)
+	}
+	if days, ok := daysUntilEarliestWindow(data, now); ok && days <= cfg.NearTermDays {
+		add(fmt.Sprintf("Requested window in %d days", days), cfg.Weights.NearTermWindow)
+	}
+
+	if pr.Score > 100 {
+		pr.Score = 100
+	}
+	switch {
+	case pr.Score >= cfg.HighThreshold:
+		pr.Label = "High"
+	case pr.Score >= cfg.MediumThreshold:
+		pr.Label = "Medium"
+	default:
+		pr.Label = "Low"
+	}
+	return pr
+}
+
+// daysUntilEarliestWindow reports how many days remain until the first
+// requested window that has not yet ended.
+func daysUntilEarliestWindow(data AuditData, now time.Time) (int, bool) {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	best, found := 0, false
+	for _, iv := range data.DateIntervals {
+		start, err := time.Parse(time.DateOnly, iv.Start)
+		if err != nil {
+			continue
+		}
+		if end, err := time.Parse(time.DateOnly, iv.End); err == nil && end.Before(today) {
+			continue
+		}
+		days := int(start.Sub(today).Hours() / 24)
+		if days < 0 {
+			days = 0
+		}
+		if !found || days < best {
+			best, found = days, true
+		}
+	}
+	return best, found
+}
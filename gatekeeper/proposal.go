@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+)
+
+//go:embed templates
+var templateFS embed.FS
+
+// auditScope describes what each requested audit type covers on site.
+var auditScope = map[AuditType]string{
+	CSSD:      "Sterile processing (CSSD/SPD): decontamination, prep and pack, sterilization, sterile storage and point-of-use treatment.",
+	ENDOSCOPY: "Flexible endoscope reprocessing: pre-cleaning, leak testing, manual cleaning, high-level disinfection, drying and storage.",
+	DENTAL:    "Dental instrument reprocessing: cleaning, packaging, sterilization, monitoring and storage.",
+}
+
+type TimelinePhase struct {
+	Phase string
+	When  string
+}
+
+// ProposalData is what proposal templates are executed against.
+type ProposalData struct {
+	Data             AuditData
+	Date             string
+	AuditTypeDisplay string
+	SystemName       string
+	Scope            []string
+	Procedures       []string
+	Findings         []string
+	Timeline         []TimelinePhase
+	Estimate         Estimate
+}
+
+// oneLine flattens free text so it can't break the document markup.
+func oneLine(s string) string {
+	return strings.Join(strings.Fields(strings.ReplaceAll(s, "|", "/")), " ")
+}
+
+func buildProposalData(sub Submission, estimate Estimate, now time.Time) ProposalData {
+	data := sub.Data
+	p := ProposalData{
+		Data:     data,
+		Date:     now.Format("January 2, 2006"),
+		Estimate: estimate,
+	}
+	if data.IsAffiliated && data.SystemName != nil {
+		p.SystemName = *data.SystemName
+	}
+
+	p.AuditTypeDisplay = formatAuditTypes(data.AuditType)
+	for _, at := range data.AuditType {
+		if scope, ok := auditScope[at]; ok {
+			p.Scope = append(p.Scope, scope)
+		}
+	}
+	p.Scope = append(p.Scope,
+		"Staffing, competency and workflow review across all shifts.",
+		"Written report of findings with AAMI references and prioritized recommendations.",
+	)
+
+	procedures := []struct {
+		label string
+		on    bool
+	}{
+		{"Endoscopes", data.ProcEndoscopes},
+		{"Vascular/Heart", data.ProcVascular},
+		{"Arthroscopic", data.ProcArthroscopic},
+		{"Robotic", data.ProcRobotic},
+		{"TEE Probes", data.ProcTee},
+	}
+	for _, proc := range procedures {
+		if proc.on {
+			p.Procedures = append(p.Procedures, proc.label)
+		}
+	}
+
+	// Copy rather than edit in place: the slice is shared with the store.
+	p.Data.AreasOfFocus = nil
+	for _, f := range data.AreasOfFocus {
+		if f = oneLine(f); f != "" {
+			p.Data.AreasOfFocus = append(p.Data.AreasOfFocus, f)
+		}
+	}
+	for _, f := range data.Findings {
+		if f = oneLine(f); f != "" {
+			p.Findings = append(p.Findings, f)
+		}
+	}
+
+	p.Timeline = proposalTimeline(sub, estimate)
+	return p
+}
+
+// proposalTimeline anchors the engagement phases on the booked window, or on
+// the first requested window when nothing is booked yet.
+func proposalTimeline(sub Submission, estimate Estimate) []TimelinePhase {
+	var startStr, endStr string
+	if sub.Booking != nil {
+		startStr, endStr = sub.Booking.Start, sub.Booking.End
+	} else if len(sub.Data.DateIntervals) > 0 {
+		startStr, endStr = sub.Data.DateIntervals[0].Start, sub.Data.DateIntervals[0].End
+	}
+	start, errStart := time.Parse(time.DateOnly, startStr)
+	end, errEnd := time.Parse(time.DateOnly, endStr)
+	onSite := fmt.Sprintf("%g consultant days", estimate.ConsultantDays)
+	if errStart != nil || errEnd != nil {
+		return []TimelinePhase{
+			{"Discovery call and document request", "On signing"},
+			{"Pre-visit document review", "2 weeks before the visit"},
+			{"On-site assessment", "To be scheduled (" + onSite + ")"},
+			{"Draft report", "10 business days after the visit"},
+			{"Read-out and final report", "3 weeks after the visit"},
+		}
+	}
+	const layout = "Jan 2, 2006"
+	return []TimelinePhase{
+		{"Discovery call and document request", "Week of " + start.AddDate(0, 0, -21).Format(layout)},
+		{"Pre-visit document review", "Week of " + start.AddDate(0, 0, -14).Format(layout)},
+		{"On-site assessment", start.Format(layout) + " – " + end.Format(layout) + " (" + onSite + ")"},
+		{"Draft report", "By " + end.AddDate(0, 0, 14).Format(layout)},
+		{"Read-out and final report", "By " + end.AddDate(0, 0, 21).Format(layout)},
+	}
+}
+
+// docTemplateFuncs are available to document templates. Every field that
+// holds free text should be piped through oneLine.
+var docTemplateFuncs = template.FuncMap{"oneLine": oneLine}
+
+// loadDocTemplate reads a document template from the path in envVar, or from
+// the embedded templates directory when the variable is unset.
+func loadDocTemplate(envVar, name string) (*template.Template, error) {
+	if path := os.Getenv(envVar); path != "" {
+		return template.New(filepath.Base(path)).Funcs(docTemplateFuncs).ParseFiles(path)
+	}
+	return template.New(name).Funcs(docTemplateFuncs).ParseFS(templateFS, "templates/"+name)
+}
+
+func GenerateProposal(sub Submission, estimate Estimate, format string) ([]byte, error) {
+	tmpl, err := loadDocTemplate("PROPOSAL_TEMPLATE", "proposal.tmpl")
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, buildProposalData(sub, estimate, time.Now())); err != nil {
+		return nil, err
+	}
+	blocks := parseDocMarkup(buf.String())
+	switch format {
+	case "pdf":
+		return renderPDF("Proposal for "+sub.Data.FacilityName, blocks)
+	case "docx":
+		return renderDOCX(blocks)
+	}
+	return nil, fmt.Errorf("unsupported format %q", format)
+}
+
+var documentContentTypes = map[string]string{
+	"pdf":  "application/pdf",
+	"docx": "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+}
+
+// writeDocument sends a generated file as a download.
+func writeDocument(w http.ResponseWriter, filename, format string, body []byte) {
+	w.Header().Set("Content-Type", documentContentTypes[format])
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename+"."+format))
+	_, _ = w.Write(body)
+}
+
+func registerProposalRoutes(mux *http.ServeMux, subs *SubmissionStore, estimator *Estimator) {
+	mux.Handle("GET /submissions/{id}/proposal", requireOffice(func(w http.ResponseWriter, r *http.Request) {
+		sub, err := subs.Get(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = "docx"
+		}
+		if _, ok := documentContentTypes[format]; !ok {
+			http.Error(w, "format must be docx or pdf", http.StatusBadRequest)
+			return
+		}
+		doc, err := GenerateProposal(sub, estimator.Estimate(sub.Data), format)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeDocument(w, "proposal-"+slugify(sub.Data.FacilityName), format, doc)
+	}))
+}
+
+func slugify(s string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(s) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			b.WriteRune(r)
+			dash = false
+		case !dash && b.Len() > 0:
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+	return strings.TrimSuffix(b.String(), "-")
+}
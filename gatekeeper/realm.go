@@ -0,0 +1,235 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+// The types below mirror realm/types.ts. JSON field names are camelCase to
+// match the workspace app, unlike the snake_case intake form.
+
+type AuditCategory string
+
+const (
+	CategoryCSSD      AuditCategory = "Sterile Processing (CSSD)"
+	CategoryEndoscopy AuditCategory = "Endoscopy"
+	CategoryDental    AuditCategory = "Dental"
+)
+
+var auditCategories = []AuditCategory{CategoryCSSD, CategoryEndoscopy, CategoryDental}
+
+func (c AuditCategory) valid() bool {
+	for _, v := range auditCategories {
+		if c == v {
+			return true
+		}
+	}
+	return false
+}
+
+type AuditSection string
+
+const (
+	SectionGeneral       AuditSection = "General"
+	SectionPrepandPack   AuditSection = "PrepandPack"
+	SectionSterilization AuditSection = "Sterilization"
+	SectionStorage       AuditSection = "Storage"
+	SectionPointOfUse    AuditSection = "Point-of-Use"
+	SectionDecon         AuditSection = "Decon"
+)
+
+// auditSections is in report order.
+var auditSections = []AuditSection{
+	SectionGeneral, SectionPointOfUse, SectionDecon, SectionPrepandPack, SectionSterilization, SectionStorage,
+}
+
+func (s AuditSection) valid() bool {
+	for _, v := range auditSections {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+type RiskLevel string
+
+const (
+	RiskCritical RiskLevel = "Critical"
+	RiskMajor    RiskLevel = "Major"
+	RiskMinor    RiskLevel = "Minor"
+)
+
+func (r RiskLevel) valid() bool {
+	return r == "" || r == RiskCritical || r == RiskMajor || r == RiskMinor
+}
+
+type AuditFinding struct {
+	ID               string    `json:"id"`
+	EquipmentSubject string    `json:"equipmentSubject"`
+	Issue            string    `json:"issue"`
+	Rationale        string    `json:"rationale"`
+	Recommendation   string    `json:"recommendation"`
+	AAMIReference    string    `json:"aamiReference"`
+	RiskLevel        RiskLevel `json:"riskLevel,omitempty"`
+}
+
+type AuditReport struct {
+	Category  AuditCategory                   `json:"category"`
+	Sections  map[AuditSection][]AuditFinding `json:"sections"`
+	Version   string                          `json:"version"`
+	UpdatedAt int64                           `json:"updatedAt"`
+}
+
+type ResponseStatus string
+
+const (
+	StatusCompliant    ResponseStatus = "Compliant"
+	StatusNonCompliant ResponseStatus = "Non-Compliant"
+	StatusNA           ResponseStatus = "N/A"
+	StatusUnanswered   ResponseStatus = "Unanswered"
+)
+
+type AuditResponse struct {
+	FindingID    string         `json:"findingId"`
+	Status       ResponseStatus `json:"status"`
+	Notes        string         `json:"notes"`
+	Images       []string       `json:"images"`
+	RiskLevel    RiskLevel      `json:"riskLevel,omitempty"`
+	CapaRequired *bool          `json:"capaRequired,omitempty"`
+	// Version and ModifiedAt are kept by the server for offline sync: the
+	// change sequence number of the last write, and when (by the writer's
+	// clock) that write was made. Values sent by clients are ignored.
+	Version    int64 `json:"version,omitempty"`
+	ModifiedAt int64 `json:"modifiedAt,omitempty"`
+}
+
+// sameContent reports whether two responses say the same thing, ignoring
+// the server's version bookkeeping.
+func (r AuditResponse) sameContent(o AuditResponse) bool {
+	capa := func(b *bool) int {
+		switch {
+		case b == nil:
+			return 0
+		case *b:
+			return 2
+		}
+		return 1
+	}
+	return r.Status == o.Status && r.Notes == o.Notes && r.RiskLevel == o.RiskLevel &&
+		capa(r.CapaRequired) == capa(o.CapaRequired) && slices.Equal(r.Images, o.Images)
+}
+
+// InstanceStatus is where an instance is in the review workflow: a draft
+// is submitted for review and then approved, which releases its report.
+type InstanceStatus string
+
+const (
+	InstanceDraft       InstanceStatus = "draft"
+	InstanceUnderReview InstanceStatus = "under-review"
+	InstanceApproved    InstanceStatus = "approved"
+	// InstanceSubmitted is a status clients could set themselves before the
+	// review workflow, so it says nothing about review. OpenWorkspaceStore
+	// moves such instances under review; it is no longer accepted.
+	InstanceSubmitted InstanceStatus = "submitted"
+)
+
+type AuditInstance struct {
+	ID                string                   `json:"id"`
+	FacilityName      string                   `json:"facilityName"`
+	Category          AuditCategory            `json:"category"`
+	Status            InstanceStatus           `json:"status"`
+	CreatedAt         int64                    `json:"createdAt"`
+	UpdatedAt         int64                    `json:"updatedAt"`
+	CompletedBy       []string                 `json:"completedBy"`
+	EngagementPartner string                   `json:"engagementPartner"`
+	Responses         map[string]AuditResponse `json:"responses"`
+	// FacilityID links the instance to the facility registry. Reports reach
+	// a facility's portal, trends and benchmarks through it, never through
+	// the name, which anyone can type.
+	FacilityID string `json:"facilityId,omitempty"`
+	// MasterVersion is the version of the category's master checklist the
+	// responses refer to. It changes only by migrating the instance.
+	MasterVersion string `json:"masterVersion,omitempty"`
+	// Orphans are responses whose finding a migration could not carry over.
+	Orphans []OrphanedResponse `json:"orphans,omitempty"`
+	// Version is the change sequence number of the last change to the
+	// instance's own fields; responses carry their own.
+	Version int64 `json:"version,omitempty"`
+}
+
+// OrphanedResponse keeps an answer to a finding that was dropped from the
+// master, with the finding as it read, so the work is not lost.
+type OrphanedResponse struct {
+	Response      AuditResponse `json:"response"`
+	Finding       AuditFinding  `json:"finding"`
+	Section       AuditSection  `json:"section"`
+	MasterVersion string        `json:"masterVersion"`
+}
+
+// AppState is the whole workspace as the realm app loads it.
+type AppState struct {
+	MasterData map[AuditCategory]*AuditReport `json:"masterData"`
+	Instances  []AuditInstance                `json:"instances"`
+	// Cursor is the sync cursor the state is at least as new as.
+	Cursor int64 `json:"cursor"`
+}
+
+// nowMillis matches JavaScript's Date.now().
+func nowMillis() int64 {
+	return time.Now().UnixMilli()
+}
+
+func (r AuditReport) validate() error {
+	if !r.Category.valid() {
+		return fmt.Errorf("unknown category %q", r.Category)
+	}
+	ids := map[string]bool{}
+	for section, findings := range r.Sections {
+		if !section.valid() {
+			return fmt.Errorf("unknown section %q", section)
+		}
+		for _, f := range findings {
+			if f.ID == "" {
+				return fmt.Errorf("section %s: finding without id", section)
+			}
+			if ids[f.ID] {
+				return fmt.Errorf("duplicate finding id %q", f.ID)
+			}
+			ids[f.ID] = true
+			if !f.RiskLevel.valid() {
+				return fmt.Errorf("finding %s: unknown risk level %q", f.ID, f.RiskLevel)
+			}
+		}
+	}
+	return nil
+}
+
+func (i AuditInstance) validate() error {
+	if i.FacilityName == "" {
+		return fmt.Errorf("facilityName is required")
+	}
+	if !i.Category.valid() {
+		return fmt.Errorf("unknown category %q", i.Category)
+	}
+	switch i.Status {
+	case InstanceDraft, InstanceUnderReview, InstanceApproved:
+	default:
+		return fmt.Errorf("unknown status %q", i.Status)
+	}
+	for key, resp := range i.Responses {
+		if resp.FindingID != key {
+			return fmt.Errorf("response %q has findingId %q", key, resp.FindingID)
+		}
+		switch resp.Status {
+		case StatusCompliant, StatusNonCompliant, StatusNA, StatusUnanswered:
+		default:
+			return fmt.Errorf("response %q: unknown status %q", key, resp.Status)
+		}
+		if !resp.RiskLevel.valid() {
+			return fmt.Errorf("response %q: unknown risk level %q", key, resp.RiskLevel)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mailjet/mailjet-apiv3-go/v4"
+)
+
+const reminderJobKind = "visit_reminder"
+
+// reminderSendHour is the local hour at which reminders go out on their day.
+const reminderSendHour = 9
+
+type reminderPayload struct {
+	SubmissionID string `json:"submission_id"`
+	BookingStart string `json:"booking_start"`
+	OffsetDays   int    `json:"offset_days"`
+}
+
+// ReminderScheduler turns bookings into pre-visit reminder jobs and sends
+// them when they come due.
+type ReminderScheduler struct {
+	jobs    *Scheduler
+	subs    *SubmissionStore
+	mailer  Mailer
+	offsets []int
+}
+
+func NewReminderScheduler(jobs *Scheduler, subs *SubmissionStore, mailer Mailer, offsets []int) *ReminderScheduler {
+	rs := &ReminderScheduler{jobs: jobs, subs: subs, mailer: mailer, offsets: offsets}
+	jobs.Handle(reminderJobKind, rs.send)
+	return rs
+}
+
+// reminderOffsets reads REMINDER_OFFSETS, a comma separated list of days
+// before the booked start, falling back to 14, 3 and 1 days.
+func reminderOffsets() ([]int, error) {
+	raw := os.Getenv("REMINDER_OFFSETS")
+	if raw == "" {
+		return []int{14, 3, 1}, nil
+	}
+	var offsets []int
+	for _, part := range strings.Split(raw, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("REMINDER_OFFSETS: invalid offset %q", part)
+		}
+		offsets = append(offsets, n)
+	}
+	return offsets, nil
+}
+
+func reminderPrefix(submissionID string) string {
+	return reminderJobKind + ":" + submissionID + ":"
+}
+
+// ScheduleFor queues reminders for the submission's current booking and
+// cancels any still pending for an earlier booking. Offsets whose send time
+// has already passed are skipped.
+func (rs *ReminderScheduler) ScheduleFor(sub Submission) error {
+	if sub.Booking == nil {
+		return rs.Cancel(sub.ID)
+	}
+	start, err := sub.Booking.StartTime()
+	if err != nil {
+		return err
+	}
+	err = rs.jobs.CancelPending(reminderPrefix(sub.ID), func(job Job) bool {
+		var p reminderPayload
+		return json.Unmarshal(job.Payload, &p) == nil && p.BookingStart == sub.Booking.Start
+	})
+	if err != nil {
+		return err
+	}
+
+	sendDay := time.Date(start.Year(), start.Month(), start.Day(), reminderSendHour, 0, 0, 0, start.Location())
+	now := time.Now()
+	for _, offset := range rs.offsets {
+		runAt := sendDay.AddDate(0, 0, -offset)
+		if runAt.Before(now) {
+			continue
+		}
+		payload, err := json.Marshal(reminderPayload{
+			SubmissionID: sub.ID,
+			BookingStart: sub.Booking.Start,
+			OffsetDays:   offset,
+		})
+		if err != nil {
+			return err
+		}
+		err = rs.jobs.Schedule(Job{
+			ID:      fmt.Sprintf("%s%s:%d", reminderPrefix(sub.ID), sub.Booking.Start, offset),
+			Kind:    reminderJobKind,
+			RunAt:   runAt.UTC(),
+			Payload: payload,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (rs *ReminderScheduler) Cancel(submissionID string) error {
+	return rs.jobs.CancelPending(reminderPrefix(submissionID), nil)
+}
+
+func (rs *ReminderScheduler) JobsFor(submissionID string) []Job {
+	return rs.jobs.Jobs(reminderPrefix(submissionID))
+}
+
+func (rs *ReminderScheduler) send(_ context.Context, job Job) error {
+	var p reminderPayload
+	if err := json.Unmarshal(job.Payload, &p); err != nil {
+		return fmt.Errorf("%w: bad payload: %v", errJobObsolete, err)
+	}
+	sub, err := rs.subs.Get(p.SubmissionID)
+	if err != nil {
+		return fmt.Errorf("%w: submission %s is gone", errJobObsolete, p.SubmissionID)
+	}
+	// The booking may have moved or been cleared since the job was queued.
+	if sub.Booking == nil || sub.Booking.Start != p.BookingStart {
+		return fmt.Errorf("%w: booking changed", errJobObsolete)
+	}
+	start, err := sub.Booking.StartTime()
+	if err != nil {
+		return fmt.Errorf("%w: %v", errJobObsolete, err)
+	}
+	if !time.Now().Before(start) {
+		return fmt.Errorf("%w: visit already started", errJobObsolete)
+	}
+
+	html, err := GenerateReminderEmail(sub)
+	if err != nil {
+		return err
+	}
+	to := []mailjet.RecipientV31{{Email: sub.Data.ContactEmail, Name: sub.Data.ContactName}}
+	if sub.Booking.ConsultantEmail != "" {
+		to = append(to, mailjet.RecipientV31{Email: sub.Booking.ConsultantEmail, Name: sub.Booking.ConsultantName})
+	}
+	subject := fmt.Sprintf("Reminder: %s audit visit begins %s", sub.Data.FacilityName, sub.Booking.Start)
+	return rs.mailer.Send(to, subject, html)
+}
+
+func GenerateReminderEmail(sub Submission) (string, error) {
+	start, err := sub.Booking.StartTime()
+	if err != nil {
+		return "", err
+	}
+	today := time.Now()
+	midnight := time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, start.Location())
+	daysLeft := int(start.Sub(midnight).Hours() / 24)
+
+	templateData := struct {
+		Sub              Submission
+		DaysLeft         int
+		AuditTypeDisplay string
+	}{
+		Sub:              sub,
+		DaysLeft:         daysLeft,
+		AuditTypeDisplay: formatAuditTypes(sub.Data.AuditType),
+	}
+
+	tmplSource := `
+<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="utf-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <style>
+        body {
+            font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, 'Helvetica Neue', Arial, sans-serif;
+            background: #f1f5f9;
+            margin: 0;
+            padding: 40px 20px;
+            color: #1a202c;
+            line-height: 1.6;
+        }
+        .container {
+            max-width: 640px;
+            margin: 0 auto;
+            background: #ffffff;
+            border-radius: 20px;
+            overflow: hidden;
+            box-shadow: 0 10px 30px rgba(0, 0, 0, 0.1);
+        }
+        .header {
+            background: linear-gradient(135deg, #667eea 0%, #764ba2 100%);
+            padding: 40px;
+            text-align: center;
+            color: white;
+        }
+        .header h1 { font-size: 26px; font-weight: 800; margin: 0; }
+        .countdown { font-size: 48px; font-weight: 800; display: block; }
+        .section { padding: 30px 40px; border-bottom: 2px solid #f7fafc; }
+        .label {
+            font-weight: 700;
+            font-size: 12px;
+            color: #64748b;
+            text-transform: uppercase;
+            letter-spacing: 0.5px;
+        }
+        .value { font-size: 15px; color: #1e293b; font-weight: 500; margin-bottom: 12px; }
+        .checklist li { margin-bottom: 8px; color: #475569; }
+        .footer {
+            background: linear-gradient(135deg, #1e293b 0%, #334155 100%);
+            padding: 30px 40px;
+            text-align: center;
+            color: #cbd5e1;
+            font-size: 13px;
+        }
+    </style>
+</head>
+<body>
+    <div class="container">
+        <div class="header">
+            <span class="countdown">{{.DaysLeft}}</span>
+            <h1>{{if eq .DaysLeft 1}}Day{{else}}Days{{end}} Until Your {{.AuditTypeDisplay}} Audit</h1>
+        </div>
+
+        <div class="section">
+            <div class="label">Facility</div>
+            <div class="value"><strong>{{.Sub.Data.FacilityName}}</strong><br>{{.Sub.Data.FacilityAddress}}</div>
+            <div class="label">On-Site Window</div>
+            <div class="value">{{.Sub.Booking.Start}} → {{.Sub.Booking.End}}</div>
+            {{if .Sub.Booking.ConsultantName}}
+            <div class="label">Lead Consultant</div>
+            <div class="value">{{.Sub.Booking.ConsultantName}}{{if .Sub.Booking.ConsultantEmail}} &lt;{{.Sub.Booking.ConsultantEmail}}&gt;{{end}}</div>
+            {{end}}
+        </div>
+
+        <div class="section">
+            <div class="label">Before We Arrive</div>
+            <ul class="checklist">
+                <li>Confirm department access and escort arrangements for the visit.</li>
+                <li>Have policies, IFUs and recent quality records available for review.</li>
+                {{if .Sub.Data.Findings}}<li>Gather corrective action evidence for findings from your last survey.</li>{{end}}
+                {{if .Sub.Data.HasTracking}}<li>Arrange read-only access to your instrument tracking system.</li>{{end}}
+                <li>Let {{.Sub.Data.ReportingTo}} know the dates so leadership can join the close-out.</li>
+            </ul>
+        </div>
+
+        <div class="footer">
+            This is an automated reminder from the Pre-Audit Assessment Portal<br>
+            <strong>Crown Point Consulting</strong>
+        </div>
+    </div>
+</body>
+</html>`
+
+	tmpl, err := template.New("reminder").Parse(tmplSource)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, templateData); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// errLocked is returned for edits to an instance that is under review or
+// approved. Its content is frozen until a partner requests changes or
+// reopens it, so what was reviewed is what gets approved. The API answers
+// 423 Locked, so clients can tell it from a 409 edit conflict.
+var errLocked = errors.New("instance is under review or approved; it must be sent back to draft before editing")
+
+type ReviewAction string
+
+const (
+	ReviewSubmit         ReviewAction = "submit"
+	ReviewRequestChanges ReviewAction = "request-changes"
+	ReviewApprove        ReviewAction = "approve"
+	ReviewReopen         ReviewAction = "reopen"
+)
+
+// reviewTransitions gives the status each action moves from and to.
+// Approving releases the report to the portal, benchmarks and trends.
+var reviewTransitions = map[ReviewAction][2]InstanceStatus{
+	ReviewSubmit:         {InstanceDraft, InstanceUnderReview},
+	ReviewRequestChanges: {InstanceUnderReview, InstanceDraft},
+	ReviewApprove:        {InstanceUnderReview, InstanceApproved},
+	ReviewReopen:         {InstanceApproved, InstanceDraft},
+}
+
+// reviewerActions may only be taken by an engagement partner (or admin);
+// any staff member may submit.
+var reviewerActions = map[ReviewAction]bool{ReviewRequestChanges: true, ReviewApprove: true, ReviewReopen: true}
+
+// ReviewStep is one entry of an instance's approval trail. ContentHash is
+// the report content hash at the time, so a later hash that differs from
+// the approval's proves the report changed.
+type ReviewStep struct {
+	ID          string         `json:"id"`
+	Action      ReviewAction   `json:"action"`
+	From        InstanceStatus `json:"from"`
+	To          InstanceStatus `json:"to"`
+	UserID      string         `json:"userId"`
+	UserName    string         `json:"userName"`
+	Role        Role           `json:"role"`
+	Comment     string         `json:"comment,omitempty"`
+	ContentHash string         `json:"contentHash"`
+	CreatedAt   int64          `json:"createdAt"`
+}
+
+// reportContent is what the client report is built from. Response
+// bookkeeping (versions, modification times) and orphans, which the report
+// does not show, are left out.
+type reportContent struct {
+	FacilityName      string                 `json:"facilityName"`
+	Category          AuditCategory          `json:"category"`
+	CompletedBy       []string               `json:"completedBy"`
+	EngagementPartner string                 `json:"engagementPartner"`
+	MasterVersion     string                 `json:"masterVersion"`
+	Findings          []reportContentFinding `json:"findings"`
+}
+
+type reportContentFinding struct {
+	Section      AuditSection   `json:"section"`
+	Finding      AuditFinding   `json:"finding"`
+	Status       ResponseStatus `json:"status"`
+	Notes        string         `json:"notes"`
+	Images       []string       `json:"images"`
+	RiskLevel    RiskLevel      `json:"riskLevel,omitempty"`
+	CapaRequired *bool          `json:"capaRequired,omitempty"`
+}
+
+// reportContentHash is the SHA-256, in hex, of the report's content as
+// canonical JSON. It does not hash the PDF, which carries the date it was
+// rendered; two renderings of unchanged content share a hash.
+func reportContentHash(inst AuditInstance, master AuditReport) string {
+	c := reportContent{
+		FacilityName:      inst.FacilityName,
+		Category:          inst.Category,
+		CompletedBy:       append([]string{}, inst.CompletedBy...),
+		EngagementPartner: inst.EngagementPartner,
+		MasterVersion:     master.Version,
+		Findings:          []reportContentFinding{},
+	}
+	walkFindings(master, inst, func(section AuditSection, f AuditFinding, resp AuditResponse) {
+		images := resp.Images
+		if images == nil {
+			images = []string{}
+		}
+		c.Findings = append(c.Findings, reportContentFinding{section, f, resp.Status, resp.Notes, images, resp.RiskLevel, resp.CapaRequired})
+	})
+	raw, _ := json.Marshal(c) // plain structs and slices; cannot fail
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// contentHash hashes an instance against the master its report is built
+// from, as MasterFor picks it.
+func (d *workspaceData) contentHash(inst *AuditInstance) (string, error) {
+	master, ok := d.masterVersion(inst.Category, inst.MasterVersion)
+	if !ok {
+		master, ok = d.masterVersion(inst.Category, "")
+	}
+	if !ok {
+		return "", errNoMaster
+	}
+	return reportContentHash(*inst, master), nil
+}
+
+// Review takes a workflow action on an instance for user. A non-zero
+// updatedAt is checked like an update.
+func (s *WorkspaceStore) Review(id string, action ReviewAction, user User, comment string, updatedAt int64) (AuditInstance, ReviewStep, error) {
+	move, ok := reviewTransitions[action]
+	if !ok {
+		return AuditInstance{}, ReviewStep{}, fmt.Errorf("%w: unknown review action %q", errInvalid, action)
+	}
+	comment = strings.TrimSpace(comment)
+	if comment == "" && (action == ReviewRequestChanges || action == ReviewReopen) {
+		return AuditInstance{}, ReviewStep{}, fmt.Errorf("%w: a comment is required to %s", errInvalid, action)
+	}
+	var (
+		inst AuditInstance
+		step ReviewStep
+	)
+	err := s.file.Update(func(d *workspaceData) error {
+		existing, ok := d.Instances[id]
+		if !ok {
+			return errNotFound
+		}
+		if updatedAt != 0 && existing.UpdatedAt != updatedAt {
+			return &conflictError{Current: cloneInstance(existing)}
+		}
+		if existing.Status != move[0] {
+			return fmt.Errorf("%w: cannot %s an instance that is %s", errInvalid, action, existing.Status)
+		}
+		hash, err := d.contentHash(existing)
+		if err != nil {
+			return fmt.Errorf("%w: %v", errInvalid, err)
+		}
+		if action == ReviewApprove {
+			if submitted := lastReviewStep(d.Reviews[id], ReviewSubmit); submitted != nil && submitted.ContentHash != hash {
+				return fmt.Errorf("%w: the report changed after it was submitted for review", errInvalid)
+			}
+		}
+		now := nowMillis()
+		step = ReviewStep{
+			ID:          newID(),
+			Action:      action,
+			From:        move[0],
+			To:          move[1],
+			UserID:      user.ID,
+			UserName:    user.Name,
+			Role:        user.Role,
+			Comment:     comment,
+			ContentHash: hash,
+			CreatedAt:   now,
+		}
+		if d.Reviews == nil {
+			d.Reviews = map[string][]ReviewStep{}
+		}
+		d.Reviews[id] = append(d.Reviews[id], step)
+		inst = cloneInstance(existing)
+		inst.Status = move[1]
+		inst.UpdatedAt = nextUpdatedAt(existing.UpdatedAt)
+		d.stamp(&inst, existing, now)
+		stored := cloneInstance(&inst)
+		d.Instances[id] = &stored
+		return nil
+	})
+	if err != nil {
+		return AuditInstance{}, ReviewStep{}, err
+	}
+	s.publish(InstanceEvent{Instance: cloneInstance(&inst)})
+	return inst, step, nil
+}
+
+func lastReviewStep(steps []ReviewStep, action ReviewAction) *ReviewStep {
+	for i := len(steps) - 1; i >= 0; i-- {
+		if steps[i].Action == action {
+			return &steps[i]
+		}
+	}
+	return nil
+}
+
+// ReviewTrail is an instance's workflow history with its current content
+// hash. For an approved instance, Unchanged tells whether the content
+// still matches what was approved.
+type ReviewTrail struct {
+	InstanceID  string         `json:"instanceId"`
+	Status      InstanceStatus `json:"status"`
+	ContentHash string         `json:"contentHash"`
+	Approval    *ReviewStep    `json:"approval,omitempty"`
+	Unchanged   *bool          `json:"unchanged,omitempty"`
+	Steps       []ReviewStep   `json:"steps"`
+}
+
+func (s *WorkspaceStore) ReviewTrail(id string) (ReviewTrail, error) {
+	var out ReviewTrail
+	err := errNotFound
+	s.file.View(func(d *workspaceData) {
+		inst, ok := d.Instances[id]
+		if !ok {
+			return
+		}
+		out = ReviewTrail{InstanceID: id, Status: inst.Status, Steps: slices.Clone(d.Reviews[id])}
+		if out.Steps == nil {
+			out.Steps = []ReviewStep{}
+		}
+		out.ContentHash, err = d.contentHash(inst)
+		if err != nil {
+			return
+		}
+		if inst.Status == InstanceApproved {
+			if out.Approval = lastReviewStep(out.Steps, ReviewApprove); out.Approval != nil {
+				unchanged := out.Approval.ContentHash == out.ContentHash
+				out.Unchanged = &unchanged
+			}
+		}
+	})
+	return out, err
+}
+
+// Approval is the step that approved an instance's current content, or nil
+// when it is not approved or has changed since.
+func (s *WorkspaceStore) Approval(id string) *ReviewStep {
+	trail, err := s.ReviewTrail(id)
+	if err != nil || trail.Unchanged == nil || !*trail.Unchanged {
+		return nil
+	}
+	return trail.Approval
+}
+
+func registerReviewRoutes(mux *http.ServeMux, ws *WorkspaceStore) {
+	mux.Handle("GET /realm/instances/{id}/review", realmRoute(func(w http.ResponseWriter, r *http.Request) {
+		trail, err := ws.ReviewTrail(r.PathValue("id"))
+		if errors.Is(err, errNoMaster) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, trail)
+	}))
+	mux.Handle("POST /realm/instances/{id}/review", realmRoute(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Action    ReviewAction `json:"action"`
+			Comment   string       `json:"comment"`
+			UpdatedAt int64        `json:"updatedAt"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		p, _ := principalFrom(r.Context())
+		if reviewerActions[body.Action] && p.User.Role != RolePartner && p.User.Role != RoleAdmin {
+			http.Error(w, "only an engagement partner can "+string(body.Action)+" a report", http.StatusForbidden)
+			return
+		}
+		inst, step, err := ws.Review(r.PathValue("id"), body.Action, p.User, body.Comment, body.UpdatedAt)
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"instance": inst, "step": step})
+	}))
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReviewApproves(t *testing.T) {
+	ws := openTestWorkspace(t)
+	master, _ := testMasters()
+	if _, err := ws.PublishMaster(master); err != nil {
+		t.Fatal(err)
+	}
+	inst, err := ws.CreateInstance(AuditInstance{FacilityName: "Mercy Hospital", Category: CategoryCSSD})
+	if err != nil {
+		t.Fatal(err)
+	}
+	auditor := User{ID: "u1", Name: "Ana Ruiz", Role: RoleAuditor}
+	partner := User{ID: "u2", Name: "Lee Chen", Role: RolePartner}
+	if _, _, err := ws.Review(inst.ID, ReviewSubmit, auditor, "", 0); err != nil {
+		t.Fatal(err)
+	}
+	got, _, err := ws.Review(inst.ID, ReviewApprove, partner, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != InstanceApproved || !clientVisible(got) {
+		t.Errorf("approved instance: status %q, client visible %v", got.Status, clientVisible(got))
+	}
+	if got, _, err = ws.Review(inst.ID, ReviewReopen, partner, "client sent new photos", 0); err != nil || got.Status != InstanceDraft {
+		t.Errorf("reopened: %q, %v", got.Status, err)
+	}
+}
+
+func TestOpenWorkspacePutsSubmittedUnderReview(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "workspace.json")
+	legacy := `{"instances": {"i1": {"id": "i1", "facilityName": "Mercy Hospital", "category": "Sterile Processing (CSSD)", "status": "submitted", "responses": {}}}}`
+	if err := os.WriteFile(path, []byte(legacy), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	var ws *WorkspaceStore
+	for range 2 {
+		var err error
+		ws, err = OpenWorkspaceStore(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		inst, err := ws.Instance("i1")
+		if err != nil || inst.Status != InstanceUnderReview || clientVisible(inst) {
+			t.Fatalf("status %q, client visible %v, %v", inst.Status, clientVisible(inst), err)
+		}
+		if ws.Approval("i1") != nil {
+			t.Error("an unreviewed instance has an approval")
+		}
+	}
+	master, _ := testMasters()
+	if _, err := ws.PublishMaster(master); err != nil {
+		t.Fatal(err)
+	}
+	partner := User{ID: "u2", Name: "Lee Chen", Role: RolePartner}
+	if got, _, err := ws.Review("i1", ReviewApprove, partner, "", 0); err != nil || got.Status != InstanceApproved || ws.Approval("i1") == nil {
+		t.Errorf("approving the migrated instance: %q, %v", got.Status, err)
+	}
+}
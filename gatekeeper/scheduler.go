@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type JobStatus string
+
+const (
+	JobPending   JobStatus = "pending"
+	JobRunning   JobStatus = "running"
+	JobDone      JobStatus = "done"
+	JobFailed    JobStatus = "failed"
+	JobCancelled JobStatus = "cancelled"
+)
+
+// Job is a unit of deferred work. IDs are chosen by the caller and double as
+// the de-duplication key: scheduling an ID that already exists is a no-op.
+type Job struct {
+	ID        string          `json:"id"`
+	Kind      string          `json:"kind"`
+	RunAt     time.Time       `json:"run_at"`
+	Payload   json.RawMessage `json:"payload,omitempty"`
+	Status    JobStatus       `json:"status"`
+	Attempts  int             `json:"attempts"`
+	LastError string          `json:"last_error,omitempty"`
+	DoneAt    *time.Time      `json:"done_at,omitempty"`
+}
+
+// errJobObsolete tells the scheduler that a job no longer applies and should
+// be cancelled rather than retried.
+var errJobObsolete = errors.New("job no longer applies")
+
+type JobHandler func(ctx context.Context, job Job) error
+
+// Scheduler runs persisted jobs once their RunAt has passed. A job is marked
+// running on disk before its handler is called; if the process dies while a
+// handler is in flight the job is failed on the next start instead of being
+// retried, because the side effect (usually an email) may already have
+// happened.
+type Scheduler struct {
+	store       *fileStore[map[string]*Job]
+	interval    time.Duration
+	maxAttempts int
+
+	mu       sync.RWMutex
+	handlers map[string]JobHandler
+}
+
+func OpenScheduler(path string) (*Scheduler, error) {
+	f, err := openFileStore[map[string]*Job](path)
+	if err != nil {
+		return nil, err
+	}
+	s := &Scheduler{
+		store:       f,
+		interval:    time.Minute,
+		maxAttempts: 5,
+		handlers:    map[string]JobHandler{},
+	}
+	err = f.Update(func(m *map[string]*Job) error {
+		for _, job := range *m {
+			if job.Status == JobRunning {
+				job.Status = JobFailed
+				job.LastError = "interrupted by restart; not retried to avoid a duplicate send"
+			}
+		}
+		return nil
+	})
+	return s, err
+}
+
+func (s *Scheduler) Handle(kind string, h JobHandler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[kind] = h
+}
+
+// Schedule persists job unless a non-cancelled job with the same ID exists.
+func (s *Scheduler) Schedule(job Job) error {
+	return s.store.Update(func(m *map[string]*Job) error {
+		if *m == nil {
+			*m = map[string]*Job{}
+		}
+		if existing, ok := (*m)[job.ID]; ok && existing.Status != JobCancelled {
+			return nil
+		}
+		job.Status = JobPending
+		job.Attempts = 0
+		job.LastError = ""
+		job.DoneAt = nil
+		(*m)[job.ID] = &job
+		return nil
+	})
+}
+
+// CancelPending cancels every pending job whose ID starts with prefix and for
+// which keep returns false. A nil keep cancels them all.
+func (s *Scheduler) CancelPending(prefix string, keep func(Job) bool) error {
+	return s.store.Update(func(m *map[string]*Job) error {
+		for id, job := range *m {
+			if job.Status != JobPending || !strings.HasPrefix(id, prefix) {
+				continue
+			}
+			if keep != nil && keep(*job) {
+				continue
+			}
+			job.Status = JobCancelled
+		}
+		return nil
+	})
+}
+
+// Jobs returns the jobs whose ID starts with prefix, ordered by RunAt.
+func (s *Scheduler) Jobs(prefix string) []Job {
+	var jobs []Job
+	s.store.View(func(m *map[string]*Job) {
+		for id, job := range *m {
+			if strings.HasPrefix(id, prefix) {
+				jobs = append(jobs, *job)
+			}
+		}
+	})
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].RunAt.Before(jobs[j].RunAt) })
+	return jobs
+}
+
+// Run polls for due jobs until ctx is cancelled.
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		s.RunDue(ctx, time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunDue executes every pending job with RunAt at or before now.
+func (s *Scheduler) RunDue(ctx context.Context, now time.Time) {
+	for _, job := range s.claimDue(now) {
+		s.mu.RLock()
+		handler, ok := s.handlers[job.Kind]
+		s.mu.RUnlock()
+
+		var err error
+		if ok {
+			err = handler(ctx, job)
+		} else {
+			err = fmt.Errorf("no handler registered for %q", job.Kind)
+		}
+		s.finish(job.ID, err, now)
+	}
+}
+
+func (s *Scheduler) claimDue(now time.Time) []Job {
+	var due []Job
+	err := s.store.Update(func(m *map[string]*Job) error {
+		for _, job := range *m {
+			if job.Status == JobPending && !job.RunAt.After(now) {
+				job.Status = JobRunning
+				job.Attempts++
+				due = append(due, *job)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("scheduler: claiming jobs: %v", err)
+		return nil
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].RunAt.Before(due[j].RunAt) })
+	return due
+}
+
+func (s *Scheduler) finish(id string, runErr error, now time.Time) {
+	err := s.store.Update(func(m *map[string]*Job) error {
+		job, ok := (*m)[id]
+		if !ok {
+			return nil
+		}
+		switch {
+		case runErr == nil:
+			job.Status = JobDone
+			job.LastError = ""
+			done := now.UTC()
+			job.DoneAt = &done
+		case errors.Is(runErr, errJobObsolete):
+			job.Status = JobCancelled
+			job.LastError = runErr.Error()
+		case job.Attempts >= s.maxAttempts:
+			job.Status = JobFailed
+			job.LastError = runErr.Error()
+		default:
+			job.Status = JobPending
+			job.LastError = runErr.Error()
+			job.RunAt = now.Add(time.Duration(job.Attempts) * 5 * time.Minute)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("scheduler: recording result of %s: %v", id, err)
+	}
+	if runErr != nil {
+		log.Printf("scheduler: job %s: %v", id, runErr)
+	}
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+)
+
+//go:embed scoring.json
+var defaultScoringConfig []byte
+
+// Unanswered policies decide how findings without a response affect a score.
+const (
+	// UnansweredExclude leaves them out of the score entirely.
+	UnansweredExclude = "exclude"
+	// UnansweredNonCompliant scores them as if they had failed.
+	UnansweredNonCompliant = "noncompliant"
+	// UnansweredPartial gives them UnansweredCredit of their weight.
+	UnansweredPartial = "partial"
+)
+
+// ScoringModel is how one audit category turns responses into a score.
+// Every scorable finding is worth its risk weight; compliant findings earn
+// all of it, non-compliant findings none. N/A findings are never scored.
+type ScoringModel struct {
+	Weights struct {
+		Critical float64 `json:"Critical"`
+		Major    float64 `json:"Major"`
+		Minor    float64 `json:"Minor"`
+		Unrated  float64 `json:"unrated"`
+	} `json:"weights"`
+	Unanswered       string  `json:"unanswered"`
+	UnansweredCredit float64 `json:"unanswered_credit"`
+}
+
+func (m ScoringModel) weight(r RiskLevel) float64 {
+	switch r {
+	case RiskCritical:
+		return m.Weights.Critical
+	case RiskMajor:
+		return m.Weights.Major
+	case RiskMinor:
+		return m.Weights.Minor
+	default:
+		return m.Weights.Unrated
+	}
+}
+
+func (m ScoringModel) validate() error {
+	w := m.Weights
+	if w.Critical <= 0 || w.Major <= 0 || w.Minor <= 0 || w.Unrated <= 0 {
+		return errors.New("weights must all be positive")
+	}
+	switch m.Unanswered {
+	case UnansweredExclude, UnansweredNonCompliant:
+	case UnansweredPartial:
+		if m.UnansweredCredit < 0 || m.UnansweredCredit > 1 {
+			return errors.New("unanswered_credit must be between 0 and 1")
+		}
+	default:
+		return fmt.Errorf("unknown unanswered policy %q", m.Unanswered)
+	}
+	return nil
+}
+
+// ScoringConfig holds the default model and per-category overrides. It is
+// loaded from SCORING_CONFIG, or from the scoring.json compiled into the
+// binary when that variable is unset.
+type ScoringConfig struct {
+	Default    ScoringModel                   `json:"default"`
+	Categories map[AuditCategory]ScoringModel `json:"categories"`
+}
+
+type ComplianceScorer struct {
+	cfg ScoringConfig
+}
+
+func LoadComplianceScorer(path string) (*ComplianceScorer, error) {
+	raw := defaultScoringConfig
+	if path != "" {
+		var err error
+		if raw, err = os.ReadFile(path); err != nil {
+			return nil, err
+		}
+	}
+	var cfg ScoringConfig
+	if err := json.Unmarshal(raw, &cfg); err != nil {
+		return nil, err
+	}
+	if err := cfg.Default.validate(); err != nil {
+		return nil, fmt.Errorf("default scoring model: %w", err)
+	}
+	for cat, m := range cfg.Categories {
+		if !cat.valid() {
+			return nil, fmt.Errorf("scoring model for unknown category %q", cat)
+		}
+		if err := m.validate(); err != nil {
+			return nil, fmt.Errorf("%s scoring model: %w", cat, err)
+		}
+	}
+	return &ComplianceScorer{cfg: cfg}, nil
+}
+
+// Model returns the scoring model for category.
+func (s *ComplianceScorer) Model(category AuditCategory) ScoringModel {
+	if m, ok := s.cfg.Categories[category]; ok {
+		return m
+	}
+	return s.cfg.Default
+}
+
+// Score is the weighted compliance of a section or a whole audit. Score is
+// nil when nothing in it could be scored, e.g. a section that is all N/A.
+type Score struct {
+	Score    *float64         `json:"score"`
+	Earned   float64          `json:"earned"`
+	Possible float64          `json:"possible"`
+	Counts   ComplianceCounts `json:"counts"`
+	// Complete is false while any finding is unanswered, whatever the
+	// model does with unanswered findings.
+	Complete bool `json:"complete"`
+}
+
+func (s *Score) add(m ScoringModel, status ResponseStatus, risk RiskLevel) {
+	s.Counts.add(status)
+	w := m.weight(risk)
+	switch status {
+	case StatusCompliant:
+		s.Earned += w
+		s.Possible += w
+	case StatusNonCompliant:
+		s.Possible += w
+	case StatusNA:
+	default:
+		switch m.Unanswered {
+		case UnansweredNonCompliant:
+			s.Possible += w
+		case UnansweredPartial:
+			s.Earned += w * m.UnansweredCredit
+			s.Possible += w
+		}
+	}
+}
+
+func (s *Score) finish() {
+	s.Complete = s.Counts.Unanswered == 0
+	if s.Possible == 0 {
+		return
+	}
+	v := math.Round(s.Earned/s.Possible*1000) / 10
+	s.Score = &v
+}
+
+type SectionScore struct {
+	Section AuditSection `json:"section"`
+	Title   string       `json:"title"`
+	Score
+}
+
+// InstanceScores is the scorecard of one audit instance.
+type InstanceScores struct {
+	InstanceID    string         `json:"instanceId"`
+	Category      AuditCategory  `json:"category"`
+	MasterVersion string         `json:"masterVersion,omitempty"`
+	Model         ScoringModel   `json:"model"`
+	Overall       Score          `json:"overall"`
+	Sections      []SectionScore `json:"sections"`
+}
+
+// Score computes the scorecard of inst against its category's master. The
+// overall score pools every finding, so sections weigh in by how much they
+// contain rather than equally.
+func (s *ComplianceScorer) Score(inst AuditInstance, master AuditReport) InstanceScores {
+	m := s.Model(inst.Category)
+	out := InstanceScores{
+		InstanceID:    inst.ID,
+		Category:      inst.Category,
+		MasterVersion: master.Version,
+		Model:         m,
+	}
+	bySection := map[AuditSection]*SectionScore{}
+	walkFindings(master, inst, func(section AuditSection, f AuditFinding, resp AuditResponse) {
+		sec, ok := bySection[section]
+		if !ok {
+			sec = &SectionScore{Section: section, Title: sectionTitles[section]}
+			bySection[section] = sec
+		}
+		risk := responseRisk(f, resp)
+		sec.add(m, resp.Status, risk)
+		out.Overall.add(m, resp.Status, risk)
+	})
+	for _, section := range auditSections {
+		if sec, ok := bySection[section]; ok {
+			sec.finish()
+			out.Sections = append(out.Sections, *sec)
+		}
+	}
+	out.Overall.finish()
+	return out
+}
+
+// ScoreSummary is one row of the instance score listing.
+type ScoreSummary struct {
+	InstanceID   string         `json:"instanceId"`
+	FacilityName string         `json:"facilityName"`
+	Category     AuditCategory  `json:"category"`
+	Status       InstanceStatus `json:"status"`
+	UpdatedAt    int64          `json:"updatedAt"`
+	Score        *float64       `json:"score"`
+	Complete     bool           `json:"complete"`
+}
+
+func registerScoringRoutes(mux *http.ServeMux, ws *WorkspaceStore, scorer *ComplianceScorer) {
+	mux.Handle("GET /realm/scoring-model", realmRoute(func(w http.ResponseWriter, r *http.Request) {
+		models := map[AuditCategory]ScoringModel{}
+		for _, cat := range auditCategories {
+			models[cat] = scorer.Model(cat)
+		}
+		writeJSON(w, http.StatusOK, models)
+	}))
+	mux.Handle("GET /realm/instances/{id}/scores", realmRoute(func(w http.ResponseWriter, r *http.Request) {
+		inst, err := ws.Instance(r.PathValue("id"))
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		master, err := ws.MasterFor(inst)
+		if errors.Is(err, errNotFound) {
+			http.Error(w, errNoMaster.Error(), http.StatusConflict)
+			return
+		}
+		writeJSON(w, http.StatusOK, scorer.Score(inst, master))
+	}))
+	// Instances whose category has no master yet are listed without a score.
+	mux.Handle("GET /realm/scores", realmRoute(func(w http.ResponseWriter, r *http.Request) {
+		category := AuditCategory(r.URL.Query().Get("category"))
+		rows := []ScoreSummary{}
+		for _, inst := range ws.Instances() {
+			if category != "" && inst.Category != category {
+				continue
+			}
+			row := ScoreSummary{
+				InstanceID:   inst.ID,
+				FacilityName: inst.FacilityName,
+				Category:     inst.Category,
+				Status:       inst.Status,
+				UpdatedAt:    inst.UpdatedAt,
+			}
+			if master, err := ws.MasterFor(inst); err == nil {
+				overall := scorer.Score(inst, master).Overall
+				row.Score, row.Complete = overall.Score, overall.Complete
+			}
+			rows = append(rows, row)
+		}
+		writeJSON(w, http.StatusOK, rows)
+	}))
+}
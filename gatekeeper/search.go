@@ -0,0 +1,490 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// stem reduces an English word to a crude root so "sterilizers",
+// "sterilized" and "sterilization" all index as "steriliz". It follows the
+// first steps of Porter's algorithm closely enough for checklist text; the
+// roots are never shown, so they only need to be consistent.
+func stem(w string) string {
+	if len(w) <= 3 || strings.ContainsAny(w, "0123456789") {
+		return w
+	}
+	switch {
+	case strings.HasSuffix(w, "sses"):
+		w = w[:len(w)-2]
+	case strings.HasSuffix(w, "ies"):
+		w = w[:len(w)-2]
+	case strings.HasSuffix(w, "ss"), strings.HasSuffix(w, "us"), strings.HasSuffix(w, "is"):
+	case strings.HasSuffix(w, "s"):
+		w = w[:len(w)-1]
+	}
+	if strings.HasSuffix(w, "eed") {
+		w = w[:len(w)-1]
+	} else {
+		for _, suffix := range []string{"ing", "ed"} {
+			base, ok := strings.CutSuffix(w, suffix)
+			if !ok || !hasVowel(base) || len(base) < 3 {
+				continue
+			}
+			w = base
+			switch {
+			case strings.HasSuffix(w, "at"), strings.HasSuffix(w, "bl"), strings.HasSuffix(w, "iz"):
+				w += "e"
+			case len(w) > 2 && w[len(w)-1] == w[len(w)-2] && !strings.ContainsRune("aeiouylsz", rune(w[len(w)-1])):
+				w = w[:len(w)-1]
+			}
+			break
+		}
+	}
+	if base, ok := strings.CutSuffix(w, "y"); ok && hasVowel(base) {
+		w = base + "i"
+	}
+	for _, r := range stemRules {
+		if base, ok := strings.CutSuffix(w, r[0]); ok && len(base) >= 3 {
+			w = base + r[1]
+			break
+		}
+	}
+	if base, ok := strings.CutSuffix(w, "ion"); ok && len(base) >= 4 && strings.ContainsRune("st", rune(base[len(base)-1])) {
+		w = base
+	}
+	if base, ok := strings.CutSuffix(w, "e"); ok && len(base) >= 4 {
+		w = base
+	}
+	return w
+}
+
+var stemRules = [][2]string{
+	{"ational", "ate"}, {"tional", "tion"}, {"ization", "ize"}, {"ation", "ate"},
+	{"ator", "ate"}, {"iveness", "ive"}, {"fulness", "ful"}, {"ousness", "ous"},
+	{"aliti", "al"}, {"iviti", "ive"}, {"biliti", "ble"}, {"alism", "al"},
+	{"enci", "ence"}, {"anci", "ance"}, {"izer", "ize"}, {"ment", ""}, {"ness", ""},
+}
+
+func hasVowel(s string) bool {
+	return strings.ContainsAny(s, "aeiouy")
+}
+
+// searchTerms are the stems of the significant words in s.
+func searchTerms(s string) []string {
+	var out []string
+	for _, w := range normalizeWords(s, nil, seedStopWords) {
+		out = append(out, stem(w))
+	}
+	return out
+}
+
+// searchFieldWeights rank a match in a finding's subject above one buried
+// in its rationale.
+var searchFieldWeights = map[string]float64{
+	"equipmentSubject": 3,
+	"issue":            2,
+	"aamiReference":    2,
+	"recommendation":   1,
+	"rationale":        1,
+	"notes":            1.5,
+}
+
+const (
+	SearchFinding = "finding"
+	SearchNote    = "note"
+)
+
+type searchField struct {
+	name, text string
+}
+
+type searchDoc struct {
+	hit    SearchHit
+	fields []searchField
+}
+
+type searchPosting struct {
+	doc, field, tf int
+}
+
+type searchIndex struct {
+	docs     []searchDoc
+	postings map[string][]searchPosting
+	vocab    []string // sorted terms, for prefix lookups
+}
+
+func buildSearchIndex(masters map[AuditCategory]*AuditReport, instances []AuditInstance, masterFor func(AuditInstance) (AuditReport, error)) *searchIndex {
+	idx := &searchIndex{postings: map[string][]searchPosting{}}
+	add := func(hit SearchHit, fields []searchField) {
+		doc := len(idx.docs)
+		idx.docs = append(idx.docs, searchDoc{hit: hit, fields: fields})
+		for i, f := range fields {
+			tf := map[string]int{}
+			for _, t := range searchTerms(f.text) {
+				tf[t]++
+			}
+			for t, n := range tf {
+				idx.postings[t] = append(idx.postings[t], searchPosting{doc, i, n})
+			}
+		}
+	}
+	for _, cat := range auditCategories {
+		master := masters[cat]
+		if master == nil {
+			continue
+		}
+		for _, section := range auditSections {
+			for _, f := range master.Sections[section] {
+				add(SearchHit{
+					Kind:             SearchFinding,
+					Category:         cat,
+					Section:          section,
+					FindingID:        f.ID,
+					EquipmentSubject: f.EquipmentSubject,
+					Issue:            f.Issue,
+				}, []searchField{
+					{"equipmentSubject", f.EquipmentSubject},
+					{"issue", f.Issue},
+					{"rationale", f.Rationale},
+					{"recommendation", f.Recommendation},
+					{"aamiReference", f.AAMIReference},
+				})
+			}
+		}
+	}
+	for _, inst := range instances {
+		master, err := masterFor(inst)
+		if err != nil {
+			continue
+		}
+		walkFindings(master, inst, func(section AuditSection, f AuditFinding, resp AuditResponse) {
+			if strings.TrimSpace(resp.Notes) == "" {
+				return
+			}
+			add(SearchHit{
+				Kind:             SearchNote,
+				Category:         inst.Category,
+				Section:          section,
+				FindingID:        f.ID,
+				InstanceID:       inst.ID,
+				FacilityName:     inst.FacilityName,
+				EquipmentSubject: f.EquipmentSubject,
+				Issue:            f.Issue,
+				Status:           resp.Status,
+			}, []searchField{{"notes", resp.Notes}})
+		})
+	}
+	for t := range idx.postings {
+		idx.vocab = append(idx.vocab, t)
+	}
+	sort.Strings(idx.vocab)
+	return idx
+}
+
+// queryTerm is one word of a query and the indexed terms it matches. A word
+// typed with a trailing "*", and the last word of every query so results
+// follow typing, also match terms that start with it.
+type queryTerm struct {
+	matches map[string]float64 // indexed term to match quality
+}
+
+const minPrefixLen = 2
+
+func (idx *searchIndex) parseQuery(q string) []queryTerm {
+	var out []queryTerm
+	fields := strings.Fields(q)
+	for i, field := range fields {
+		prefix := strings.HasSuffix(field, "*") || i == len(fields)-1
+		words := normalizeWords(field, nil, seedStopWords)
+		for j, w := range words {
+			t := queryTerm{matches: map[string]float64{}}
+			s := stem(w)
+			if _, ok := idx.postings[s]; ok {
+				t.matches[s] = 1
+			}
+			if prefix && j == len(words)-1 && len(w) >= minPrefixLen {
+				// A word as typed can run past its indexed stem
+				// ("washe" of "washes", indexed as "wash"), so the
+				// stem is tried as well.
+				for _, p := range []string{w, s} {
+					for k := sort.SearchStrings(idx.vocab, p); k < len(idx.vocab) && strings.HasPrefix(idx.vocab[k], p); k++ {
+						if _, exact := t.matches[idx.vocab[k]]; !exact {
+							t.matches[idx.vocab[k]] = 0.8
+						}
+					}
+				}
+			}
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// SearchHit is a master finding or a response note matching a search.
+// InstanceID, FacilityName and Status are set for notes only.
+type SearchHit struct {
+	Kind             string         `json:"kind"`
+	Category         AuditCategory  `json:"category"`
+	Section          AuditSection   `json:"section"`
+	FindingID        string         `json:"findingId"`
+	InstanceID       string         `json:"instanceId,omitempty"`
+	FacilityName     string         `json:"facilityName,omitempty"`
+	Status           ResponseStatus `json:"status,omitempty"`
+	EquipmentSubject string         `json:"equipmentSubject"`
+	Issue            string         `json:"issue"`
+	// Fields names where the query matched; Snippet is an excerpt of the
+	// best of them.
+	Fields  []string `json:"fields"`
+	Snippet string   `json:"snippet"`
+	Score   float64  `json:"score"`
+}
+
+type SearchFilter struct {
+	Category AuditCategory
+	Section  AuditSection
+	Kind     string
+	Limit    int
+}
+
+func (f SearchFilter) allows(h SearchHit) bool {
+	return (f.Category == "" || h.Category == f.Category) &&
+		(f.Section == "" || h.Section == f.Section) &&
+		(f.Kind == "" || h.Kind == f.Kind)
+}
+
+type SearchResults struct {
+	Query string      `json:"query"`
+	Total int         `json:"total"`
+	Hits  []SearchHit `json:"hits"`
+}
+
+// search ranks documents matching every query word by a BM25-style sum
+// over the words, weighting each field by searchFieldWeights.
+func (idx *searchIndex) search(q string, filter SearchFilter) SearchResults {
+	out := SearchResults{Query: q, Hits: []SearchHit{}}
+	terms := idx.parseQuery(q)
+	if len(terms) == 0 {
+		return out
+	}
+	type match struct {
+		score   float64
+		words   int
+		fields  map[int]float64
+		matched map[string]bool
+	}
+	matches := map[int]*match{}
+	n := float64(len(idx.docs))
+	for ti, t := range terms {
+		for term, quality := range t.matches {
+			postings := idx.postings[term]
+			idf := math.Log(1 + (n-float64(len(postings))+0.5)/(float64(len(postings))+0.5))
+			for _, p := range postings {
+				doc := idx.docs[p.doc]
+				if !filter.allows(doc.hit) {
+					continue
+				}
+				m, ok := matches[p.doc]
+				if !ok {
+					if ti > 0 {
+						continue // missed an earlier word
+					}
+					m = &match{fields: map[int]float64{}, matched: map[string]bool{}}
+					matches[p.doc] = m
+				}
+				if m.words < ti {
+					continue
+				}
+				tf := float64(p.tf)
+				s := quality * idf * searchFieldWeights[doc.fields[p.field].name] * tf * 2.2 / (tf + 1.2)
+				m.score += s
+				m.fields[p.field] += s
+				m.matched[term] = true
+				m.words = ti + 1
+			}
+		}
+		for doc, m := range matches {
+			if m.words < ti+1 {
+				delete(matches, doc)
+			}
+		}
+	}
+	for doc, m := range matches {
+		d := idx.docs[doc]
+		hit := d.hit
+		best := -1
+		for i := range d.fields {
+			if _, ok := m.fields[i]; !ok {
+				continue
+			}
+			hit.Fields = append(hit.Fields, d.fields[i].name)
+			if best < 0 || m.fields[i] > m.fields[best] {
+				best = i
+			}
+		}
+		hit.Snippet = snippet(d.fields[best].text, m.matched)
+		hit.Score = math.Round(m.score*1000) / 1000
+		out.Hits = append(out.Hits, hit)
+	}
+	sort.Slice(out.Hits, func(i, j int) bool {
+		a, b := out.Hits[i], out.Hits[j]
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		if a.Kind != b.Kind {
+			return a.Kind == SearchFinding
+		}
+		return a.FindingID+a.InstanceID < b.FindingID+b.InstanceID
+	})
+	out.Total = len(out.Hits)
+	if filter.Limit > 0 && len(out.Hits) > filter.Limit {
+		out.Hits = out.Hits[:filter.Limit]
+	}
+	return out
+}
+
+var wordPattern = regexp.MustCompile(`[\p{L}\p{N}]+`)
+
+const snippetRunes = 160
+
+// snippet cuts text to about snippetRunes runes around the first word whose
+// stem matched, on word boundaries.
+func snippet(text string, matched map[string]bool) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if utf8.RuneCountInString(text) <= snippetRunes {
+		return text
+	}
+	start := 0
+	for _, loc := range wordPattern.FindAllStringIndex(text, -1) {
+		if matched[stem(strings.ToLower(text[loc[0]:loc[1]]))] {
+			start = loc[0]
+			break
+		}
+	}
+	lead := []rune(text[:start])
+	from := max(0, len(lead)-snippetRunes/4)
+	runes := []rune(text)
+	to := min(len(runes), from+snippetRunes)
+	if to == len(runes) {
+		from = max(0, to-snippetRunes)
+	}
+	// Move each cut inwards to a space, or leave it mid-word when there is
+	// none between the two.
+	if i := from; i > 0 {
+		for i < to && runes[i-1] != ' ' {
+			i++
+		}
+		if i < to {
+			from = i
+		}
+	}
+	if j := to; j < len(runes) {
+		for j > from && runes[j] != ' ' {
+			j--
+		}
+		if j > from {
+			to = j
+		}
+	}
+	out := strings.TrimSpace(string(runes[from:to]))
+	if from > 0 {
+		out = "…" + out
+	}
+	if to < len(runes) {
+		out += "…"
+	}
+	return out
+}
+
+// SearchIndex keeps a full-text index over the current master findings and
+// every instance's response notes. It is rebuilt on the first search after
+// an instance or master changes.
+type SearchIndex struct {
+	ws *WorkspaceStore
+
+	mu      sync.Mutex
+	stale   bool
+	masters string
+	idx     *searchIndex
+}
+
+func NewSearchIndex(ws *WorkspaceStore) *SearchIndex {
+	s := &SearchIndex{ws: ws, stale: true}
+	ws.Subscribe(func(InstanceEvent) {
+		s.mu.Lock()
+		s.stale = true
+		s.mu.Unlock()
+	})
+	return s
+}
+
+func (s *SearchIndex) current() *searchIndex {
+	masters := s.ws.Masters()
+	var sig strings.Builder
+	for _, cat := range auditCategories {
+		if m := masters[cat]; m != nil {
+			fmt.Fprintf(&sig, "%s@%s@%d;", cat, m.Version, m.UpdatedAt)
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stale || s.masters != sig.String() {
+		s.stale = false
+		s.masters = sig.String()
+		s.idx = buildSearchIndex(masters, s.ws.Instances(), s.ws.MasterFor)
+	}
+	return s.idx
+}
+
+func (s *SearchIndex) Search(q string, filter SearchFilter) SearchResults {
+	return s.current().search(q, filter)
+}
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+)
+
+func registerSearchRoutes(mux *http.ServeMux, search *SearchIndex) {
+	mux.Handle("GET /realm/search", realmRoute(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		query := strings.TrimSpace(q.Get("q"))
+		if query == "" {
+			http.Error(w, "q is required", http.StatusBadRequest)
+			return
+		}
+		filter := SearchFilter{
+			Category: AuditCategory(q.Get("category")),
+			Section:  AuditSection(q.Get("section")),
+			Kind:     q.Get("kind"),
+			Limit:    defaultSearchLimit,
+		}
+		if filter.Category != "" && !filter.Category.valid() {
+			http.Error(w, "unknown category", http.StatusBadRequest)
+			return
+		}
+		if filter.Section != "" && !filter.Section.valid() {
+			http.Error(w, "unknown section", http.StatusBadRequest)
+			return
+		}
+		if filter.Kind != "" && filter.Kind != SearchFinding && filter.Kind != SearchNote {
+			http.Error(w, `kind must be "finding" or "note"`, http.StatusBadRequest)
+			return
+		}
+		if v := q.Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > maxSearchLimit {
+				http.Error(w, fmt.Sprintf("limit must be between 1 and %d", maxSearchLimit), http.StatusBadRequest)
+				return
+			}
+			filter.Limit = n
+		}
+		writeJSON(w, http.StatusOK, search.Search(query, filter))
+	}))
+}
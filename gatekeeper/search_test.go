@@ -0,0 +1,35 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSnippet(t *testing.T) {
+	matched := map[string]bool{stem("sterilizer"): true}
+	tests := []struct {
+		name, text string
+		wholeWords bool
+	}{
+		{"short", "Sterilizer door gasket is worn", true},
+		{"words", strings.Repeat("the tray ", 30) + "sterilizer " + strings.Repeat("load record ", 30), true},
+		{"no spaces around the match", "word " + strings.Repeat("x", 100) + "-sterilizer-" + strings.Repeat("y", 200), false},
+		{"no spaces at all", strings.Repeat("x", 100) + "sterilizer" + strings.Repeat("y", 200), false},
+		{"match at the end", strings.Repeat("z", 300) + " sterilizer", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := snippet(tt.text, matched)
+			if n := utf8.RuneCountInString(got); n > snippetRunes+2 {
+				t.Errorf("snippet is %d runes", n)
+			}
+			if !strings.Contains(got, "terilizer") {
+				t.Errorf("snippet %q lost the match", got)
+			}
+			if words := strings.Trim(got, "…"); tt.wholeWords && !strings.Contains(" "+tt.text+" ", " "+words+" ") {
+				t.Errorf("snippet %q is not cut on word boundaries", got)
+			}
+		})
+	}
+}
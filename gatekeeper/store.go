@@ -0,0 +1,115 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// fileStore keeps a single JSON document in memory and mirrors every change
+// to disk. Writes go to a temporary file that is renamed over the original,
+// so a crash mid-write never leaves a truncated document behind.
+type fileStore[T any] struct {
+	mu   sync.Mutex
+	path string
+	data T
+}
+
+func openFileStore[T any](path string) (*fileStore[T], error) {
+	s := &fileStore[T]{path: path}
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &s.data); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// View runs fn with the current document. fn must not keep references to the
+// document after it returns.
+func (s *fileStore[T]) View(fn func(data *T)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fn(&s.data)
+}
+
+// Update runs fn against the document and persists the result. If fn or
+// saving fails the document is reloaded from disk, so memory never holds a
+// change the file does not.
+func (s *fileStore[T]) Update(fn func(data *T) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := fn(&s.data)
+	if err == nil {
+		err = s.save()
+	}
+	if err != nil {
+		s.reload()
+	}
+	return err
+}
+
+func (s *fileStore[T]) reload() {
+	var fresh T
+	if raw, err := os.ReadFile(s.path); err == nil && len(raw) > 0 {
+		_ = json.Unmarshal(raw, &fresh)
+	}
+	s.data = fresh
+}
+
+func (s *fileStore[T]) save() error {
+	raw, err := json.MarshalIndent(s.data, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(raw); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+// dataPath resolves name inside the DATA_DIR directory (default "data").
+func dataPath(name string) string {
+	dir := os.Getenv("DATA_DIR")
+	if dir == "" {
+		dir = "data"
+	}
+	return filepath.Join(dir, name)
+}
+
+// newID returns a random 16 byte identifier encoded as hex.
+func newID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileStoreUpdateFailureKeepsDiskState(t *testing.T) {
+	s, err := openFileStore[map[string]any](filepath.Join(t.TempDir(), "store.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Update(func(d *map[string]any) error {
+		*d = map[string]any{"kept": "yes"}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	failures := map[string]func(d *map[string]any) error{
+		"fn fails": func(d *map[string]any) error {
+			(*d)["dropped"] = "yes"
+			return errors.New("boom")
+		},
+		// A channel cannot be encoded, so saving fails.
+		"save fails": func(d *map[string]any) error {
+			(*d)["dropped"] = make(chan int)
+			return nil
+		},
+	}
+	for name, fn := range failures {
+		if err := s.Update(fn); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+		s.View(func(d *map[string]any) {
+			if _, ok := (*d)["dropped"]; ok || (*d)["kept"] != "yes" {
+				t.Errorf("%s: memory holds %v", name, *d)
+			}
+		})
+	}
+}
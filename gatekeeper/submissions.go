@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/mail"
+	"sort"
+	"time"
+)
+
+var errNotFound = errors.New("not found")
+
+// Submission is a pre-audit request as received from the public form, plus
+// whatever the team has recorded against it since.
+type Submission struct {
+	ID          string       `json:"id"`
+	ReceivedAt  time.Time    `json:"received_at"`
+	Data        AuditData    `json:"data"`
+	FacilityID  string       `json:"facility_id,omitempty"`
+	Attribution *Attribution `json:"attribution,omitempty"`
+	Booking     *Booking     `json:"booking,omitempty"`
+	CRM         *CRMSync     `json:"crm,omitempty"`
+}
+
+// Booking is the confirmed on-site window for a submission.
+type Booking struct {
+	Start           string    `json:"start"`
+	End             string    `json:"end"`
+	ConsultantName  string    `json:"consultant_name"`
+	ConsultantEmail string    `json:"consultant_email"`
+	BookedAt        time.Time `json:"booked_at"`
+}
+
+// StartTime parses the booking's first day. Dates use the same YYYY-MM-DD
+// layout as DateInterval.
+func (b Booking) StartTime() (time.Time, error) {
+	return time.ParseInLocation(time.DateOnly, b.Start, time.Local)
+}
+
+type SubmissionStore struct {
+	file *fileStore[map[string]*Submission]
+}
+
+func OpenSubmissionStore(path string) (*SubmissionStore, error) {
+	f, err := openFileStore[map[string]*Submission](path)
+	if err != nil {
+		return nil, err
+	}
+	return &SubmissionStore{file: f}, nil
+}
+
+func (s *SubmissionStore) Add(data AuditData, attribution *Attribution) (Submission, error) {
+	sub := Submission{ID: newID(), ReceivedAt: time.Now().UTC(), Data: data, Attribution: attribution}
+	err := s.file.Update(func(m *map[string]*Submission) error {
+		if *m == nil {
+			*m = map[string]*Submission{}
+		}
+		stored := sub
+		(*m)[sub.ID] = &stored
+		return nil
+	})
+	return sub, err
+}
+
+func (s *SubmissionStore) Get(id string) (Submission, error) {
+	var (
+		sub Submission
+		ok  bool
+	)
+	s.file.View(func(m *map[string]*Submission) {
+		var p *Submission
+		if p, ok = (*m)[id]; ok {
+			sub = *p
+		}
+	})
+	if !ok {
+		return Submission{}, errNotFound
+	}
+	return sub, nil
+}
+
+// List returns every submission, newest first.
+func (s *SubmissionStore) List() []Submission {
+	var subs []Submission
+	s.file.View(func(m *map[string]*Submission) {
+		for _, p := range *m {
+			subs = append(subs, *p)
+		}
+	})
+	sort.Slice(subs, func(i, j int) bool { return subs[i].ReceivedAt.After(subs[j].ReceivedAt) })
+	return subs
+}
+
+// Modify applies fn to a stored submission and persists the result.
+func (s *SubmissionStore) Modify(id string, fn func(sub *Submission) error) (Submission, error) {
+	var out Submission
+	err := s.file.Update(func(m *map[string]*Submission) error {
+		p, ok := (*m)[id]
+		if !ok {
+			return errNotFound
+		}
+		if err := fn(p); err != nil {
+			return err
+		}
+		out = *p
+		return nil
+	})
+	return out, err
+}
+
+func (b Booking) validate() error {
+	start, err := time.Parse(time.DateOnly, b.Start)
+	if err != nil {
+		return errors.New("start must be a YYYY-MM-DD date")
+	}
+	end, err := time.Parse(time.DateOnly, b.End)
+	if err != nil {
+		return errors.New("end must be a YYYY-MM-DD date")
+	}
+	if end.Before(start) {
+		return errors.New("end must not be before start")
+	}
+	if b.ConsultantEmail != "" {
+		if _, err := mail.ParseAddress(b.ConsultantEmail); err != nil {
+			return errors.New("consultant_email is not a valid address")
+		}
+	}
+	return nil
+}
+
+// scoredSubmission is the admin API view of a submission.
+type scoredSubmission struct {
+	Submission
+	Priority Priority `json:"priority"`
+}
+
+func registerSubmissionRoutes(mux *http.ServeMux, subs *SubmissionStore, reminders *ReminderScheduler, scorer *PriorityScorer) {
+	mux.Handle("GET /submissions", requireOffice(func(w http.ResponseWriter, r *http.Request) {
+		now := time.Now()
+		out := []scoredSubmission{}
+		for _, sub := range subs.List() {
+			out = append(out, scoredSubmission{Submission: sub, Priority: scorer.Score(sub.Data, now)})
+		}
+		if r.URL.Query().Get("sort") == "priority" {
+			sort.SliceStable(out, func(i, j int) bool { return out[i].Priority.Score > out[j].Priority.Score })
+		}
+		writeJSON(w, http.StatusOK, out)
+	}))
+	mux.Handle("GET /submissions/{id}", requireOffice(func(w http.ResponseWriter, r *http.Request) {
+		sub, err := subs.Get(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, scoredSubmission{Submission: sub, Priority: scorer.Score(sub.Data, time.Now())})
+	}))
+	mux.Handle("PUT /submissions/{id}/booking", requireOffice(func(w http.ResponseWriter, r *http.Request) {
+		var booking Booking
+		decoder := json.NewDecoder(r.Body)
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&booking); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := booking.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		booking.BookedAt = time.Now().UTC()
+		sub, err := subs.Modify(r.PathValue("id"), func(sub *Submission) error {
+			sub.Booking = &booking
+			return nil
+		})
+		if errors.Is(err, errNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := reminders.ScheduleFor(sub); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, sub)
+	}))
+	mux.Handle("DELETE /submissions/{id}/booking", requireOffice(func(w http.ResponseWriter, r *http.Request) {
+		sub, err := subs.Modify(r.PathValue("id"), func(sub *Submission) error {
+			sub.Booking = nil
+			return nil
+		})
+		if errors.Is(err, errNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := reminders.Cancel(sub.ID); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	mux.Handle("GET /submissions/{id}/reminders", requireOffice(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := subs.Get(r.PathValue("id")); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, reminders.JobsFor(r.PathValue("id")))
+	}))
+}
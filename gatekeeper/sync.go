@@ -0,0 +1,380 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+)
+
+// maxSyncChanges bounds one sync request; a client with more queued sends
+// them in several batches.
+const maxSyncChanges = 500
+
+// Tombstones are kept long enough for a device that was offline for weeks
+// to learn of deletions; one offline longer gets a full download instead.
+// Conflicts nobody dismissed are dropped after a quarter.
+const (
+	tombstoneRetention = 30 * 24 * 60 * 60 * 1000
+	conflictRetention  = 90 * 24 * 60 * 60 * 1000
+)
+
+// SyncChange is one response as edited offline. BaseVersion is the
+// response's version when the client last synced it (0 if it had none) and
+// ClientTime when the edit was made, by the device's clock.
+type SyncChange struct {
+	InstanceID  string        `json:"instanceId"`
+	Response    AuditResponse `json:"response"`
+	BaseVersion int64         `json:"baseVersion"`
+	ClientTime  int64         `json:"clientTime"`
+}
+
+// SyncRequest is a batch of offline edits plus the cursor returned by the
+// client's previous sync, or 0 for a full download.
+type SyncRequest struct {
+	ClientID string       `json:"clientId"`
+	Cursor   int64        `json:"cursor"`
+	Changes  []SyncChange `json:"changes"`
+}
+
+// Sync result statuses.
+const (
+	SyncApplied   = "applied"   // no one else had changed the response
+	SyncUnchanged = "unchanged" // the server already had this content
+	SyncWon       = "won"       // both sides changed it; the client's edit was newer
+	SyncLost      = "lost"      // both sides changed it; the server's was newer
+	SyncRejected  = "rejected"
+)
+
+type SyncResult struct {
+	InstanceID string `json:"instanceId"`
+	FindingID  string `json:"findingId"`
+	Status     string `json:"status"`
+	Version    int64  `json:"version,omitempty"`
+	ConflictID string `json:"conflictId,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// SyncConflict records a response changed on both sides since the client's
+// base version. The losing side is kept so nothing typed in a basement is
+// silently lost.
+type SyncConflict struct {
+	ID         string        `json:"id"`
+	InstanceID string        `json:"instanceId"`
+	FindingID  string        `json:"findingId"`
+	ClientID   string        `json:"clientId,omitempty"`
+	Winner     string        `json:"winner"` // "client" or "server"
+	Client     AuditResponse `json:"client"`
+	Server     AuditResponse `json:"server"`
+	CreatedAt  int64         `json:"createdAt"`
+}
+
+// SyncInstance is an instance's own fields, without responses. Version is
+// that of the fields; UpdatedAt is what the next full update must send.
+type SyncInstance struct {
+	ID                string             `json:"id"`
+	FacilityName      string             `json:"facilityName"`
+	Category          AuditCategory      `json:"category"`
+	Status            InstanceStatus     `json:"status"`
+	CreatedAt         int64              `json:"createdAt"`
+	UpdatedAt         int64              `json:"updatedAt"`
+	CompletedBy       []string           `json:"completedBy"`
+	EngagementPartner string             `json:"engagementPartner"`
+	MasterVersion     string             `json:"masterVersion,omitempty"`
+	Orphans           []OrphanedResponse `json:"orphans,omitempty"`
+	Version           int64              `json:"version"`
+}
+
+type SyncResponseDelta struct {
+	InstanceID string        `json:"instanceId"`
+	Response   AuditResponse `json:"response"`
+}
+
+// SyncDelta is everything that changed after a cursor, including the
+// client's own writes, so the client can apply it without special cases.
+// Full is set when the cursor predates pruned tombstones: the delta then
+// holds every instance, and the client drops any it has synced before that
+// the delta does not list.
+type SyncDelta struct {
+	Cursor    int64               `json:"cursor"`
+	Full      bool                `json:"full,omitempty"`
+	Results   []SyncResult        `json:"results"`
+	Instances []SyncInstance      `json:"instances"`
+	Responses []SyncResponseDelta `json:"responses"`
+	Deleted   []string            `json:"deleted"`
+	Conflicts []SyncConflict      `json:"conflicts"`
+}
+
+// apply merges one offline edit into d using per-response last-writer-wins.
+func (d *workspaceData) apply(c SyncChange, clientID string, now int64) (SyncResult, *SyncConflict) {
+	id := c.Response.FindingID
+	res := SyncResult{InstanceID: c.InstanceID, FindingID: id}
+	reject := func(msg string) (SyncResult, *SyncConflict) {
+		res.Status, res.Error = SyncRejected, msg
+		return res, nil
+	}
+	inst, ok := d.Instances[c.InstanceID]
+	switch {
+	case id == "":
+		return reject("findingId is required")
+	case !ok:
+		if _, gone := d.Deleted[c.InstanceID]; gone {
+			return reject("instance was deleted")
+		}
+		return reject("instance not found")
+	case inst.Status != InstanceDraft:
+		return reject(errLocked.Error())
+	}
+	incoming := c.Response
+	if incoming.Images == nil {
+		incoming.Images = []string{}
+	}
+	if err := (ResponsePatch{Status: &incoming.Status, RiskLevel: &incoming.RiskLevel}).validate(); err != nil {
+		return reject(err.Error())
+	}
+
+	// A device clock running ahead would otherwise win every conflict.
+	clientTime := min(c.ClientTime, now)
+	current, exists := inst.Responses[id]
+	if exists && incoming.sameContent(current) {
+		res.Status, res.Version = SyncUnchanged, current.Version
+		return res, nil
+	}
+
+	var conflict *SyncConflict
+	res.Status = SyncApplied
+	if exists && current.Version != c.BaseVersion {
+		conflict = &SyncConflict{
+			ID:         newID(),
+			InstanceID: c.InstanceID,
+			FindingID:  id,
+			ClientID:   clientID,
+			Client:     incoming,
+			Server:     current,
+			CreatedAt:  now,
+		}
+		conflict.Client.Version, conflict.Client.ModifiedAt = 0, clientTime
+		if clientTime > current.ModifiedAt {
+			conflict.Winner, res.Status = "client", SyncWon
+		} else {
+			conflict.Winner, res.Status = "server", SyncLost
+		}
+		res.ConflictID = conflict.ID
+		d.Conflicts = append(d.Conflicts, *conflict)
+	}
+	if res.Status == SyncLost {
+		res.Version = current.Version
+		return res, conflict
+	}
+
+	d.Seq++
+	incoming.Version, incoming.ModifiedAt = d.Seq, clientTime
+	if inst.Responses == nil {
+		inst.Responses = map[string]AuditResponse{}
+	}
+	inst.Responses[id] = incoming
+	inst.UpdatedAt = nextUpdatedAt(inst.UpdatedAt)
+	res.Version = incoming.Version
+	return res, conflict
+}
+
+// prune drops tombstones and conflicts past their retention, and conflicts
+// about instances that are gone.
+func (d *workspaceData) prune(now int64) {
+	for id, seq := range d.Deleted {
+		if now-d.DeletedAt[id] > tombstoneRetention {
+			delete(d.Deleted, id)
+			delete(d.DeletedAt, id)
+			d.PrunedSeq = max(d.PrunedSeq, seq)
+		}
+	}
+	d.Conflicts = slices.DeleteFunc(d.Conflicts, func(c SyncConflict) bool {
+		_, live := d.Instances[c.InstanceID]
+		return !live || now-c.CreatedAt > conflictRetention
+	})
+}
+
+// delta collects what changed after cursor. Cursor 0 returns everything.
+func (d *workspaceData) delta(cursor int64) SyncDelta {
+	full := cursor > 0 && cursor < d.PrunedSeq
+	if full {
+		cursor = 0
+	}
+	out := SyncDelta{
+		Cursor:    d.Seq,
+		Full:      full,
+		Instances: []SyncInstance{},
+		Responses: []SyncResponseDelta{},
+		Deleted:   []string{},
+		Conflicts: []SyncConflict{},
+	}
+	for _, inst := range d.Instances {
+		// The header also goes with any changed response, since the
+		// instance's updatedAt moved with it.
+		header := cursor == 0 || inst.Version > cursor
+		for _, resp := range inst.Responses {
+			header = header || resp.Version > cursor
+		}
+		if header {
+			out.Instances = append(out.Instances, SyncInstance{
+				ID:                inst.ID,
+				FacilityName:      inst.FacilityName,
+				Category:          inst.Category,
+				Status:            inst.Status,
+				CreatedAt:         inst.CreatedAt,
+				UpdatedAt:         inst.UpdatedAt,
+				CompletedBy:       append([]string{}, inst.CompletedBy...),
+				EngagementPartner: inst.EngagementPartner,
+				MasterVersion:     inst.MasterVersion,
+				Orphans:           cloneInstance(inst).Orphans,
+				Version:           inst.Version,
+			})
+		}
+		for _, resp := range inst.Responses {
+			if cursor == 0 || resp.Version > cursor {
+				resp.Images = append([]string{}, resp.Images...)
+				out.Responses = append(out.Responses, SyncResponseDelta{InstanceID: inst.ID, Response: resp})
+			}
+		}
+	}
+	if cursor > 0 {
+		for id, seq := range d.Deleted {
+			if seq > cursor {
+				out.Deleted = append(out.Deleted, id)
+			}
+		}
+	}
+	sort.Slice(out.Instances, func(i, j int) bool { return out.Instances[i].Version < out.Instances[j].Version })
+	sort.Slice(out.Responses, func(i, j int) bool { return out.Responses[i].Response.Version < out.Responses[j].Response.Version })
+	sort.Strings(out.Deleted)
+	return out
+}
+
+// Sync applies a batch of offline edits and returns the delta since the
+// request's cursor. Changes are applied in order, so a client that edited a
+// response twice offline may send both edits.
+func (s *WorkspaceStore) Sync(req SyncRequest) (SyncDelta, error) {
+	if len(req.Changes) > maxSyncChanges {
+		return SyncDelta{}, fmt.Errorf("%w: at most %d changes per sync", errInvalid, maxSyncChanges)
+	}
+	if req.Cursor < 0 {
+		return SyncDelta{}, fmt.Errorf("%w: cursor must not be negative", errInvalid)
+	}
+	var (
+		out     SyncDelta
+		results []SyncResult
+		changed = map[string]map[string]bool{}
+		events  []InstanceEvent
+	)
+	now := nowMillis()
+	err := s.file.Update(func(d *workspaceData) error {
+		d.prune(now)
+		var conflicts []SyncConflict
+		// Later edits of a response in the same batch build on the earlier
+		// ones rather than conflicting with them.
+		written := map[[2]string]int64{}
+		for _, c := range req.Changes {
+			key := [2]string{c.InstanceID, c.Response.FindingID}
+			if v, ok := written[key]; ok {
+				c.BaseVersion = v
+			}
+			res, conflict := d.apply(c, req.ClientID, now)
+			if res.Status != SyncRejected && res.Status != SyncLost {
+				written[key] = res.Version
+			}
+			results = append(results, res)
+			if conflict != nil {
+				conflicts = append(conflicts, *conflict)
+			}
+			if res.Status == SyncApplied || res.Status == SyncWon {
+				if changed[res.InstanceID] == nil {
+					changed[res.InstanceID] = map[string]bool{}
+				}
+				changed[res.InstanceID][res.FindingID] = true
+			}
+		}
+		out = d.delta(req.Cursor)
+		out.Results = results
+		if conflicts != nil {
+			out.Conflicts = conflicts
+		}
+		for instID, findings := range changed {
+			inst := cloneInstance(d.Instances[instID])
+			for findingID := range findings {
+				events = append(events, InstanceEvent{Instance: inst, FindingID: findingID, Fields: []string{"status", "notes", "images", "riskLevel", "capaRequired"}})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return SyncDelta{}, err
+	}
+	if out.Results == nil {
+		out.Results = []SyncResult{}
+	}
+	for _, e := range events {
+		s.publish(e)
+	}
+	return out, nil
+}
+
+// Cursor is the current change sequence number.
+func (s *WorkspaceStore) Cursor() int64 {
+	var seq int64
+	s.file.View(func(d *workspaceData) { seq = d.Seq })
+	return seq
+}
+
+// Conflicts lists recorded sync conflicts for an instance, newest first.
+func (s *WorkspaceStore) Conflicts(instanceID string) []SyncConflict {
+	out := []SyncConflict{}
+	s.file.View(func(d *workspaceData) {
+		for _, c := range d.Conflicts {
+			if c.InstanceID == instanceID {
+				out = append(out, c)
+			}
+		}
+	})
+	sort.SliceStable(out, func(i, j int) bool { return out[i].CreatedAt > out[j].CreatedAt })
+	return out
+}
+
+// DismissConflict removes a conflict record once someone has reviewed it.
+func (s *WorkspaceStore) DismissConflict(id string) error {
+	return s.file.Update(func(d *workspaceData) error {
+		for i, c := range d.Conflicts {
+			if c.ID == id {
+				d.Conflicts = append(d.Conflicts[:i], d.Conflicts[i+1:]...)
+				return nil
+			}
+		}
+		return errNotFound
+	})
+}
+
+func registerSyncRoutes(mux *http.ServeMux, ws *WorkspaceStore) {
+	mux.Handle("POST /realm/sync", realmRoute(func(w http.ResponseWriter, r *http.Request) {
+		var req SyncRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		delta, err := ws.Sync(req)
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, delta)
+	}))
+	mux.Handle("GET /realm/instances/{id}/conflicts", realmRoute(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, ws.Conflicts(r.PathValue("id")))
+	}))
+	mux.Handle("DELETE /realm/conflicts/{id}", realmRoute(func(w http.ResponseWriter, r *http.Request) {
+		if err := ws.DismissConflict(r.PathValue("id")); err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+}
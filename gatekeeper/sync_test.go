@@ -0,0 +1,254 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestSyncApply(t *testing.T) {
+	const now = int64(1_700_000_000_000)
+	server := AuditResponse{FindingID: "f1", Status: StatusCompliant, Images: []string{}, Version: 5, ModifiedAt: now - 60_000}
+	edit := AuditResponse{FindingID: "f1", Status: StatusNonCompliant, Notes: "rust on trays"}
+
+	tests := []struct {
+		name        string
+		status      InstanceStatus
+		deleted     bool
+		existing    *AuditResponse
+		change      SyncChange
+		want        string
+		wantWinner  string // "" for no conflict
+		wantStored  ResponseStatus
+		wantModTime int64
+	}{
+		{
+			name:        "new response",
+			change:      SyncChange{Response: edit, ClientTime: now - 1000},
+			want:        SyncApplied,
+			wantStored:  StatusNonCompliant,
+			wantModTime: now - 1000,
+		},
+		{
+			name:        "base matches",
+			existing:    &server,
+			change:      SyncChange{Response: edit, BaseVersion: 5, ClientTime: now - 1000},
+			want:        SyncApplied,
+			wantStored:  StatusNonCompliant,
+			wantModTime: now - 1000,
+		},
+		{
+			name:        "base mismatch, client newer",
+			existing:    &server,
+			change:      SyncChange{Response: edit, BaseVersion: 3, ClientTime: now - 1000},
+			want:        SyncWon,
+			wantWinner:  "client",
+			wantStored:  StatusNonCompliant,
+			wantModTime: now - 1000,
+		},
+		{
+			name:        "base mismatch, server newer",
+			existing:    &server,
+			change:      SyncChange{Response: edit, BaseVersion: 3, ClientTime: now - 120_000},
+			want:        SyncLost,
+			wantWinner:  "server",
+			wantStored:  StatusCompliant,
+			wantModTime: now - 60_000,
+		},
+		{
+			name:        "clock ahead is clamped and cannot win",
+			existing:    &AuditResponse{FindingID: "f1", Status: StatusCompliant, Version: 5, ModifiedAt: now},
+			change:      SyncChange{Response: edit, BaseVersion: 3, ClientTime: now + 3_600_000},
+			want:        SyncLost,
+			wantWinner:  "server",
+			wantStored:  StatusCompliant,
+			wantModTime: now,
+		},
+		{
+			name:        "clock ahead is clamped when applied",
+			change:      SyncChange{Response: edit, ClientTime: now + 3_600_000},
+			want:        SyncApplied,
+			wantStored:  StatusNonCompliant,
+			wantModTime: now,
+		},
+		{
+			name:        "same content",
+			existing:    &server,
+			change:      SyncChange{Response: AuditResponse{FindingID: "f1", Status: StatusCompliant}, BaseVersion: 1, ClientTime: now},
+			want:        SyncUnchanged,
+			wantStored:  StatusCompliant,
+			wantModTime: now - 60_000,
+		},
+		{
+			name:     "invalid status",
+			existing: &server,
+			change:   SyncChange{Response: AuditResponse{FindingID: "f1", Status: "Maybe"}, BaseVersion: 5, ClientTime: now},
+			want:     SyncRejected,
+		},
+		{
+			name:   "locked instance",
+			status: InstanceUnderReview,
+			change: SyncChange{Response: edit, ClientTime: now},
+			want:   SyncRejected,
+		},
+		{
+			name:    "deleted instance",
+			deleted: true,
+			change:  SyncChange{Response: edit, ClientTime: now},
+			want:    SyncRejected,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status := tt.status
+			if status == "" {
+				status = InstanceDraft
+			}
+			inst := &AuditInstance{ID: "i1", Status: status, Responses: map[string]AuditResponse{}}
+			if tt.existing != nil {
+				inst.Responses["f1"] = *tt.existing
+			}
+			d := &workspaceData{Instances: map[string]*AuditInstance{"i1": inst}, Seq: 5}
+			if tt.deleted {
+				d.Instances = map[string]*AuditInstance{}
+				d.Deleted = map[string]int64{"i1": 5}
+			}
+			tt.change.InstanceID = "i1"
+			res, conflict := d.apply(tt.change, "device-1", now)
+			if res.Status != tt.want {
+				t.Fatalf("status = %s (%s), want %s", res.Status, res.Error, tt.want)
+			}
+			if tt.want == SyncRejected {
+				if res.Error == "" {
+					t.Error("rejection carries no error")
+				}
+				return
+			}
+			switch {
+			case tt.wantWinner == "" && conflict != nil:
+				t.Errorf("unexpected conflict %+v", conflict)
+			case tt.wantWinner != "" && (conflict == nil || conflict.Winner != tt.wantWinner):
+				t.Errorf("conflict = %+v, want winner %s", conflict, tt.wantWinner)
+			case conflict != nil && (len(d.Conflicts) != 1 || res.ConflictID != conflict.ID):
+				t.Errorf("conflict not recorded: %d stored, result id %q", len(d.Conflicts), res.ConflictID)
+			}
+			got := d.Instances["i1"].Responses["f1"]
+			if got.Status != tt.wantStored || got.ModifiedAt != tt.wantModTime {
+				t.Errorf("stored %s modified %d, want %s modified %d", got.Status, got.ModifiedAt, tt.wantStored, tt.wantModTime)
+			}
+			if res.Version != got.Version {
+				t.Errorf("result version %d, stored %d", res.Version, got.Version)
+			}
+		})
+	}
+}
+
+func openTestWorkspace(t *testing.T) *WorkspaceStore {
+	t.Helper()
+	ws, err := OpenWorkspaceStore(filepath.Join(t.TempDir(), "workspace.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ws
+}
+
+func TestSyncRepeatedEditsInOneBatch(t *testing.T) {
+	ws := openTestWorkspace(t)
+	inst, err := ws.CreateInstance(AuditInstance{FacilityName: "Mercy Hospital", Category: CategoryCSSD})
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := ws.Sync(SyncRequest{ClientID: "a", Changes: []SyncChange{
+		{InstanceID: inst.ID, Response: AuditResponse{FindingID: "f1", Status: StatusCompliant}, ClientTime: 1},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := first.Results[0].Version
+	delta, err := ws.Sync(SyncRequest{ClientID: "a", Cursor: first.Cursor, Changes: []SyncChange{
+		{InstanceID: inst.ID, Response: AuditResponse{FindingID: "f1", Status: StatusNonCompliant, Notes: "one"}, BaseVersion: base, ClientTime: 2},
+		{InstanceID: inst.ID, Response: AuditResponse{FindingID: "f1", Status: StatusNonCompliant, Notes: "two"}, BaseVersion: base, ClientTime: 3},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range delta.Results {
+		if r.Status != SyncApplied {
+			t.Errorf("result %+v; want the second edit to build on the first", r)
+		}
+	}
+	if len(delta.Conflicts) != 0 || len(ws.Conflicts(inst.ID)) != 0 {
+		t.Errorf("conflicts = %+v", delta.Conflicts)
+	}
+	if len(delta.Responses) != 1 || delta.Responses[0].Response.Notes != "two" {
+		t.Errorf("delta responses = %+v", delta.Responses)
+	}
+}
+
+func TestSyncDeletes(t *testing.T) {
+	ws := openTestWorkspace(t)
+	keep, _ := ws.CreateInstance(AuditInstance{FacilityName: "Mercy Hospital", Category: CategoryCSSD})
+	drop, _ := ws.CreateInstance(AuditInstance{FacilityName: "St Jude", Category: CategoryCSSD})
+	start, err := ws.Sync(SyncRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ws.DeleteInstance(drop.ID, 0); err != nil {
+		t.Fatal(err)
+	}
+	delta, err := ws.Sync(SyncRequest{Cursor: start.Cursor, Changes: []SyncChange{
+		{InstanceID: drop.ID, Response: AuditResponse{FindingID: "f1", Status: StatusCompliant}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(delta.Deleted) != 1 || delta.Deleted[0] != drop.ID || delta.Full {
+		t.Errorf("deleted = %v, full = %v", delta.Deleted, delta.Full)
+	}
+	if delta.Results[0].Status != SyncRejected {
+		t.Errorf("edit to a deleted instance: %+v", delta.Results[0])
+	}
+
+	// Once the tombstone is pruned, a client that missed it gets everything.
+	ws.file.Update(func(d *workspaceData) error {
+		d.DeletedAt[drop.ID] -= tombstoneRetention + 1
+		return nil
+	})
+	delta, err = ws.Sync(SyncRequest{Cursor: start.Cursor})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !delta.Full || len(delta.Deleted) != 0 || len(delta.Instances) != 1 || delta.Instances[0].ID != keep.ID {
+		t.Errorf("after pruning: full = %v, deleted = %v, instances = %d", delta.Full, delta.Deleted, len(delta.Instances))
+	}
+	// A client that already saw the deletion is unaffected.
+	if delta, _ = ws.Sync(SyncRequest{Cursor: delta.Cursor}); delta.Full {
+		t.Error("a current cursor got a full delta")
+	}
+}
+
+func TestSyncPrunesConflicts(t *testing.T) {
+	ws := openTestWorkspace(t)
+	inst, _ := ws.CreateInstance(AuditInstance{FacilityName: "Mercy Hospital", Category: CategoryCSSD})
+	other, _ := ws.CreateInstance(AuditInstance{FacilityName: "St Jude", Category: CategoryCSSD})
+	now := nowMillis()
+	ws.file.Update(func(d *workspaceData) error {
+		d.Conflicts = []SyncConflict{
+			{ID: "recent", InstanceID: inst.ID, CreatedAt: now},
+			{ID: "stale", InstanceID: inst.ID, CreatedAt: now - conflictRetention - 1},
+			{ID: "orphan", InstanceID: other.ID, CreatedAt: now},
+		}
+		return nil
+	})
+	if err := ws.DeleteInstance(other.ID, 0); err != nil {
+		t.Fatal(err)
+	}
+	got := ws.Conflicts(inst.ID)
+	if len(got) != 1 || got[0].ID != "recent" {
+		t.Errorf("conflicts = %+v", got)
+	}
+	ws.file.View(func(d *workspaceData) {
+		if len(d.Conflicts) != 1 {
+			t.Errorf("stored conflicts = %d, want 1", len(d.Conflicts))
+		}
+	})
+}
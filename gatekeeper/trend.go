@@ -0,0 +1,385 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// TrendAudit is one audit in a facility's series, oldest first.
+type TrendAudit struct {
+	InstanceID    string         `json:"instanceId"`
+	Status        InstanceStatus `json:"status"`
+	CreatedAt     int64          `json:"createdAt"`
+	MasterVersion string         `json:"masterVersion,omitempty"`
+	Score         *float64       `json:"score"`
+}
+
+// SectionTrend is a section's score in each audit, in the order of
+// TrendReport.Audits; nil where the section could not be scored.
+type SectionTrend struct {
+	Section AuditSection `json:"section"`
+	Title   string       `json:"title"`
+	Scores  []*float64   `json:"scores"`
+}
+
+type SectionChange struct {
+	Section AuditSection `json:"section"`
+	Title   string       `json:"title"`
+	From    *float64     `json:"from"`
+	To      *float64     `json:"to"`
+	Change  *float64     `json:"change"`
+}
+
+// TrendFinding is a finding's outcome across the series. Findings are
+// matched by their text, so one that moved or was renumbered between
+// master versions is still recognised. TimesNonCompliant counts the
+// audits up to this period's that found it non-compliant.
+type TrendFinding struct {
+	Section           AuditSection `json:"section"`
+	FindingID         string       `json:"findingId"`
+	EquipmentSubject  string       `json:"equipmentSubject"`
+	Issue             string       `json:"issue"`
+	RiskLevel         RiskLevel    `json:"riskLevel,omitempty"`
+	TimesNonCompliant int          `json:"timesNonCompliant"`
+}
+
+// TrendPeriod compares an audit with the one before it. Resolved findings
+// were non-compliant before and compliant now; recurring ones are
+// non-compliant now and were in some earlier audit; new ones never were.
+type TrendPeriod struct {
+	From          string          `json:"from"`
+	To            string          `json:"to"`
+	OverallChange *float64        `json:"overallChange"`
+	Sections      []SectionChange `json:"sections"`
+	Resolved      []TrendFinding  `json:"resolved"`
+	Recurring     []TrendFinding  `json:"recurring"`
+	New           []TrendFinding  `json:"new"`
+}
+
+type TrendReport struct {
+	FacilityID   string         `json:"facilityId"`
+	FacilityName string         `json:"facilityName"`
+	Category     AuditCategory  `json:"category"`
+	Audits       []TrendAudit   `json:"audits"`
+	Sections     []SectionTrend `json:"sections"`
+	Periods      []TrendPeriod  `json:"periods"`
+}
+
+type trendOutcome struct {
+	section AuditSection
+	finding AuditFinding
+	status  ResponseStatus
+	risk    RiskLevel
+}
+
+func scoreChange(from, to *float64) *float64 {
+	if from == nil || to == nil {
+		return nil
+	}
+	v := math.Round((*to-*from)*10) / 10
+	return &v
+}
+
+// BuildTrendReport follows a facility's approved audits in one category.
+// Drafts and audits under review are left out so the trend only shows
+// results the facility has received.
+func BuildTrendReport(ws *WorkspaceStore, scorer *ComplianceScorer, f Facility, category AuditCategory) (TrendReport, error) {
+	out := TrendReport{
+		FacilityID:   f.ID,
+		FacilityName: f.Name,
+		Category:     category,
+		Audits:       []TrendAudit{},
+		Sections:     []SectionTrend{},
+		Periods:      []TrendPeriod{},
+	}
+	var instances []AuditInstance
+	for _, inst := range ws.Instances() {
+		if inst.Category == category && clientVisible(inst) && inst.FacilityID == f.ID {
+			instances = append(instances, inst)
+		}
+	}
+	if len(instances) == 0 {
+		return out, fmt.Errorf("%w: no approved %s audits for %s", errNotFound, category, f.Name)
+	}
+	sort.Slice(instances, func(i, j int) bool { return instances[i].CreatedAt < instances[j].CreatedAt })
+
+	scores := make([]InstanceScores, len(instances))
+	outcomes := make([]map[string]trendOutcome, len(instances))
+	for i, inst := range instances {
+		master, err := ws.MasterFor(inst)
+		if err != nil {
+			return out, errNoMaster
+		}
+		scores[i] = scorer.Score(inst, master)
+		outcomes[i] = map[string]trendOutcome{}
+		walkFindings(master, inst, func(section AuditSection, f AuditFinding, resp AuditResponse) {
+			outcomes[i][findingKey(f)] = trendOutcome{section, f, resp.Status, responseRisk(f, resp)}
+		})
+		out.Audits = append(out.Audits, TrendAudit{
+			InstanceID:    inst.ID,
+			Status:        inst.Status,
+			CreatedAt:     inst.CreatedAt,
+			MasterVersion: master.Version,
+			Score:         scores[i].Overall.Score,
+		})
+	}
+
+	sectionScore := func(i int, section AuditSection) *float64 {
+		for _, s := range scores[i].Sections {
+			if s.Section == section {
+				return s.Score.Score
+			}
+		}
+		return nil
+	}
+	for _, section := range auditSections {
+		t := SectionTrend{Section: section, Title: sectionTitles[section]}
+		scored := false
+		for i := range instances {
+			v := sectionScore(i, section)
+			scored = scored || v != nil
+			t.Scores = append(t.Scores, v)
+		}
+		if scored {
+			out.Sections = append(out.Sections, t)
+		}
+	}
+
+	// timesNC[key] counts non-compliant audits so far as periods advance.
+	timesNC := map[string]int{}
+	for key, o := range outcomes[0] {
+		if o.status == StatusNonCompliant {
+			timesNC[key]++
+		}
+	}
+	for i := 1; i < len(instances); i++ {
+		prev, cur := outcomes[i-1], outcomes[i]
+		p := TrendPeriod{
+			From:          instances[i-1].ID,
+			To:            instances[i].ID,
+			OverallChange: scoreChange(scores[i-1].Overall.Score, scores[i].Overall.Score),
+			Sections:      []SectionChange{},
+			Resolved:      []TrendFinding{},
+			Recurring:     []TrendFinding{},
+			New:           []TrendFinding{},
+		}
+		for _, t := range out.Sections {
+			p.Sections = append(p.Sections, SectionChange{
+				Section: t.Section,
+				Title:   t.Title,
+				From:    t.Scores[i-1],
+				To:      t.Scores[i],
+				Change:  scoreChange(t.Scores[i-1], t.Scores[i]),
+			})
+		}
+		finding := func(key string, o trendOutcome) TrendFinding {
+			return TrendFinding{o.section, o.finding.ID, o.finding.EquipmentSubject, o.finding.Issue, o.risk, timesNC[key]}
+		}
+		for key, o := range cur {
+			if o.status == StatusNonCompliant {
+				timesNC[key]++
+			}
+		}
+		for key, o := range cur {
+			switch {
+			case o.status == StatusNonCompliant && timesNC[key] > 1:
+				p.Recurring = append(p.Recurring, finding(key, o))
+			case o.status == StatusNonCompliant:
+				p.New = append(p.New, finding(key, o))
+			case o.status == StatusCompliant && prev[key].status == StatusNonCompliant:
+				p.Resolved = append(p.Resolved, finding(key, o))
+			}
+		}
+		for _, list := range [][]TrendFinding{p.Resolved, p.Recurring, p.New} {
+			sortTrendFindings(list)
+		}
+		out.Periods = append(out.Periods, p)
+	}
+	return out, nil
+}
+
+// sortTrendFindings puts findings in checklist order: by section, then
+// most often failed, then by subject.
+func sortTrendFindings(list []TrendFinding) {
+	order := map[AuditSection]int{}
+	for i, s := range auditSections {
+		order[s] = i
+	}
+	sort.Slice(list, func(i, j int) bool {
+		a, b := list[i], list[j]
+		if a.Section != b.Section {
+			return order[a.Section] < order[b.Section]
+		}
+		if a.TimesNonCompliant != b.TimesNonCompliant {
+			return a.TimesNonCompliant > b.TimesNonCompliant
+		}
+		return a.EquipmentSubject+a.Issue < b.EquipmentSubject+b.Issue
+	})
+}
+
+// TrendReportData is what trend_report.tmpl renders. Scores are
+// preformatted because the document markup has no formatting of its own.
+type TrendReportData struct {
+	Report   TrendReport
+	Date     string
+	Header   []string // "Section", one column per audit date, "Change"
+	Rows     [][]string
+	Overall  []string
+	Periods  []TrendPeriodData
+	Headline string
+}
+
+type TrendPeriodData struct {
+	Title     string
+	Summary   string
+	Sections  [][]string // title, before, after, change
+	Resolved  []string
+	Recurring []string
+	New       []string
+}
+
+func formatScore(v *float64) string {
+	if v == nil {
+		return "n/a"
+	}
+	return fmt.Sprintf("%.1f%%", *v)
+}
+
+func formatChange(v *float64) string {
+	switch {
+	case v == nil:
+		return "n/a"
+	case *v > 0:
+		return fmt.Sprintf("+%.1f", *v)
+	}
+	return fmt.Sprintf("%.1f", *v)
+}
+
+func auditDate(ms int64) string {
+	return time.UnixMilli(ms).Format("Jan 2, 2006")
+}
+
+func buildTrendReportData(report TrendReport, now time.Time) TrendReportData {
+	d := TrendReportData{Report: report, Date: now.Format("January 2, 2006")}
+	d.Header = []string{"Section"}
+	for _, a := range report.Audits {
+		d.Header = append(d.Header, auditDate(a.CreatedAt))
+	}
+	d.Header = append(d.Header, "Change")
+	first, last := 0, len(report.Audits)-1
+	for _, s := range report.Sections {
+		row := []string{s.Title}
+		for _, v := range s.Scores {
+			row = append(row, formatScore(v))
+		}
+		d.Rows = append(d.Rows, append(row, formatChange(scoreChange(s.Scores[first], s.Scores[last]))))
+	}
+	d.Overall = []string{"Overall"}
+	for _, a := range report.Audits {
+		d.Overall = append(d.Overall, formatScore(a.Score))
+	}
+	d.Overall = append(d.Overall, formatChange(scoreChange(report.Audits[first].Score, report.Audits[last].Score)))
+
+	dates := map[string]string{}
+	for _, a := range report.Audits {
+		dates[a.InstanceID] = auditDate(a.CreatedAt)
+	}
+	line := func(f TrendFinding) string {
+		s := sectionTitles[f.Section] + ": "
+		if f.EquipmentSubject != "" {
+			s += oneLine(f.EquipmentSubject) + " – "
+		}
+		s += oneLine(f.Issue)
+		if f.TimesNonCompliant > 1 {
+			s += fmt.Sprintf(" (non-compliant in %d audits)", f.TimesNonCompliant)
+		}
+		return s
+	}
+	for i, p := range report.Periods {
+		pd := TrendPeriodData{
+			Title: dates[p.From] + " to " + dates[p.To],
+			Summary: fmt.Sprintf("The overall score went from %s to %s (%s points). Resolved: %d. Recurring: %d. New: %d.",
+				formatScore(report.Audits[i].Score), formatScore(report.Audits[i+1].Score), formatChange(p.OverallChange),
+				len(p.Resolved), len(p.Recurring), len(p.New)),
+		}
+		for _, c := range p.Sections {
+			pd.Sections = append(pd.Sections, []string{c.Title, formatScore(c.From), formatScore(c.To), formatChange(c.Change)})
+		}
+		for _, f := range p.Resolved {
+			pd.Resolved = append(pd.Resolved, line(f))
+		}
+		for _, f := range p.Recurring {
+			pd.Recurring = append(pd.Recurring, line(f))
+		}
+		for _, f := range p.New {
+			pd.New = append(pd.New, line(f))
+		}
+		d.Periods = append(d.Periods, pd)
+	}
+	if len(report.Audits) == 1 {
+		d.Headline = "This is the facility's first approved audit in this category; later audits will be compared against it."
+	} else {
+		d.Headline = fmt.Sprintf("Across %d audits from %s to %s, the overall score went from %s to %s.",
+			len(report.Audits), auditDate(report.Audits[first].CreatedAt), auditDate(report.Audits[last].CreatedAt),
+			formatScore(report.Audits[first].Score), formatScore(report.Audits[last].Score))
+	}
+	return d
+}
+
+// GenerateTrendReport renders a trend report as PDF.
+func GenerateTrendReport(report TrendReport) ([]byte, error) {
+	tmpl, err := loadDocTemplate("TREND_REPORT_TEMPLATE", "trend_report.tmpl")
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, buildTrendReportData(report, time.Now())); err != nil {
+		return nil, err
+	}
+	return renderPDF(fmt.Sprintf("%s Trend – %s", report.Category, report.FacilityName), parseDocMarkup(buf.String()))
+}
+
+func registerTrendRoutes(mux *http.ServeMux, ws *WorkspaceStore, registry *FacilityRegistry, scorer *ComplianceScorer) {
+	// ?format=pdf for the document; JSON otherwise.
+	mux.Handle("GET /realm/facilities/{id}/trend", realmRoute(func(w http.ResponseWriter, r *http.Request) {
+		f, err := registry.Get(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		q := r.URL.Query()
+		category := AuditCategory(q.Get("category"))
+		if !category.valid() {
+			http.Error(w, "unknown category", http.StatusBadRequest)
+			return
+		}
+		format := q.Get("format")
+		if format != "" && format != "json" && format != "pdf" {
+			http.Error(w, "format must be json or pdf", http.StatusBadRequest)
+			return
+		}
+		report, err := BuildTrendReport(ws, scorer, f, category)
+		if err == errNoMaster {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		if format != "pdf" {
+			writeJSON(w, http.StatusOK, report)
+			return
+		}
+		body, err := GenerateTrendReport(report)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeDocument(w, slugify(f.Name+" "+string(category)+" trend report"), "pdf", body)
+	}))
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTrendReportKeepsFreeTextOnOneLine(t *testing.T) {
+	score := 80.0
+	finding := TrendFinding{Section: SectionDecon, EquipmentSubject: "Cart\n## Injected", Issue: "Cart | not covered", TimesNonCompliant: 2}
+	report := TrendReport{
+		FacilityName: "Mercy Hospital\n# Injected",
+		Category:     CategoryCSSD,
+		Audits:       []TrendAudit{{InstanceID: "a", Score: &score}, {InstanceID: "b", Score: &score}},
+		Periods:      []TrendPeriod{{From: "a", To: "b", Recurring: []TrendFinding{finding}}},
+	}
+	tmpl, err := loadDocTemplate("TREND_REPORT_TEMPLATE", "trend_report.tmpl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, buildTrendReportData(report, time.Now())); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, line := range strings.Split(out, "\n") {
+		if strings.HasPrefix(line, "# Injected") || strings.HasPrefix(line, "## Injected") {
+			t.Errorf("free text started a heading: %q", line)
+		}
+	}
+	if !strings.Contains(out, "Prepared for Mercy Hospital # Injected by") || !strings.Contains(out, "Cart ## Injected – Cart / not covered") {
+		t.Errorf("report:\n%s", out)
+	}
+}
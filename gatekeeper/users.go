@@ -0,0 +1,683 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	userSessionTTL = 12 * time.Hour
+	bcryptCost     = 12
+	minPasswordLen = 12
+	// bcrypt ignores everything after 72 bytes.
+	maxPasswordLen = 72
+	apiTokenPrefix = "gk_"
+)
+
+var errBadCredentials = errors.New("incorrect email or password")
+
+// User is someone who signs in to the gatekeeper or the realm workspace.
+type User struct {
+	ID    string `json:"id"`
+	Email string `json:"email"`
+	Name  string `json:"name"`
+	Role  Role   `json:"role"`
+	// FacilityID scopes a client to one facility; other roles leave it empty.
+	FacilityID   string    `json:"facility_id,omitempty"`
+	PasswordHash string    `json:"password_hash,omitempty"`
+	OIDCSubject  string    `json:"oidc_subject,omitempty"`
+	Disabled     bool      `json:"disabled,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// public is the user as shown by the API, without the password hash.
+func (u User) public() User {
+	u.PasswordHash = ""
+	return u
+}
+
+func (u User) validate() error {
+	if _, err := mail.ParseAddress(u.Email); err != nil {
+		return fmt.Errorf("email: %v", err)
+	}
+	if !u.Role.valid() {
+		return fmt.Errorf("unknown role %q", u.Role)
+	}
+	if (u.Role == RoleClient) != (u.FacilityID != "") {
+		return errors.New("facility_id is required for clients and only for clients")
+	}
+	return nil
+}
+
+func hashPassword(password string) (string, error) {
+	if len(password) < minPasswordLen || len(password) > maxPasswordLen {
+		return "", fmt.Errorf("%w: passwords must be %d to %d bytes long", errInvalid, minPasswordLen, maxPasswordLen)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcryptCost)
+	return string(hash), err
+}
+
+// dummyHash is compared against when no user matches, so a failed sign-in
+// takes as long whether or not the address exists.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("not a real password"), bcryptCost)
+
+type userSession struct {
+	UserID    string    `json:"user_id"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// APIToken is a long-lived credential for scripts and services. Only
+// a hash of the secret is stored; the secret is shown once, on creation.
+type APIToken struct {
+	ID        string     `json:"id"`
+	UserID    string     `json:"user_id"`
+	Name      string     `json:"name"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+type usersData struct {
+	Users    map[string]*User        `json:"users"`
+	Sessions map[string]*userSession `json:"sessions"` // by token hash
+	Tokens   map[string]*APIToken    `json:"tokens"`   // by secret hash
+}
+
+// UserStore keeps users, their sign-in sessions and API tokens.
+type UserStore struct {
+	file *fileStore[usersData]
+}
+
+func OpenUserStore(path string) (*UserStore, error) {
+	f, err := openFileStore[usersData](path)
+	if err != nil {
+		return nil, err
+	}
+	return &UserStore{file: f}, nil
+}
+
+func (d *usersData) byEmail(email string) *User {
+	for _, u := range d.Users {
+		if u.Email == email {
+			return u
+		}
+	}
+	return nil
+}
+
+// endSessions drops a user's sessions, and with tokens also their API
+// tokens.
+func (d *usersData) endSessions(userID string, tokens bool) {
+	for k, s := range d.Sessions {
+		if s.UserID == userID {
+			delete(d.Sessions, k)
+		}
+	}
+	if tokens {
+		for k, t := range d.Tokens {
+			if t.UserID == userID {
+				delete(d.Tokens, k)
+			}
+		}
+	}
+}
+
+func (d *usersData) pruneSessions(now time.Time) {
+	for k, s := range d.Sessions {
+		if !now.Before(s.ExpiresAt) {
+			delete(d.Sessions, k)
+		}
+	}
+}
+
+// Create adds a user. password may be empty for users who sign in only
+// through OIDC.
+func (s *UserStore) Create(u User, password string) (User, error) {
+	u.Email = normalizeEmail(u.Email)
+	u.Name = strings.TrimSpace(u.Name)
+	if err := u.validate(); err != nil {
+		return User{}, fmt.Errorf("%w: %v", errInvalid, err)
+	}
+	if password != "" {
+		hash, err := hashPassword(password)
+		if err != nil {
+			return User{}, err
+		}
+		u.PasswordHash = hash
+	}
+	u.ID = newID()
+	u.CreatedAt = time.Now().UTC()
+	u.UpdatedAt = u.CreatedAt
+	err := s.file.Update(func(d *usersData) error {
+		if existing := d.byEmail(u.Email); existing != nil {
+			return &conflictError{Current: existing.public()}
+		}
+		if d.Users == nil {
+			d.Users = map[string]*User{}
+		}
+		stored := u
+		d.Users[u.ID] = &stored
+		return nil
+	})
+	if err != nil {
+		return User{}, err
+	}
+	return u.public(), nil
+}
+
+func (s *UserStore) Get(id string) (User, error) {
+	var (
+		u  User
+		ok bool
+	)
+	s.file.View(func(d *usersData) {
+		var p *User
+		if p, ok = d.Users[id]; ok {
+			u = p.public()
+		}
+	})
+	if !ok {
+		return User{}, errNotFound
+	}
+	return u, nil
+}
+
+func (s *UserStore) List() []User {
+	out := []User{}
+	s.file.View(func(d *usersData) {
+		for _, u := range d.Users {
+			out = append(out, u.public())
+		}
+	})
+	sort.Slice(out, func(i, j int) bool { return out[i].Email < out[j].Email })
+	return out
+}
+
+// UserUpdate changes a user; nil fields are left alone.
+type UserUpdate struct {
+	Name       *string `json:"name"`
+	Role       *Role   `json:"role"`
+	FacilityID *string `json:"facility_id"`
+	Password   *string `json:"password"`
+	Disabled   *bool   `json:"disabled"`
+}
+
+func (s *UserStore) Update(id string, upd UserUpdate) (User, error) {
+	var hash string
+	if upd.Password != nil {
+		var err error
+		if hash, err = hashPassword(*upd.Password); err != nil {
+			return User{}, err
+		}
+	}
+	var out User
+	err := s.file.Update(func(d *usersData) error {
+		p, ok := d.Users[id]
+		if !ok {
+			return errNotFound
+		}
+		u := *p
+		if upd.Name != nil {
+			u.Name = strings.TrimSpace(*upd.Name)
+		}
+		if upd.Role != nil {
+			u.Role = *upd.Role
+		}
+		if upd.FacilityID != nil {
+			u.FacilityID = *upd.FacilityID
+		}
+		if upd.Disabled != nil {
+			u.Disabled = *upd.Disabled
+		}
+		if hash != "" {
+			u.PasswordHash = hash
+		}
+		if err := u.validate(); err != nil {
+			return fmt.Errorf("%w: %v", errInvalid, err)
+		}
+		// A new password signs out sessions; changed access also revokes
+		// API tokens.
+		access := u.Role != p.Role || u.FacilityID != p.FacilityID || u.Disabled
+		if access || hash != "" {
+			d.endSessions(id, access)
+		}
+		u.UpdatedAt = time.Now().UTC()
+		*p = u
+		out = u.public()
+		return nil
+	})
+	return out, err
+}
+
+// Delete removes a user with their sessions and tokens.
+func (s *UserStore) Delete(id string) error {
+	return s.file.Update(func(d *usersData) error {
+		if _, ok := d.Users[id]; !ok {
+			return errNotFound
+		}
+		delete(d.Users, id)
+		d.endSessions(id, true)
+		return nil
+	})
+}
+
+// ChangePassword lets a user replace their own password.
+func (s *UserStore) ChangePassword(id, current, next string) error {
+	hash, err := hashPassword(next)
+	if err != nil {
+		return err
+	}
+	return s.file.Update(func(d *usersData) error {
+		u, ok := d.Users[id]
+		if !ok {
+			return errNotFound
+		}
+		if u.PasswordHash == "" || bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(current)) != nil {
+			return errBadCredentials
+		}
+		u.PasswordHash = hash
+		u.UpdatedAt = time.Now().UTC()
+		d.endSessions(id, false)
+		return nil
+	})
+}
+
+// Login checks a password and starts a session.
+func (s *UserStore) Login(email, password string) (User, string, time.Time, error) {
+	email = normalizeEmail(email)
+	var hash []byte
+	var u User
+	s.file.View(func(d *usersData) {
+		if p := d.byEmail(email); p != nil && !p.Disabled && p.PasswordHash != "" {
+			u, hash = *p, []byte(p.PasswordHash)
+		}
+	})
+	if hash == nil {
+		_ = bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return User{}, "", time.Time{}, errBadCredentials
+	}
+	if bcrypt.CompareHashAndPassword(hash, []byte(password)) != nil {
+		return User{}, "", time.Time{}, errBadCredentials
+	}
+	token, expires, err := s.StartSession(u.ID)
+	return u.public(), token, expires, err
+}
+
+// StartSession signs a user in and returns the session token.
+func (s *UserStore) StartSession(userID string) (string, time.Time, error) {
+	token := newID()
+	now := time.Now().UTC()
+	expires := now.Add(userSessionTTL)
+	err := s.file.Update(func(d *usersData) error {
+		if _, ok := d.Users[userID]; !ok {
+			return errNotFound
+		}
+		d.pruneSessions(now)
+		if d.Sessions == nil {
+			d.Sessions = map[string]*userSession{}
+		}
+		d.Sessions[hashToken(token)] = &userSession{UserID: userID, CreatedAt: now, ExpiresAt: expires}
+		return nil
+	})
+	return token, expires, err
+}
+
+func (s *UserStore) EndSession(token string) error {
+	return s.file.Update(func(d *usersData) error {
+		delete(d.Sessions, hashToken(token))
+		return nil
+	})
+}
+
+// IssueToken creates an API token for a user and returns its secret. A zero
+// ttl never expires.
+func (s *UserStore) IssueToken(userID, name string, ttl time.Duration) (APIToken, string, error) {
+	secret := apiTokenPrefix + newID()
+	t := APIToken{ID: newID(), UserID: userID, Name: strings.TrimSpace(name), CreatedAt: time.Now().UTC()}
+	if ttl > 0 {
+		expires := t.CreatedAt.Add(ttl)
+		t.ExpiresAt = &expires
+	}
+	err := s.file.Update(func(d *usersData) error {
+		if _, ok := d.Users[userID]; !ok {
+			return errNotFound
+		}
+		if d.Tokens == nil {
+			d.Tokens = map[string]*APIToken{}
+		}
+		stored := t
+		d.Tokens[hashToken(secret)] = &stored
+		return nil
+	})
+	if err != nil {
+		return APIToken{}, "", err
+	}
+	return t, secret, nil
+}
+
+func (s *UserStore) Tokens(userID string) []APIToken {
+	out := []APIToken{}
+	s.file.View(func(d *usersData) {
+		for _, t := range d.Tokens {
+			if t.UserID == userID {
+				out = append(out, *t)
+			}
+		}
+	})
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.Before(out[j].CreatedAt) })
+	return out
+}
+
+func (s *UserStore) RevokeToken(userID, id string) error {
+	return s.file.Update(func(d *usersData) error {
+		for k, t := range d.Tokens {
+			if t.ID == id && t.UserID == userID {
+				delete(d.Tokens, k)
+				return nil
+			}
+		}
+		return errNotFound
+	})
+}
+
+// Principal resolves a bearer token, session token or the admin key.
+func (s *UserStore) Principal(token string) (Principal, bool) {
+	if validAdminToken(token) {
+		return Principal{User: User{ID: "admin-key", Name: "Admin API key", Role: RoleAdmin}, Via: "admin_key"}, true
+	}
+	var (
+		p   Principal
+		ok  bool
+		now = time.Now()
+	)
+	key := hashToken(token)
+	s.file.View(func(d *usersData) {
+		userID, via := "", ""
+		if strings.HasPrefix(token, apiTokenPrefix) {
+			if t, found := d.Tokens[key]; found && (t.ExpiresAt == nil || now.Before(*t.ExpiresAt)) {
+				userID, via = t.UserID, "token"
+			}
+		} else if sess, found := d.Sessions[key]; found && now.Before(sess.ExpiresAt) {
+			userID, via = sess.UserID, "session"
+		}
+		if u, found := d.Users[userID]; found && !u.Disabled {
+			p, ok = Principal{User: u.public(), Via: via}, true
+		}
+	})
+	return p, ok
+}
+
+// LinkOIDC finds the user for a verified identity: by subject, or else by
+// verified email, recording the subject for next time. There is no
+// self-registration; users must have been created first.
+func (s *UserStore) LinkOIDC(subject, email string, emailVerified bool) (User, error) {
+	var out User
+	err := s.file.Update(func(d *usersData) error {
+		for _, u := range d.Users {
+			if u.OIDCSubject == subject {
+				out = *u
+				return nil
+			}
+		}
+		if !emailVerified {
+			return errNotFound
+		}
+		u := d.byEmail(normalizeEmail(email))
+		if u == nil || (u.OIDCSubject != "" && u.OIDCSubject != subject) {
+			return errNotFound
+		}
+		u.OIDCSubject = subject
+		u.UpdatedAt = time.Now().UTC()
+		out = *u
+		return nil
+	})
+	if err != nil {
+		return User{}, err
+	}
+	if out.Disabled {
+		return User{}, errNotFound
+	}
+	return out.public(), nil
+}
+
+func setSessionCookie(w http.ResponseWriter, r *http.Request, token string, expires time.Time) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+type loginResponse struct {
+	User      User      `json:"user"`
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type createUserRequest struct {
+	Email      string `json:"email"`
+	Name       string `json:"name"`
+	Role       Role   `json:"role"`
+	FacilityID string `json:"facility_id"`
+	Password   string `json:"password"`
+}
+
+type createdToken struct {
+	APIToken
+	Token string `json:"token"`
+}
+
+func registerUserRoutes(mux *http.ServeMux, users *UserStore, registry *FacilityRegistry) {
+	// The audit app signs in from its own origin and sends the returned
+	// token as a bearer header.
+	mux.HandleFunc("OPTIONS /auth/", func(w http.ResponseWriter, r *http.Request) {
+		enableCors(w, r)
+	})
+	// The session token is set as a cookie for browsers and returned for
+	// clients that prefer a bearer header.
+	mux.HandleFunc("POST /auth/login", func(w http.ResponseWriter, r *http.Request) {
+		enableCors(w, r)
+		var req struct {
+			Email    string `json:"email"`
+			Password string `json:"password"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		u, token, expires, err := users.Login(req.Email, req.Password)
+		if errors.Is(err, errBadCredentials) {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		setSessionCookie(w, r, token, expires)
+		writeJSON(w, http.StatusOK, loginResponse{User: u, Token: token, ExpiresAt: expires})
+	})
+	mux.HandleFunc("POST /auth/logout", func(w http.ResponseWriter, r *http.Request) {
+		enableCors(w, r)
+		if p, ok := principalFrom(r.Context()); ok && p.Via == "session" {
+			if err := users.EndSession(requestToken(r)); err != nil {
+				log.Printf("ending session: %v", err)
+			}
+		}
+		setSessionCookie(w, r, "", time.Unix(0, 0))
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.Handle("GET /auth/me", requireRole(func(w http.ResponseWriter, r *http.Request) {
+		p, _ := principalFrom(r.Context())
+		writeJSON(w, http.StatusOK, p.User)
+	}, RoleAdmin, RolePartner, RoleAuditor, RoleClient))
+	mux.Handle("PUT /auth/password", requireRole(func(w http.ResponseWriter, r *http.Request) {
+		p, _ := principalFrom(r.Context())
+		var req struct {
+			CurrentPassword string `json:"current_password"`
+			NewPassword     string `json:"new_password"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		err := users.ChangePassword(p.User.ID, req.CurrentPassword, req.NewPassword)
+		if errors.Is(err, errBadCredentials) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}, RoleAdmin, RolePartner, RoleAuditor, RoleClient))
+
+	// API tokens belong to the caller. Clients use the portal and get none.
+	mux.Handle("GET /auth/tokens", requireStaff(func(w http.ResponseWriter, r *http.Request) {
+		p, _ := principalFrom(r.Context())
+		writeJSON(w, http.StatusOK, users.Tokens(p.User.ID))
+	}))
+	mux.Handle("POST /auth/tokens", requireStaff(func(w http.ResponseWriter, r *http.Request) {
+		p, _ := principalFrom(r.Context())
+		var req struct {
+			Name          string `json:"name"`
+			ExpiresInDays int    `json:"expires_in_days"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ExpiresInDays < 0 {
+			http.Error(w, "body must be {\"name\": ..., \"expires_in_days\": <days, 0 for never>}", http.StatusBadRequest)
+			return
+		}
+		t, secret, err := users.IssueToken(p.User.ID, req.Name, time.Duration(req.ExpiresInDays)*24*time.Hour)
+		if err != nil {
+			// The admin key has no user to hang a token on.
+			writeStoreError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, createdToken{APIToken: t, Token: secret})
+	}))
+	mux.Handle("DELETE /auth/tokens/{id}", requireStaff(func(w http.ResponseWriter, r *http.Request) {
+		p, _ := principalFrom(r.Context())
+		if err := users.RevokeToken(p.User.ID, r.PathValue("id")); err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	checkFacility := func(w http.ResponseWriter, id string) bool {
+		if id == "" {
+			return true
+		}
+		if _, err := registry.Get(id); err != nil {
+			http.Error(w, "facility_id: no such facility", http.StatusBadRequest)
+			return false
+		}
+		return true
+	}
+	mux.Handle("GET /users", requireAdmin(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, users.List())
+	}))
+	mux.Handle("POST /users", requireAdmin(func(w http.ResponseWriter, r *http.Request) {
+		var req createUserRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !checkFacility(w, req.FacilityID) {
+			return
+		}
+		u, err := users.Create(User{Email: req.Email, Name: req.Name, Role: req.Role, FacilityID: req.FacilityID}, req.Password)
+		var conflict *conflictError
+		if errors.As(err, &conflict) {
+			writeJSON(w, http.StatusConflict, map[string]any{"error": "a user with this email already exists", "current": conflict.Current})
+			return
+		}
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, u)
+	}))
+	mux.Handle("GET /users/{id}", requireAdmin(func(w http.ResponseWriter, r *http.Request) {
+		u, err := users.Get(r.PathValue("id"))
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, u)
+	}))
+	mux.Handle("PUT /users/{id}", requireAdmin(func(w http.ResponseWriter, r *http.Request) {
+		var upd UserUpdate
+		if err := json.NewDecoder(r.Body).Decode(&upd); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if upd.FacilityID != nil && !checkFacility(w, *upd.FacilityID) {
+			return
+		}
+		u, err := users.Update(r.PathValue("id"), upd)
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, u)
+	}))
+	mux.Handle("DELETE /users/{id}", requireAdmin(func(w http.ResponseWriter, r *http.Request) {
+		if err := users.Delete(r.PathValue("id")); err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+}
+
+// runAddUserCommand implements "gatekeeper add-user", which creates the
+// first admin before anyone can sign in. The password is read from the
+// GATEKEEPER_PASSWORD environment variable so it stays out of shell history.
+func runAddUserCommand(args []string) error {
+	fs := flag.NewFlagSet("add-user", flag.ContinueOnError)
+	email := fs.String("email", "", "sign-in email address")
+	name := fs.String("name", "", "display name")
+	role := fs.String("role", string(RoleAdmin), "admin, engagement_partner, auditor or client")
+	facility := fs.String("facility", "", "facility ID, for clients")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: GATEKEEPER_PASSWORD=... gatekeeper add-user -email <email> [-name <name>] [-role <role>] [-facility <id>]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); errors.Is(err, flag.ErrHelp) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if *email == "" {
+		fs.Usage()
+		return errors.New("an email is required")
+	}
+	users, err := OpenUserStore(dataPath("users.json"))
+	if err != nil {
+		return err
+	}
+	u, err := users.Create(User{Email: *email, Name: *name, Role: Role(*role), FacilityID: *facility}, os.Getenv("GATEKEEPER_PASSWORD"))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("created %s %s (%s)\n", u.Role, u.Email, u.ID)
+	return nil
+}
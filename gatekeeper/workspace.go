@@ -0,0 +1,826 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// errInvalid marks records rejected by validation.
+var errInvalid = errors.New("invalid record")
+
+// conflictError is returned when a write was based on a stale updatedAt. It
+// carries the stored record so the client can merge and retry.
+type conflictError struct {
+	Current any
+}
+
+func (e *conflictError) Error() string {
+	return "record was modified by someone else"
+}
+
+type workspaceData struct {
+	Instances map[string]*AuditInstance      `json:"instances"`
+	Masters   map[AuditCategory]*AuditReport `json:"masters"`
+	// History holds superseded masters per category, oldest first.
+	History map[AuditCategory][]AuditReport `json:"history,omitempty"`
+
+	// Seq numbers instance changes for sync cursors. Deleted holds the Seq
+	// at which each deleted instance went, so clients can drop it, and
+	// DeletedAt when. PrunedSeq is the highest Seq of a tombstone since
+	// pruned: a client whose cursor is older may have missed a deletion.
+	Seq       int64            `json:"seq"`
+	Deleted   map[string]int64 `json:"deleted,omitempty"`
+	DeletedAt map[string]int64 `json:"deletedAt,omitempty"`
+	PrunedSeq int64            `json:"prunedSeq,omitempty"`
+	Conflicts []SyncConflict   `json:"conflicts,omitempty"`
+
+	// Reviews is each instance's approval trail, kept apart from the
+	// instance so that no instance update can rewrite it.
+	Reviews map[string][]ReviewStep `json:"reviews,omitempty"`
+}
+
+// stamp assigns change sequence numbers to inst, which is about to replace
+// prev (nil for a new instance). The instance's version moves only when its
+// own fields change and each response's only when that response does, so a
+// sync delta carries just what changed. Versions sent by the client are
+// discarded.
+func (d *workspaceData) stamp(inst *AuditInstance, prev *AuditInstance, modifiedAt int64) {
+	d.Seq++
+	if prev == nil || !sameInstanceFields(*inst, *prev) {
+		inst.Version = d.Seq
+	} else {
+		inst.Version = prev.Version
+	}
+	for id, resp := range inst.Responses {
+		old, ok := AuditResponse{}, false
+		if prev != nil {
+			old, ok = prev.Responses[id]
+		}
+		if ok && resp.sameContent(old) {
+			resp.Version, resp.ModifiedAt = old.Version, old.ModifiedAt
+		} else {
+			resp.Version, resp.ModifiedAt = d.Seq, modifiedAt
+		}
+		inst.Responses[id] = resp
+	}
+	delete(d.Deleted, inst.ID)
+	delete(d.DeletedAt, inst.ID)
+}
+
+func sameInstanceFields(a, b AuditInstance) bool {
+	return a.FacilityName == b.FacilityName && a.Category == b.Category && a.Status == b.Status &&
+		a.CreatedAt == b.CreatedAt && a.EngagementPartner == b.EngagementPartner &&
+		a.FacilityID == b.FacilityID && a.MasterVersion == b.MasterVersion && slices.Equal(a.CompletedBy, b.CompletedBy) &&
+		slices.EqualFunc(a.Orphans, b.Orphans, func(x, y OrphanedResponse) bool {
+			return x.Finding == y.Finding && x.Response.sameContent(y.Response)
+		})
+}
+
+// WorkspaceStore persists the realm audit workspace: in-progress audit
+// instances and the master checklist for each category.
+type WorkspaceStore struct {
+	file *fileStore[workspaceData]
+
+	mu        sync.Mutex
+	listeners []func(InstanceEvent)
+}
+
+// InstanceEvent describes a stored change to an audit instance. FindingID is
+// set when only that response changed, with the names of the fields that
+// did. Origin identifies the collaboration client behind the change, if any.
+type InstanceEvent struct {
+	Instance  AuditInstance
+	FindingID string
+	Fields    []string
+	Deleted   bool
+	Origin    string
+}
+
+// Subscribe registers fn to be called after every instance change. Events
+// are delivered outside the store lock, so two changes made at once may
+// arrive out of order; Instance.UpdatedAt tells which is newer.
+func (s *WorkspaceStore) Subscribe(fn func(InstanceEvent)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.listeners = append(s.listeners, fn)
+}
+
+func (s *WorkspaceStore) publish(e InstanceEvent) {
+	s.mu.Lock()
+	listeners := s.listeners
+	s.mu.Unlock()
+	for _, fn := range listeners {
+		fn(e)
+	}
+}
+
+// OpenWorkspaceStore loads the workspace. Instances a client marked as
+// submitted before the review workflow were never reviewed, so they are put
+// under review for a partner to approve.
+func OpenWorkspaceStore(path string) (*WorkspaceStore, error) {
+	f, err := openFileStore[workspaceData](path)
+	if err != nil {
+		return nil, err
+	}
+	var legacy bool
+	f.View(func(d *workspaceData) {
+		for _, inst := range d.Instances {
+			legacy = legacy || inst.Status == InstanceSubmitted
+		}
+	})
+	if legacy {
+		err = f.Update(func(d *workspaceData) error {
+			now := nowMillis()
+			for id, existing := range d.Instances {
+				if existing.Status != InstanceSubmitted {
+					continue
+				}
+				inst := cloneInstance(existing)
+				inst.Status = InstanceUnderReview
+				inst.UpdatedAt = nextUpdatedAt(existing.UpdatedAt)
+				d.stamp(&inst, existing, now)
+				d.Instances[id] = &inst
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &WorkspaceStore{file: f}, nil
+}
+
+func cloneInstance(in *AuditInstance) AuditInstance {
+	out := *in
+	out.CompletedBy = append([]string{}, in.CompletedBy...)
+	out.Responses = make(map[string]AuditResponse, len(in.Responses))
+	for k, v := range in.Responses {
+		v.Images = append([]string{}, v.Images...)
+		out.Responses[k] = v
+	}
+	out.Orphans = nil
+	for _, o := range in.Orphans {
+		o.Response.Images = append([]string{}, o.Response.Images...)
+		out.Orphans = append(out.Orphans, o)
+	}
+	return out
+}
+
+func cloneReport(in *AuditReport) AuditReport {
+	out := *in
+	out.Sections = make(map[AuditSection][]AuditFinding, len(in.Sections))
+	for k, v := range in.Sections {
+		out.Sections[k] = append([]AuditFinding{}, v...)
+	}
+	return out
+}
+
+// nextUpdatedAt returns a timestamp strictly after prev, so two saves within
+// the same millisecond still produce distinct versions.
+func nextUpdatedAt(prev int64) int64 {
+	now := nowMillis()
+	if now <= prev {
+		now = prev + 1
+	}
+	return now
+}
+
+// normalizeInstance fills the collections the app expects to be non-null.
+func normalizeInstance(inst *AuditInstance) {
+	if inst.CompletedBy == nil {
+		inst.CompletedBy = []string{}
+	}
+	if inst.Responses == nil {
+		inst.Responses = map[string]AuditResponse{}
+	}
+	for k, resp := range inst.Responses {
+		if resp.Images == nil {
+			resp.Images = []string{}
+			inst.Responses[k] = resp
+		}
+	}
+}
+
+// Instances returns every audit instance, most recently updated first.
+func (s *WorkspaceStore) Instances() []AuditInstance {
+	out := []AuditInstance{}
+	s.file.View(func(d *workspaceData) {
+		for _, inst := range d.Instances {
+			out = append(out, cloneInstance(inst))
+		}
+	})
+	sort.Slice(out, func(i, j int) bool { return out[i].UpdatedAt > out[j].UpdatedAt })
+	return out
+}
+
+func (s *WorkspaceStore) Instance(id string) (AuditInstance, error) {
+	var (
+		inst AuditInstance
+		ok   bool
+	)
+	s.file.View(func(d *workspaceData) {
+		var p *AuditInstance
+		if p, ok = d.Instances[id]; ok {
+			inst = cloneInstance(p)
+		}
+	})
+	if !ok {
+		return AuditInstance{}, errNotFound
+	}
+	return inst, nil
+}
+
+// CreateInstance stores a new instance. The client may choose the ID (the app
+// generates its own); otherwise one is assigned. Instances start as drafts;
+// only the review workflow moves them on.
+func (s *WorkspaceStore) CreateInstance(inst AuditInstance) (AuditInstance, error) {
+	if inst.ID == "" {
+		inst.ID = newID()
+	}
+	inst.Status = InstanceDraft
+	normalizeInstance(&inst)
+	if err := inst.validate(); err != nil {
+		return AuditInstance{}, fmt.Errorf("%w: %v", errInvalid, err)
+	}
+	now := nowMillis()
+	if inst.CreatedAt == 0 {
+		inst.CreatedAt = now
+	}
+	inst.UpdatedAt = now
+	err := s.file.Update(func(d *workspaceData) error {
+		if d.Instances == nil {
+			d.Instances = map[string]*AuditInstance{}
+		}
+		if existing, ok := d.Instances[inst.ID]; ok {
+			return &conflictError{Current: cloneInstance(existing)}
+		}
+		if master, ok := d.Masters[inst.Category]; ok && inst.MasterVersion == "" {
+			inst.MasterVersion = master.Version
+		}
+		d.stamp(&inst, nil, now)
+		stored := cloneInstance(&inst)
+		d.Instances[inst.ID] = &stored
+		return nil
+	})
+	if err == nil {
+		s.publish(InstanceEvent{Instance: cloneInstance(&inst)})
+	}
+	return inst, err
+}
+
+// UpdateInstance replaces an instance. inst.UpdatedAt must equal the stored
+// value, otherwise a *conflictError holding the current instance is returned.
+func (s *WorkspaceStore) UpdateInstance(id string, inst AuditInstance) (AuditInstance, error) {
+	inst.ID = id
+	normalizeInstance(&inst)
+	if err := inst.validate(); err != nil {
+		return AuditInstance{}, fmt.Errorf("%w: %v", errInvalid, err)
+	}
+	err := s.file.Update(func(d *workspaceData) error {
+		existing, ok := d.Instances[id]
+		if !ok {
+			return errNotFound
+		}
+		if existing.UpdatedAt != inst.UpdatedAt {
+			return &conflictError{Current: cloneInstance(existing)}
+		}
+		if existing.Status != InstanceDraft {
+			return errLocked
+		}
+		inst.CreatedAt = existing.CreatedAt
+		// Only a migration moves an instance to another master version, only
+		// the review workflow changes its status and only LinkFacility
+		// changes its facility.
+		inst.MasterVersion, inst.Orphans = existing.MasterVersion, existing.Orphans
+		inst.FacilityID = existing.FacilityID
+		inst.Status = existing.Status
+		inst.UpdatedAt = nextUpdatedAt(existing.UpdatedAt)
+		d.stamp(&inst, existing, nowMillis())
+		stored := cloneInstance(&inst)
+		d.Instances[id] = &stored
+		return nil
+	})
+	if err == nil {
+		s.publish(InstanceEvent{Instance: cloneInstance(&inst)})
+	}
+	return inst, err
+}
+
+// LinkFacility links an instance to a registry facility ("" unlinks it). It
+// is allowed at any status, since linking is what releases an approved
+// report to the facility. A non-zero updatedAt is checked as for updates.
+func (s *WorkspaceStore) LinkFacility(id, facilityID string, updatedAt int64) (AuditInstance, error) {
+	var inst AuditInstance
+	err := s.file.Update(func(d *workspaceData) error {
+		existing, ok := d.Instances[id]
+		if !ok {
+			return errNotFound
+		}
+		if updatedAt != 0 && existing.UpdatedAt != updatedAt {
+			return &conflictError{Current: cloneInstance(existing)}
+		}
+		inst = cloneInstance(existing)
+		if inst.FacilityID == facilityID {
+			return nil
+		}
+		inst.FacilityID = facilityID
+		inst.UpdatedAt = nextUpdatedAt(existing.UpdatedAt)
+		d.stamp(&inst, existing, nowMillis())
+		stored := cloneInstance(&inst)
+		d.Instances[id] = &stored
+		return nil
+	})
+	if err == nil {
+		s.publish(InstanceEvent{Instance: cloneInstance(&inst)})
+	}
+	return inst, err
+}
+
+// RelinkFacility moves every instance linked to from over to to, after the
+// registry merged the two facilities.
+func (s *WorkspaceStore) RelinkFacility(from, to string) error {
+	if from == "" {
+		return nil
+	}
+	var moved []AuditInstance
+	err := s.file.Update(func(d *workspaceData) error {
+		now := nowMillis()
+		for id, existing := range d.Instances {
+			if existing.FacilityID != from {
+				continue
+			}
+			inst := cloneInstance(existing)
+			inst.FacilityID = to
+			inst.UpdatedAt = nextUpdatedAt(existing.UpdatedAt)
+			d.stamp(&inst, existing, now)
+			stored := cloneInstance(&inst)
+			d.Instances[id] = &stored
+			moved = append(moved, inst)
+		}
+		return nil
+	})
+	for _, inst := range moved {
+		s.publish(InstanceEvent{Instance: inst})
+	}
+	return err
+}
+
+// DeleteInstance removes an instance. A non-zero updatedAt is checked like an
+// update; zero deletes unconditionally.
+func (s *WorkspaceStore) DeleteInstance(id string, updatedAt int64) error {
+	var deleted AuditInstance
+	err := s.file.Update(func(d *workspaceData) error {
+		existing, ok := d.Instances[id]
+		if !ok {
+			return errNotFound
+		}
+		if updatedAt != 0 && existing.UpdatedAt != updatedAt {
+			return &conflictError{Current: cloneInstance(existing)}
+		}
+		deleted = cloneInstance(existing)
+		delete(d.Instances, id)
+		d.Seq++
+		if d.Deleted == nil {
+			d.Deleted = map[string]int64{}
+		}
+		if d.DeletedAt == nil {
+			d.DeletedAt = map[string]int64{}
+		}
+		now := nowMillis()
+		d.Deleted[id], d.DeletedAt[id] = d.Seq, now
+		d.prune(now)
+		return nil
+	})
+	if err == nil {
+		s.publish(InstanceEvent{Instance: deleted, Deleted: true})
+	}
+	return err
+}
+
+// ResponsePatch changes some fields of one response and leaves the rest as
+// stored, so auditors answering different findings, or different fields of
+// the same finding, do not overwrite each other. Images are added and
+// removed rather than replaced for the same reason.
+type ResponsePatch struct {
+	Status       *ResponseStatus `json:"status,omitempty"`
+	Notes        *string         `json:"notes,omitempty"`
+	AddImages    []string        `json:"addImages,omitempty"`
+	RemoveImages []string        `json:"removeImages,omitempty"`
+	RiskLevel    *RiskLevel      `json:"riskLevel,omitempty"`
+	CapaRequired *bool           `json:"capaRequired,omitempty"`
+}
+
+func (p ResponsePatch) validate() error {
+	if p.Status != nil {
+		switch *p.Status {
+		case StatusCompliant, StatusNonCompliant, StatusNA, StatusUnanswered:
+		default:
+			return fmt.Errorf("unknown status %q", *p.Status)
+		}
+	}
+	if p.RiskLevel != nil && !p.RiskLevel.valid() {
+		return fmt.Errorf("unknown risk level %q", *p.RiskLevel)
+	}
+	return nil
+}
+
+// apply merges p into resp and returns the names of the fields it changed.
+func (p ResponsePatch) apply(resp *AuditResponse) []string {
+	var fields []string
+	if p.Status != nil && *p.Status != resp.Status {
+		resp.Status = *p.Status
+		fields = append(fields, "status")
+	}
+	if p.Notes != nil && *p.Notes != resp.Notes {
+		resp.Notes = *p.Notes
+		fields = append(fields, "notes")
+	}
+	if p.RiskLevel != nil && *p.RiskLevel != resp.RiskLevel {
+		resp.RiskLevel = *p.RiskLevel
+		fields = append(fields, "riskLevel")
+	}
+	if p.CapaRequired != nil && (resp.CapaRequired == nil || *resp.CapaRequired != *p.CapaRequired) {
+		v := *p.CapaRequired
+		resp.CapaRequired = &v
+		fields = append(fields, "capaRequired")
+	}
+	images := make([]string, 0, len(resp.Images)+len(p.AddImages))
+	for _, img := range resp.Images {
+		if !containsString(p.RemoveImages, img) {
+			images = append(images, img)
+		}
+	}
+	for _, img := range p.AddImages {
+		if !containsString(images, img) {
+			images = append(images, img)
+		}
+	}
+	if !slices.Equal(images, resp.Images) {
+		resp.Images = images
+		fields = append(fields, "images")
+	}
+	return fields
+}
+
+// PatchResponse merges patch into the response for findingID, creating it
+// as unanswered if the instance has none yet. Unlike UpdateInstance it does
+// not check updatedAt: the patch only names the fields the caller changed.
+// A patch that changes nothing leaves the instance untouched.
+func (s *WorkspaceStore) PatchResponse(id, findingID string, patch ResponsePatch, origin string) (AuditInstance, []string, error) {
+	if findingID == "" {
+		return AuditInstance{}, nil, fmt.Errorf("%w: findingId is required", errInvalid)
+	}
+	if err := patch.validate(); err != nil {
+		return AuditInstance{}, nil, fmt.Errorf("%w: %v", errInvalid, err)
+	}
+	var (
+		inst   AuditInstance
+		fields []string
+	)
+	err := s.file.Update(func(d *workspaceData) error {
+		existing, ok := d.Instances[id]
+		if !ok {
+			return errNotFound
+		}
+		if existing.Status != InstanceDraft {
+			return errLocked
+		}
+		resp, ok := existing.Responses[findingID]
+		if !ok {
+			resp = AuditResponse{FindingID: findingID, Status: StatusUnanswered, Images: []string{}}
+		}
+		if fields = patch.apply(&resp); len(fields) > 0 {
+			if existing.Responses == nil {
+				existing.Responses = map[string]AuditResponse{}
+			}
+			d.Seq++
+			resp.Version, resp.ModifiedAt = d.Seq, nowMillis()
+			existing.Responses[findingID] = resp
+			existing.UpdatedAt = nextUpdatedAt(existing.UpdatedAt)
+		}
+		inst = cloneInstance(existing)
+		return nil
+	})
+	if err != nil {
+		return AuditInstance{}, nil, err
+	}
+	if len(fields) > 0 {
+		s.publish(InstanceEvent{Instance: inst, FindingID: findingID, Fields: fields, Origin: origin})
+	}
+	return inst, fields, nil
+}
+
+func (s *WorkspaceStore) Masters() map[AuditCategory]*AuditReport {
+	out := map[AuditCategory]*AuditReport{}
+	s.file.View(func(d *workspaceData) {
+		for cat, report := range d.Masters {
+			r := cloneReport(report)
+			out[cat] = &r
+		}
+	})
+	return out
+}
+
+func (s *WorkspaceStore) Master(category AuditCategory) (AuditReport, error) {
+	var (
+		report AuditReport
+		ok     bool
+	)
+	s.file.View(func(d *workspaceData) {
+		var p *AuditReport
+		if p, ok = d.Masters[category]; ok {
+			report = cloneReport(p)
+		}
+	})
+	if !ok {
+		return AuditReport{}, errNotFound
+	}
+	return report, nil
+}
+
+// SaveMaster stores an edited master checklist for a category. Once a
+// master exists, report.UpdatedAt must match it as with instances. Masters
+// are never changed in place: an edit that changes any finding is published
+// as a new version, and one that changes nothing returns the current master.
+func (s *WorkspaceStore) SaveMaster(report AuditReport) (AuditReport, error) {
+	if report.Sections == nil {
+		report.Sections = map[AuditSection][]AuditFinding{}
+	}
+	if err := report.validate(); err != nil {
+		return AuditReport{}, fmt.Errorf("%w: %v", errInvalid, err)
+	}
+	err := s.file.Update(func(d *workspaceData) error {
+		if existing, ok := d.Masters[report.Category]; ok {
+			if existing.UpdatedAt != report.UpdatedAt {
+				return &conflictError{Current: cloneReport(existing)}
+			}
+			if sameFindings(*existing, report) {
+				report = cloneReport(existing)
+				return nil
+			}
+		}
+		d.publish(&report)
+		return nil
+	})
+	return report, err
+}
+
+// PublishMaster stores report as a new version of its category's master,
+// regardless of report.Version and report.UpdatedAt.
+func (s *WorkspaceStore) PublishMaster(report AuditReport) (AuditReport, error) {
+	if err := report.validate(); err != nil {
+		return AuditReport{}, fmt.Errorf("%w: %v", errInvalid, err)
+	}
+	err := s.file.Update(func(d *workspaceData) error {
+		d.publish(&report)
+		return nil
+	})
+	return report, err
+}
+
+// publish moves the current master of report's category to the history and
+// makes report the current one with the next version number.
+func (d *workspaceData) publish(report *AuditReport) {
+	if d.Masters == nil {
+		d.Masters = map[AuditCategory]*AuditReport{}
+	}
+	if d.History == nil {
+		d.History = map[AuditCategory][]AuditReport{}
+	}
+	var prev int64
+	if existing, ok := d.Masters[report.Category]; ok {
+		d.History[report.Category] = append(d.History[report.Category], *existing)
+		prev = existing.UpdatedAt
+	}
+	report.Version = strconv.Itoa(len(d.History[report.Category]) + 1)
+	report.UpdatedAt = nextUpdatedAt(prev)
+	stored := cloneReport(report)
+	d.Masters[report.Category] = &stored
+}
+
+func sameFindings(a, b AuditReport) bool {
+	for _, section := range auditSections {
+		if !slices.Equal(a.Sections[section], b.Sections[section]) {
+			return false
+		}
+	}
+	return true
+}
+
+// MasterVersionInfo summarises one stored version of a master checklist.
+type MasterVersionInfo struct {
+	Version   string `json:"version"`
+	UpdatedAt int64  `json:"updatedAt"`
+	Findings  int    `json:"findings"`
+	Current   bool   `json:"current"`
+}
+
+// MasterVersions lists every version of a category's master, oldest first.
+func (s *WorkspaceStore) MasterVersions(category AuditCategory) []MasterVersionInfo {
+	out := []MasterVersionInfo{}
+	info := func(r AuditReport, current bool) MasterVersionInfo {
+		n := 0
+		for _, findings := range r.Sections {
+			n += len(findings)
+		}
+		return MasterVersionInfo{Version: r.Version, UpdatedAt: r.UpdatedAt, Findings: n, Current: current}
+	}
+	s.file.View(func(d *workspaceData) {
+		for _, r := range d.History[category] {
+			out = append(out, info(r, false))
+		}
+		if cur, ok := d.Masters[category]; ok {
+			out = append(out, info(*cur, true))
+		}
+	})
+	return out
+}
+
+// MasterVersion returns one version of a category's master; "" is the
+// current one.
+func (s *WorkspaceStore) MasterVersion(category AuditCategory, version string) (AuditReport, error) {
+	var (
+		report AuditReport
+		ok     bool
+	)
+	s.file.View(func(d *workspaceData) {
+		report, ok = d.masterVersion(category, version)
+	})
+	if !ok {
+		return AuditReport{}, errNotFound
+	}
+	return report, nil
+}
+
+func (d *workspaceData) masterVersion(category AuditCategory, version string) (AuditReport, bool) {
+	cur, ok := d.Masters[category]
+	if ok && (version == "" || cur.Version == version) {
+		return cloneReport(cur), true
+	}
+	for _, r := range d.History[category] {
+		if r.Version == version {
+			return cloneReport(&r), true
+		}
+	}
+	return AuditReport{}, false
+}
+
+// MasterFor returns the master version an instance was answered against,
+// falling back to the current master for instances from before versions
+// were recorded.
+func (s *WorkspaceStore) MasterFor(inst AuditInstance) (AuditReport, error) {
+	if inst.MasterVersion != "" {
+		if report, err := s.MasterVersion(inst.Category, inst.MasterVersion); err == nil {
+			return report, nil
+		}
+	}
+	return s.Master(inst.Category)
+}
+
+// writeStoreError maps workspace store errors to HTTP responses.
+func writeStoreError(w http.ResponseWriter, err error) {
+	var conflict *conflictError
+	switch {
+	case errors.As(err, &conflict):
+		writeJSON(w, http.StatusConflict, map[string]any{"error": conflict.Error(), "current": conflict.Current})
+	case errors.Is(err, errNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, errLocked):
+		http.Error(w, err.Error(), http.StatusLocked)
+	case errors.Is(err, errInvalid):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// realmRoute sets CORS headers before authorizing, so the browser app can
+// read 401s as well as successful responses. Without roles any staff member
+// may call the route.
+func realmRoute(next http.HandlerFunc, roles ...Role) http.Handler {
+	if len(roles) == 0 {
+		roles = []Role{RoleAdmin, RolePartner, RoleAuditor}
+	}
+	guarded := requireRole(next, roles...)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		enableCors(w, r)
+		guarded.ServeHTTP(w, r)
+	})
+}
+
+func registerWorkspaceRoutes(mux *http.ServeMux, ws *WorkspaceStore) {
+	mux.HandleFunc("OPTIONS /realm/", func(w http.ResponseWriter, r *http.Request) {
+		enableCors(w, r)
+	})
+
+	mux.Handle("GET /realm/state", realmRoute(func(w http.ResponseWriter, r *http.Request) {
+		// Read the cursor first: a change landing in between is then sent
+		// again by the next sync rather than missed.
+		cursor := ws.Cursor()
+		writeJSON(w, http.StatusOK, AppState{MasterData: ws.Masters(), Instances: ws.Instances(), Cursor: cursor})
+	}))
+
+	mux.Handle("GET /realm/instances", realmRoute(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, ws.Instances())
+	}))
+
+	mux.Handle("POST /realm/instances", realmRoute(func(w http.ResponseWriter, r *http.Request) {
+		var inst AuditInstance
+		if err := json.NewDecoder(r.Body).Decode(&inst); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		created, err := ws.CreateInstance(inst)
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, created)
+	}))
+
+	mux.Handle("GET /realm/instances/{id}", realmRoute(func(w http.ResponseWriter, r *http.Request) {
+		inst, err := ws.Instance(r.PathValue("id"))
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, inst)
+	}))
+
+	mux.Handle("PUT /realm/instances/{id}", realmRoute(func(w http.ResponseWriter, r *http.Request) {
+		var inst AuditInstance
+		if err := json.NewDecoder(r.Body).Decode(&inst); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if inst.ID != "" && inst.ID != r.PathValue("id") {
+			http.Error(w, "id does not match the URL", http.StatusBadRequest)
+			return
+		}
+		updated, err := ws.UpdateInstance(r.PathValue("id"), inst)
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, updated)
+	}))
+
+	// Deleting audits and changing master checklists is for admins and
+	// engagement partners.
+	mux.Handle("DELETE /realm/instances/{id}", realmRoute(func(w http.ResponseWriter, r *http.Request) {
+		var updatedAt int64
+		if v := r.URL.Query().Get("updatedAt"); v != "" {
+			var err error
+			if updatedAt, err = strconv.ParseInt(v, 10, 64); err != nil {
+				http.Error(w, "updatedAt must be a millisecond timestamp", http.StatusBadRequest)
+				return
+			}
+		}
+		if err := ws.DeleteInstance(r.PathValue("id"), updatedAt); err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}, RoleAdmin, RolePartner))
+
+	mux.Handle("GET /realm/master", realmRoute(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, ws.Masters())
+	}))
+
+	mux.Handle("GET /realm/master/{category}", realmRoute(func(w http.ResponseWriter, r *http.Request) {
+		report, err := ws.Master(AuditCategory(r.PathValue("category")))
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, report)
+	}))
+
+	mux.Handle("PUT /realm/master/{category}", realmRoute(func(w http.ResponseWriter, r *http.Request) {
+		var report AuditReport
+		if err := json.NewDecoder(r.Body).Decode(&report); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		category := AuditCategory(r.PathValue("category"))
+		if report.Category == "" {
+			report.Category = category
+		}
+		if report.Category != category {
+			http.Error(w, "category does not match the URL", http.StatusBadRequest)
+			return
+		}
+		saved, err := ws.SaveMaster(report)
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, saved)
+	}, RoleAdmin, RolePartner))
+}
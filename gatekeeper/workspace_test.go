@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// asUser returns r as sent by a signed-in user with the given role.
+func asUser(r *http.Request, role Role) *http.Request {
+	p := Principal{User: User{ID: "u-" + string(role), Name: "Test " + string(role), Role: role}, Via: "test"}
+	return r.WithContext(context.WithValue(r.Context(), principalKey{}, p))
+}
+
+func TestUpdateInstanceConcurrency(t *testing.T) {
+	ws := openTestWorkspace(t)
+	master, _ := testMasters()
+	if _, err := ws.PublishMaster(master); err != nil {
+		t.Fatal(err)
+	}
+	inst, err := ws.CreateInstance(AuditInstance{FacilityName: "Mercy Hospital", Category: CategoryCSSD})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	registerWorkspaceRoutes(mux, ws)
+	put := func(edit AuditInstance) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(edit)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, asUser(httptest.NewRequest(http.MethodPut, "/realm/instances/"+inst.ID, bytes.NewReader(body)), RoleAuditor))
+		return rec
+	}
+
+	mine, theirs := inst, inst
+	mine.CompletedBy = []string{"Ana Ruiz"}
+	rec := put(mine)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("update with the current updatedAt: %d %s", rec.Code, rec.Body)
+	}
+	var saved AuditInstance
+	json.NewDecoder(rec.Body).Decode(&saved)
+	if saved.UpdatedAt <= inst.UpdatedAt || len(saved.CompletedBy) != 1 {
+		t.Fatalf("saved %+v", saved)
+	}
+
+	// The second writer still has the version the first one replaced.
+	theirs.EngagementPartner = "Lee Chen"
+	rec = put(theirs)
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("stale update: %d %s", rec.Code, rec.Body)
+	}
+	var conflict struct {
+		Error   string        `json:"error"`
+		Current AuditInstance `json:"current"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&conflict); err != nil {
+		t.Fatal(err)
+	}
+	if conflict.Error == "" || conflict.Current.UpdatedAt != saved.UpdatedAt || len(conflict.Current.CompletedBy) != 1 {
+		t.Errorf("conflict = %+v; want the first writer's version", conflict)
+	}
+	if stored, _ := ws.Instance(inst.ID); stored.EngagementPartner != "" || stored.UpdatedAt != saved.UpdatedAt {
+		t.Errorf("the stale update was stored: %+v", stored)
+	}
+
+	// Rebased on the current version, it goes through.
+	theirs.CompletedBy, theirs.UpdatedAt = conflict.Current.CompletedBy, conflict.Current.UpdatedAt
+	if rec = put(theirs); rec.Code != http.StatusOK {
+		t.Errorf("rebased update: %d %s", rec.Code, rec.Body)
+	}
+}
@@ -1,8 +1,11 @@
 
-import React, { useState, useEffect } from 'react';
+import React, { useState, useEffect, useRef } from 'react';
 import * as Lucide from 'lucide-react';
 import { AppState, AuditInstance, AuditCategory, AuditResponse } from './types';
 import { CloudStorage } from './services/storageService';
+import { CollabSession, Presence, connectCollab, toPatch } from './services/collabService';
+import { OfflineSync } from './services/syncService';
+import { Auth, SessionUser } from './services/authService';
 import { parseAuditExcel } from './services/excelService';
 
 // Module Imports
@@ -12,8 +15,17 @@ import { Dashboard } from './components/dashboard/Dashboard';
 import { AuditForm } from './components/audit/AuditForm';
 
 const LOGO_URL = "https://crownpointconsult.com/assets/bg_less_logo.png";
+// Shown to other auditors in a live session.
+const auditorName = (user: SessionUser | null) => localStorage.getItem('realm_auditor_name') || user?.name || 'Auditor';
 
 const App = () => {
+	const [user, setUser] = useState<SessionUser | null>(Auth.user());
+	useEffect(() => Auth.subscribe(setUser), []);
+	if (Auth.required && !user) return <LoginView />;
+	return <Workspace user={user} />;
+};
+
+const Workspace = ({ user }: { user: SessionUser | null }) => {
 	const [view, setView] = useState<'dashboard' | 'upload' | 'new-audit' | 'audit-form'>('dashboard');
 	const [activeId, setActiveId] = useState<string | null>(null);
 	const [isSyncing, setIsSyncing] = useState(false);
@@ -21,10 +33,38 @@ const App = () => {
 		masterData: { "Sterile Processing (CSSD)": null, "Endoscopy": null, "Dental": null },
 		instances: []
 	});
+	const [collaborators, setCollaborators] = useState<Presence[]>([]);
+	const collab = useRef<CollabSession | null>(null);
+	const [syncNotice, setSyncNotice] = useState<string | null>(null);
+	const stateRef = useRef(state);
+	stateRef.current = state;
+
+	// Sends response edits made offline and pulls in what others changed.
+	const flushOffline = async (cursor = stateRef.current.cursor ?? 0) => {
+		if (!OfflineSync.enabled || !navigator.onLine) return;
+		try {
+			const delta = await OfflineSync.flush(cursor);
+			setState(prev => OfflineSync.overlay(CloudStorage.applyDelta(prev, delta)));
+			const lost = delta.conflicts.filter(c => c.winner === 'server').length;
+			if (delta.conflicts.length) {
+				setSyncNotice(`${delta.conflicts.length} offline edit${delta.conflicts.length === 1 ? '' : 's'} overlapped with someone else's` +
+					(lost ? `; ${lost} kept the newer server version` : ''));
+			}
+		} catch (err) {
+			console.warn('Offline sync failed', err);
+		}
+	};
 
 	// Initial Boot Sequence
 	useEffect(() => {
-		CloudStorage.fetchState().then(s => s && setState(s));
+		CloudStorage.fetchState().then(s => {
+			if (!s) return;
+			setState(OfflineSync.overlay(s));
+			if (OfflineSync.pending() || !s.cursor) flushOffline(s.cursor ?? 0);
+		});
+		const onOnline = () => flushOffline();
+		window.addEventListener('online', onOnline);
+		return () => window.removeEventListener('online', onOnline);
 	}, []);
 
 
@@ -32,12 +72,65 @@ const App = () => {
 	useEffect(() => {
 		const sync = async () => {
 			setIsSyncing(true);
-			await CloudStorage.saveState(state);
+			const { locked } = await CloudStorage.saveState(state);
 			setIsSyncing(false);
+			if (!locked.length) return;
+			// Edits to an audit that went to review meanwhile were refused;
+			// show it as the server has it.
+			setState(prev => ({
+				...prev,
+				instances: prev.instances.map(i => locked.find(l => l.id === i.id) || i)
+			}));
+			setSyncNotice(`${locked.map(l => l.facilityName).join(', ')} ${locked.length === 1 ? 'is' : 'are'} under review or approved; your changes were not saved`);
 		};
 		sync();
 	}, [state]);
 
+	// Live session for the open audit: response edits go out as field-level
+	// patches and other auditors' changes come back in.
+	useEffect(() => {
+		if (view !== 'audit-form' || !activeId) return;
+		const instId = activeId;
+		const replace = (inst: AuditInstance) => setState(prev => ({
+			...prev,
+			instances: prev.instances.some(i => i.id === inst.id)
+				? prev.instances.map(i => i.id === inst.id ? inst : i)
+				: [inst, ...prev.instances]
+		}));
+		collab.current = connectCollab(instId, auditorName(user), {
+			onInstance: inst => {
+				CloudStorage.acceptInstance(inst);
+				replace(inst);
+			},
+			onResponse: (findId, resp, fields, own, updatedAt) => {
+				CloudStorage.acceptResponse(instId, resp, updatedAt);
+				if (own) return;
+				setState(prev => ({
+					...prev,
+					instances: prev.instances.map(i => {
+						if (i.id !== instId) return i;
+						// Take only the fields that changed, keeping our own
+						// edits to the others.
+						const merged: any = { ...(i.responses[findId] || resp) };
+						for (const f of fields) merged[f] = resp[f];
+						return { ...i, responses: { ...i.responses, [findId]: merged } };
+					})
+				}));
+			},
+			onPresence: (presence, self) => setCollaborators(presence.filter(p => p.clientId !== self)),
+			onDeleted: () => {
+				CloudStorage.forgetInstance(instId);
+				setState(prev => ({ ...prev, instances: prev.instances.filter(i => i.id !== instId) }));
+				navigate('dashboard');
+			}
+		});
+		return () => {
+			collab.current?.close();
+			collab.current = null;
+			setCollaborators([]);
+		};
+	}, [view, activeId]);
+
 	// Handlers
 	const navigate = (v: typeof view, id: string | null = null) => {
 		setView(v);
@@ -46,6 +139,18 @@ const App = () => {
 	};
 
 	const updateResponse = (instId: string, findId: string, upd: Partial<AuditResponse>) => {
+		// Audits under review or approved are read-only until sent back to draft.
+		if (state.instances.find(i => i.id === instId)?.status !== 'draft') return;
+		const session = instId === activeId ? collab.current : null;
+		const prev = state.instances.find(i => i.id === instId)?.responses[findId];
+		const base: AuditResponse = prev || { findingId: findId, status: 'Unanswered', notes: '', images: [] };
+		if (OfflineSync.enabled && !navigator.onLine) {
+			CloudStorage.acceptResponse(instId, { ...base, ...upd });
+			OfflineSync.queue(instId, { ...base, ...upd });
+		} else if (session) {
+			CloudStorage.acceptResponse(instId, { ...base, ...upd });
+			session.patch(findId, toPatch(prev, upd));
+		}
 		setState(prev => ({
 			...prev,
 			instances: prev.instances.map(i => {
@@ -116,10 +221,21 @@ const App = () => {
 					</div>
 					<div className="flex-1" />
 					<div className="flex items-center gap-3">
+						{syncNotice && (
+							<button onClick={() => setSyncNotice(null)} title="Dismiss">
+								<Badge variant="outline" className="text-[9px] border-amber-300 text-amber-700">{syncNotice}</Badge>
+							</button>
+						)}
 						<Badge variant="secondary" className="hidden sm:inline-flex text-[9px]">V3.0 Enterprise</Badge>
+						{user && <span className="hidden sm:inline text-xs font-medium text-slate-600">{user.name}</span>}
 						<div className="h-7 w-7 rounded-full bg-slate-100 flex items-center justify-center text-slate-400 border border-slate-200 overflow-hidden">
 							<Lucide.User size={14} />
 						</div>
+						{user && (
+							<button onClick={() => Auth.logout()} title="Sign out" className="text-slate-400 hover:text-slate-900">
+								<Lucide.LogOut size={14} />
+							</button>
+						)}
 					</div>
 				</header>
 
@@ -130,6 +246,8 @@ const App = () => {
 							instanceId={activeId}
 							state={state}
 							onUpdateResponse={updateResponse}
+							collaborators={collaborators}
+							onFocusFinding={(findId: string | null) => collab.current?.focus(findId)}
 							onNavigate={navigate}
 							setIsSyncing={setIsSyncing}
 						/>
@@ -142,6 +260,46 @@ const App = () => {
 	);
 };
 
+const LoginView = () => {
+	const [email, setEmail] = useState('');
+	const [password, setPassword] = useState('');
+	const [error, setError] = useState<string | null>(null);
+	const [busy, setBusy] = useState(false);
+	const submit = async (e: React.FormEvent) => {
+		e.preventDefault();
+		setBusy(true);
+		setError(null);
+		try {
+			await Auth.login(email.trim(), password);
+		} catch (err: any) {
+			setError(err.message);
+		} finally {
+			setBusy(false);
+		}
+	};
+	return (
+		<div className="min-h-screen flex items-center justify-center bg-slate-50/50 p-4">
+			<Card className="w-full max-w-sm p-6 space-y-6 animate-slide-up">
+				<div className="flex items-center gap-3">
+					<img src={LOGO_URL} alt="Logo" className="h-8 w-8 object-contain" />
+					<div className="flex flex-col">
+						<span className="text-sm font-bold tracking-tight text-slate-900 leading-tight">Crown Point</span>
+						<span className="text-[10px] font-medium text-slate-400 uppercase tracking-widest">Auditor Portal</span>
+					</div>
+				</div>
+				<form onSubmit={submit} className="space-y-4">
+					<Input type="email" placeholder="Email" autoComplete="username" className="h-11" value={email} onChange={(e: any) => setEmail(e.target.value)} />
+					<Input type="password" placeholder="Password" autoComplete="current-password" className="h-11" value={password} onChange={(e: any) => setPassword(e.target.value)} />
+					{error && <p className="text-xs font-medium text-red-500">{error}</p>}
+					<Button type="submit" disabled={busy || !email.trim() || !password} className="w-full h-11">
+						{busy ? 'Signing in…' : 'Sign in'}
+					</Button>
+				</form>
+			</Card>
+		</div>
+	);
+};
+
 const UploadView = ({ state, onUpload, isSyncing }: any) => (
 	<div className="max-w-2xl mx-auto py-10 space-y-10 animate-slide-up">
 		<div className="text-center space-y-4">
@@ -4,6 +4,7 @@ import * as Lucide from 'lucide-react';
 import { AuditInstance, AppState, AuditSection, AuditResponse } from '../../types';
 import { AuditItem } from './AuditItem';
 import { CloudStorage } from '../../services/storageService';
+import { Presence } from '../../services/collabService';
 import { Button, Progress, Badge, Card, Input } from '../shared/Atomic';
 
 interface AuditFormProps {
@@ -12,10 +13,12 @@ interface AuditFormProps {
 	onUpdateResponse: (instId: string, findId: string, upd: Partial<AuditResponse>) => void;
 	onNavigate: (view: any) => void;
 	setIsSyncing: (s: boolean) => void;
+	collaborators?: Presence[];
+	onFocusFinding?: (findId: string | null) => void;
 }
 
 export const AuditForm: React.FC<AuditFormProps> = ({
-	instanceId, state, onUpdateResponse, onNavigate, setIsSyncing
+	instanceId, state, onUpdateResponse, onNavigate, setIsSyncing, collaborators = [], onFocusFinding
 }) => {
 	const inst = state.instances.find((i: { id: any; }) => i.id === instanceId);
 	const master = inst ? state.masterData[inst.category] : null;
@@ -23,6 +26,7 @@ export const AuditForm: React.FC<AuditFormProps> = ({
 	const [searchQuery, setSearchQuery] = useState('');
 	const fileRef = useRef<HTMLInputElement>(null);
 	const [uploadingId, setUploadingId] = useState<string | null>(null);
+	const [uploadError, setUploadError] = useState<string | null>(null);
 
 	if (!inst || !master) return (
 		<div className="flex flex-col items-center justify-center h-64 text-slate-400">
@@ -32,6 +36,7 @@ export const AuditForm: React.FC<AuditFormProps> = ({
 	);
 
 	const sections = Object.keys(master.sections) as AuditSection[];
+	const locked = inst.status !== 'draft';
 
 	const filteredItems = useMemo(() => {
 		let items = filter === 'All' ? Object.values(master.sections).flat() : master.sections[filter] || [];
@@ -64,13 +69,21 @@ export const AuditForm: React.FC<AuditFormProps> = ({
 
 	const handleImageCapture = async (e: React.ChangeEvent<HTMLInputElement>) => {
 		const file = e.target.files?.[0];
+		e.target.value = '';
 		if (file && uploadingId) {
 			setIsSyncing(true);
-			const cloudUrl = await CloudStorage.uploadAsset(file, `audit_${inst.id}_${uploadingId}`);
-			const existing = inst.responses[uploadingId]?.images || [];
-			onUpdateResponse(inst.id, uploadingId, { images: [...existing, cloudUrl] });
-			setIsSyncing(false);
-			setUploadingId(null);
+			setUploadError(null);
+			try {
+				const ref = await CloudStorage.uploadAsset(file, inst.id, uploadingId);
+				const existing = inst.responses[uploadingId]?.images || [];
+				onUpdateResponse(inst.id, uploadingId, { images: [...existing, ref] });
+			} catch (err) {
+				console.warn('Photo upload failed', err);
+				setUploadError(`${file.name} was not uploaded: ${err instanceof Error ? err.message : err}`);
+			} finally {
+				setIsSyncing(false);
+				setUploadingId(null);
+			}
 		}
 	};
 
@@ -87,11 +100,25 @@ export const AuditForm: React.FC<AuditFormProps> = ({
 						<div className="flex items-center gap-2 mt-0.5">
 							<Badge variant="outline" className="text-[9px] uppercase tracking-wider">{inst.category}</Badge>
 							<span className="text-[10px] text-slate-400 font-medium">Session ID: {inst.id.slice(-6)}</span>
+							{locked && (
+								<Badge variant="outline" className="text-[9px] border-amber-300 text-amber-700" title="Responses can be edited again once the audit is sent back to draft">
+									<Lucide.Lock size={10} className="mr-1" />Read-only: {inst.status}
+								</Badge>
+							)}
 						</div>
 					</div>
 				</div>
 
 				<div className="flex items-center gap-6">
+					{collaborators.length > 0 && (
+						<div className="flex -space-x-2" title={collaborators.map(c => c.name).join(', ')}>
+							{collaborators.map(c => (
+								<div key={c.clientId} className="h-7 w-7 rounded-full bg-blue-600 text-white border-2 border-white flex items-center justify-center text-[10px] font-bold uppercase">
+									{c.name.slice(0, 2)}
+								</div>
+							))}
+						</div>
+					)}
 					<div className="hidden lg:flex items-center gap-4 border-l border-slate-100 pl-6">
 						<RiskMetric label="Crit" count={riskStats.critical} color="bg-red-500" />
 						<RiskMetric label="Maj" count={riskStats.major} color="bg-amber-500" />
@@ -129,6 +156,12 @@ export const AuditForm: React.FC<AuditFormProps> = ({
 				</div>
 			</div>
 
+			{uploadError && (
+				<button onClick={() => setUploadError(null)} title="Dismiss" className="text-left">
+					<Badge variant="outline" className="text-[10px] border-red-300 text-red-700">{uploadError}</Badge>
+				</button>
+			)}
+
 			{/* Main content Area */}
 			<div className="space-y-4">
 				{filteredItems.length === 0 ? (
@@ -138,16 +171,20 @@ export const AuditForm: React.FC<AuditFormProps> = ({
 						<Button variant="link" size="sm" onClick={() => setSearchQuery('')}>Clear search</Button>
 					</Card>
 				) : (
-					filteredItems.map((f, idx) => (
-						<AuditItem
-							key={f.id}
-							index={idx}
-							finding={f}
-							response={inst.responses[f.id] || { findingId: f.id, status: 'Unanswered', notes: '', images: [] }}
-							onUpdate={(upd) => onUpdateResponse(inst.id, f.id, upd)}
-							onAddPhoto={() => { setUploadingId(f.id); fileRef.current?.click(); }}
-						/>
-					))
+					<fieldset disabled={locked} className="space-y-4">
+						{filteredItems.map((f, idx) => (
+							<AuditItem
+								key={f.id}
+								index={idx}
+								finding={f}
+								response={inst.responses[f.id] || { findingId: f.id, status: 'Unanswered', notes: '', images: [] }}
+								onUpdate={(upd) => onUpdateResponse(inst.id, f.id, upd)}
+								onAddPhoto={() => { setUploadingId(f.id); fileRef.current?.click(); }}
+								editors={collaborators.filter(c => c.findingId === f.id).map(c => c.name)}
+								onFocus={() => onFocusFinding?.(f.id)}
+							/>
+						))}
+					</fieldset>
 				)}
 			</div>
 
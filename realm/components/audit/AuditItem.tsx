@@ -1,8 +1,9 @@
 
-import React, { useState } from 'react';
+import React, { useEffect, useState } from 'react';
 import * as Lucide from 'lucide-react';
 import { AuditFinding, AuditResponse } from '../../types';
 import { Badge, Button, Card, CardHeader, CardContent, Textarea } from '../shared/Atomic';
+import { CloudStorage } from '../../services/storageService';
 
 interface AuditItemProps {
   index: number;
@@ -10,6 +11,9 @@ interface AuditItemProps {
   response: AuditResponse;
   onUpdate: (updates: Partial<AuditResponse>) => void;
   onAddPhoto: () => void;
+  /** Other auditors currently working on this finding. */
+  editors?: string[];
+  onFocus?: () => void;
 }
 
 export const AuditItem: React.FC<AuditItemProps> = ({ 
@@ -17,13 +21,19 @@ export const AuditItem: React.FC<AuditItemProps> = ({
   finding, 
   response, 
   onUpdate, 
-  onAddPhoto 
+  onAddPhoto,
+  editors = [],
+  onFocus
 }) => {
   const [showRationale, setShowRationale] = useState(false);
   const isNonCompliant = response.status === 'Non-Compliant';
 
   return (
-    <Card className={`transition-all duration-200 ${isNonCompliant ? 'border-red-200' : ''}`}>
+    <Card
+      className={`transition-all duration-200 ${isNonCompliant ? 'border-red-200' : ''} ${editors.length ? 'ring-2 ring-blue-200' : ''}`}
+      onFocusCapture={onFocus}
+      onPointerDownCapture={onFocus}
+    >
       <div className="flex flex-col md:flex-row">
         {/* Main Info Section */}
         <div className="flex-1 p-6 space-y-4">
@@ -33,6 +43,11 @@ export const AuditItem: React.FC<AuditItemProps> = ({
             </div>
             <div className="space-y-1">
               <h4 className="text-base font-bold leading-tight">{finding.equipmentSubject}</h4>
+              {editors.length > 0 && (
+                <span className="text-[10px] font-semibold text-blue-600">
+                  {editors.join(', ')} {editors.length === 1 ? 'is' : 'are'} here
+                </span>
+              )}
               <div className="flex items-center gap-2">
                 <Badge variant="outline" className="text-[10px] py-0">{finding.aamiReference}</Badge>
                 <button 
@@ -78,7 +93,7 @@ export const AuditItem: React.FC<AuditItemProps> = ({
               <div className="flex gap-2 overflow-x-auto pb-1 flex-1">
                 {response.images.map((img, i) => (
                   <div key={i} className="relative h-12 w-12 rounded-lg overflow-hidden border border-slate-200 shrink-0">
-                    <img src={img} className="h-full w-full object-cover" alt="Audit evidence" />
+                    <EvidencePhoto imageRef={img} />
                     <button 
                       onClick={() => {
                         const updated = [...response.images];
@@ -171,3 +186,17 @@ const RiskLevelBtn = ({ active, label, color, onClick }: any) => (
     {label}
   </button>
 );
+
+/** A thumbnail of a stored photo, loaded through a signed link. */
+const EvidencePhoto = ({ imageRef }: { imageRef: string }) => {
+  const [src, setSrc] = useState<string | null>(null);
+  useEffect(() => {
+    let live = true;
+    CloudStorage.imageSrc(imageRef, 'thumb')
+      .then(url => live && setSrc(url))
+      .catch(err => console.warn('Loading photo failed', err));
+    return () => { live = false; };
+  }, [imageRef]);
+  if (!src) return <div className="h-full w-full bg-slate-100 animate-pulse" />;
+  return <img src={src} className="h-full w-full object-cover" alt="Audit evidence" />;
+};
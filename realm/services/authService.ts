@@ -0,0 +1,82 @@
+const SESSION_KEY = 'realm_session_v1';
+const API_URL = (process.env.REALM_API_URL || '').replace(/\/$/, '');
+
+export interface SessionUser {
+  id: string;
+  email: string;
+  name: string;
+  role: 'admin' | 'engagement_partner' | 'auditor' | 'client';
+}
+
+interface Session {
+  user: SessionUser;
+  token: string;
+  expiresAt: string;
+}
+
+/**
+ * The signed-in user's gatekeeper session. The token comes from
+ * POST /auth/login and is kept in sessionStorage, so it ends with the tab
+ * and is never part of the built app.
+ */
+let session: Session | null = (() => {
+  try {
+    const s: Session | null = JSON.parse(sessionStorage.getItem(SESSION_KEY) || 'null');
+    return s && new Date(s.expiresAt).getTime() > Date.now() ? s : null;
+  } catch {
+    return null;
+  }
+})();
+
+const listeners = new Set<(user: SessionUser | null) => void>();
+
+function setSession(s: Session | null) {
+  session = s;
+  if (s) sessionStorage.setItem(SESSION_KEY, JSON.stringify(s));
+  else sessionStorage.removeItem(SESSION_KEY);
+  for (const fn of listeners) fn(s?.user ?? null);
+}
+
+export const Auth = {
+  /** Without a workspace API there is nothing to sign in to. */
+  required: !!API_URL,
+
+  user: (): SessionUser | null => session?.user ?? null,
+
+  token: (): string => session?.token ?? '',
+
+  async login(email: string, password: string): Promise<SessionUser> {
+    const res = await fetch(`${API_URL}/auth/login`, {
+      method: 'POST',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify({ email, password }),
+    });
+    if (res.status === 401) throw new Error('Incorrect email or password');
+    if (!res.ok) throw new Error(`Sign-in failed: ${res.status} ${await res.text()}`);
+    const { user, token, expires_at } = await res.json();
+    if (user.role === 'client') throw new Error('Facility accounts sign in through the client portal');
+    setSession({ user, token, expiresAt: expires_at });
+    return user;
+  },
+
+  async logout() {
+    const token = session?.token;
+    setSession(null);
+    if (!token) return;
+    try {
+      await fetch(`${API_URL}/auth/logout`, { method: 'POST', headers: { Authorization: `Bearer ${token}` } });
+    } catch (err) {
+      console.warn('Sign-out request failed', err);
+    }
+  },
+
+  /** Called when the API rejects the token, e.g. after the session expired. */
+  expired() {
+    if (session) setSession(null);
+  },
+
+  subscribe(fn: (user: SessionUser | null) => void): () => void {
+    listeners.add(fn);
+    return () => listeners.delete(fn);
+  },
+};
@@ -0,0 +1,156 @@
+import { AuditInstance, AuditResponse } from '../types';
+import { Auth } from './authService';
+
+const API_URL = (process.env.REALM_API_URL || '').replace(/\/$/, '');
+
+export interface Presence {
+  clientId: string;
+  name: string;
+  findingId?: string;
+  joinedAt: number;
+}
+
+/** Field-level change to one response; see ResponsePatch in the gatekeeper. */
+export interface ResponsePatch {
+  status?: AuditResponse['status'];
+  notes?: string;
+  addImages?: string[];
+  removeImages?: string[];
+  riskLevel?: AuditResponse['riskLevel'];
+  capaRequired?: boolean;
+}
+
+interface Handlers {
+  /**
+   * A response as stored on the server after a patch. `fields` are the ones
+   * the patch changed; `own` is set for the echo of our own patch, which the
+   * screen already shows.
+   */
+  onResponse: (findingId: string, response: AuditResponse, fields: (keyof AuditResponse)[], own: boolean, updatedAt: number) => void;
+  /** The whole instance, on connect and after a full replace. */
+  onInstance: (inst: AuditInstance) => void;
+  onPresence: (presence: Presence[], self: string) => void;
+  onDeleted: () => void;
+}
+
+export interface CollabSession {
+  patch: (findingId: string, patch: ResponsePatch) => void;
+  focus: (findingId: string | null) => void;
+  close: () => void;
+}
+
+/** Turns an edit of the app's AuditResponse into a patch. */
+export function toPatch(prev: AuditResponse | undefined, upd: Partial<AuditResponse>): ResponsePatch {
+  const { images, findingId, ...fields } = upd;
+  const patch: ResponsePatch = { ...fields };
+  if (images) {
+    const before = prev?.images || [];
+    patch.addImages = images.filter(i => !before.includes(i));
+    patch.removeImages = before.filter(i => !images.includes(i));
+  }
+  return patch;
+}
+
+/**
+ * Joins the live session for an instance. Returns null without a workspace
+ * API, where there is nobody to collaborate with. The socket reconnects with
+ * backoff; patches made while it is down are queued and sent on reconnect.
+ */
+export function connectCollab(instanceId: string, name: string, handlers: Handlers): CollabSession | null {
+  if (!API_URL) return null;
+  const url = new URL(`${API_URL}/realm/instances/${encodeURIComponent(instanceId)}/live`, window.location.href);
+  url.protocol = url.protocol === 'https:' ? 'wss:' : 'ws:';
+  url.searchParams.set('access_token', Auth.token());
+  url.searchParams.set('name', name);
+
+  let socket: WebSocket | null = null;
+  let self = '';
+  let ready = false; // hello received on the current socket
+  let closed = false;
+  let retry = 0;
+  let seq = 0;
+  let focused: string | null = null;
+  const pending: { ref?: string }[] = [];
+  // Patches replayed after a snapshot, whose acks must be applied like
+  // anyone else's change because the snapshot replaced our local copy.
+  const replayed = new Set<string>();
+  // Latest updatedAt seen per finding, to drop messages that arrive late.
+  const seen = new Map<string, number>();
+
+  const send = (msg: { ref?: string }) => {
+    if (ready && socket?.readyState === WebSocket.OPEN) socket.send(JSON.stringify(msg));
+    else pending.push(msg);
+  };
+
+  const accept = (msg: any, own: boolean) => {
+    if ((seen.get(msg.findingId) ?? 0) > msg.updatedAt) return;
+    seen.set(msg.findingId, msg.updatedAt);
+    handlers.onResponse(msg.findingId, msg.response, msg.fields || [], own, msg.updatedAt);
+  };
+
+  const open = () => {
+    socket = new WebSocket(url);
+    socket.onopen = () => {
+      retry = 0;
+    };
+    socket.onmessage = (ev) => {
+      const msg = JSON.parse(ev.data);
+      switch (msg.type) {
+        case 'hello':
+          self = msg.clientId;
+          ready = true;
+          seen.clear();
+          handlers.onInstance(msg.instance);
+          handlers.onPresence(msg.presence || [], self);
+          // Queued edits go after the snapshot so it does not undo them.
+          if (focused) send({ type: 'focus', findingId: focused });
+          while (pending.length) {
+            const msg = pending.shift()!;
+            if (msg.ref) replayed.add(msg.ref);
+            send(msg);
+          }
+          break;
+        case 'ack':
+          accept(msg, !replayed.delete(msg.ref));
+          break;
+        case 'response':
+          accept(msg, false);
+          break;
+        case 'instance':
+          seen.clear();
+          handlers.onInstance(msg.instance);
+          break;
+        case 'presence':
+          handlers.onPresence(msg.presence || [], self);
+          break;
+        case 'deleted':
+          closed = true;
+          handlers.onDeleted();
+          break;
+        case 'error':
+          console.warn('Live session error', msg.error);
+          break;
+      }
+    };
+    socket.onclose = () => {
+      ready = false;
+      if (closed) return;
+      handlers.onPresence([], self);
+      setTimeout(open, Math.min(30000, 1000 * 2 ** retry++));
+    };
+  };
+  open();
+
+  return {
+    patch: (findingId, patch) => send({ type: 'patch', ref: String(++seq), findingId, patch }),
+    focus: (findingId) => {
+      if (findingId === focused) return;
+      focused = findingId;
+      if (ready) send({ type: 'focus', findingId: findingId || '' });
+    },
+    close: () => {
+      closed = true;
+      socket?.close();
+    },
+  };
+}
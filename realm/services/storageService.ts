@@ -1,41 +1,276 @@
+import { AppState, AuditCategory, AuditInstance, AuditReport, AuditResponse } from '../types';
+import type { SyncDelta } from './syncService';
+import { Auth } from './authService';
 
-import { AppState } from '../types';
+const LOCAL_KEY = 'realm_enterprise_v1';
+const API_URL = (process.env.REALM_API_URL || '').replace(/\/$/, '');
 
 /**
- * Cloudflare R2 / S3 Storage Implementation (Simulated)
- * Architecture designed for pre-signed URL patterns.
+ * Server-side copies as last seen, keyed by instance id or category. The
+ * server owns updatedAt, so every write sends the version it was based on
+ * and a stale write comes back as 409 instead of overwriting someone else.
+ * An instance under review or approved refuses edits with 423.
  */
+const synced = {
+  instances: new Map<string, AuditInstance>(),
+  masters: new Map<AuditCategory, AuditReport>(),
+};
+
+export async function api<T>(method: string, path: string, body?: unknown): Promise<T> {
+  const token = Auth.token();
+  const res = await fetch(`${API_URL}/realm${path}`, {
+    method,
+    headers: {
+      'Content-Type': 'application/json',
+      ...(token ? { Authorization: `Bearer ${token}` } : {}),
+    },
+    body: body === undefined ? undefined : JSON.stringify(body),
+  });
+  if (res.status === 401) {
+    Auth.expired();
+    throw new Error('Signed out: please sign in again');
+  }
+  if (res.status === 409 && res.headers.get('Content-Type')?.includes('application/json')) {
+    const { current } = await res.json();
+    throw new ConflictError(current);
+  }
+  if (res.status === 423) throw new LockedError(await res.text());
+  if (!res.ok) throw new Error(`${method} ${path}: ${res.status} ${await res.text()}`);
+  return res.status === 204 ? (undefined as T) : res.json();
+}
+
+export class ConflictError extends Error {
+  constructor(public current: unknown) {
+    super('Record was modified by someone else');
+  }
+}
+
+/** The instance is under review or approved and cannot be edited. */
+export class LockedError extends Error {}
+
+// Compares records ignoring updatedAt, which the app bumps on every edit,
+// and the version stamps the server keeps for offline sync.
+const bookkeeping = new Set(['updatedAt', 'version', 'modifiedAt']);
+const sameContent = (a: object, b: object) => {
+  const strip = (k: string, v: unknown) => (bookkeeping.has(k) ? undefined : v);
+  return JSON.stringify(a, strip) === JSON.stringify(b, strip);
+};
+
+/** Evidence metadata as returned by the workspace API. */
+interface Asset {
+  id: string;
+  status: 'pending' | 'uploaded' | 'rejected';
+  rejectReason?: string;
+  url: string;
+  downloadUrl?: string;
+  variants?: { name: string; downloadUrl?: string }[];
+}
+
+interface UploadTicket {
+  asset: Asset;
+  uploadUrl: string;
+  uploadMethod: string;
+  uploadHeaders: Record<string, string>;
+}
+
+const ASSET_PATH = /^\/realm\/assets\/([0-9a-f]+)\/content$/;
+// Signed download links last ten minutes; fetch new ones well before that.
+const LINK_REFRESH_MS = 5 * 60 * 1000;
+const assetLinks = new Map<string, Promise<Asset> & { fetchedAt?: number }>();
+
+const readDataUrl = (file: File) => new Promise<string>((resolve, reject) => {
+  const reader = new FileReader();
+  reader.onload = () => resolve(reader.result as string);
+  reader.onerror = () => reject(reader.error);
+  reader.readAsDataURL(file);
+});
+
 export const CloudStorage = {
   /**
-   * Simulates uploading a file to an R2 bucket.
-   * In production, this would use a PUT request to a pre-signed URL.
+   * Uploads a photo for one finding's response and returns the reference to
+   * store in its images. With the workspace API the file goes straight to
+   * storage through a pre-signed URL and the server then checks it and
+   * strips its metadata; without one it is kept inline as a data URL.
    */
-  async uploadAsset(file: File | string, fileName: string): Promise<string> {
-    return new Promise((resolve) => {
-      setTimeout(() => {
-        // Returns the "Cloud URL". For this simulation, we persist the data URL.
-        resolve(typeof file === 'string' ? file : URL.createObjectURL(file));
-      }, 600);
-    });
+  async uploadAsset(file: File, instanceId: string, findingId: string): Promise<string> {
+    if (!API_URL) return readDataUrl(file);
+    const ticket = await api<UploadTicket>(
+      'POST',
+      `/instances/${encodeURIComponent(instanceId)}/responses/${encodeURIComponent(findingId)}/assets`,
+      { fileName: file.name, contentType: file.type, size: file.size },
+    );
+    const put = await fetch(ticket.uploadUrl, { method: ticket.uploadMethod, headers: ticket.uploadHeaders, body: file });
+    if (!put.ok) throw new Error(`Uploading ${file.name}: ${put.status} ${await put.text()}`);
+    const asset = await api<Asset>('POST', `/assets/${ticket.asset.id}/complete`);
+    assetLinks.set(asset.id, Object.assign(Promise.resolve(asset), { fetchedAt: Date.now() }));
+    return asset.url;
   },
 
   /**
-   * Persists the entire application state to the cloud.
+   * Resolves a stored image reference to something an <img> can load.
+   * Uploaded assets need the bearer token, which image tags cannot send, so
+   * they are shown through short-lived signed links.
    */
-  async saveState(state: AppState): Promise<boolean> {
-    return new Promise((resolve) => {
-      setTimeout(() => {
-        localStorage.setItem('realm_enterprise_v1', JSON.stringify(state));
-        resolve(true);
-      }, 400);
+  async imageSrc(ref: string, variant?: string): Promise<string> {
+    const m = ASSET_PATH.exec(ref);
+    if (!m || !API_URL) return ref;
+    let cached = assetLinks.get(m[1]);
+    if (!cached || Date.now() - (cached.fetchedAt ?? 0) > LINK_REFRESH_MS) {
+      cached = Object.assign(api<Asset>('GET', `/assets/${m[1]}`), { fetchedAt: Date.now() });
+      cached.catch(() => assetLinks.delete(m[1]));
+      assetLinks.set(m[1], cached);
+    }
+    const asset = await cached;
+    return asset.variants?.find(v => v.name === variant)?.downloadUrl ?? asset.downloadUrl ?? '';
+  },
+
+  /**
+   * Persists the application state. With REALM_API_URL set, only records
+   * that changed since the last sync are sent to the workspace API; without
+   * it the state stays in localStorage. Instances the server refused because
+   * they are locked come back as the server has them, for the caller to
+   * put in place of the local copy.
+   */
+  async saveState(state: AppState): Promise<{ ok: boolean; locked: AuditInstance[] }> {
+    // With the API this is an offline cache; fetchState falls back to it.
+    localStorage.setItem(LOCAL_KEY, JSON.stringify(state));
+    const locked: AuditInstance[] = [];
+    if (!API_URL) return { ok: true, locked };
+    let ok = true;
+    const write = async (fn: () => Promise<void>) => {
+      try {
+        await fn();
+      } catch (err) {
+        ok = false;
+        console.warn('Workspace sync failed', err);
+      }
+    };
+
+    for (const inst of state.instances) {
+      const base = synced.instances.get(inst.id);
+      if (base && sameContent(base, inst)) continue;
+      await write(async () => {
+        try {
+          const saved = base
+            ? await api<AuditInstance>('PUT', `/instances/${encodeURIComponent(inst.id)}`, { ...inst, updatedAt: base.updatedAt })
+            : await api<AuditInstance>('POST', '/instances', inst);
+          synced.instances.set(saved.id, saved);
+        } catch (err) {
+          if (!(err instanceof LockedError)) throw err;
+          const current = await api<AuditInstance>('GET', `/instances/${encodeURIComponent(inst.id)}`);
+          synced.instances.set(current.id, current);
+          locked.push(current);
+        }
+      });
+    }
+    const live = new Set(state.instances.map(i => i.id));
+    for (const [id, base] of [...synced.instances]) {
+      if (live.has(id)) continue;
+      await write(async () => {
+        await api('DELETE', `/instances/${encodeURIComponent(id)}?updatedAt=${base.updatedAt}`);
+        synced.instances.delete(id);
+      });
+    }
+
+    for (const [cat, report] of Object.entries(state.masterData) as [AuditCategory, AuditReport | null][]) {
+      if (!report) continue;
+      const base = synced.masters.get(cat);
+      if (base && sameContent(base, report)) continue;
+      await write(async () => {
+        const saved = await api<AuditReport>('PUT', `/master/${encodeURIComponent(cat)}`, { ...report, updatedAt: base?.updatedAt ?? 0 });
+        synced.masters.set(cat, saved);
+      });
+    }
+    return { ok, locked };
+  },
+
+  /**
+   * Records a response the server already has, from a live session, so the
+   * next saveState does not send the whole instance again. Without
+   * updatedAt the response is our own patch still in flight.
+   */
+  acceptResponse(instanceId: string, response: AuditResponse, updatedAt?: number) {
+    const base = synced.instances.get(instanceId);
+    if (!base) return;
+    synced.instances.set(instanceId, {
+      ...base,
+      updatedAt: Math.max(base.updatedAt, updatedAt ?? 0),
+      responses: { ...base.responses, [response.findingId]: response },
     });
   },
 
+  /** Records an instance snapshot received from a live session. */
+  acceptInstance(inst: AuditInstance) {
+    synced.instances.set(inst.id, inst);
+  },
+
+  /** Forgets an instance deleted by someone else. */
+  forgetInstance(instanceId: string) {
+    synced.instances.delete(instanceId);
+  },
+
   /**
-   * Fetches the latest state from the cloud.
+   * Fetches the latest state from the workspace API, or localStorage when
+   * no API is configured.
    */
   async fetchState(): Promise<AppState | null> {
-    const data = localStorage.getItem('realm_enterprise_v1');
-    return data ? JSON.parse(data) : null;
+    if (!API_URL) {
+      const data = localStorage.getItem(LOCAL_KEY);
+      return data ? JSON.parse(data) : null;
+    }
+    let remote: AppState;
+    try {
+      remote = await api<AppState>('GET', '/state');
+    } catch (err) {
+      // Offline: work from the cached copy. Nothing is marked as synced, so
+      // edits stay local until OfflineSync delivers them.
+      console.warn('Workspace unreachable, using offline copy', err);
+      const data = localStorage.getItem(LOCAL_KEY);
+      // Without a cursor the first sync downloads everything, which also
+      // fills in the synced copies.
+      return data ? { ...JSON.parse(data), cursor: 0 } : null;
+    }
+    synced.instances = new Map(remote.instances.map(i => [i.id, i]));
+    synced.masters = new Map();
+    const masterData: AppState['masterData'] = { "Sterile Processing (CSSD)": null, "Endoscopy": null, "Dental": null };
+    for (const [cat, report] of Object.entries(remote.masterData) as [AuditCategory, AuditReport][]) {
+      masterData[cat] = report;
+      synced.masters.set(cat, report);
+    }
+    return { masterData, instances: remote.instances, cursor: remote.cursor };
+  },
+
+  /**
+   * Folds a sync delta into the app state and the synced copies. Responses
+   * replace ours wholesale: the server has already settled any conflict.
+   */
+  applyDelta(state: AppState, delta: SyncDelta): AppState {
+    const gone = new Set(delta.deleted);
+    if (delta.full) {
+      // Anything we synced before that the server no longer lists was
+      // deleted; instances that never reached the server stay.
+      const listed = new Set(delta.instances.map(i => i.id));
+      for (const id of synced.instances.keys()) if (!listed.has(id)) gone.add(id);
+    }
+    const byId = new Map(state.instances.filter(i => !gone.has(i.id)).map(i => [i.id, i]));
+    for (const head of delta.instances) {
+      byId.set(head.id, { ...head, responses: byId.get(head.id)?.responses || {} });
+    }
+    for (const { instanceId, response } of delta.responses) {
+      const inst = byId.get(instanceId);
+      if (inst) byId.set(instanceId, { ...inst, responses: { ...inst.responses, [response.findingId]: response } });
+    }
+    for (const id of gone) synced.instances.delete(id);
+    const touched = new Set([...delta.instances.map(i => i.id), ...delta.responses.map(r => r.instanceId)]);
+    for (const id of touched) {
+      const inst = byId.get(id);
+      // Merge into the synced copy rather than replace it, keeping the
+      // updatedAt our next PUT must carry.
+      const base = synced.instances.get(id);
+      if (inst) synced.instances.set(id, base ? { ...inst, updatedAt: Math.max(base.updatedAt, inst.updatedAt) } : inst);
+    }
+    // Keep the order the dashboard shows: newest first.
+    const instances = [...byId.values()].sort((a, b) => b.updatedAt - a.updatedAt);
+    return { ...state, instances, cursor: delta.cursor };
   }
 };
@@ -0,0 +1,118 @@
+import { AppState, AuditInstance, AuditResponse } from '../types';
+import { api, CloudStorage } from './storageService';
+
+const OUTBOX_KEY = 'realm_sync_outbox_v1';
+const DEVICE_KEY = 'realm_device_id';
+const API_URL = process.env.REALM_API_URL || '';
+
+/** A response edited offline, waiting to be sent. */
+export interface SyncChange {
+  instanceId: string;
+  response: AuditResponse;
+  baseVersion: number;
+  clientTime: number;
+}
+
+export interface SyncConflict {
+  id: string;
+  instanceId: string;
+  findingId: string;
+  winner: 'client' | 'server';
+  client: AuditResponse;
+  server: AuditResponse;
+  createdAt: number;
+}
+
+export interface SyncDelta {
+  cursor: number;
+  /** The cursor was too old to list deletions; this delta holds everything. */
+  full?: boolean;
+  results: { instanceId: string; findingId: string; status: 'applied' | 'unchanged' | 'won' | 'lost' | 'rejected'; error?: string }[];
+  instances: Omit<AuditInstance, 'responses'>[];
+  responses: { instanceId: string; response: AuditResponse }[];
+  deleted: string[];
+  conflicts: SyncConflict[];
+}
+
+const key = (c: { instanceId: string; response: { findingId: string } }) => `${c.instanceId}/${c.response.findingId}`;
+
+function loadOutbox(): SyncChange[] {
+  try {
+    return JSON.parse(localStorage.getItem(OUTBOX_KEY) || '[]');
+  } catch {
+    return [];
+  }
+}
+
+function saveOutbox(outbox: SyncChange[]) {
+  localStorage.setItem(OUTBOX_KEY, JSON.stringify(outbox));
+}
+
+function deviceId(): string {
+  let id = localStorage.getItem(DEVICE_KEY);
+  if (!id) {
+    id = `device-${Date.now().toString(36)}-${Math.random().toString(36).slice(2, 8)}`;
+    localStorage.setItem(DEVICE_KEY, id);
+  }
+  return id;
+}
+
+/**
+ * Response edits made without a connection. They are kept in localStorage,
+ * one entry per response, and sent to /realm/sync when the browser is back
+ * online. The server applies last-writer-wins per response and records a
+ * conflict when someone else changed the same response meanwhile.
+ */
+export const OfflineSync = {
+  enabled: !!API_URL,
+
+  pending(): number {
+    return loadOutbox().length;
+  },
+
+  /**
+   * Queues the latest content of a response. Repeat edits replace the
+   * queued one but keep its base version: the server compares against
+   * what we last saw, not against our own unsent edits.
+   */
+  queue(instanceId: string, response: AuditResponse) {
+    const outbox = loadOutbox();
+    const change: SyncChange = { instanceId, response, baseVersion: response.version ?? 0, clientTime: Date.now() };
+    const i = outbox.findIndex(c => key(c) === key(change));
+    if (i >= 0) outbox[i] = { ...change, baseVersion: outbox[i].baseVersion };
+    else outbox.push(change);
+    saveOutbox(outbox);
+  },
+
+  /**
+   * Sends queued edits and fetches everything changed since cursor. Entries
+   * edited again while the request was in flight stay queued.
+   */
+  async flush(cursor: number): Promise<SyncDelta> {
+    const sent = loadOutbox();
+    const delta = await api<SyncDelta>('POST', '/sync', { clientId: deviceId(), cursor, changes: sent });
+    const stamps = new Map(sent.map(c => [key(c), c.clientTime]));
+    saveOutbox(loadOutbox().filter(c => stamps.get(key(c)) !== c.clientTime));
+    return delta;
+  },
+
+  /**
+   * Re-applies still-queued edits on top of a state fresh from the server.
+   * They are marked as synced so saveState leaves them to the next flush.
+   */
+  overlay(state: AppState): AppState {
+    const outbox = loadOutbox();
+    if (!outbox.length) return state;
+    for (const c of outbox) CloudStorage.acceptResponse(c.instanceId, c.response);
+    return {
+      ...state,
+      instances: state.instances.map(inst => {
+        const mine = outbox.filter(c => c.instanceId === inst.id);
+        if (!mine.length) return inst;
+        const responses = { ...inst.responses };
+        for (const c of mine) responses[c.response.findingId] = c.response;
+        return { ...inst, responses };
+      })
+    };
+  }
+};
@@ -37,21 +37,40 @@ export interface AuditResponse {
     images: string[];
     riskLevel?: 'Critical' | 'Major' | 'Minor';
     capaRequired?: boolean; // Corrective Action Preventive Action
+    version?: number; // server change number, for offline sync
+    modifiedAt?: number;
 }
 
 export interface AuditInstance {
     id: string;
     facilityName: string;
+    facilityId?: string; // facility registry link, set by staff on the server
     category: AuditCategory;
-    status: 'draft' | 'submitted' | 'under-review';
+    // Set by the server's review workflow: a draft goes under review when
+    // submitted, and approval releases the report to the client portal,
+    // benchmarks and trends. Requesting changes or reopening sends it back
+    // to draft; only drafts can be edited.
+    status: 'draft' | 'under-review' | 'approved';
     createdAt: number;
     updatedAt: number;
     completedBy: string[];
     engagementPartner: string;
     responses: Record<string, AuditResponse>;
+    version?: number;
+    masterVersion?: string; // master checklist version the responses refer to
+    orphans?: OrphanedResponse[];
+}
+
+/** A response whose finding no longer exists after a master migration. */
+export interface OrphanedResponse {
+    response: AuditResponse;
+    finding: AuditFinding;
+    section: AuditSection;
+    masterVersion: string;
 }
 
 export interface AppState {
     masterData: Record<AuditCategory, AuditReport | null>;
     instances: AuditInstance[];
+    cursor?: number; // sync cursor the state is current to
 }